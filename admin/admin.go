@@ -0,0 +1,324 @@
+// Package admin is a client of the f-license admin API.
+package admin
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/furkansenharputlu/f-license/lcs"
+)
+
+// Error is returned when the server responds with an error.
+type Error struct {
+	StatusCode int
+	Message    string
+
+	body []byte
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// IsUnauthorized reports whether err is caused by a failed authorization.
+func IsUnauthorized(err error) bool {
+	e, ok := err.(*Error)
+	return ok && e.StatusCode == http.StatusUnauthorized
+}
+
+// Client calls the admin API of an f-license server.
+type Client struct {
+	baseURL    string
+	apiKey     string
+	tenant     string
+	httpClient *http.Client
+}
+
+type Option func(c *Client)
+
+// WithHTTPClient sets the HTTP client used for the requests, e.g. to configure TLS.
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = httpClient
+	}
+}
+
+// WithTenant makes the requests of the super-admin act on the tenant. API keys of tenants
+// act on their own tenant and don't need it.
+func WithTenant(tenant string) Option {
+	return func(c *Client) {
+		c.tenant = tenant
+	}
+}
+
+// NewClient returns a client of the server at baseURL authenticating with apiKey, which
+// is the admin secret of the server or an API key of a tenant.
+func NewClient(baseURL, apiKey string, opts ...Option) *Client {
+	c := &Client{
+		baseURL:    strings.TrimSuffix(baseURL, "/"),
+		apiKey:     apiKey,
+		httpClient: http.DefaultClient,
+	}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	return c
+}
+
+// CreateResult is the identity of a generated license.
+type CreateResult struct {
+	ID    string `json:"id"`
+	Token string `json:"token"`
+}
+
+// Create generates and stores the license.
+func (c *Client) Create(ctx context.Context, l *lcs.License) (*CreateResult, error) {
+	body, err := json.Marshal(l)
+	if err != nil {
+		return nil, err
+	}
+
+	var res CreateResult
+	_, err = c.do(ctx, http.MethodPost, "/admin/licenses", bytes.NewReader(body), &res)
+	if err != nil {
+		return nil, err
+	}
+
+	return &res, nil
+}
+
+// BatchResult is the outcome of generating one license of a batch.
+type BatchResult struct {
+	Index int    `json:"index"`
+	ID    string `json:"id,omitempty"`
+	Token string `json:"token,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// BatchResponse reports the outcome of every license of a batch.
+type BatchResponse struct {
+	Results   []BatchResult `json:"results"`
+	Succeeded int           `json:"succeeded"`
+	Failed    int           `json:"failed"`
+}
+
+// CreateBatch generates and stores the licenses. Failures of single licenses are reported
+// in the results. If atomic is true, either all licenses are stored or none, and a failed
+// batch is returned together with an *Error having status code 409.
+func (c *Client) CreateBatch(ctx context.Context, licenses []*lcs.License, atomic bool) (*BatchResponse, error) {
+	body, err := json.Marshal(map[string]interface{}{
+		"licenses": licenses,
+		"atomic":   atomic,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var res BatchResponse
+	_, err = c.do(ctx, http.MethodPost, "/admin/licenses:batch", bytes.NewReader(body), &res)
+	if e, ok := err.(*Error); ok && e.StatusCode == http.StatusConflict && len(e.body) > 0 {
+		if json.Unmarshal(e.body, &res) == nil {
+			e.Message = "batch is not stored because some licenses failed"
+			return &res, e
+		}
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &res, nil
+}
+
+// Get returns the license with the given ID.
+func (c *Client) Get(ctx context.Context, id string) (*lcs.License, error) {
+	var l lcs.License
+	_, err := c.do(ctx, http.MethodGet, "/admin/licenses/"+url.PathEscape(id), nil, &l)
+	if err != nil {
+		return nil, err
+	}
+
+	return &l, nil
+}
+
+// GetByToken returns the license having the given token.
+func (c *Client) GetByToken(ctx context.Context, token string) (*lcs.License, error) {
+	var l lcs.License
+	_, err := c.do(ctx, http.MethodGet, "/admin/licenses:lookup?token="+url.QueryEscape(token), nil, &l)
+	if err != nil {
+		return nil, err
+	}
+
+	return &l, nil
+}
+
+// ListOptions filters licenses and selects a page of them.
+// Empty filters match every license and zero Limit means no limit.
+type ListOptions struct {
+	App        string
+	Typ        string
+	Active     *bool
+	Claims     map[string]string
+	CustomerID string
+	OrderID    string
+	Offset     int64
+	Limit      int64
+}
+
+// LicensePage is a page of licenses with the total number of licenses.
+type LicensePage struct {
+	Licenses []*lcs.License
+	Total    int64
+}
+
+// List returns a page of licenses.
+func (c *Client) List(ctx context.Context, opts ListOptions) (*LicensePage, error) {
+	query := url.Values{}
+	if opts.App != "" {
+		query.Set("app", opts.App)
+	}
+	if opts.Typ != "" {
+		query.Set("typ", opts.Typ)
+	}
+	if opts.Active != nil {
+		query.Set("active", strconv.FormatBool(*opts.Active))
+	}
+	for k, v := range opts.Claims {
+		query.Add("claim", k+"="+v)
+	}
+	if opts.CustomerID != "" {
+		query.Set("customer_id", opts.CustomerID)
+	}
+	if opts.OrderID != "" {
+		query.Set("order_id", opts.OrderID)
+	}
+	if opts.Offset > 0 {
+		query.Set("offset", strconv.FormatInt(opts.Offset, 10))
+	}
+	if opts.Limit > 0 {
+		query.Set("limit", strconv.FormatInt(opts.Limit, 10))
+	}
+
+	path := "/admin/licenses"
+	if len(query) > 0 {
+		path += "?" + query.Encode()
+	}
+
+	var page LicensePage
+	header, err := c.do(ctx, http.MethodGet, path, nil, &page.Licenses)
+	if err != nil {
+		return nil, err
+	}
+
+	page.Total, err = strconv.ParseInt(header.Get("X-Total-Count"), 10, 64)
+	if err != nil {
+		page.Total = int64(len(page.Licenses))
+	}
+
+	return &page, nil
+}
+
+// Activate activates the license with the given ID.
+func (c *Client) Activate(ctx context.Context, id string) error {
+	_, err := c.do(ctx, http.MethodPut, "/admin/licenses/"+url.PathEscape(id)+"/activate", nil, nil)
+	return err
+}
+
+// Inactivate inactivates the license with the given ID.
+func (c *Client) Inactivate(ctx context.Context, id string) error {
+	_, err := c.do(ctx, http.MethodPut, "/admin/licenses/"+url.PathEscape(id)+"/inactivate", nil, nil)
+	return err
+}
+
+// Renew extends the expiry of the license with the given ID and signs it again, and
+// returns the renewed license with its new token.
+func (c *Client) Renew(ctx context.Context, id string, opts lcs.RenewOptions) (*lcs.License, error) {
+	body, err := json.Marshal(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var l lcs.License
+	_, err = c.do(ctx, http.MethodPost, "/admin/licenses/"+url.PathEscape(id)+"/renew", bytes.NewReader(body), &l)
+	if err != nil {
+		return nil, err
+	}
+
+	return &l, nil
+}
+
+// Delete deletes the license with the given ID.
+func (c *Client) Delete(ctx context.Context, id string) error {
+	_, err := c.do(ctx, http.MethodDelete, "/admin/licenses/"+url.PathEscape(id)+"/delete", nil, nil)
+	return err
+}
+
+func (c *Client) do(ctx context.Context, method, path string, body io.Reader, out interface{}) (http.Header, error) {
+	req, err := http.NewRequest(method, c.baseURL+path, body)
+	if err != nil {
+		return nil, err
+	}
+
+	req = req.WithContext(ctx)
+	req.Header.Set("Authorization", c.apiKey)
+	if c.tenant != "" {
+		req.Header.Set("X-Tenant-ID", c.tenant)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	respBytes, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		return nil, decodeError(resp.StatusCode, respBytes)
+	}
+
+	if out != nil {
+		err = json.Unmarshal(respBytes, out)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't decode response: %s", err)
+		}
+	}
+
+	return resp.Header, nil
+}
+
+// decodeError builds an Error from the bodies written by ReturnError, or from the
+// message field some failures like authorization are reported with.
+func decodeError(statusCode int, body []byte) error {
+	var res struct {
+		Error   string `json:"error"`
+		Message string `json:"message"`
+	}
+	_ = json.Unmarshal(body, &res)
+
+	e := &Error{StatusCode: statusCode, Message: res.Error, body: body}
+	if e.Message == "" {
+		e.Message = res.Message
+	}
+	if e.Message == "" {
+		e.Message = http.StatusText(statusCode)
+	}
+
+	return e
+}
@@ -0,0 +1,66 @@
+package admin
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+
+	"github.com/furkansenharputlu/f-license/config"
+)
+
+// CreateApp stores the app on the server.
+func (c *Client) CreateApp(ctx context.Context, app *config.App) (*config.App, error) {
+	return c.sendApp(ctx, http.MethodPost, "/admin/apps", app)
+}
+
+// GetApp returns the stored app with the given name.
+func (c *Client) GetApp(ctx context.Context, name string) (*config.App, error) {
+	var app config.App
+	_, err := c.do(ctx, http.MethodGet, "/admin/apps/"+url.PathEscape(name), nil, &app)
+	if err != nil {
+		return nil, err
+	}
+
+	return &app, nil
+}
+
+// ListApps returns the stored apps. Apps only in the configuration of the server are
+// not included.
+func (c *Client) ListApps(ctx context.Context) ([]*config.App, error) {
+	var apps []*config.App
+	_, err := c.do(ctx, http.MethodGet, "/admin/apps", nil, &apps)
+	if err != nil {
+		return nil, err
+	}
+
+	return apps, nil
+}
+
+// UpdateApp replaces the stored app having the same name.
+func (c *Client) UpdateApp(ctx context.Context, app *config.App) (*config.App, error) {
+	return c.sendApp(ctx, http.MethodPut, "/admin/apps/"+url.PathEscape(app.Name), app)
+}
+
+// DeleteApp deletes the stored app with the given name. It fails with status code 409
+// while there are licenses of the app.
+func (c *Client) DeleteApp(ctx context.Context, name string) error {
+	_, err := c.do(ctx, http.MethodDelete, "/admin/apps/"+url.PathEscape(name), nil, nil)
+	return err
+}
+
+func (c *Client) sendApp(ctx context.Context, method, path string, app *config.App) (*config.App, error) {
+	body, err := json.Marshal(app)
+	if err != nil {
+		return nil, err
+	}
+
+	var res config.App
+	_, err = c.do(ctx, method, path, bytes.NewReader(body), &res)
+	if err != nil {
+		return nil, err
+	}
+
+	return &res, nil
+}
@@ -0,0 +1,150 @@
+package admin
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+
+	"github.com/furkansenharputlu/f-license/lcs"
+)
+
+// CustomerFilter selects customers. Empty fields match every customer.
+type CustomerFilter struct {
+	Email     string
+	Company   string
+	BillingID string
+}
+
+// CustomerLicenses is a customer with its orders and licenses.
+type CustomerLicenses struct {
+	Customer *lcs.Customer  `json:"customer"`
+	Orders   []*lcs.Order   `json:"orders"`
+	Licenses []*lcs.License `json:"licenses"`
+}
+
+// CreateCustomer stores the customer and returns it with its ID.
+func (c *Client) CreateCustomer(ctx context.Context, customer *lcs.Customer) (*lcs.Customer, error) {
+	return c.sendCustomer(ctx, http.MethodPost, "/admin/customers", customer)
+}
+
+// GetCustomer returns the customer with the given ID.
+func (c *Client) GetCustomer(ctx context.Context, id string) (*lcs.Customer, error) {
+	var customer lcs.Customer
+	_, err := c.do(ctx, http.MethodGet, "/admin/customers/"+url.PathEscape(id), nil, &customer)
+	if err != nil {
+		return nil, err
+	}
+
+	return &customer, nil
+}
+
+// ListCustomers returns the customers matching the filter.
+func (c *Client) ListCustomers(ctx context.Context, f CustomerFilter) ([]*lcs.Customer, error) {
+	query := url.Values{}
+	if f.Email != "" {
+		query.Set("email", f.Email)
+	}
+	if f.Company != "" {
+		query.Set("company", f.Company)
+	}
+	if f.BillingID != "" {
+		query.Set("billing_id", f.BillingID)
+	}
+
+	path := "/admin/customers"
+	if len(query) > 0 {
+		path += "?" + query.Encode()
+	}
+
+	var customers []*lcs.Customer
+	_, err := c.do(ctx, http.MethodGet, path, nil, &customers)
+	if err != nil {
+		return nil, err
+	}
+
+	return customers, nil
+}
+
+// UpdateCustomer replaces the name, email, company and billing ID of the customer having
+// the same ID.
+func (c *Client) UpdateCustomer(ctx context.Context, customer *lcs.Customer) (*lcs.Customer, error) {
+	return c.sendCustomer(ctx, http.MethodPut, "/admin/customers/"+url.PathEscape(customer.ID.Hex()), customer)
+}
+
+// DeleteCustomer deletes the customer with the given ID. It fails with status code 409
+// while there are licenses or orders of the customer.
+func (c *Client) DeleteCustomer(ctx context.Context, id string) error {
+	_, err := c.do(ctx, http.MethodDelete, "/admin/customers/"+url.PathEscape(id), nil, nil)
+	return err
+}
+
+// GetCustomerLicenses returns the customer with all of its orders and licenses.
+func (c *Client) GetCustomerLicenses(ctx context.Context, id string) (*CustomerLicenses, error) {
+	var res CustomerLicenses
+	_, err := c.do(ctx, http.MethodGet, "/admin/customers/"+url.PathEscape(id)+"/licenses", nil, &res)
+	if err != nil {
+		return nil, err
+	}
+
+	return &res, nil
+}
+
+// CreateOrder stores the order of a customer and returns it with its ID.
+func (c *Client) CreateOrder(ctx context.Context, o *lcs.Order) (*lcs.Order, error) {
+	body, err := json.Marshal(o)
+	if err != nil {
+		return nil, err
+	}
+
+	var res lcs.Order
+	_, err = c.do(ctx, http.MethodPost, "/admin/orders", bytes.NewReader(body), &res)
+	if err != nil {
+		return nil, err
+	}
+
+	return &res, nil
+}
+
+// GetOrder returns the order with the given ID.
+func (c *Client) GetOrder(ctx context.Context, id string) (*lcs.Order, error) {
+	var o lcs.Order
+	_, err := c.do(ctx, http.MethodGet, "/admin/orders/"+url.PathEscape(id), nil, &o)
+	if err != nil {
+		return nil, err
+	}
+
+	return &o, nil
+}
+
+// ListOrders returns the orders of the customer, or every order if customerID is empty.
+func (c *Client) ListOrders(ctx context.Context, customerID string) ([]*lcs.Order, error) {
+	path := "/admin/orders"
+	if customerID != "" {
+		path += "?customer_id=" + url.QueryEscape(customerID)
+	}
+
+	var orders []*lcs.Order
+	_, err := c.do(ctx, http.MethodGet, path, nil, &orders)
+	if err != nil {
+		return nil, err
+	}
+
+	return orders, nil
+}
+
+func (c *Client) sendCustomer(ctx context.Context, method, path string, customer *lcs.Customer) (*lcs.Customer, error) {
+	body, err := json.Marshal(customer)
+	if err != nil {
+		return nil, err
+	}
+
+	var res lcs.Customer
+	_, err = c.do(ctx, method, path, bytes.NewReader(body), &res)
+	if err != nil {
+		return nil, err
+	}
+
+	return &res, nil
+}
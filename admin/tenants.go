@@ -0,0 +1,100 @@
+package admin
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Tenant is an organisation owning licenses, apps and API keys.
+type Tenant struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// APIKey authenticates the admin API requests of a tenant. Key is returned only when the
+// key is created.
+type APIKey struct {
+	ID        string    `json:"id"`
+	Tenant    string    `json:"tenant"`
+	Name      string    `json:"name"`
+	Role      string    `json:"role"`
+	CreatedAt time.Time `json:"created_at"`
+	Key       string    `json:"key,omitempty"`
+}
+
+// CreateTenant creates a tenant. Only the super-admin can create tenants.
+func (c *Client) CreateTenant(ctx context.Context, id, name string) (*Tenant, error) {
+	body, err := json.Marshal(Tenant{ID: id, Name: name})
+	if err != nil {
+		return nil, err
+	}
+
+	var t Tenant
+	_, err = c.do(ctx, http.MethodPost, "/admin/tenants", bytes.NewReader(body), &t)
+	if err != nil {
+		return nil, err
+	}
+
+	return &t, nil
+}
+
+// GetTenant returns the tenant with the given ID.
+func (c *Client) GetTenant(ctx context.Context, id string) (*Tenant, error) {
+	var t Tenant
+	_, err := c.do(ctx, http.MethodGet, "/admin/tenants/"+url.PathEscape(id), nil, &t)
+	if err != nil {
+		return nil, err
+	}
+
+	return &t, nil
+}
+
+// ListTenants returns all tenants. Only the super-admin can list tenants.
+func (c *Client) ListTenants(ctx context.Context) ([]*Tenant, error) {
+	var tenants []*Tenant
+	_, err := c.do(ctx, http.MethodGet, "/admin/tenants", nil, &tenants)
+	if err != nil {
+		return nil, err
+	}
+
+	return tenants, nil
+}
+
+// CreateAPIKey creates an API key of the tenant with the given role, admin or read-only.
+// Tenant is required only for the super-admin, the key of a tenant creates keys of itself.
+func (c *Client) CreateAPIKey(ctx context.Context, tenant, name, role string) (*APIKey, error) {
+	body, err := json.Marshal(map[string]string{"tenant": tenant, "name": name, "role": role})
+	if err != nil {
+		return nil, err
+	}
+
+	var k APIKey
+	_, err = c.do(ctx, http.MethodPost, "/admin/api-keys", bytes.NewReader(body), &k)
+	if err != nil {
+		return nil, err
+	}
+
+	return &k, nil
+}
+
+// ListAPIKeys returns the API keys of the tenant, or of all tenants for the super-admin.
+func (c *Client) ListAPIKeys(ctx context.Context) ([]*APIKey, error) {
+	var keys []*APIKey
+	_, err := c.do(ctx, http.MethodGet, "/admin/api-keys", nil, &keys)
+	if err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+// DeleteAPIKey deletes the API key with the given ID.
+func (c *Client) DeleteAPIKey(ctx context.Context, id string) error {
+	_, err := c.do(ctx, http.MethodDelete, "/admin/api-keys/"+url.PathEscape(id), nil, nil)
+	return err
+}
@@ -0,0 +1,113 @@
+package admin
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/furkansenharputlu/f-license/webhook"
+)
+
+// DeliveryFilter selects webhook deliveries. Empty fields match every delivery and zero
+// Limit means no limit.
+type DeliveryFilter struct {
+	WebhookID string
+	Status    string
+	Limit     int64
+}
+
+// CreateWebhook subscribes the URL of the webhook to its events. The returned webhook has
+// the secret payloads are signed with, generated if it is not given.
+func (c *Client) CreateWebhook(ctx context.Context, s *webhook.Subscription) (*webhook.Subscription, error) {
+	body, err := json.Marshal(s)
+	if err != nil {
+		return nil, err
+	}
+
+	var res webhook.Subscription
+	_, err = c.do(ctx, http.MethodPost, "/admin/webhooks", bytes.NewReader(body), &res)
+	if err != nil {
+		return nil, err
+	}
+
+	return &res, nil
+}
+
+// GetWebhook returns the webhook with the given ID, without its secret.
+func (c *Client) GetWebhook(ctx context.Context, id string) (*webhook.Subscription, error) {
+	var s webhook.Subscription
+	_, err := c.do(ctx, http.MethodGet, "/admin/webhooks/"+url.PathEscape(id), nil, &s)
+	if err != nil {
+		return nil, err
+	}
+
+	return &s, nil
+}
+
+// ListWebhooks returns the webhooks without their secrets.
+func (c *Client) ListWebhooks(ctx context.Context) ([]*webhook.Subscription, error) {
+	var subs []*webhook.Subscription
+	_, err := c.do(ctx, http.MethodGet, "/admin/webhooks", nil, &subs)
+	if err != nil {
+		return nil, err
+	}
+
+	return subs, nil
+}
+
+func (c *Client) DeleteWebhook(ctx context.Context, id string) error {
+	_, err := c.do(ctx, http.MethodDelete, "/admin/webhooks/"+url.PathEscape(id), nil, nil)
+	return err
+}
+
+// ListDeliveries returns the webhook deliveries matching the filter, the newest first.
+// Dead deliveries, which are given up, are selected with webhook.StatusDead.
+func (c *Client) ListDeliveries(ctx context.Context, f DeliveryFilter) ([]*webhook.Delivery, error) {
+	query := url.Values{}
+	if f.WebhookID != "" {
+		query.Set("webhook_id", f.WebhookID)
+	}
+	if f.Status != "" {
+		query.Set("status", f.Status)
+	}
+	if f.Limit > 0 {
+		query.Set("limit", strconv.FormatInt(f.Limit, 10))
+	}
+
+	path := "/admin/deliveries"
+	if len(query) > 0 {
+		path += "?" + query.Encode()
+	}
+
+	var deliveries []*webhook.Delivery
+	_, err := c.do(ctx, http.MethodGet, path, nil, &deliveries)
+	if err != nil {
+		return nil, err
+	}
+
+	return deliveries, nil
+}
+
+func (c *Client) GetDelivery(ctx context.Context, id string) (*webhook.Delivery, error) {
+	var d webhook.Delivery
+	_, err := c.do(ctx, http.MethodGet, "/admin/deliveries/"+url.PathEscape(id), nil, &d)
+	if err != nil {
+		return nil, err
+	}
+
+	return &d, nil
+}
+
+// Redeliver schedules the delivery to be delivered again with the full number of attempts.
+func (c *Client) Redeliver(ctx context.Context, id string) (*webhook.Delivery, error) {
+	var d webhook.Delivery
+	_, err := c.do(ctx, http.MethodPost, "/admin/deliveries/"+url.PathEscape(id)+"/redeliver", nil, &d)
+	if err != nil {
+		return nil, err
+	}
+
+	return &d, nil
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/furkansenharputlu/f-license/admin"
+	"github.com/furkansenharputlu/f-license/config"
+	"github.com/furkansenharputlu/f-license/lcs"
+	"github.com/furkansenharputlu/f-license/storage"
+	"github.com/furkansenharputlu/f-license/webhook"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAdminClient(t *testing.T) {
+	defer storage.LicenseHandler.DropDatabase(context.Background())
+
+	ctx := context.Background()
+	c := admin.NewClient(tr.server.URL, config.Get().AdminSecret)
+
+	var ids []string
+	for _, name := range []string{"Furkan", "Ahmet", "Mehmet"} {
+		l := sampleLicense(func(l *lcs.License) {
+			l.Claims["name"] = name
+		})
+
+		res, err := c.Create(ctx, l)
+		require.NoError(t, err)
+		assert.NotEmpty(t, res.Token)
+
+		ids = append(ids, res.ID)
+	}
+
+	t.Run("create existing", func(t *testing.T) {
+		_, err := c.Create(ctx, sampleLicense())
+		assert.EqualError(t, err, "there is already such license with ID: "+ids[0])
+		assert.Equal(t, http.StatusInternalServerError, err.(*admin.Error).StatusCode)
+	})
+
+	t.Run("get", func(t *testing.T) {
+		l, err := c.Get(ctx, ids[1])
+		require.NoError(t, err)
+		assert.Equal(t, "Ahmet", l.Claims["name"])
+
+		byToken, err := c.GetByToken(ctx, l.Token)
+		require.NoError(t, err)
+		assert.Equal(t, l, byToken)
+
+		_, err = c.Get(ctx, "invalid-id")
+		assert.IsType(t, &admin.Error{}, err)
+	})
+
+	t.Run("list", func(t *testing.T) {
+		page, err := c.List(ctx, admin.ListOptions{})
+		require.NoError(t, err)
+		assert.Len(t, page.Licenses, 3)
+		assert.Equal(t, int64(3), page.Total)
+
+		page, err = c.List(ctx, admin.ListOptions{Offset: 1, Limit: 1})
+		require.NoError(t, err)
+		assert.Len(t, page.Licenses, 1)
+		assert.Equal(t, ids[1], page.Licenses[0].ID.Hex())
+		assert.Equal(t, int64(3), page.Total)
+	})
+
+	t.Run("activeness", func(t *testing.T) {
+		assert.NoError(t, c.Inactivate(ctx, ids[0]))
+		assert.EqualError(t, c.Inactivate(ctx, ids[0]), "already inactive")
+		assert.NoError(t, c.Activate(ctx, ids[0]))
+		assert.EqualError(t, c.Activate(ctx, ids[0]), "already active")
+	})
+
+	t.Run("renew", func(t *testing.T) {
+		l, err := c.Renew(ctx, ids[1], lcs.RenewOptions{Duration: "30d"})
+		require.NoError(t, err)
+		assert.Equal(t, ids[1], l.ID.Hex())
+		assert.Len(t, l.Renewals, 1)
+
+		byToken, err := c.GetByToken(ctx, l.Token)
+		require.NoError(t, err)
+		assert.Equal(t, l.Renewals, byToken.Renewals)
+
+		_, err = c.Renew(ctx, ids[0], lcs.RenewOptions{Duration: "30d", KeepOldToken: true})
+		assert.EqualError(t, err, lcs.ErrOldTokenNotExpiring.Error())
+		assert.Equal(t, http.StatusBadRequest, err.(*admin.Error).StatusCode)
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		assert.NoError(t, c.Delete(ctx, ids[2]))
+		assert.EqualError(t, c.Delete(ctx, ids[2]), "there is no license with ID: "+ids[2])
+	})
+}
+
+func TestAdminClient_Unauthorized(t *testing.T) {
+	c := admin.NewClient(tr.server.URL, "wrong-secret")
+
+	_, err := c.List(context.Background(), admin.ListOptions{})
+	assert.EqualError(t, err, "Authorization failed")
+	assert.True(t, admin.IsUnauthorized(err))
+}
+
+func TestAdminClient_Context(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	c := admin.NewClient(tr.server.URL, config.Get().AdminSecret)
+	_, err := c.Get(ctx, "5ea1f7c4d3b6a0a4c8e1b2f3")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "context canceled")
+}
+
+func TestAdminClient_Apps(t *testing.T) {
+	defer storage.LicenseHandler.DropDatabase(context.Background())
+
+	ctx := context.Background()
+	c := admin.NewClient(tr.server.URL, config.Get().AdminSecret)
+
+	created, err := c.CreateApp(ctx, sampleApp("sdk-app"))
+	require.NoError(t, err)
+	assert.Equal(t, sampleApp("sdk-app"), created)
+
+	_, err = c.CreateApp(ctx, sampleApp("sdk-app"))
+	assert.Equal(t, http.StatusConflict, err.(*admin.Error).StatusCode)
+
+	app := sampleApp("sdk-app")
+	app.Plans = nil
+	_, err = c.UpdateApp(ctx, app)
+	require.NoError(t, err)
+
+	got, err := c.GetApp(ctx, "sdk-app")
+	require.NoError(t, err)
+	assert.Equal(t, app, got)
+
+	apps, err := c.ListApps(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []*config.App{app}, apps)
+
+	assert.NoError(t, c.DeleteApp(ctx, "sdk-app"))
+	assert.EqualError(t, c.DeleteApp(ctx, "sdk-app"), "app not found")
+}
+
+func TestAdminClient_Tenants(t *testing.T) {
+	defer storage.LicenseHandler.DropDatabase(context.Background())
+
+	ctx := context.Background()
+	c := admin.NewClient(tr.server.URL, config.Get().AdminSecret)
+
+	created, err := c.CreateTenant(ctx, "sdk-tenant", "SDK")
+	require.NoError(t, err)
+	assert.Equal(t, "SDK", created.Name)
+
+	tenants, err := c.ListTenants(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []*admin.Tenant{created}, tenants)
+
+	k, err := c.CreateAPIKey(ctx, "sdk-tenant", "ci", config.RoleAdmin)
+	require.NoError(t, err)
+	assert.NotEmpty(t, k.Key)
+
+	// The key of the tenant acts on its own licenses
+	tenantClient := admin.NewClient(tr.server.URL, k.Key)
+	res, err := tenantClient.Create(ctx, sampleLicense(func(l *lcs.License) { l.Headers["app"] = "test-app" }))
+	require.NoError(t, err)
+
+	l, err := tenantClient.Get(ctx, res.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "sdk-tenant", l.Tenant)
+
+	_, err = tenantClient.ListTenants(ctx)
+	assert.Equal(t, http.StatusForbidden, err.(*admin.Error).StatusCode)
+
+	// The super-admin acts on a tenant with WithTenant
+	page, err := admin.NewClient(tr.server.URL, config.Get().AdminSecret, admin.WithTenant("other")).List(ctx, admin.ListOptions{})
+	require.NoError(t, err)
+	assert.Empty(t, page.Licenses)
+
+	assert.NoError(t, tenantClient.DeleteAPIKey(ctx, k.ID))
+	_, err = tenantClient.Get(ctx, res.ID)
+	assert.True(t, admin.IsUnauthorized(err))
+}
+
+func TestAdminClient_Customers(t *testing.T) {
+	defer storage.LicenseHandler.DropDatabase(context.Background())
+
+	ctx := context.Background()
+	c := admin.NewClient(tr.server.URL, config.Get().AdminSecret)
+
+	customer, err := c.CreateCustomer(ctx, &lcs.Customer{Name: "Furkan", Email: "furkan@example.com", BillingID: "cus_1"})
+	require.NoError(t, err)
+	assert.False(t, customer.ID.IsZero())
+
+	customer.Company = "Acme"
+	customer, err = c.UpdateCustomer(ctx, customer)
+	require.NoError(t, err)
+	assert.Equal(t, "Acme", customer.Company)
+
+	customers, err := c.ListCustomers(ctx, admin.CustomerFilter{BillingID: "cus_1"})
+	require.NoError(t, err)
+	assert.Equal(t, []*lcs.Customer{customer}, customers)
+
+	order, err := c.CreateOrder(ctx, &lcs.Order{CustomerID: customer.ID.Hex(), Plan: "pro", Quantity: 1})
+	require.NoError(t, err)
+
+	res, err := c.Create(ctx, sampleLicense(func(l *lcs.License) { l.OrderID = order.ID.Hex() }))
+	require.NoError(t, err)
+
+	page, err := c.List(ctx, admin.ListOptions{CustomerID: customer.ID.Hex()})
+	require.NoError(t, err)
+	assert.Len(t, page.Licenses, 1)
+	assert.Equal(t, res.ID, page.Licenses[0].ID.Hex())
+
+	all, err := c.GetCustomerLicenses(ctx, customer.ID.Hex())
+	require.NoError(t, err)
+	assert.Equal(t, customer, all.Customer)
+	assert.Equal(t, []*lcs.Order{order}, all.Orders)
+	assert.Len(t, all.Licenses, 1)
+
+	err = c.DeleteCustomer(ctx, customer.ID.Hex())
+	assert.Equal(t, http.StatusConflict, err.(*admin.Error).StatusCode)
+}
+
+func TestAdminClient_Webhooks(t *testing.T) {
+	defer storage.LicenseHandler.DropDatabase(context.Background())
+
+	ctx := context.Background()
+	c := admin.NewClient(tr.server.URL, config.Get().AdminSecret)
+
+	created, err := c.CreateWebhook(ctx, &webhook.Subscription{URL: "https://crm.example.com/hooks", Events: []string{webhook.EventDeleted}})
+	require.NoError(t, err)
+	assert.NotEmpty(t, created.Secret)
+
+	subs, err := c.ListWebhooks(ctx)
+	require.NoError(t, err)
+	assert.Len(t, subs, 1)
+	assert.Empty(t, subs[0].Secret)
+
+	res, err := c.Create(ctx, sampleLicense())
+	require.NoError(t, err)
+	assert.NoError(t, c.Delete(ctx, res.ID))
+
+	deliveries, err := c.ListDeliveries(ctx, admin.DeliveryFilter{WebhookID: created.ID})
+	require.NoError(t, err)
+	assert.Len(t, deliveries, 1)
+	assert.Equal(t, webhook.EventDeleted, deliveries[0].Event)
+	assert.Equal(t, webhook.StatusPending, deliveries[0].Status)
+
+	redelivered, err := c.Redeliver(ctx, deliveries[0].ID)
+	require.NoError(t, err)
+	assert.Equal(t, 0, redelivered.Attempts)
+
+	assert.NoError(t, c.DeleteWebhook(ctx, created.ID))
+	_, err = c.GetWebhook(ctx, created.ID)
+	assert.Equal(t, http.StatusNotFound, err.(*admin.Error).StatusCode)
+}
@@ -1,15 +1,25 @@
 package main
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"math"
+	"net"
 	"net/http"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/furkansenharputlu/f-license/config"
 	"github.com/furkansenharputlu/f-license/lcs"
+	"github.com/furkansenharputlu/f-license/ratelimit"
 	"github.com/furkansenharputlu/f-license/storage"
+	"github.com/furkansenharputlu/f-license/webhook"
 
 	"github.com/gorilla/mux"
 	"github.com/sirupsen/logrus"
@@ -21,31 +31,93 @@ func GenerateLicense(w http.ResponseWriter, r *http.Request) {
 	var l lcs.License
 	_ = json.Unmarshal(bytes, &l)
 
-	err := l.Generate()
+	err := storage.PrepareLicense(r.Context(), storage.LicenseHandler, &l)
+	if err != nil {
+		ReturnError(w, customerErrorStatus(err), err.Error())
+		return
+	}
+
+	err = l.Generate()
 	if err != nil {
 		logrus.WithError(err).Error("License couldn't be generated")
 		ReturnError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
 
-	err = storage.LicenseHandler.AddIfNotExisting(&l)
+	err = storage.LicenseHandler.AddIfNotExisting(r.Context(), &l)
 	if err != nil {
 		logrus.WithError(err).Error("License couldn't be stored")
 		ReturnError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
 
+	licensesGenerated.WithLabelValues(l.GetAppName()).Inc()
+	publish(r.Context(), webhook.EventGenerated, &l)
+
 	ReturnResponse(w, http.StatusOK, map[string]interface{}{
 		"id":    l.ID.Hex(),
 		"token": l.Token,
 	})
 }
 
+// BatchRequest is the body of the batch generation endpoint.
+type BatchRequest struct {
+	Licenses []*lcs.License `json:"licenses"`
+	Atomic   bool           `json:"atomic"`
+}
+
+func GenerateLicenses(w http.ResponseWriter, r *http.Request) {
+	var req BatchRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		ReturnError(w, http.StatusBadRequest, "couldn't decode batch: "+err.Error())
+		return
+	}
+
+	if maxBatchSize := config.Get().GetMaxBatchSize(); len(req.Licenses) > maxBatchSize {
+		ReturnError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("batch has %d licenses, at most %d are allowed", len(req.Licenses), maxBatchSize))
+		return
+	}
+
+	results, failed := storage.GenerateBatch(r.Context(), storage.LicenseHandler, req.Licenses, req.Atomic)
+
+	for i, res := range results {
+		if res.Error == "" {
+			licensesGenerated.WithLabelValues(req.Licenses[i].GetAppName()).Inc()
+			publish(r.Context(), webhook.EventGenerated, req.Licenses[i])
+		}
+	}
+
+	statusCode := http.StatusOK
+	if req.Atomic && failed > 0 {
+		statusCode = http.StatusConflict
+	}
+
+	ReturnResponse(w, statusCode, map[string]interface{}{
+		"results":   results,
+		"succeeded": len(results) - failed,
+		"failed":    failed,
+	})
+}
+
 func GetLicense(w http.ResponseWriter, r *http.Request) {
 	id := mux.Vars(r)["id"]
 
 	var l lcs.License
-	err := storage.LicenseHandler.GetByID(id, &l)
+	err := storage.LicenseHandler.GetByID(r.Context(), id, &l)
+	if err != nil {
+		ReturnError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	ReturnResponse(w, 200, l)
+}
+
+func GetLicenseByToken(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+
+	var l lcs.License
+	err := storage.LicenseHandler.GetByToken(r.Context(), token, &l)
 	if err != nil {
 		ReturnError(w, http.StatusInternalServerError, err.Error())
 		return
@@ -55,13 +127,61 @@ func GetLicense(w http.ResponseWriter, r *http.Request) {
 }
 
 func GetAllLicenses(w http.ResponseWriter, r *http.Request) {
-	var licenses []*lcs.License
-	err := storage.LicenseHandler.GetAll(&licenses)
+	var opts storage.ListOptions
+	var err error
+
+	query := r.URL.Query()
+	opts.App = query.Get("app")
+	opts.Typ = query.Get("typ")
+	opts.CustomerID = query.Get("customer_id")
+	opts.OrderID = query.Get("order_id")
+
+	if active := query.Get("active"); active != "" {
+		isActive, err := strconv.ParseBool(active)
+		if err != nil {
+			ReturnError(w, http.StatusBadRequest, "invalid active: "+active)
+			return
+		}
+		opts.Active = &isActive
+	}
+
+	for _, claim := range query["claim"] {
+		kv := strings.SplitN(claim, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			ReturnError(w, http.StatusBadRequest, "invalid claim filter, expected key=value: "+claim)
+			return
+		}
+
+		if opts.Claims == nil {
+			opts.Claims = make(map[string]string)
+		}
+		opts.Claims[kv[0]] = kv[1]
+	}
+
+	if offset := query.Get("offset"); offset != "" {
+		opts.Offset, err = strconv.ParseInt(offset, 10, 64)
+		if err != nil || opts.Offset < 0 {
+			ReturnError(w, http.StatusBadRequest, "invalid offset: "+offset)
+			return
+		}
+	}
+
+	if limit := query.Get("limit"); limit != "" {
+		opts.Limit, err = strconv.ParseInt(limit, 10, 64)
+		if err != nil || opts.Limit < 0 {
+			ReturnError(w, http.StatusBadRequest, "invalid limit: "+limit)
+			return
+		}
+	}
+
+	licenses := make([]*lcs.License, 0)
+	total, err := storage.LicenseHandler.List(r.Context(), opts, &licenses)
 	if err != nil {
 		ReturnError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
 
+	w.Header().Set("X-Total-Count", strconv.FormatInt(total, 10))
 	ReturnResponse(w, 200, licenses)
 }
 
@@ -70,19 +190,25 @@ func ChangeLicenseActiveness(w http.ResponseWriter, r *http.Request) {
 
 	inactivate := strings.Contains(r.URL.Path, "/inactivate")
 
-	err := storage.LicenseHandler.Activate(id, inactivate)
+	err := storage.LicenseHandler.Activate(r.Context(), id, inactivate)
 	if err != nil {
 		logrus.WithError(err).Error("Error while activeness change")
 		ReturnError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
 
-	var message string
+	var message, event string
 
 	if inactivate {
-		message = "Inactivated"
+		licensesRevoked.WithLabelValues("inactivated").Inc()
+		message, event = "Inactivated", webhook.EventInactivated
 	} else {
-		message = "Activated"
+		message, event = "Activated", webhook.EventActivated
+	}
+
+	var l lcs.License
+	if err := storage.LicenseHandler.GetByID(r.Context(), id, &l); err == nil {
+		publish(r.Context(), event, &l)
 	}
 
 	ReturnResponse(w, 200, map[string]interface{}{
@@ -94,38 +220,77 @@ func VerifyLicense(w http.ResponseWriter, r *http.Request) {
 	token := r.FormValue("token")
 
 	var l lcs.License
-	err := storage.LicenseHandler.GetByToken(token, &l)
+	err := storage.LicenseHandler.GetByToken(r.Context(), token, &l)
 	if err != nil {
+		if err == storage.ErrLicenseNotFound {
+			verifications.WithLabelValues("", "invalid", "not_found").Inc()
+		} else {
+			verifications.WithLabelValues("", "error", "storage_error").Inc()
+		}
 		logrus.WithError(err).Error("Error while getting license")
 		ReturnError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
 
+	// Clients verifying with a token replaced by a renewal are told to fetch the new one
+	previous := l.PreviousToken(token)
+
 	ok, err := l.IsLicenseValid(token)
 	if err != nil {
-		ReturnResponse(w, http.StatusUnauthorized, map[string]interface{}{
+		verifications.WithLabelValues(l.GetAppName(), "invalid", "invalid_token").Inc()
+		res := map[string]interface{}{
 			"valid":   false,
 			"message": err.Error(),
-		})
+		}
+		if previous != nil {
+			res["new_token_available"] = true
+		}
+
+		ReturnResponse(w, http.StatusUnauthorized, res)
 
 		return
 	}
 
-	ReturnResponse(w, 200, map[string]interface{}{
+	switch {
+	case ok:
+		verifications.WithLabelValues(l.GetAppName(), "valid", "ok").Inc()
+	case !l.Active:
+		verifications.WithLabelValues(l.GetAppName(), "invalid", "inactive").Inc()
+	case previous != nil:
+		verifications.WithLabelValues(l.GetAppName(), "invalid", "superseded").Inc()
+	default:
+		verifications.WithLabelValues(l.GetAppName(), "invalid", "unknown_app").Inc()
+	}
+
+	res := map[string]interface{}{
 		"valid": ok,
-	})
+	}
+	if previous != nil {
+		res["new_token_available"] = true
+	}
+
+	ReturnResponse(w, 200, res)
 }
 
 func DeleteLicense(w http.ResponseWriter, r *http.Request) {
 	id := mux.Vars(r)["id"]
 
-	err := storage.LicenseHandler.DeleteByID(id)
+	// The license is sent with the event after it is deleted
+	var l lcs.License
+	found := storage.LicenseHandler.GetByID(r.Context(), id, &l) == nil
+
+	err := storage.LicenseHandler.DeleteByID(r.Context(), id)
 	if err != nil {
 		logrus.WithError(err).Error("Error while deleting license")
 		ReturnError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
 
+	licensesRevoked.WithLabelValues("deleted").Inc()
+	if found {
+		publish(r.Context(), webhook.EventDeleted, &l)
+	}
+
 	ReturnResponse(w, 200, map[string]interface{}{
 		"message": "License successfully deleted",
 	})
@@ -135,6 +300,79 @@ func Ping(w http.ResponseWriter, r *http.Request) {
 
 }
 
+func Healthz(w http.ResponseWriter, r *http.Request) {
+	ReturnResponse(w, http.StatusOK, map[string]interface{}{
+		"status": "ok",
+	})
+}
+
+// readinessTimeout bounds the storage check of Readyz.
+const readinessTimeout = 2 * time.Second
+
+func Readyz(w http.ResponseWriter, r *http.Request) {
+	components := make(map[string]interface{})
+	ready := true
+
+	check := func(name string, err error) {
+		if err != nil {
+			ready = false
+			components[name] = map[string]interface{}{
+				"status": "fail",
+				"error":  err.Error(),
+			}
+			return
+		}
+
+		components[name] = map[string]interface{}{
+			"status": "ok",
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+	defer cancel()
+
+	check("storage", storage.LicenseHandler.Ping(ctx))
+	c := config.Get()
+	check("keys", checkKeys(c))
+	check("config", c.Validate())
+
+	status, statusCode := "ok", http.StatusOK
+	if !ready {
+		status, statusCode = "unavailable", http.StatusServiceUnavailable
+	}
+
+	ReturnResponse(w, statusCode, map[string]interface{}{
+		"status":     status,
+		"components": components,
+	})
+}
+
+// checkKeys checks every key of the configuration can be loaded.
+func checkKeys(c *config.Config) error {
+	if err := lcs.CheckKeys("", c.DefaultSignature); err != nil {
+		return fmt.Errorf("default signature: %s", err)
+	}
+
+	var appNames []string
+	for name := range c.Apps {
+		appNames = append(appNames, name)
+	}
+	sort.Strings(appNames)
+
+	for _, name := range appNames {
+		app := c.Apps[name]
+		if app == nil {
+			continue
+		}
+
+		if err := lcs.CheckKeys(app.Alg, app.Signature); err != nil {
+			return fmt.Errorf("app %s: %s", name, err)
+		}
+	}
+
+	return nil
+}
+
 func ReturnResponse(w http.ResponseWriter, statusCode int, resp interface{}) {
 	bytes, _ := json.Marshal(resp)
 
@@ -154,16 +392,184 @@ func ReturnError(w http.ResponseWriter, statusCode int, errMsg string) {
 	_, _ = fmt.Fprintf(w, string(bytes))
 }
 
+// TenantHeader selects the tenant the super-admin acts on. Without it, the super-admin
+// acts on every tenant.
+const TenantHeader = "X-Tenant-ID"
+
+// AuthenticationMiddleware accepts the admin secret of the super-admin and the API keys of
+// tenants. Requests of a tenant are scoped to it in the storage, and read-only keys can't
+// change anything.
 func AuthenticationMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if r.Header.Get("Authorization") != config.Global.AdminSecret {
+		authorization := r.Header.Get("Authorization")
+		if authorization == config.Get().AdminSecret {
+			ctx := context.WithValue(r.Context(), superAdminContextKey, true)
+			ctx = storage.WithTenant(ctx, r.Header.Get(TenantHeader))
+
+			// Call the next handler, which can be another middleware in the chain, or the final handler.
+			next.ServeHTTP(w, r.WithContext(ctx))
+			return
+		}
+
+		var key *storage.APIKey
+		err := storage.ErrAPIKeyNotFound
+		if authorization != "" {
+			key, err = storage.LicenseHandler.GetAPIKeyByHash(r.Context(), storage.HashAPIKey(authorization))
+		}
+
+		if err != nil {
+			if err != storage.ErrAPIKeyNotFound {
+				logrus.WithError(err).Error("Error while getting API key")
+			}
+
 			ReturnResponse(w, http.StatusUnauthorized, map[string]interface{}{
 				"message": "Authorization failed",
 			})
 			return
 		}
 
-		// Call the next handler, which can be another middleware in the chain, or the final handler.
+		if key.Role == config.RoleReadOnly && r.Method != http.MethodGet {
+			ReturnError(w, http.StatusForbidden, "API key role doesn't allow this operation")
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(storage.WithTenant(r.Context(), key.Tenant)))
+	})
+}
+
+type contextKey string
+
+const superAdminContextKey contextKey = "super-admin"
+
+func isSuperAdmin(r *http.Request) bool {
+	superAdmin, _ := r.Context().Value(superAdminContextKey).(bool)
+	return superAdmin
+}
+
+// ClientCertMiddleware requires a verified and allow-listed client certificate when
+// mutual TLS is enabled, and rejects write requests of read-only clients.
+func ClientCertMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		clientAuth := config.Get().ServerOptions.ClientAuth
+		if !clientAuth.Enable {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+			ReturnError(w, http.StatusUnauthorized, "client certificate required")
+			return
+		}
+
+		client, ok := clientAuth.Match(r.TLS.VerifiedChains[0][0])
+		if !ok {
+			ReturnError(w, http.StatusForbidden, "client certificate not allowed")
+			return
+		}
+
+		role := client.GetRole()
+		switch role {
+		case config.RoleAdmin:
+		case config.RoleReadOnly:
+			if r.Method != http.MethodGet {
+				ReturnError(w, http.StatusForbidden, "client role doesn't allow this operation")
+				return
+			}
+		default:
+			ReturnError(w, http.StatusForbidden, "unknown client role: "+role)
+			return
+		}
+
 		next.ServeHTTP(w, r)
 	})
 }
+
+// RateLimitStore keeps the token buckets of RateLimitMiddleware.
+var RateLimitStore ratelimit.Store = ratelimit.NewMemoryStore()
+
+// RateLimitMiddleware throttles requests by client IP and by the digest of the passed license token.
+func RateLimitMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rateLimit := config.Get().RateLimit
+		if !rateLimit.Enable {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		now := time.Now()
+
+		if rateLimit.PerIP.Enabled() {
+			key := "ip:" + clientIP(r, rateLimit)
+			if !takeToken(w, key, rateLimit.PerIP, now) {
+				return
+			}
+		}
+
+		if token := r.FormValue("token"); token != "" && rateLimit.PerToken.Enabled() {
+			digest := sha256.Sum256([]byte(token))
+			key := "token:" + hex.EncodeToString(digest[:])
+			if !takeToken(w, key, rateLimit.PerToken, now) {
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func takeToken(w http.ResponseWriter, key string, limit ratelimit.Limit, now time.Time) bool {
+	allowed, retryAfter, err := RateLimitStore.Take(key, limit, now)
+	if err != nil {
+		// Don't block verification because of a limiter failure
+		logrus.WithError(err).Error("Error while rate limiting")
+		return true
+	}
+
+	if !allowed {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+		ReturnError(w, http.StatusTooManyRequests, "too many requests")
+		return false
+	}
+
+	return true
+}
+
+// clientIP returns the IP of the client. If forwarded headers are trusted, X-Forwarded-For
+// is walked from the right, since its leftmost entries are set by the client, and the first
+// entry that isn't a trusted proxy is taken.
+func clientIP(r *http.Request, rateLimit config.RateLimit) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	forwardedFor := r.Header["X-Forwarded-For"]
+	if !rateLimit.TrustForwardedFor || len(forwardedFor) == 0 {
+		return host
+	}
+
+	trustedProxies := rateLimit.GetTrustedProxies()
+	if len(trustedProxies) > 0 && !containsIP(trustedProxies, net.ParseIP(host)) {
+		return host
+	}
+
+	hops := strings.Split(strings.Join(forwardedFor, ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if ip := net.ParseIP(hop); ip == nil || !containsIP(trustedProxies, ip) {
+			return hop
+		}
+	}
+
+	return strings.TrimSpace(hops[0])
+}
+
+func containsIP(networks []*net.IPNet, ip net.IP) bool {
+	for _, network := range networks {
+		if ip != nil && network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -1,20 +1,33 @@
 package main
 
 import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/json"
+	"encoding/pem"
 	"fmt"
 	"io/ioutil"
+	"math/big"
 	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/furkansenharputlu/f-license/config"
 	"github.com/furkansenharputlu/f-license/lcs"
+	"github.com/furkansenharputlu/f-license/ratelimit"
 	"github.com/furkansenharputlu/f-license/storage"
 
 	"github.com/stretchr/testify/assert"
 )
 
 func TestGenerateLicense(t *testing.T) {
-	defer storage.LicenseHandler.DropDatabase()
+	defer storage.LicenseHandler.DropDatabase(context.Background())
 
 	path := "/admin/licenses"
 
@@ -31,7 +44,7 @@ func TestGenerateLicense(t *testing.T) {
 }
 
 func TestGetLicense(t *testing.T) {
-	defer storage.LicenseHandler.DropDatabase()
+	defer storage.LicenseHandler.DropDatabase(context.Background())
 
 	path := "/admin/licenses"
 
@@ -63,8 +76,24 @@ func TestGetLicense(t *testing.T) {
 	assert.Equal(t, expectedToken, retLicense.Token)
 }
 
+func TestGetLicenseByToken(t *testing.T) {
+	defer storage.LicenseHandler.DropDatabase(context.Background())
+
+	resp := tr.Run(t, &TestCase{Method: http.MethodPost, Path: "/admin/licenses", Data: sampleLicense(), BodyMatch: `"id":.*"token":"ey.*"`})
+	resBytes, _ := ioutil.ReadAll(resp.Body)
+
+	var resMap map[string]string
+	_ = json.Unmarshal(resBytes, &resMap)
+
+	tr.Run(t, &TestCase{Method: http.MethodGet, Path: "/admin/licenses:lookup?token=" + resMap["token"],
+		BodyMatch: fmt.Sprintf(`"id":"%s"`, resMap["id"])})
+
+	tr.Run(t, &TestCase{Method: http.MethodGet, Path: "/admin/licenses:lookup?token=invalid",
+		BodyMatch: `{"error":"license not found"}`})
+}
+
 func TestVerifyLicense(t *testing.T) {
-	defer storage.LicenseHandler.DropDatabase()
+	defer storage.LicenseHandler.DropDatabase(context.Background())
 
 	path := "/admin/licenses"
 
@@ -82,8 +111,31 @@ func TestVerifyLicense(t *testing.T) {
 	tr.Run(t, &TestCase{Method: http.MethodPost, Path: verifyPath, FormParams: formParams, BodyMatch: `"valid":true`})
 }
 
+func TestKeyLoadErrors(t *testing.T) {
+	defer storage.LicenseHandler.DropDatabase(context.Background())
+
+	resp := tr.Run(t, &TestCase{Method: http.MethodPost, Path: "/admin/licenses", Data: sampleLicense(), BodyMatch: `"id":.*"token":"ey.*"`})
+	var created map[string]string
+	_ = json.NewDecoder(resp.Body).Decode(&created)
+
+	// Keys which can't be loaded fail the requests instead of stopping the server
+	defer setTestConfig(func(c *config.Config) {
+		c.Apps["test-app"].Signature.RSAPrivateKeyFile = "missing.pem"
+		c.Apps["test-app"].Signature.RSAPublicKeyFile = "missing.pem"
+	})()
+
+	resp = tr.Run(t, &TestCase{Method: http.MethodPost, Path: "/admin/licenses", Data: sampleLicense(func(l *lcs.License) {
+		l.Claims["name"] = "Ahmet"
+	}), BodyMatch: `"error":"couldn't load sign key: `})
+	assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+
+	resp = tr.Run(t, &TestCase{Method: http.MethodPost, Path: "/license/verify", FormParams: map[string]string{"token": created["token"]},
+		BodyMatch: `"message":"couldn't load verify key: .*"valid":false`})
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+}
+
 func TestDeleteLicense(t *testing.T) {
-	defer storage.LicenseHandler.DropDatabase()
+	defer storage.LicenseHandler.DropDatabase(context.Background())
 
 	path := "/admin/licenses"
 
@@ -107,7 +159,7 @@ func TestDeleteLicense(t *testing.T) {
 }
 
 func TestChangeLicenseActiveness(t *testing.T) {
-	defer storage.LicenseHandler.DropDatabase()
+	defer storage.LicenseHandler.DropDatabase(context.Background())
 
 	path := "/admin/licenses"
 
@@ -129,3 +181,375 @@ func TestChangeLicenseActiveness(t *testing.T) {
 	tr.Run(t, &TestCase{Method: http.MethodPut, Path: activatePath, BodyMatch: `{"message":"Activated"}`})
 	tr.Run(t, &TestCase{Method: http.MethodPut, Path: activatePath, BodyMatch: `{"error":"already active"}`})
 }
+
+func genCert(t *testing.T, template *x509.Certificate, parent *x509.Certificate, parentKey *rsa.PrivateKey) (*x509.Certificate, *rsa.PrivateKey, tls.Certificate) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+
+	serialNumber, _ := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	template.SerialNumber = serialNumber
+	template.NotBefore = time.Now().Add(-time.Minute)
+	template.NotAfter = time.Now().Add(time.Hour)
+
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+
+	derBytes, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	assert.NoError(t, err)
+
+	cert, err := x509.ParseCertificate(derBytes)
+	assert.NoError(t, err)
+
+	return cert, key, tls.Certificate{Certificate: [][]byte{derBytes}, PrivateKey: key}
+}
+
+func genCA(t *testing.T, commonName string) (*x509.Certificate, *rsa.PrivateKey) {
+	ca, caKey, _ := genCert(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: commonName},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil, nil)
+
+	return ca, caKey
+}
+
+func genClientCert(t *testing.T, ca *x509.Certificate, caKey *rsa.PrivateKey, commonName string, dnsNames ...string) tls.Certificate {
+	_, _, cert := genCert(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: commonName},
+		DNSNames:    dnsNames,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, ca, caKey)
+
+	return cert
+}
+
+func TestClientCertMiddleware(t *testing.T) {
+	ca, caKey := genCA(t, "f-license test CA")
+	otherCA, otherCAKey := genCA(t, "other CA")
+
+	caFile, _ := ioutil.TempFile("", "ca.pem")
+	defer caFile.Close()
+	_ = pem.Encode(caFile, &pem.Block{Type: "CERTIFICATE", Bytes: ca.Raw})
+
+	defer setTestConfig(func(c *config.Config) {
+		c.ServerOptions.ClientAuth = config.ClientAuth{
+			Enable: true,
+			CAFile: caFile.Name(),
+			Clients: []config.Client{
+				{Subject: "billing", Role: config.RoleReadOnly},
+				{SANs: []string{"ops.example.com"}},
+			},
+		}
+	})()
+
+	tlsConfig, err := config.Get().ServerOptions.BuildTLSConfig()
+	assert.NoError(t, err)
+
+	server := httptest.NewUnstartedServer(GenerateRouter())
+	server.TLS = tlsConfig
+	server.StartTLS()
+	defer server.Close()
+
+	do := func(method, path string, certs ...tls.Certificate) (*http.Response, string, error) {
+		transport := server.Client().Transport.(*http.Transport).Clone()
+		transport.TLSClientConfig.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			if len(certs) == 0 {
+				return &tls.Certificate{}, nil
+			}
+			return &certs[0], nil
+		}
+		c := &http.Client{Transport: transport}
+
+		r, _ := http.NewRequest(method, server.URL+path, nil)
+		r.Header.Set("Authorization", config.Get().AdminSecret)
+
+		resp, err := c.Do(r)
+		if err != nil {
+			return nil, "", err
+		}
+		defer resp.Body.Close()
+
+		body, _ := ioutil.ReadAll(resp.Body)
+		return resp, string(body), nil
+	}
+
+	t.Run("license routes don't require certificate", func(t *testing.T) {
+		resp, _, err := do(http.MethodPost, "/license/ping")
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	})
+
+	t.Run("admin routes require certificate", func(t *testing.T) {
+		resp, body, err := do(http.MethodGet, "/admin/licenses")
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+		assert.Contains(t, body, "client certificate required")
+	})
+
+	t.Run("certificate from unknown CA", func(t *testing.T) {
+		_, _, err := do(http.MethodGet, "/admin/licenses", genClientCert(t, otherCA, otherCAKey, "billing"))
+		assert.Error(t, err)
+	})
+
+	t.Run("not allowed certificate", func(t *testing.T) {
+		resp, body, err := do(http.MethodGet, "/admin/licenses", genClientCert(t, ca, caKey, "intruder"))
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+		assert.Contains(t, body, "client certificate not allowed")
+	})
+
+	t.Run("read-only role", func(t *testing.T) {
+		cert := genClientCert(t, ca, caKey, "billing")
+
+		resp, body, err := do(http.MethodPut, "/admin/licenses/invalid-id/activate", cert)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+		assert.Contains(t, body, "client role doesn't allow this operation")
+
+		resp, _, err = do(http.MethodGet, "/admin/licenses", cert)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	})
+
+	t.Run("admin role matched by SAN", func(t *testing.T) {
+		cert := genClientCert(t, ca, caKey, "ops", "ops.example.com")
+
+		resp, body, err := do(http.MethodDelete, "/admin/licenses/invalid-id/delete", cert)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+		assert.Contains(t, body, "ID format error")
+	})
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	oldStore := RateLimitStore
+	defer func() {
+		RateLimitStore = oldStore
+	}()
+
+	defer setTestConfig(func(c *config.Config) {
+		c.RateLimit = config.RateLimit{
+			Enable:            true,
+			PerIP:             ratelimit.Limit{Rate: 0.1, Burst: 3},
+			PerToken:          ratelimit.Limit{Rate: 0.5, Burst: 1},
+			TrustForwardedFor: true,
+		}
+	})()
+
+	ping := func(ip, token string) *http.Response {
+		form := fmt.Sprintf("token=%s", token)
+		r, _ := http.NewRequest(http.MethodPost, tr.server.URL+"/license/ping", strings.NewReader(form))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r.Header.Set("X-Forwarded-For", ip)
+
+		resp, err := tr.client.Do(r)
+		assert.NoError(t, err)
+		_ = resp.Body.Close()
+
+		return resp
+	}
+
+	t.Run("per IP", func(t *testing.T) {
+		RateLimitStore = ratelimit.NewMemoryStore()
+
+		for i := 0; i < 3; i++ {
+			assert.Equal(t, http.StatusOK, ping("10.0.0.1", fmt.Sprintf("token-%d", i)).StatusCode)
+		}
+
+		resp := ping("10.0.0.1", "token-3")
+		assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+		assert.Equal(t, "10", resp.Header.Get("Retry-After"))
+
+		assert.Equal(t, http.StatusOK, ping("10.0.0.2", "token-4").StatusCode)
+	})
+
+	t.Run("per token", func(t *testing.T) {
+		RateLimitStore = ratelimit.NewMemoryStore()
+
+		assert.Equal(t, http.StatusOK, ping("10.0.0.1", "same-token").StatusCode)
+
+		resp := ping("10.0.0.2", "same-token")
+		assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+		assert.Equal(t, "2", resp.Header.Get("Retry-After"))
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		RateLimitStore = ratelimit.NewMemoryStore()
+		defer setTestConfig(func(c *config.Config) {
+			c.RateLimit.Enable = false
+		})()
+
+		for i := 0; i < 5; i++ {
+			assert.Equal(t, http.StatusOK, ping("10.0.0.1", "same-token").StatusCode)
+		}
+	})
+}
+
+func TestClientIP(t *testing.T) {
+	request := func(remoteAddr string, forwardedFor ...string) *http.Request {
+		r := httptest.NewRequest(http.MethodPost, "/license/verify", nil)
+		r.RemoteAddr = remoteAddr
+		for _, value := range forwardedFor {
+			r.Header.Add("X-Forwarded-For", value)
+		}
+
+		return r
+	}
+
+	rateLimit := config.RateLimit{}
+	assert.Equal(t, "10.0.0.1", clientIP(request("10.0.0.1:1234", "192.0.2.1"), rateLimit))
+
+	rateLimit.TrustForwardedFor = true
+	assert.Equal(t, "10.0.0.1", clientIP(request("10.0.0.1:1234"), rateLimit))
+	assert.Equal(t, "192.0.2.1", clientIP(request("10.0.0.1:1234", "192.0.2.1"), rateLimit))
+	assert.Equal(t, "192.0.2.1", clientIP(request("10.0.0.1:1234", "198.51.100.7, 192.0.2.1"), rateLimit), "spoofed entries are skipped")
+	assert.Equal(t, "192.0.2.1", clientIP(request("10.0.0.1:1234", "198.51.100.7", "192.0.2.1"), rateLimit))
+
+	rateLimit.TrustedProxies = []string{"10.0.0.0/8"}
+	assert.Equal(t, "192.0.2.1", clientIP(request("10.0.0.1:1234", "198.51.100.7, 192.0.2.1, 10.0.0.2"), rateLimit))
+	assert.Equal(t, "10.0.0.3", clientIP(request("10.0.0.1:1234", "10.0.0.3, 10.0.0.2"), rateLimit))
+	assert.Equal(t, "192.0.2.9", clientIP(request("192.0.2.9:1234", "198.51.100.7"), rateLimit), "untrusted peers can't forward")
+}
+
+func TestMetrics(t *testing.T) {
+	defer storage.LicenseHandler.DropDatabase(context.Background())
+
+	tr.Run(t, &TestCase{Method: http.MethodPost, Path: "/license/ping"})
+
+	resp := tr.Run(t, &TestCase{Method: http.MethodPost, Path: "/admin/licenses", Data: sampleLicense(), BodyMatch: `"id":.*"token":"ey.*"`})
+	resBytes, _ := ioutil.ReadAll(resp.Body)
+
+	var resMap map[string]string
+	_ = json.Unmarshal(resBytes, &resMap)
+
+	tr.Run(t, &TestCase{Method: http.MethodPost, Path: "/license/verify", FormParams: map[string]string{"token": resMap["token"]}, BodyMatch: `"valid":true`})
+
+	resp = tr.Run(t, &TestCase{Method: http.MethodGet, Path: "/metrics"})
+	resBytes, _ = ioutil.ReadAll(resp.Body)
+	body := string(resBytes)
+
+	assert.Regexp(t, `f_license_http_requests_total\{method="POST",route="/license/ping",status="200"\} \d+`, body)
+	assert.Regexp(t, `f_license_http_request_duration_seconds_count\{method="POST",route="/admin/licenses"\} \d+`, body)
+	assert.Regexp(t, `f_license_verifications_total\{app="",outcome="valid",reason="ok"\} \d+`, body)
+	assert.Regexp(t, `f_license_licenses_generated_total\{app=""\} \d+`, body)
+	assert.Regexp(t, `f_license_storage_operation_duration_seconds_count\{operation="get_by_token"\} \d+`, body)
+}
+
+func TestHealthz(t *testing.T) {
+	tr.Run(t, &TestCase{Method: http.MethodGet, Path: "/healthz", BodyMatch: `{"status":"ok"}`})
+}
+
+func TestReadyz(t *testing.T) {
+	tr.Run(t, &TestCase{Method: http.MethodGet, Path: "/readyz",
+		BodyMatch: `"components":{"config":{"status":"ok"},"keys":{"status":"ok"},"storage":{"status":"ok"}},"status":"ok"`})
+
+	defer setTestConfig(func(c *config.Config) {
+		c.Port = 0
+	})()
+
+	resp := tr.Run(t, &TestCase{Method: http.MethodGet, Path: "/readyz",
+		BodyMatch: `"config":{"error":"invalid configuration: invalid port: 0","status":"fail"}.*"status":"unavailable"`})
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+}
+
+func TestRequestContextCancelsStorage(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	r := httptest.NewRequest(http.MethodGet, "/admin/licenses", nil).WithContext(ctx)
+	w := httptest.NewRecorder()
+
+	GetAllLicenses(w, r)
+
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Contains(t, w.Body.String(), "context canceled")
+}
+
+func TestGetAllLicenses(t *testing.T) {
+	defer storage.LicenseHandler.DropDatabase(context.Background())
+
+	path := "/admin/licenses"
+
+	tr.Run(t, &TestCase{Method: http.MethodPost, Path: path, Data: sampleLicense(func(l *lcs.License) {
+		l.Headers["app"] = "test-app"
+	}), BodyMatch: `"id":.*"token":"ey.*"`})
+	tr.Run(t, &TestCase{Method: http.MethodPost, Path: path, Data: sampleLicense(func(l *lcs.License) {
+		l.Claims["name"] = "Ahmet"
+	}), BodyMatch: `"id":.*"token":"ey.*"`})
+
+	resp := tr.Run(t, &TestCase{Method: http.MethodGet, Path: path + "?app=test-app", BodyMatch: `^\[{"id":"\w+","headers":{"alg":"RS512","app":"test-app"`})
+	assert.Equal(t, "1", resp.Header.Get("X-Total-Count"))
+
+	resp = tr.Run(t, &TestCase{Method: http.MethodGet, Path: path + "?claim=name=Ahmet&active=true", BodyMatch: `^\[{.*"name":"Ahmet".*}\]$`})
+	assert.Equal(t, "1", resp.Header.Get("X-Total-Count"))
+
+	resp = tr.Run(t, &TestCase{Method: http.MethodGet, Path: path + "?limit=1&offset=1", BodyMatch: `^\[{.*"name":"Ahmet".*}\]$`})
+	assert.Equal(t, "2", resp.Header.Get("X-Total-Count"))
+
+	tr.Run(t, &TestCase{Method: http.MethodGet, Path: path + "?active=false", BodyMatch: `^\[\]$`})
+}
+
+func TestGetAllLicenses_InvalidQuery(t *testing.T) {
+	path := "/admin/licenses"
+
+	tr.Run(t, &TestCase{Method: http.MethodGet, Path: path + "?active=maybe", BodyMatch: `{"error":"invalid active: maybe"}`})
+	tr.Run(t, &TestCase{Method: http.MethodGet, Path: path + "?claim=name", BodyMatch: `{"error":"invalid claim filter, expected key=value: name"}`})
+	tr.Run(t, &TestCase{Method: http.MethodGet, Path: path + "?limit=-1", BodyMatch: `{"error":"invalid limit: -1"}`})
+	tr.Run(t, &TestCase{Method: http.MethodGet, Path: path + "?offset=x", BodyMatch: `{"error":"invalid offset: x"}`})
+}
+
+func TestGenerateLicenses(t *testing.T) {
+	defer storage.LicenseHandler.DropDatabase(context.Background())
+
+	path := "/admin/licenses:batch"
+
+	batch := func(atomic bool) BatchRequest {
+		return BatchRequest{
+			Licenses: []*lcs.License{
+				sampleLicense(),
+				sampleLicense(func(l *lcs.License) { l.Claims["name"] = "Ahmet" }),
+				sampleLicense(),
+			},
+			Atomic: atomic,
+		}
+	}
+
+	t.Run("atomic", func(t *testing.T) {
+		resp := tr.Run(t, &TestCase{Method: http.MethodPost, Path: path, Data: batch(true),
+			BodyMatch: `"failed":3,"results":\[{"index":0,"error":"rolled back because of other failures in the batch"},` +
+				`{"index":1,"error":"rolled back because of other failures in the batch"},` +
+				`{"index":2,"error":"there is already such license with ID: \w+"}\],"succeeded":0`})
+		assert.Equal(t, http.StatusConflict, resp.StatusCode)
+
+		resp = tr.Run(t, &TestCase{Method: http.MethodGet, Path: "/admin/licenses", BodyMatch: `^\[\]$`})
+		assert.Equal(t, "0", resp.Header.Get("X-Total-Count"))
+	})
+
+	t.Run("per license", func(t *testing.T) {
+		resp := tr.Run(t, &TestCase{Method: http.MethodPost, Path: path, Data: batch(false),
+			BodyMatch: `"failed":1,"results":\[{"index":0,"id":"\w+","token":"ey[^"]*"},{"index":1,"id":"\w+","token":"ey[^"]*"},` +
+				`{"index":2,"error":"there is already such license with ID: \w+"}\],"succeeded":2`})
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	})
+
+	t.Run("empty license", func(t *testing.T) {
+		resp := tr.Run(t, &TestCase{Method: http.MethodPost, Path: path, Data: map[string]interface{}{"licenses": []interface{}{nil}},
+			BodyMatch: `"failed":1,"results":\[{"index":0,"error":"license is empty"}\]`})
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	})
+
+	t.Run("too large", func(t *testing.T) {
+		defer setTestConfig(func(c *config.Config) { c.MaxBatchSize = 2 })()
+
+		resp := tr.Run(t, &TestCase{Method: http.MethodPost, Path: path, Data: batch(false),
+			BodyMatch: `"error":"batch has 3 licenses, at most 2 are allowed"`})
+		assert.Equal(t, http.StatusRequestEntityTooLarge, resp.StatusCode)
+	})
+
+	t.Run("invalid body", func(t *testing.T) {
+		resp := tr.Run(t, &TestCase{Method: http.MethodPost, Path: path, Data: "licenses", BodyMatch: `couldn't decode batch`})
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	})
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/furkansenharputlu/f-license/config"
+	"github.com/furkansenharputlu/f-license/lcs"
+	"github.com/furkansenharputlu/f-license/storage"
+
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+)
+
+// appResponse is an app returned by the API. Its HMAC secret is never returned, only
+// whether it has one.
+type appResponse struct {
+	*config.App
+	HasHMACSecret bool `json:"has_hmac_secret"`
+}
+
+func newAppResponse(app *config.App) appResponse {
+	redacted := *app
+	redacted.Signature.HMACSecret = ""
+
+	return appResponse{App: &redacted, HasHMACSecret: app.Signature.HMACSecret != ""}
+}
+
+func CreateApp(w http.ResponseWriter, r *http.Request) {
+	app, ok := readApp(w, r)
+	if !ok {
+		return
+	}
+
+	err := storage.LicenseHandler.CreateApp(r.Context(), app)
+	if err != nil {
+		logrus.WithError(err).Error("App couldn't be stored")
+		ReturnError(w, appErrorStatus(err), err.Error())
+		return
+	}
+
+	logrus.Infof("App is successfully created: %s", app.Name)
+
+	ReturnResponse(w, http.StatusOK, newAppResponse(app))
+}
+
+func GetApps(w http.ResponseWriter, r *http.Request) {
+	apps, err := storage.LicenseHandler.ListApps(r.Context())
+	if err != nil {
+		ReturnError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	res := make([]appResponse, 0, len(apps))
+	for _, app := range apps {
+		res = append(res, newAppResponse(app))
+	}
+
+	ReturnResponse(w, http.StatusOK, res)
+}
+
+func GetApp(w http.ResponseWriter, r *http.Request) {
+	app, err := storage.LicenseHandler.GetApp(r.Context(), mux.Vars(r)["name"])
+	if err != nil {
+		ReturnError(w, appErrorStatus(err), err.Error())
+		return
+	}
+
+	ReturnResponse(w, http.StatusOK, newAppResponse(app))
+}
+
+func UpdateApp(w http.ResponseWriter, r *http.Request) {
+	app, ok := readApp(w, r)
+	if !ok {
+		return
+	}
+
+	err := storage.LicenseHandler.UpdateApp(r.Context(), app)
+	if err != nil {
+		logrus.WithError(err).Error("App couldn't be updated")
+		ReturnError(w, appErrorStatus(err), err.Error())
+		return
+	}
+
+	logrus.Infof("App is successfully updated: %s", app.Name)
+
+	ReturnResponse(w, http.StatusOK, newAppResponse(app))
+}
+
+func DeleteApp(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+
+	err := storage.LicenseHandler.DeleteApp(r.Context(), name)
+	if err != nil {
+		logrus.WithError(err).Error("App couldn't be deleted")
+		ReturnError(w, appErrorStatus(err), err.Error())
+		return
+	}
+
+	logrus.Infof("App is successfully deleted: %s", name)
+
+	ReturnResponse(w, http.StatusOK, map[string]interface{}{
+		"message": "App successfully deleted",
+	})
+}
+
+// readApp reads the app in the request body and checks its keys can be loaded. The name
+// in the path, if any, overrides the one in the body. Keys of the apps stored with the API
+// keys of tenants are checked to be their own before they are loaded.
+func readApp(w http.ResponseWriter, r *http.Request) (*config.App, bool) {
+	bytes, _ := ioutil.ReadAll(r.Body)
+
+	var app config.App
+	if err := json.Unmarshal(bytes, &app); err != nil {
+		ReturnError(w, http.StatusBadRequest, "invalid app: "+err.Error())
+		return nil, false
+	}
+
+	if name, ok := mux.Vars(r)["name"]; ok {
+		app.Name = name
+	}
+
+	// The super-admin can use any keys, also when acting on a tenant
+	if tenant := storage.TenantOf(r.Context()); tenant != "" && !isSuperAdmin(r) {
+		if err := checkTenantKeys(tenant, app.Signature); err != nil {
+			ReturnError(w, http.StatusForbidden, err.Error())
+			return nil, false
+		}
+	}
+
+	if err := lcs.CheckApp(&app); err != nil {
+		ReturnError(w, http.StatusBadRequest, err.Error())
+		return nil, false
+	}
+
+	// The super-admin can store apps of any existing tenant
+	if app.Tenant != "" && storage.TenantOf(r.Context()) == "" {
+		if _, err := storage.LicenseHandler.GetTenant(r.Context(), app.Tenant); err != nil {
+			ReturnError(w, tenantErrorStatus(err), err.Error())
+			return nil, false
+		}
+	}
+
+	return &app, true
+}
+
+// checkTenantKeys checks the signature of an app of the tenant uses only keys of the
+// tenant: an inline HMAC secret, or keystore and KMS keys whose IDs start with the tenant.
+// Key files of the server and keys of other apps can't be referenced.
+func checkTenantKeys(tenant string, signature config.Signature) error {
+	if signature.RSAPrivateKeyFile != "" || signature.RSAPublicKeyFile != "" {
+		return errors.New("apps of tenants can't use key files")
+	}
+
+	if signature.KeyID != "" && !strings.HasPrefix(signature.KeyID, tenant+"/") {
+		return fmt.Errorf("key_id of the apps of tenant %s must start with %q", tenant, tenant+"/")
+	}
+
+	return nil
+}
+
+func appErrorStatus(err error) int {
+	switch err {
+	case storage.ErrAppNotFound:
+		return http.StatusNotFound
+	case storage.ErrAppExists, storage.ErrAppInUse:
+		return http.StatusConflict
+	}
+
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"testing"
+
+	"github.com/furkansenharputlu/f-license/config"
+	"github.com/furkansenharputlu/f-license/lcs"
+	"github.com/furkansenharputlu/f-license/storage"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func sampleApp(name string) *config.App {
+	return &config.App{
+		Name:      name,
+		Alg:       "HS256",
+		Signature: config.Signature{HMACSecret: "stored-secret"},
+		Plans:     []config.Plan{{Name: "pro", Typ: "Subscription"}},
+	}
+}
+
+func TestApps(t *testing.T) {
+	defer storage.LicenseHandler.DropDatabase(context.Background())
+
+	path := "/admin/apps"
+
+	t.Run("create", func(t *testing.T) {
+		resp := tr.Run(t, &TestCase{Method: http.MethodPost, Path: path, Data: sampleApp("stored-app"),
+			BodyMatch: `^{"name":"stored-app","alg":"HS256","signature":{"hmac_secret":"".*"plans":\[{"name":"pro","typ":"Subscription"}\],"has_hmac_secret":true}$`})
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		resp = tr.Run(t, &TestCase{Method: http.MethodPost, Path: path, Data: sampleApp("stored-app"), BodyMatch: `"error":"app already exists"`})
+		assert.Equal(t, http.StatusConflict, resp.StatusCode)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		app := sampleApp("")
+		app.Alg = "XX256"
+		resp := tr.Run(t, &TestCase{Method: http.MethodPost, Path: path, Data: app,
+			BodyMatch: `"error":"invalid app: name is empty; unknown alg \\"XX256\\""`})
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+		app = sampleApp("rsa-app")
+		app.Alg = "RS256"
+		resp = tr.Run(t, &TestCase{Method: http.MethodPost, Path: path, Data: app, BodyMatch: `"error":"invalid app keys: `})
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	})
+
+	t.Run("get and list", func(t *testing.T) {
+		resp := tr.Run(t, &TestCase{Method: http.MethodGet, Path: path + "/stored-app", BodyMatch: `^{"name":"stored-app","alg":"HS256".*"has_hmac_secret":true}$`})
+		body, _ := ioutil.ReadAll(resp.Body)
+		assert.NotContains(t, string(body), "stored-secret")
+
+		resp = tr.Run(t, &TestCase{Method: http.MethodGet, Path: path, BodyMatch: `^\[{"name":"stored-app","alg":"HS256"`})
+		body, _ = ioutil.ReadAll(resp.Body)
+		assert.NotContains(t, string(body), "stored-secret")
+
+		resp = tr.Run(t, &TestCase{Method: http.MethodGet, Path: path + "/unknown", BodyMatch: `"error":"app not found"`})
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	})
+
+	t.Run("update", func(t *testing.T) {
+		app := sampleApp("ignored")
+		app.Alg = "HS512"
+		tr.Run(t, &TestCase{Method: http.MethodPut, Path: path + "/stored-app", Data: app, BodyMatch: `^{"name":"stored-app","alg":"HS512"`})
+
+		resp := tr.Run(t, &TestCase{Method: http.MethodPut, Path: path + "/unknown", Data: app, BodyMatch: `"error":"app not found"`})
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	})
+
+	t.Run("licenses use stored apps", func(t *testing.T) {
+		l := sampleLicense(func(l *lcs.License) { l.Headers["app"] = "stored-app" })
+		assert.NoError(t, l.Generate())
+		assert.Equal(t, "HS512", l.GetAlg())
+		assert.Equal(t, "stored-secret", l.Signature.HMACSecret)
+		assert.NoError(t, storage.LicenseHandler.AddIfNotExisting(context.Background(), l))
+
+		// Configured apps are still used when there is no stored one
+		configured, err := l.GetApp("test-app")
+		assert.NoError(t, err)
+		assert.Equal(t, "RS512", configured.Alg)
+
+		resp := tr.Run(t, &TestCase{Method: http.MethodDelete, Path: path + "/stored-app", BodyMatch: `"error":"app has licenses"`})
+		assert.Equal(t, http.StatusConflict, resp.StatusCode)
+
+		assert.NoError(t, storage.LicenseHandler.DeleteByID(context.Background(), l.ID.Hex()))
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		tr.Run(t, &TestCase{Method: http.MethodDelete, Path: path + "/stored-app", BodyMatch: `"message":"App successfully deleted"`})
+
+		resp := tr.Run(t, &TestCase{Method: http.MethodDelete, Path: path + "/stored-app", BodyMatch: `"error":"app not found"`})
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	})
+}
+
+type failingAppStore struct{}
+
+func (failingAppStore) GetApp(ctx context.Context, tenant, name string) (*config.App, error) {
+	return nil, errors.New("connection lost")
+}
+
+func TestLicense_GetApp_StoreError(t *testing.T) {
+	defer func(original lcs.AppStore) { lcs.Apps = original }(lcs.Apps)
+	lcs.Apps = failingAppStore{}
+
+	// Configured apps don't need the stored apps
+	_, err := sampleLicense().GetApp("test-app")
+	assert.NoError(t, err)
+
+	_, err = sampleLicense().GetApp("stored-app")
+	assert.EqualError(t, err, "connection lost")
+}
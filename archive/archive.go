@@ -0,0 +1,175 @@
+// Package archive exports and imports the license database as a versioned JSONL archive
+// which doesn't depend on the storage backend.
+//
+// The first line of an archive is a header record, and every following line is a record
+// of one stored entity, e.g.
+//
+//	{"kind":"header","version":1,"created_at":"2020-05-01T10:00:00Z"}
+//	{"kind":"license","hash":"1234","license":{"id":"...","headers":{...},"claims":{...},...}}
+package archive
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/furkansenharputlu/f-license/lcs"
+	"github.com/furkansenharputlu/f-license/storage"
+)
+
+// Version is the archive format version written by Export.
+const Version = 1
+
+const (
+	KindHeader  = "header"
+	KindLicense = "license"
+)
+
+type record struct {
+	Kind      string       `json:"kind"`
+	Version   int          `json:"version,omitempty"`
+	CreatedAt *time.Time   `json:"created_at,omitempty"`
+	Hash      string       `json:"hash,omitempty"`
+	License   *lcs.License `json:"license,omitempty"`
+	// PreviousTokens keep the tokens replaced by renewals verifiable after an import.
+	PreviousTokens []lcs.PreviousToken `json:"previous_tokens,omitempty"`
+}
+
+// Export writes every license of the storage to w.
+func Export(ctx context.Context, h storage.Handler, w io.Writer) (count int, err error) {
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+
+	now := time.Now().UTC()
+	err = enc.Encode(record{Kind: KindHeader, Version: Version, CreatedAt: &now})
+	if err != nil {
+		return 0, err
+	}
+
+	err = h.Iterate(ctx, func(l *lcs.License) error {
+		count++
+		// Hash and previous tokens are not part of the JSON form of a license
+		return enc.Encode(record{Kind: KindLicense, Hash: l.Hash, PreviousTokens: l.PreviousTokens, License: l})
+	})
+	if err != nil {
+		return count, err
+	}
+
+	return count, bw.Flush()
+}
+
+// ConflictPolicy decides what happens when an imported license has the same ID or hash
+// as a stored one.
+type ConflictPolicy string
+
+const (
+	// Skip keeps the stored license.
+	Skip ConflictPolicy = "skip"
+	// Overwrite replaces the stored license with the imported one.
+	Overwrite ConflictPolicy = "overwrite"
+	// Fail stops the import.
+	Fail ConflictPolicy = "fail"
+)
+
+func ParseConflictPolicy(s string) (ConflictPolicy, error) {
+	switch p := ConflictPolicy(s); p {
+	case Skip, Overwrite, Fail:
+		return p, nil
+	}
+
+	return "", fmt.Errorf("unknown conflict policy %q, should be one of: skip, overwrite, fail", s)
+}
+
+// Stats counts the imported records.
+type Stats struct {
+	Imported    int `json:"imported"`
+	Skipped     int `json:"skipped"`
+	Overwritten int `json:"overwritten"`
+}
+
+// Import reads an archive written by Export and stores its licenses keeping their IDs and hashes.
+func Import(ctx context.Context, h storage.Handler, r io.Reader, policy ConflictPolicy) (Stats, error) {
+	var stats Stats
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+
+	lineNumber := 0
+	headerRead := false
+	for scanner.Scan() {
+		lineNumber++
+
+		var rec record
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			return stats, fmt.Errorf("line %d: %s", lineNumber, err)
+		}
+
+		if !headerRead {
+			if rec.Kind != KindHeader {
+				return stats, errors.New("archive doesn't start with a header")
+			}
+
+			if rec.Version > Version {
+				return stats, fmt.Errorf("archive version %d is not supported, should be at most %d", rec.Version, Version)
+			}
+
+			headerRead = true
+			continue
+		}
+
+		switch rec.Kind {
+		case KindLicense:
+			if rec.License == nil {
+				return stats, fmt.Errorf("line %d: license record without license", lineNumber)
+			}
+
+			rec.License.Hash = rec.Hash
+			rec.License.PreviousTokens = rec.PreviousTokens
+			if err := importLicense(ctx, h, rec.License, policy, &stats); err != nil {
+				return stats, fmt.Errorf("line %d: %s", lineNumber, err)
+			}
+		default:
+			return stats, fmt.Errorf("line %d: unknown record kind %q", lineNumber, rec.Kind)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return stats, err
+	}
+
+	if !headerRead {
+		return stats, errors.New("archive is empty")
+	}
+
+	return stats, nil
+}
+
+func importLicense(ctx context.Context, h storage.Handler, l *lcs.License, policy ConflictPolicy, stats *Stats) error {
+	err := h.Insert(ctx, l)
+	if err == nil {
+		stats.Imported++
+		return nil
+	}
+
+	if err != storage.ErrLicenseExists {
+		return err
+	}
+
+	switch policy {
+	case Skip:
+		stats.Skipped++
+		return nil
+	case Overwrite:
+		if err := h.Replace(ctx, l); err != nil {
+			return err
+		}
+		stats.Overwritten++
+		return nil
+	}
+
+	return fmt.Errorf("license %s: %s", l.ID.Hex(), err)
+}
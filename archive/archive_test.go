@@ -0,0 +1,145 @@
+package archive
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/furkansenharputlu/f-license/lcs"
+	"github.com/furkansenharputlu/f-license/storage"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// memoryHandler implements the parts of storage.Handler used by archives.
+type memoryHandler struct {
+	storage.Handler
+	licenses []*lcs.License
+}
+
+func (h *memoryHandler) Iterate(ctx context.Context, fn func(l *lcs.License) error) error {
+	for _, l := range h.licenses {
+		if err := fn(l); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (h *memoryHandler) Insert(ctx context.Context, l *lcs.License) error {
+	for _, existing := range h.licenses {
+		if existing.ID == l.ID || existing.Hash == l.Hash {
+			return storage.ErrLicenseExists
+		}
+	}
+
+	h.licenses = append(h.licenses, l)
+
+	return nil
+}
+
+func (h *memoryHandler) Replace(ctx context.Context, l *lcs.License) error {
+	var licenses []*lcs.License
+	for _, existing := range h.licenses {
+		if existing.ID != l.ID && existing.Hash != l.Hash {
+			licenses = append(licenses, existing)
+		}
+	}
+
+	h.licenses = append(licenses, l)
+
+	return nil
+}
+
+func sampleLicense(name, hash string) *lcs.License {
+	return &lcs.License{
+		ID:      primitive.NewObjectID(),
+		Headers: map[string]interface{}{"typ": "Trial", "alg": "HS256"},
+		Claims:  jwt.MapClaims{"name": name},
+		Token:   "ey." + name,
+		Hash:    hash,
+		Active:  true,
+	}
+}
+
+func TestExportImport(t *testing.T) {
+	ctx := context.Background()
+	renewed := sampleLicense("Ahmet", "2")
+	renewed.PreviousTokens = []lcs.PreviousToken{{Hash: "3", Kept: true}}
+	source := &memoryHandler{licenses: []*lcs.License{sampleLicense("Furkan", "1"), renewed}}
+
+	var buf bytes.Buffer
+	count, err := Export(ctx, source, &buf)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, count)
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	assert.Len(t, lines, 3)
+	assert.Regexp(t, `^{"kind":"header","version":1,"created_at":".*"}$`, lines[0])
+	assert.Regexp(t, `^{"kind":"license","hash":"1","license":{"id":"`+source.licenses[0].ID.Hex()+`",`, lines[1])
+
+	t.Run("into empty storage", func(t *testing.T) {
+		target := &memoryHandler{}
+		stats, err := Import(ctx, target, bytes.NewReader(buf.Bytes()), Fail)
+		assert.NoError(t, err)
+		assert.Equal(t, Stats{Imported: 2}, stats)
+		assert.Equal(t, source.licenses, target.licenses)
+	})
+
+	conflicting := func() *memoryHandler {
+		existing := sampleLicense("Mehmet", "2")
+		return &memoryHandler{licenses: []*lcs.License{existing}}
+	}
+
+	t.Run("skip", func(t *testing.T) {
+		target := conflicting()
+		stats, err := Import(ctx, target, bytes.NewReader(buf.Bytes()), Skip)
+		assert.NoError(t, err)
+		assert.Equal(t, Stats{Imported: 1, Skipped: 1}, stats)
+		assert.Equal(t, "Mehmet", target.licenses[0].Claims["name"])
+	})
+
+	t.Run("overwrite", func(t *testing.T) {
+		target := conflicting()
+		stats, err := Import(ctx, target, bytes.NewReader(buf.Bytes()), Overwrite)
+		assert.NoError(t, err)
+		assert.Equal(t, Stats{Imported: 1, Overwritten: 1}, stats)
+		assert.Equal(t, source.licenses, target.licenses)
+	})
+
+	t.Run("fail", func(t *testing.T) {
+		target := conflicting()
+		stats, err := Import(ctx, target, bytes.NewReader(buf.Bytes()), Fail)
+		assert.EqualError(t, err, "line 3: license "+source.licenses[1].ID.Hex()+": license already exists")
+		assert.Equal(t, Stats{Imported: 1}, stats)
+	})
+}
+
+func TestImport_InvalidArchive(t *testing.T) {
+	ctx := context.Background()
+
+	_, err := Import(ctx, &memoryHandler{}, strings.NewReader(""), Fail)
+	assert.EqualError(t, err, "archive is empty")
+
+	_, err = Import(ctx, &memoryHandler{}, strings.NewReader(`{"kind":"license"}`), Fail)
+	assert.EqualError(t, err, "archive doesn't start with a header")
+
+	_, err = Import(ctx, &memoryHandler{}, strings.NewReader(`{"kind":"header","version":2}`), Fail)
+	assert.EqualError(t, err, "archive version 2 is not supported, should be at most 1")
+
+	_, err = Import(ctx, &memoryHandler{}, strings.NewReader(`{"kind":"header","version":1}`+"\n"+`{"kind":"activation"}`), Fail)
+	assert.EqualError(t, err, `line 2: unknown record kind "activation"`)
+}
+
+func TestParseConflictPolicy(t *testing.T) {
+	p, err := ParseConflictPolicy("overwrite")
+	assert.NoError(t, err)
+	assert.Equal(t, Overwrite, p)
+
+	_, err = ParseConflictPolicy("merge")
+	assert.Error(t, err)
+}
@@ -0,0 +1,345 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/furkansenharputlu/f-license/billing"
+	"github.com/furkansenharputlu/f-license/config"
+	"github.com/furkansenharputlu/f-license/lcs"
+	"github.com/furkansenharputlu/f-license/storage"
+	"github.com/furkansenharputlu/f-license/webhook"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/sirupsen/logrus"
+)
+
+// maxBillingEventSize limits the body of the events of the payment provider.
+const maxBillingEventSize = 1 << 20
+
+// actionCreateCustomer is reported for the events creating customers.
+const actionCreateCustomer = "create_customer"
+
+// billingEventLease is how long an event is processing before another delivery of it can
+// process it again, e.g. after the server processing it stopped.
+const billingEventLease = 5 * time.Minute
+
+// billingEventTimeout is how long processing an event can take. Events are processed on
+// their own context, so that they are not left half processed when the provider closes
+// the request.
+const billingEventTimeout = time.Minute
+
+// BillingWebhook receives the events of the payment provider and generates, renews,
+// suspends or revokes the licenses of subscriptions by the rules of their plans. An event
+// is processed once however many times it is delivered: deliveries of an event which is
+// being processed are answered with a conflict, and failed events are released so that
+// the provider can deliver them again.
+func BillingWebhook(w http.ResponseWriter, r *http.Request) {
+	c := config.Get().Billing
+	if !c.Enable {
+		ReturnError(w, http.StatusNotFound, "billing is not enabled")
+		return
+	}
+
+	provider, err := billing.ProviderOf(c.Provider)
+	if err != nil {
+		ReturnError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, maxBillingEventSize))
+	if err != nil {
+		ReturnError(w, http.StatusBadRequest, "couldn't read event: "+err.Error())
+		return
+	}
+
+	if err := provider.Verify(r.Header, body, c.WebhookSecret, c.GetTolerance(), time.Now()); err != nil {
+		ReturnError(w, http.StatusBadRequest, "invalid signature: "+err.Error())
+		return
+	}
+
+	e, err := provider.Parse(body)
+	if err != nil {
+		ReturnError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if e.Kind == "" {
+		ReturnResponse(w, http.StatusOK, map[string]interface{}{"message": "Event ignored"})
+		return
+	}
+
+	err = storage.LicenseHandler.ClaimBillingEvent(r.Context(), &storage.BillingEvent{ID: e.ID, Type: e.Type, ReceivedAt: time.Now().UTC()}, billingEventLease)
+	switch err {
+	case nil:
+	case storage.ErrBillingEventExists:
+		ReturnResponse(w, http.StatusOK, map[string]interface{}{"message": "Event already processed"})
+		return
+	case storage.ErrBillingEventProcessing:
+		ReturnError(w, http.StatusConflict, err.Error())
+		return
+	default:
+		ReturnError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	entry := logrus.WithFields(logrus.Fields{"event": e.ID, "type": e.Type})
+
+	ctx, cancel := context.WithTimeout(context.Background(), billingEventTimeout)
+	defer cancel()
+
+	action, err := processBillingEvent(ctx, e)
+	if err != nil {
+		entry.WithError(err).Error("Billing event couldn't be processed")
+
+		if err := storage.LicenseHandler.DeleteBillingEvent(context.Background(), e.ID); err != nil {
+			entry.WithError(err).Error("Billing event couldn't be deleted to be processed again")
+		}
+
+		ReturnError(w, customerErrorStatus(err), err.Error())
+		return
+	}
+
+	if err := storage.LicenseHandler.CompleteBillingEvent(context.Background(), e.ID); err != nil {
+		// The event is processed again after its lease expires
+		entry.WithError(err).Error("Billing event couldn't be completed")
+		ReturnError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	entry.WithField("action", action).Info("Billing event processed")
+
+	ReturnResponse(w, http.StatusOK, map[string]interface{}{"message": "Event processed", "action": action})
+}
+
+// processBillingEvent takes the action of the event by the rules of its plan, and returns
+// the action. Events of unknown plans are ignored.
+func processBillingEvent(ctx context.Context, e *billing.Event) (string, error) {
+	if e.Kind == billing.KindCustomerCreated {
+		return actionCreateCustomer, createBillingCustomer(ctx, e)
+	}
+
+	plan, ok := config.Get().Billing.Plans[e.Plan]
+	if !ok || plan == nil {
+		return config.ActionIgnore, nil
+	}
+
+	action := plan.Action(e.Kind)
+
+	var err error
+	switch action {
+	case config.ActionGenerate:
+		err = generateSubscriptionLicenses(ctx, e, plan)
+	case config.ActionRenew:
+		err = renewSubscriptionLicenses(ctx, e, plan)
+	case config.ActionSuspend, config.ActionRevoke:
+		err = inactivateSubscriptionLicenses(ctx, e, action == config.ActionRevoke)
+	}
+
+	return action, err
+}
+
+func createBillingCustomer(ctx context.Context, e *billing.Event) error {
+	if _, err := billingCustomer(ctx, e.CustomerID); err != storage.ErrCustomerNotFound {
+		return err
+	}
+
+	c := &lcs.Customer{Name: e.CustomerName, Email: e.CustomerEmail, BillingID: e.CustomerID}
+	if c.Name == "" {
+		c.Name = c.Email
+	}
+
+	if err := c.Validate(); err != nil {
+		return err
+	}
+
+	return storage.LicenseHandler.CreateCustomer(ctx, c)
+}
+
+// billingCustomer returns the customer with the billing ID.
+func billingCustomer(ctx context.Context, billingID string) (*lcs.Customer, error) {
+	customers, err := storage.LicenseHandler.ListCustomers(ctx, storage.CustomerFilter{BillingID: billingID})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(customers) == 0 {
+		return nil, storage.ErrCustomerNotFound
+	}
+
+	return customers[0], nil
+}
+
+// generateSubscriptionLicenses creates the order of the subscription and generates a
+// license for every seat of it, both in the tenant of the customer.
+func generateSubscriptionLicenses(ctx context.Context, e *billing.Event, plan *config.BillingPlan) error {
+	o, err := storage.LicenseHandler.GetOrderByBillingID(ctx, e.SubscriptionID)
+	switch err {
+	case nil:
+		ctx = storage.WithTenant(ctx, o.Tenant)
+
+		// The order is created but its licenses may not be, if a previous attempt failed
+		n, err := storage.LicenseHandler.Count(ctx, storage.ListOptions{OrderID: o.ID.Hex()})
+		if err != nil || n > 0 {
+			return err
+		}
+	case storage.ErrOrderNotFound:
+		customer, err := billingCustomer(ctx, e.CustomerID)
+		if err != nil {
+			return err
+		}
+
+		ctx = storage.WithTenant(ctx, customer.Tenant)
+
+		o = &lcs.Order{CustomerID: customer.ID.Hex(), BillingID: e.SubscriptionID, App: plan.App, Plan: plan.Plan, Quantity: e.Quantity}
+		if err := storage.LicenseHandler.CreateOrder(ctx, o); err != nil {
+			return err
+		}
+	default:
+		return err
+	}
+
+	seats := e.Quantity
+	if seats < 1 {
+		seats = 1
+	}
+
+	licenses := make([]*lcs.License, 0, seats)
+	for seat := 1; seat <= seats; seat++ {
+		licenses = append(licenses, subscriptionLicense(e, plan, o, seat))
+	}
+
+	results, failed := storage.GenerateBatch(ctx, storage.LicenseHandler, licenses, true)
+	if failed > 0 {
+		for _, res := range results {
+			if res.Error != "" {
+				return errors.New(res.Error)
+			}
+		}
+	}
+
+	for _, l := range licenses {
+		licensesGenerated.WithLabelValues(l.GetAppName()).Inc()
+		publish(ctx, webhook.EventGenerated, l)
+	}
+
+	return nil
+}
+
+// subscriptionLicense returns the license of the seat of the order, with the type and the
+// claims of the plan of the app.
+func subscriptionLicense(e *billing.Event, plan *config.BillingPlan, o *lcs.Order, seat int) *lcs.License {
+	l := &lcs.License{
+		Active:     true,
+		Headers:    map[string]interface{}{"app": plan.App},
+		Claims:     jwt.MapClaims{},
+		CustomerID: o.CustomerID,
+		OrderID:    o.ID.Hex(),
+	}
+
+	if app, err := l.GetApp(plan.App); err == nil {
+		if appPlan := app.GetPlan(plan.Plan); appPlan != nil {
+			if appPlan.Typ != "" {
+				l.Headers["typ"] = appPlan.Typ
+			}
+
+			for k, v := range appPlan.Claims {
+				l.Claims[k] = v
+			}
+		}
+	}
+
+	// Seats are told apart so that their tokens differ
+	l.Claims["seat"] = seat
+	l.Claims["exp"] = float64(subscriptionExpiry(e, plan).Unix())
+
+	return l
+}
+
+// subscriptionExpiry is when the licenses of the paid period of the subscription expire.
+func subscriptionExpiry(e *billing.Event, plan *config.BillingPlan) time.Time {
+	return e.PeriodEnd.AddDate(0, 0, plan.GraceDays)
+}
+
+func subscriptionLicenses(ctx context.Context, subscriptionID string) ([]*lcs.License, error) {
+	o, err := storage.LicenseHandler.GetOrderByBillingID(ctx, subscriptionID)
+	if err != nil {
+		return nil, err
+	}
+
+	var licenses []*lcs.License
+	_, err = storage.LicenseHandler.List(ctx, storage.ListOptions{OrderID: o.ID.Hex()}, &licenses)
+
+	return licenses, err
+}
+
+// renewSubscriptionLicenses extends the licenses of the subscription until the end of the
+// new paid period, activating the suspended ones. The old tokens stay valid until they
+// expire, so that instances keep working until they fetch the new tokens. Revoked licenses
+// are not renewed.
+func renewSubscriptionLicenses(ctx context.Context, e *billing.Event, plan *config.BillingPlan) error {
+	licenses, err := subscriptionLicenses(ctx, e.SubscriptionID)
+	if err != nil {
+		return err
+	}
+
+	for _, l := range licenses {
+		if l.RevokedAt != nil {
+			continue
+		}
+
+		// Suspended licenses are activated, and licenses already renewed for the period are kept
+		err := storage.RenewLicense(ctx, storage.LicenseHandler, l, subscriptionExpiry(e, plan), true, true)
+		if err != nil && err != lcs.ErrExpiryNotExtended {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// inactivateSubscriptionLicenses inactivates the active licenses of the subscription, or
+// revokes every license of it which is not revoked yet. Customers are emailed only about
+// revoked licenses, as suspended ones are activated again when the subscription is renewed.
+func inactivateSubscriptionLicenses(ctx context.Context, e *billing.Event, revoke bool) error {
+	licenses, err := subscriptionLicenses(ctx, e.SubscriptionID)
+	if err != nil {
+		return err
+	}
+
+	for _, l := range licenses {
+		if revoke {
+			if l.RevokedAt != nil {
+				continue
+			}
+
+			now := time.Now().UTC().Truncate(time.Millisecond)
+			if err := storage.LicenseHandler.Revoke(ctx, l.ID.Hex(), now); err != nil {
+				return err
+			}
+
+			l.Active, l.RevokedAt = false, &now
+			publish(ctx, webhook.EventInactivated, l)
+			continue
+		}
+
+		if !l.Active {
+			continue
+		}
+
+		if err := storage.LicenseHandler.Activate(ctx, l.ID.Hex(), true); err != nil {
+			return err
+		}
+
+		l.Active = false
+		if err := storage.Publish(ctx, storage.LicenseHandler, webhook.EventInactivated, l); err != nil {
+			logrus.WithError(err).WithField("event", webhook.EventInactivated).Error("Webhook event couldn't be published")
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,57 @@
+package billing
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/furkansenharputlu/f-license/config"
+)
+
+// KindCustomerCreated is the kind of the events creating customers, which are not mapped
+// by the rules of plans.
+const KindCustomerCreated = "customer.created"
+
+// Event is an event of the payment provider translated to the kinds of config, e.g.
+// config.BillingSubscriptionRenewed. Kind is empty for the events which are ignored.
+type Event struct {
+	ID   string
+	Kind string
+	// Type is the type of the event in the provider.
+	Type      string
+	CreatedAt time.Time
+	// CustomerID and SubscriptionID are the IDs in the provider, the billing IDs of the
+	// customer and the order of the licenses.
+	CustomerID     string
+	CustomerName   string
+	CustomerEmail  string
+	SubscriptionID string
+	// Plan is the ID of the plan in the provider, the key of config.Billing.Plans.
+	Plan     string
+	Quantity int
+	// PeriodEnd is when the paid period of the subscription ends.
+	PeriodEnd time.Time
+}
+
+// Provider verifies and parses the webhook requests of a payment provider.
+type Provider interface {
+	// Verify checks the signature of the request sent with the headers and the body at
+	// now. Requests signed more than tolerance before or after now are rejected.
+	Verify(h http.Header, body []byte, secret string, tolerance time.Duration, now time.Time) error
+	Parse(body []byte) (*Event, error)
+}
+
+// Providers are the supported payment providers by their names in the configuration.
+var Providers = map[string]Provider{
+	config.BillingProviderStripe: Stripe{},
+}
+
+// ProviderOf returns the provider with the name.
+func ProviderOf(name string) (Provider, error) {
+	p, ok := Providers[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown billing provider: %s", name)
+	}
+
+	return p, nil
+}
@@ -0,0 +1,92 @@
+package billing
+
+import (
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/furkansenharputlu/f-license/config"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func fixture(t *testing.T, name string) []byte {
+	body, err := ioutil.ReadFile(filepath.Join("testdata", "stripe", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return body
+}
+
+func TestStripe_Verify(t *testing.T) {
+	body := fixture(t, "customer_created.json")
+	now := time.Unix(1709251200, 0)
+	stripe := Stripe{}
+
+	h := http.Header{}
+	h.Set(StripeSignatureHeader, stripe.Sign("whsec_test", now.Unix(), body))
+
+	assert.NoError(t, stripe.Verify(h, body, "whsec_test", time.Minute, now))
+	assert.EqualError(t, stripe.Verify(h, body, "whsec_other", time.Minute, now), "signature mismatch")
+	assert.EqualError(t, stripe.Verify(h, body[1:], "whsec_test", time.Minute, now), "signature mismatch")
+	assert.EqualError(t, stripe.Verify(h, body, "whsec_test", time.Minute, now.Add(-2*time.Minute)), "timestamp is out of tolerance")
+
+	// Any of the signatures can match while the secret is rolled
+	h.Set(StripeSignatureHeader, "t=1709251200,v1=00ff,"+stripe.Sign("whsec_test", now.Unix(), body)[len("t=1709251200,"):]+",v0=legacy")
+	assert.NoError(t, stripe.Verify(h, body, "whsec_test", time.Minute, now))
+
+	h.Set(StripeSignatureHeader, "v1=00ff")
+	assert.EqualError(t, stripe.Verify(h, body, "whsec_test", time.Minute, now), "invalid timestamp")
+}
+
+func TestStripe_Parse(t *testing.T) {
+	stripe := Stripe{}
+	subscription := "sub_1OqA0bLkdIwHu7ixZr4T8sYh"
+	plan := "price_1OqA00LkdIwHu7ixPro"
+
+	tests := []struct {
+		fixture  string
+		expected Event
+	}{
+		{"customer_created.json", Event{ID: "evt_1OqA0aLkdIwHu7ix2cT5fJ0a", Kind: KindCustomerCreated, Type: "customer.created",
+			CreatedAt: time.Unix(1709251200, 0).UTC(), CustomerID: "cus_PfVq3X2bZ9kLmN", CustomerName: "Jane Doe", CustomerEmail: "jane@acme.com"}},
+		{"subscription_created.json", Event{ID: "evt_1OqA0cLkdIwHu7ixQw8RrV1b", Kind: config.BillingSubscriptionCreated, Type: "customer.subscription.created",
+			CreatedAt: time.Unix(1709251205, 0).UTC(), CustomerID: "cus_PfVq3X2bZ9kLmN", SubscriptionID: subscription, Plan: plan, Quantity: 2,
+			PeriodEnd: time.Unix(1740787204, 0).UTC()}},
+		// The first invoice is ignored as the subscription is created by its own event
+		{"invoice_paid_create.json", Event{ID: "evt_1OqA0eLkdIwHu7ixK3nS6uP2", Type: "invoice.paid", CreatedAt: time.Unix(1709251207, 0).UTC()}},
+		{"invoice_payment_failed.json", Event{ID: "evt_1PzB7fLkdIwHu7ixR2d8mN4q", Kind: config.BillingPaymentFailed, Type: "invoice.payment_failed",
+			CreatedAt: time.Unix(1740787500, 0).UTC(), CustomerID: "cus_PfVq3X2bZ9kLmN", CustomerName: "Jane Doe", CustomerEmail: "jane@acme.com",
+			SubscriptionID: subscription, Plan: plan, Quantity: 2, PeriodEnd: time.Unix(1772323204, 0).UTC()}},
+		{"invoice_paid_cycle.json", Event{ID: "evt_1PzD2kLkdIwHu7ixA0v3cZ9e", Kind: config.BillingSubscriptionRenewed, Type: "invoice.paid",
+			CreatedAt: time.Unix(1740960400, 0).UTC(), CustomerID: "cus_PfVq3X2bZ9kLmN", CustomerName: "Jane Doe", CustomerEmail: "jane@acme.com",
+			SubscriptionID: subscription, Plan: plan, Quantity: 2, PeriodEnd: time.Unix(1772323204, 0).UTC()}},
+		{"subscription_deleted.json", Event{ID: "evt_1QaF9pLkdIwHu7ixT6w1yB8r", Kind: config.BillingSubscriptionCancelled, Type: "customer.subscription.deleted",
+			CreatedAt: time.Unix(1750000000, 0).UTC(), CustomerID: "cus_PfVq3X2bZ9kLmN", SubscriptionID: subscription, Plan: plan, Quantity: 2,
+			PeriodEnd: time.Unix(1772323204, 0).UTC()}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.fixture, func(t *testing.T) {
+			e, err := stripe.Parse(fixture(t, test.fixture))
+			assert.NoError(t, err)
+			assert.Equal(t, &test.expected, e)
+		})
+	}
+
+	_, err := stripe.Parse([]byte(`{"type":"invoice.paid"}`))
+	assert.EqualError(t, err, "invalid event: id or type is empty")
+
+	_, err = stripe.Parse([]byte(`{"id":"evt_1","type":"customer.created","data":{"object":{"email":1}}}`))
+	assert.EqualError(t, err, "invalid customer.created event: json: cannot unmarshal number into Go struct field stripeCustomer.email of type string")
+
+	e, err := stripe.Parse([]byte(`{"id":"evt_1","type":"charge.refunded","data":{"object":{}}}`))
+	assert.NoError(t, err)
+	assert.Empty(t, e.Kind)
+
+	_, err = ProviderOf("paypal")
+	assert.EqualError(t, err, "unknown billing provider: paypal")
+}
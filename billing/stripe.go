@@ -0,0 +1,192 @@
+package billing
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/furkansenharputlu/f-license/config"
+)
+
+// StripeSignatureHeader is the header Stripe signs the events with, e.g.
+// "t=1600000000,v1=<signature>".
+const StripeSignatureHeader = "Stripe-Signature"
+
+// Stripe is the Stripe provider. Customers are created by customer.created events, and
+// the subscriptions are followed by the customer.subscription.created,
+// customer.subscription.deleted, invoice.paid and invoice.payment_failed events.
+type Stripe struct{}
+
+// Sign returns the signature header value of the body sent at the timestamp, which has
+// the hex encoded HMAC-SHA256 of "<timestamp>.<body>" with the secret.
+func (Stripe) Sign(secret string, timestamp int64, body []byte) string {
+	return fmt.Sprintf("t=%d,v1=%s", timestamp, stripeSignature(secret, timestamp, body))
+}
+
+func stripeSignature(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10) + "."))
+	mac.Write(body)
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (Stripe) Verify(h http.Header, body []byte, secret string, tolerance time.Duration, now time.Time) error {
+	var timestamp int64 = -1
+	var signatures []string
+	for _, pair := range strings.Split(h.Get(StripeSignatureHeader), ",") {
+		kv := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+
+		switch kv[0] {
+		case "t":
+			if t, err := strconv.ParseInt(kv[1], 10, 64); err == nil {
+				timestamp = t
+			}
+		case "v1":
+			// There are several signatures while the secret is rolled
+			signatures = append(signatures, kv[1])
+		}
+	}
+
+	if timestamp < 0 {
+		return errors.New("invalid timestamp")
+	}
+
+	signedAt := time.Unix(timestamp, 0)
+	if now.Sub(signedAt) > tolerance || signedAt.Sub(now) > tolerance {
+		return errors.New("timestamp is out of tolerance")
+	}
+
+	expected := []byte(stripeSignature(secret, timestamp, body))
+	for _, signature := range signatures {
+		if hmac.Equal([]byte(signature), expected) {
+			return nil
+		}
+	}
+
+	return errors.New("signature mismatch")
+}
+
+type stripeEvent struct {
+	ID      string `json:"id"`
+	Type    string `json:"type"`
+	Created int64  `json:"created"`
+	Data    struct {
+		Object json.RawMessage `json:"object"`
+	} `json:"data"`
+}
+
+type stripeCustomer struct {
+	ID    string `json:"id"`
+	Name  string `json:"name"`
+	Email string `json:"email"`
+}
+
+type stripeSubscription struct {
+	ID               string `json:"id"`
+	Customer         string `json:"customer"`
+	CurrentPeriodEnd int64  `json:"current_period_end"`
+	Items            struct {
+		Data []struct {
+			Price    stripePrice `json:"price"`
+			Quantity int         `json:"quantity"`
+		} `json:"data"`
+	} `json:"items"`
+}
+
+type stripeInvoice struct {
+	ID            string `json:"id"`
+	Customer      string `json:"customer"`
+	CustomerName  string `json:"customer_name"`
+	CustomerEmail string `json:"customer_email"`
+	Subscription  string `json:"subscription"`
+	BillingReason string `json:"billing_reason"`
+	Lines         struct {
+		Data []struct {
+			Price    stripePrice `json:"price"`
+			Quantity int         `json:"quantity"`
+			Period   struct {
+				End int64 `json:"end"`
+			} `json:"period"`
+		} `json:"data"`
+	} `json:"lines"`
+}
+
+type stripePrice struct {
+	ID string `json:"id"`
+}
+
+func (Stripe) Parse(body []byte) (*Event, error) {
+	var se stripeEvent
+	if err := json.Unmarshal(body, &se); err != nil {
+		return nil, fmt.Errorf("invalid event: %s", err)
+	}
+
+	if se.ID == "" || se.Type == "" {
+		return nil, errors.New("invalid event: id or type is empty")
+	}
+
+	e := &Event{ID: se.ID, Type: se.Type, CreatedAt: time.Unix(se.Created, 0).UTC()}
+
+	var err error
+	switch se.Type {
+	case "customer.created":
+		var c stripeCustomer
+		if err = json.Unmarshal(se.Data.Object, &c); err == nil {
+			e.Kind = KindCustomerCreated
+			e.CustomerID, e.CustomerName, e.CustomerEmail = c.ID, c.Name, c.Email
+		}
+	case "customer.subscription.created", "customer.subscription.deleted":
+		var s stripeSubscription
+		if err = json.Unmarshal(se.Data.Object, &s); err == nil {
+			e.Kind = config.BillingSubscriptionCreated
+			if se.Type == "customer.subscription.deleted" {
+				e.Kind = config.BillingSubscriptionCancelled
+			}
+
+			e.CustomerID, e.SubscriptionID = s.Customer, s.ID
+			e.PeriodEnd = time.Unix(s.CurrentPeriodEnd, 0).UTC()
+			if len(s.Items.Data) > 0 {
+				e.Plan, e.Quantity = s.Items.Data[0].Price.ID, s.Items.Data[0].Quantity
+			}
+		}
+	case "invoice.paid", "invoice.payment_failed":
+		var i stripeInvoice
+		if err = json.Unmarshal(se.Data.Object, &i); err != nil {
+			break
+		}
+
+		// The first invoice of a subscription is paid when the subscription is created
+		if (se.Type == "invoice.paid" && i.BillingReason != "subscription_cycle") || i.Subscription == "" {
+			return e, nil
+		}
+
+		e.Kind = config.BillingSubscriptionRenewed
+		if se.Type == "invoice.payment_failed" {
+			e.Kind = config.BillingPaymentFailed
+		}
+
+		e.CustomerID, e.CustomerName, e.CustomerEmail, e.SubscriptionID = i.Customer, i.CustomerName, i.CustomerEmail, i.Subscription
+		if len(i.Lines.Data) > 0 {
+			line := i.Lines.Data[0]
+			e.Plan, e.Quantity = line.Price.ID, line.Quantity
+			e.PeriodEnd = time.Unix(line.Period.End, 0).UTC()
+		}
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s event: %s", se.Type, err)
+	}
+
+	return e, nil
+}
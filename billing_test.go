@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/furkansenharputlu/f-license/billing"
+	"github.com/furkansenharputlu/f-license/config"
+	"github.com/furkansenharputlu/f-license/lcs"
+	"github.com/furkansenharputlu/f-license/storage"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setBilling(c *config.Config) {
+	c.Billing = config.Billing{Enable: true, Provider: config.BillingProviderStripe, WebhookSecret: "whsec_test",
+		Plans: map[string]*config.BillingPlan{
+			"price_1OqA00LkdIwHu7ixPro": {App: "test-app", GraceDays: 7},
+		}}
+}
+
+// sendBillingEvent posts the recorded event signed now.
+func sendBillingEvent(t *testing.T, fixture, bodyMatch string) *http.Response {
+	recorded, err := ioutil.ReadFile(filepath.Join("billing", "testdata", "stripe", fixture))
+	assert.NoError(t, err)
+
+	// The test runner sends the compacted JSON
+	body, _ := json.Marshal(json.RawMessage(recorded))
+	signature := billing.Stripe{}.Sign("whsec_test", time.Now().Unix(), body)
+
+	return tr.Run(t, &TestCase{Method: http.MethodPost, Path: "/billing/webhook", Data: json.RawMessage(body),
+		Headers: map[string]string{billing.StripeSignatureHeader: signature}, BodyMatch: bodyMatch})
+}
+
+func TestBillingWebhook(t *testing.T) {
+	defer storage.LicenseHandler.DropDatabase(context.Background())
+	defer setTestConfig(setBilling)()
+
+	ctx := context.Background()
+
+	licenses := func(t *testing.T) []*lcs.License {
+		o, err := storage.LicenseHandler.GetOrderByBillingID(ctx, "sub_1OqA0bLkdIwHu7ixZr4T8sYh")
+		assert.NoError(t, err)
+
+		var licenses []*lcs.License
+		_, err = storage.LicenseHandler.List(ctx, storage.ListOptions{OrderID: o.ID.Hex()}, &licenses)
+		assert.NoError(t, err)
+
+		return licenses
+	}
+
+	t.Run("unknown customer is retried", func(t *testing.T) {
+		resp := sendBillingEvent(t, "subscription_created.json", `"error":"customer not found"`)
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	})
+
+	t.Run("customer is created", func(t *testing.T) {
+		sendBillingEvent(t, "customer_created.json", `"action":"create_customer","message":"Event processed"`)
+		sendBillingEvent(t, "customer_created.json", `"message":"Event already processed"`)
+
+		customers, err := storage.LicenseHandler.ListCustomers(ctx, storage.CustomerFilter{BillingID: "cus_PfVq3X2bZ9kLmN"})
+		assert.NoError(t, err)
+		if assert.Len(t, customers, 1) {
+			assert.Equal(t, "Jane Doe", customers[0].Name)
+			assert.Equal(t, "jane@acme.com", customers[0].Email)
+		}
+	})
+
+	t.Run("events being processed are retried after their lease", func(t *testing.T) {
+		event := &storage.BillingEvent{ID: "evt_1OqA0cLkdIwHu7ixQw8RrV1b", Type: "customer.subscription.created", ReceivedAt: time.Now().UTC()}
+		assert.NoError(t, storage.LicenseHandler.ClaimBillingEvent(ctx, event, time.Minute))
+
+		resp := sendBillingEvent(t, "subscription_created.json", `"error":"billing event is being processed"`)
+		assert.Equal(t, http.StatusConflict, resp.StatusCode)
+
+		// The next delivery takes over the expired lease
+		assert.NoError(t, storage.LicenseHandler.DeleteBillingEvent(ctx, event.ID))
+		assert.NoError(t, storage.LicenseHandler.ClaimBillingEvent(ctx, event, -time.Minute))
+	})
+
+	var tokens []string
+	t.Run("licenses of seats are generated", func(t *testing.T) {
+		sendBillingEvent(t, "subscription_created.json", `"action":"generate"`)
+		sendBillingEvent(t, "subscription_created.json", `"message":"Event already processed"`)
+		sendBillingEvent(t, "invoice_paid_create.json", `"message":"Event ignored"`)
+
+		generated := licenses(t)
+		assert.Len(t, generated, 2)
+		for _, l := range generated {
+			assert.True(t, l.Active)
+			assert.Equal(t, "test-app", l.GetAppName())
+
+			expiresAt, _ := l.ExpiresAt()
+			assert.Equal(t, time.Unix(1740787204, 0).AddDate(0, 0, 7).Unix(), expiresAt.Unix())
+
+			tokens = append(tokens, l.Token)
+		}
+		assert.NotEqual(t, tokens[0], tokens[1])
+	})
+
+	t.Run("licenses are suspended and renewed", func(t *testing.T) {
+		sendBillingEvent(t, "invoice_payment_failed.json", `"action":"suspend"`)
+		for _, l := range licenses(t) {
+			assert.False(t, l.Active)
+		}
+
+		sendBillingEvent(t, "invoice_paid_cycle.json", `"action":"renew"`)
+		for i, l := range licenses(t) {
+			assert.True(t, l.Active)
+			assert.NotEqual(t, tokens[i], l.Token)
+
+			// Instances keep working with the old tokens until they fetch the new ones
+			if previous := l.PreviousToken(tokens[i]); assert.NotNil(t, previous) {
+				assert.True(t, previous.Kept)
+			}
+
+			expiresAt, _ := l.ExpiresAt()
+			assert.Equal(t, time.Unix(1772323204, 0).AddDate(0, 0, 7).Unix(), expiresAt.Unix())
+		}
+	})
+
+	t.Run("licenses are revoked", func(t *testing.T) {
+		sendBillingEvent(t, "subscription_deleted.json", `"action":"revoke"`)
+		for _, l := range licenses(t) {
+			assert.False(t, l.Active)
+			assert.NotNil(t, l.RevokedAt)
+		}
+
+		// Revoked licenses are not renewed
+		require.NoError(t, storage.LicenseHandler.DeleteBillingEvent(ctx, "evt_1PzD2kLkdIwHu7ixA0v3cZ9e"))
+		sendBillingEvent(t, "invoice_paid_cycle.json", `"action":"renew"`)
+		for _, l := range licenses(t) {
+			assert.False(t, l.Active)
+		}
+	})
+
+	t.Run("rules of plans", func(t *testing.T) {
+		defer setTestConfig(func(c *config.Config) {
+			setBilling(c)
+			c.Billing.Plans["price_1OqA00LkdIwHu7ixPro"].Rules = map[string]string{config.BillingSubscriptionRenewed: config.ActionIgnore}
+		})()
+
+		_ = storage.LicenseHandler.DeleteBillingEvent(ctx, "evt_1PzD2kLkdIwHu7ixA0v3cZ9e")
+		sendBillingEvent(t, "invoice_paid_cycle.json", `"action":"ignore"`)
+		for _, l := range licenses(t) {
+			assert.False(t, l.Active)
+		}
+	})
+
+	t.Run("processed events are pruned", func(t *testing.T) {
+		n, err := storage.LicenseHandler.PruneBillingEvents(ctx, time.Now().Add(time.Minute))
+		assert.NoError(t, err)
+		assert.True(t, n > 0)
+
+		sendBillingEvent(t, "customer_created.json", `"action":"create_customer","message":"Event processed"`)
+	})
+
+	t.Run("invalid requests", func(t *testing.T) {
+		resp := tr.Run(t, &TestCase{Method: http.MethodPost, Path: "/billing/webhook", Data: map[string]string{"id": "evt_1"},
+			Headers: map[string]string{billing.StripeSignatureHeader: "t=1,v1=00"}, BodyMatch: `"error":"invalid signature: timestamp is out of tolerance"`})
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+		resp = tr.Run(t, &TestCase{Method: http.MethodPost, Path: "/billing/webhook", Data: map[string]string{"id": "evt_1"},
+			BodyMatch: `"error":"invalid signature: invalid timestamp"`})
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+		defer setTestConfig(func(c *config.Config) { c.Billing.Enable = false })()
+		resp = sendBillingEvent(t, "customer_created.json", `"error":"billing is not enabled"`)
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	})
+}
+
+func TestBillingWebhook_Tenant(t *testing.T) {
+	defer storage.LicenseHandler.DropDatabase(context.Background())
+	defer setTestConfig(setBilling)()
+
+	ctx := context.Background()
+	acme := storage.WithTenant(ctx, "acme")
+
+	require.NoError(t, storage.LicenseHandler.CreateTenant(ctx, &storage.Tenant{ID: "acme", Name: "Acme"}))
+	require.NoError(t, storage.LicenseHandler.CreateCustomer(acme, &lcs.Customer{Name: "Jane Doe", Email: "jane@acme.com", BillingID: "cus_PfVq3X2bZ9kLmN"}))
+
+	// The order and the licenses of the subscription belong to the tenant of the customer
+	sendBillingEvent(t, "subscription_created.json", `"action":"generate"`)
+
+	o, err := storage.LicenseHandler.GetOrderByBillingID(acme, "sub_1OqA0bLkdIwHu7ixZr4T8sYh")
+	require.NoError(t, err)
+	assert.Equal(t, "acme", o.Tenant)
+
+	var licenses []*lcs.License
+	_, err = storage.LicenseHandler.List(acme, storage.ListOptions{OrderID: o.ID.Hex()}, &licenses)
+	require.NoError(t, err)
+	assert.Len(t, licenses, 2)
+	for _, l := range licenses {
+		assert.Equal(t, "acme", l.Tenant)
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+
+	"github.com/furkansenharputlu/f-license/config"
+
+	"github.com/spf13/cobra"
+)
+
+// appColumns are the default table and CSV columns of apps.
+var appColumns = []string{"name", "alg"}
+
+var appsCmd = &cobra.Command{
+	Use:   "apps",
+	Short: "Manage apps stored at runtime",
+	Long: `Manage apps stored at runtime. Stored apps are used before the apps of the config file
+having the same name, and an app can't be deleted while it has licenses.`,
+}
+
+var appsListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List stored apps",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		apps, err := licenseBackend.ListApps()
+		checkErr(err)
+
+		checkErr(printOutput(cmd, apps, appColumns...))
+	},
+}
+
+var appsGetCmd = &cobra.Command{
+	Use:   "get <name>",
+	Short: "Get a stored app",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		app, err := licenseBackend.GetApp(args[0])
+		checkErr(err)
+
+		checkErr(printOutput(cmd, app, appColumns...))
+	},
+}
+
+var appsCreateCmd = &cobra.Command{
+	Use:   "create <app.json>",
+	Short: "Create an app from a JSON file",
+	Long: `Create an app from a JSON file having its name, alg, signature and plans, e.g.
+{"name": "my-app", "alg": "RS256", "signature": {"rsa_private_key_file": "keys/my-app_private_key.pem",
+"rsa_public_key_file": "keys/my-app_public_key.pem"}, "plans": [{"name": "pro", "typ": "Subscription"}]}`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		app, err := readAppFile(args[0])
+		checkErr(err)
+
+		checkErr(licenseBackend.CreateApp(app))
+
+		checkErr(printOutput(cmd, app, appColumns...))
+	},
+}
+
+var appsUpdateCmd = &cobra.Command{
+	Use:   "update <app.json>",
+	Short: "Replace a stored app with the one in a JSON file",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		app, err := readAppFile(args[0])
+		checkErr(err)
+
+		checkErr(licenseBackend.UpdateApp(app))
+
+		checkErr(printOutput(cmd, app, appColumns...))
+	},
+}
+
+var appsDeleteCmd = &cobra.Command{
+	Use:   "delete <name>",
+	Short: "Delete a stored app without licenses",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		checkErr(licenseBackend.DeleteApp(args[0]))
+
+		checkErr(printMessage(cmd, "App successfully deleted"))
+	},
+}
+
+func readAppFile(filePath string) (*config.App, error) {
+	appBytes, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	var app config.App
+	err = json.Unmarshal(appBytes, &app)
+	if err != nil {
+		return nil, err
+	}
+
+	return &app, nil
+}
+
+func addAppsCommands() {
+	appsCmd.AddCommand(appsListCmd)
+	appsCmd.AddCommand(appsGetCmd)
+	appsCmd.AddCommand(appsCreateCmd)
+	appsCmd.AddCommand(appsUpdateCmd)
+	appsCmd.AddCommand(appsDeleteCmd)
+}
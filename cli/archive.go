@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+
+	"github.com/furkansenharputlu/f-license/archive"
+	"github.com/furkansenharputlu/f-license/storage"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// localStorage returns the storage for the commands which work only on the database.
+func localStorage() (storage.Handler, error) {
+	if _, ok := licenseBackend.(localBackend); !ok {
+		return nil, errors.New("this command works directly on the database, don't pass a server")
+	}
+
+	return storage.LicenseHandler, nil
+}
+
+// localContext scopes the operations of the commands which work only on the database to
+// the tenant, if it is given.
+func localContext() context.Context {
+	b, _ := licenseBackend.(localBackend)
+	return b.context()
+}
+
+var exportCmd = &cobra.Command{
+	Use:   "export [archive.jsonl]",
+	Short: "Export all licenses to an archive",
+	Long: `Export all licenses to a versioned JSONL archive which can be imported into any storage.
+The archive is written to the standard output if no file is given.`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		h, err := localStorage()
+		checkErr(err)
+
+		var w io.Writer = cmd.OutOrStdout()
+		if len(args) == 1 && args[0] != "-" {
+			f, err := os.OpenFile(args[0], os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+			checkErr(err)
+			defer f.Close()
+			w = f
+		}
+
+		count, err := archive.Export(localContext(), h, w)
+		checkErr(err)
+
+		logrus.Infof("%d licenses exported", count)
+	},
+}
+
+var importOnConflictFlag = string(archive.Fail)
+
+var importCmd = &cobra.Command{
+	Use:   "import <archive.jsonl>",
+	Short: "Import licenses from an archive",
+	Long: `Import licenses from an archive written by export, keeping their IDs and hashes.
+--on-conflict decides what happens to licenses having the same ID or hash as a stored one.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		h, err := localStorage()
+		checkErr(err)
+
+		policy, err := archive.ParseConflictPolicy(importOnConflictFlag)
+		checkErr(err)
+
+		var r io.Reader = os.Stdin
+		if args[0] != "-" {
+			f, err := os.Open(args[0])
+			checkErr(err)
+			defer f.Close()
+			r = f
+		}
+
+		stats, err := archive.Import(localContext(), h, r, policy)
+		if err != nil {
+			logrus.Infof("Before the failure %d licenses imported, %d skipped and %d overwritten", stats.Imported, stats.Skipped, stats.Overwritten)
+		}
+		checkErr(err)
+
+		checkErr(printOutput(cmd, stats, "imported", "skipped", "overwritten"))
+	},
+}
+
+func setImportCMDFlags() {
+	importCmd.Flags().StringVar(&importOnConflictFlag, "on-conflict", importOnConflictFlag, "What to do with existing licenses: skip, overwrite or fail")
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/furkansenharputlu/f-license/config"
+	"github.com/furkansenharputlu/f-license/lcs"
+	"github.com/furkansenharputlu/f-license/storage"
+	"github.com/furkansenharputlu/f-license/webhook"
+
+	"github.com/sirupsen/logrus"
+)
+
+// backend executes the license operations of the commands either directly on the
+// storage or through the admin API of a remote server.
+type backend interface {
+	Generate(l *lcs.License) error
+	GenerateBatch(licenses []*lcs.License, atomic bool) ([]storage.BatchResult, error)
+	GetByID(id string, l *lcs.License) error
+	GetByToken(token string, l *lcs.License) error
+	List(opts storage.ListOptions) ([]*lcs.License, error)
+	Activate(id string, inactivate bool) error
+	Renew(id string, opts lcs.RenewOptions) (*lcs.License, error)
+	Delete(id string) error
+	Verify(token string) (bool, error)
+	CreateApp(app *config.App) error
+	GetApp(name string) (*config.App, error)
+	ListApps() ([]*config.App, error)
+	UpdateApp(app *config.App) error
+	DeleteApp(name string) error
+	CreateTenant(id, name string) (*storage.Tenant, error)
+	GetTenant(id string) (*storage.Tenant, error)
+	ListTenants() ([]*storage.Tenant, error)
+	CreateAPIKey(tenant, name, role string) (*storage.APIKey, error)
+	ListAPIKeys() ([]*storage.APIKey, error)
+	DeleteAPIKey(id string) error
+	CreateCustomer(c *lcs.Customer) error
+	GetCustomer(id string) (*lcs.Customer, error)
+	ListCustomers(f storage.CustomerFilter) ([]*lcs.Customer, error)
+	UpdateCustomer(c *lcs.Customer) error
+	DeleteCustomer(id string) error
+	CreateOrder(o *lcs.Order) error
+	GetOrder(id string) (*lcs.Order, error)
+	ListOrders(customerID string) ([]*lcs.Order, error)
+}
+
+// licenseBackend is the backend used by the commands. It is replaced with a remote
+// backend when a server is given.
+var licenseBackend backend = localBackend{}
+
+// localBackend works directly on the database configured in config.json. The operations
+// are limited to the tenant if it is given.
+type localBackend struct {
+	tenant string
+}
+
+func (b localBackend) context() context.Context {
+	return storage.WithTenant(context.Background(), b.tenant)
+}
+
+func (b localBackend) Generate(l *lcs.License) error {
+	err := storage.PrepareLicense(b.context(), storage.LicenseHandler, l)
+	if err != nil {
+		return err
+	}
+
+	err = l.Generate()
+	if err != nil {
+		return err
+	}
+
+	err = storage.LicenseHandler.AddIfNotExisting(b.context(), l)
+	if err != nil {
+		return err
+	}
+
+	b.publish(webhook.EventGenerated, l)
+
+	return nil
+}
+
+func (b localBackend) GenerateBatch(licenses []*lcs.License, atomic bool) ([]storage.BatchResult, error) {
+	results, _ := storage.GenerateBatch(b.context(), storage.LicenseHandler, licenses, atomic)
+	for i, res := range results {
+		if res.Error == "" {
+			b.publish(webhook.EventGenerated, licenses[i])
+		}
+	}
+
+	return results, nil
+}
+
+func (b localBackend) GetByID(id string, l *lcs.License) error {
+	return storage.LicenseHandler.GetByID(b.context(), id, l)
+}
+
+func (b localBackend) GetByToken(token string, l *lcs.License) error {
+	return storage.LicenseHandler.GetByToken(b.context(), token, l)
+}
+
+func (b localBackend) List(opts storage.ListOptions) ([]*lcs.License, error) {
+	licenses := make([]*lcs.License, 0)
+	_, err := storage.LicenseHandler.List(b.context(), opts, &licenses)
+
+	return licenses, err
+}
+
+func (b localBackend) Activate(id string, inactivate bool) error {
+	err := storage.LicenseHandler.Activate(b.context(), id, inactivate)
+	if err != nil {
+		return err
+	}
+
+	event := webhook.EventActivated
+	if inactivate {
+		event = webhook.EventInactivated
+	}
+
+	var l lcs.License
+	if err := storage.LicenseHandler.GetByID(b.context(), id, &l); err == nil {
+		b.publish(event, &l)
+	}
+
+	return nil
+}
+
+func (b localBackend) Renew(id string, opts lcs.RenewOptions) (*lcs.License, error) {
+	var l lcs.License
+	err := storage.LicenseHandler.GetByID(b.context(), id, &l)
+	if err != nil {
+		return nil, err
+	}
+
+	expiresAt, err := opts.ExpiryOf(&l, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	err = storage.RenewLicense(b.context(), storage.LicenseHandler, &l, expiresAt, opts.KeepOldToken, opts.Force)
+	if err != nil {
+		return nil, err
+	}
+
+	return &l, nil
+}
+
+func (b localBackend) Delete(id string) error {
+	var l lcs.License
+	found := storage.LicenseHandler.GetByID(b.context(), id, &l) == nil
+
+	err := storage.LicenseHandler.DeleteByID(b.context(), id)
+	if err != nil {
+		return err
+	}
+
+	if found {
+		b.publish(webhook.EventDeleted, &l)
+	}
+
+	return nil
+}
+
+// publish puts the event to the webhook outbox, and the email to the customer of the
+// license about it to the email outbox. Both are sent by the server.
+func (b localBackend) publish(event string, l *lcs.License) {
+	storage.PublishEvent(b.context(), storage.LicenseHandler, event, l)
+}
+
+func (b localBackend) Verify(token string) (bool, error) {
+	var l lcs.License
+	err := storage.LicenseHandler.GetByToken(b.context(), token, &l)
+	if err != nil {
+		return false, err
+	}
+
+	if l.PreviousToken(token) != nil {
+		logrus.Warn(newTokenAvailable)
+	}
+
+	return l.IsLicenseValid(token)
+}
+
+// newTokenAvailable is logged when a token replaced by a renewal is verified.
+const newTokenAvailable = "The license is renewed and a new token is available"
+
+func (b localBackend) CreateApp(app *config.App) error {
+	if err := lcs.CheckApp(app); err != nil {
+		return err
+	}
+
+	return storage.LicenseHandler.CreateApp(b.context(), app)
+}
+
+func (b localBackend) GetApp(name string) (*config.App, error) {
+	return storage.LicenseHandler.GetApp(b.context(), name)
+}
+
+func (b localBackend) ListApps() ([]*config.App, error) {
+	return storage.LicenseHandler.ListApps(b.context())
+}
+
+func (b localBackend) UpdateApp(app *config.App) error {
+	if err := lcs.CheckApp(app); err != nil {
+		return err
+	}
+
+	return storage.LicenseHandler.UpdateApp(b.context(), app)
+}
+
+func (b localBackend) DeleteApp(name string) error {
+	return storage.LicenseHandler.DeleteApp(b.context(), name)
+}
+
+func (b localBackend) CreateTenant(id, name string) (*storage.Tenant, error) {
+	t := &storage.Tenant{ID: id, Name: name, CreatedAt: time.Now().UTC().Truncate(time.Millisecond)}
+	if err := t.Validate(); err != nil {
+		return nil, err
+	}
+
+	return t, storage.LicenseHandler.CreateTenant(b.context(), t)
+}
+
+func (b localBackend) GetTenant(id string) (*storage.Tenant, error) {
+	return storage.LicenseHandler.GetTenant(b.context(), id)
+}
+
+func (b localBackend) ListTenants() ([]*storage.Tenant, error) {
+	return storage.LicenseHandler.ListTenants(b.context())
+}
+
+func (b localBackend) CreateAPIKey(tenant, name, role string) (*storage.APIKey, error) {
+	if b.tenant != "" {
+		tenant = b.tenant
+	}
+
+	k, err := storage.NewAPIKey(tenant, name, role)
+	if err != nil {
+		return nil, err
+	}
+
+	return k, storage.LicenseHandler.CreateAPIKey(b.context(), k)
+}
+
+func (b localBackend) ListAPIKeys() ([]*storage.APIKey, error) {
+	return storage.LicenseHandler.ListAPIKeys(b.context())
+}
+
+func (b localBackend) DeleteAPIKey(id string) error {
+	return storage.LicenseHandler.DeleteAPIKey(b.context(), id)
+}
+
+func (b localBackend) CreateCustomer(c *lcs.Customer) error {
+	if err := c.Validate(); err != nil {
+		return err
+	}
+
+	return storage.LicenseHandler.CreateCustomer(b.context(), c)
+}
+
+func (b localBackend) GetCustomer(id string) (*lcs.Customer, error) {
+	return storage.LicenseHandler.GetCustomer(b.context(), id)
+}
+
+func (b localBackend) ListCustomers(f storage.CustomerFilter) ([]*lcs.Customer, error) {
+	return storage.LicenseHandler.ListCustomers(b.context(), f)
+}
+
+func (b localBackend) UpdateCustomer(c *lcs.Customer) error {
+	if err := c.Validate(); err != nil {
+		return err
+	}
+
+	return storage.LicenseHandler.UpdateCustomer(b.context(), c)
+}
+
+func (b localBackend) DeleteCustomer(id string) error {
+	return storage.LicenseHandler.DeleteCustomer(b.context(), id)
+}
+
+func (b localBackend) CreateOrder(o *lcs.Order) error {
+	if err := o.Validate(); err != nil {
+		return err
+	}
+
+	return storage.LicenseHandler.CreateOrder(b.context(), o)
+}
+
+func (b localBackend) GetOrder(id string) (*lcs.Order, error) {
+	return storage.LicenseHandler.GetOrder(b.context(), id)
+}
+
+func (b localBackend) ListOrders(customerID string) ([]*lcs.Order, error) {
+	return storage.LicenseHandler.ListOrders(b.context(), customerID)
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/furkansenharputlu/f-license/lcs"
+)
+
+// readBatch reads the licenses of a batch file. JSONL files have a license JSON like
+// sample_license.json on each line. CSV files have a header row naming the column of
+// each cell as active, headers.<name> or claims.<name>.
+func readBatch(filePath string) ([]*lcs.License, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	switch strings.ToLower(filepath.Ext(filePath)) {
+	case ".jsonl", ".ndjson":
+		return readJSONLBatch(f)
+	case ".csv":
+		return readCSVBatch(f)
+	}
+
+	return nil, fmt.Errorf("unknown batch file format %q, should be .csv or .jsonl", filepath.Ext(filePath))
+}
+
+func readJSONLBatch(r io.Reader) ([]*lcs.License, error) {
+	var licenses []*lcs.License
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		var l lcs.License
+		if err := json.Unmarshal([]byte(line), &l); err != nil {
+			return nil, fmt.Errorf("line %d: %s", lineNumber, err)
+		}
+
+		licenses = append(licenses, &l)
+	}
+
+	return licenses, scanner.Err()
+}
+
+func readCSVBatch(r io.Reader) ([]*lcs.License, error) {
+	records, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(records) == 0 {
+		return nil, nil
+	}
+
+	columns := records[0]
+	var licenses []*lcs.License
+
+	for i, record := range records[1:] {
+		l := &lcs.License{
+			Active:  true,
+			Headers: make(map[string]interface{}),
+			Claims:  make(map[string]interface{}),
+		}
+
+		for j, column := range columns {
+			value := record[j]
+			if value == "" {
+				continue
+			}
+
+			switch {
+			case column == "active":
+				l.Active, err = strconv.ParseBool(value)
+				if err != nil {
+					return nil, fmt.Errorf("row %d: invalid active: %s", i+2, value)
+				}
+			case strings.HasPrefix(column, "headers."):
+				l.Headers[strings.TrimPrefix(column, "headers.")] = value
+			case strings.HasPrefix(column, "claims."):
+				l.Claims[strings.TrimPrefix(column, "claims.")] = csvValue(value)
+			default:
+				return nil, fmt.Errorf("unknown column %q, should be active, headers.<name> or claims.<name>", column)
+			}
+		}
+
+		licenses = append(licenses, l)
+	}
+
+	return licenses, nil
+}
+
+// csvValue keeps numbers and booleans typed, e.g. for exp and nbf claims.
+func csvValue(value string) interface{} {
+	if f, err := strconv.ParseFloat(value, 64); err == nil {
+		return f
+	}
+
+	switch value {
+	case "true":
+		return true
+	case "false":
+		return false
+	}
+
+	return value
+}
@@ -1,11 +1,13 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
+	"strings"
 
 	"github.com/furkansenharputlu/f-license/config"
 	"github.com/furkansenharputlu/f-license/lcs"
@@ -15,11 +17,30 @@ import (
 	"github.com/spf13/cobra"
 )
 
+var generateBatchFlag string
+var generateAtomicFlag bool
+
 var generateCmd = &cobra.Command{
-	Use:   "generate",
+	Use:   "generate [license.json]",
 	Short: "Generate new license",
-	Args:  cobra.ExactArgs(1),
+	Long: `Generate new license from a JSON file like sample_license.json.
+
+With --batch, many licenses are generated from a CSV or JSONL file and the outcome of each
+one is printed. CSV files have a header row naming the column of each cell as active,
+headers.<name> or claims.<name>, and JSONL files have a license JSON on each line.`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if generateBatchFlag != "" {
+			return cobra.NoArgs(cmd, args)
+		}
+
+		return cobra.ExactArgs(1)(cmd, args)
+	},
 	Run: func(cmd *cobra.Command, args []string) {
+		if generateBatchFlag != "" {
+			generateBatch(cmd)
+			return
+		}
+
 		var l *lcs.License
 
 		// JSON formatted license file path
@@ -32,31 +53,59 @@ var generateCmd = &cobra.Command{
 		err = json.Unmarshal(byteValue, &l)
 		checkErr(err)
 
-		err = l.Generate()
-		checkErr(err)
-
-		err = storage.LicenseHandler.AddIfNotExisting(l)
+		err = licenseBackend.Generate(l)
 		checkErr(err)
 
-		respBytes, err := json.MarshalIndent(struct {
+		checkErr(printOutput(cmd, struct {
 			ID    string `json:"id"`
 			Token string `json:"token"`
 		}{
 			ID:    l.ID.Hex(),
 			Token: l.Token,
-		}, "", "    ")
-
-		_, _ = fmt.Fprintf(cmd.OutOrStdout(), string(respBytes))
+		}, "id", "token"))
 	},
 }
 
+func generateBatch(cmd *cobra.Command) {
+	licenses, err := readBatch(generateBatchFlag)
+	checkErr(err)
+
+	results, err := licenseBackend.GenerateBatch(licenses, generateAtomicFlag)
+	checkErr(err)
+
+	checkErr(printOutput(cmd, results, "index", "id", "token", "error"))
+
+	failed := 0
+	for _, res := range results {
+		if res.Error != "" {
+			failed++
+		}
+	}
+
+	if failed > 0 {
+		checkErr(fmt.Errorf("%d of %d licenses couldn't be generated", failed, len(results)))
+	}
+}
+
+func clearGenerateFlags() {
+	generateBatchFlag = ""
+	generateAtomicFlag = false
+}
+
+func setGenerateCMDFlags() {
+	generateCmd.Flags().StringVar(&generateBatchFlag, "batch", "", "CSV or JSONL file of licenses to generate")
+	generateCmd.Flags().BoolVar(&generateAtomicFlag, "atomic", false, "Store either all licenses of the batch or none")
+}
+
 var activateCmd = &cobra.Command{
 	Use:   "activate",
 	Short: "Activate license",
 	Args:  cobra.ExactArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
-		err := storage.LicenseHandler.Activate(args[0], false)
+		err := licenseBackend.Activate(args[0], false)
 		checkErr(err)
+
+		checkErr(printMessage(cmd, "Activated"))
 	},
 }
 
@@ -65,11 +114,59 @@ var inactivateCmd = &cobra.Command{
 	Short: "Inactivate license",
 	Args:  cobra.ExactArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
-		err := storage.LicenseHandler.Activate(args[0], true)
+		err := licenseBackend.Activate(args[0], true)
+		checkErr(err)
+
+		checkErr(printMessage(cmd, "Inactivated"))
+	},
+}
+
+var (
+	renewDurationFlag     string
+	renewUntilFlag        string
+	renewKeepOldTokenFlag bool
+	renewForceFlag        bool
+)
+
+var renewCmd = &cobra.Command{
+	Use:   "renew <id> --duration <duration> | --until <time>",
+	Short: "Renew license",
+	Long: `Renew a license by extending its expiry with --duration, e.g. 30d or 720h, or until
+--until, e.g. 2027-01-01 or 2027-01-01T00:00:00Z, and signing it again. Durations extend
+the current expiry, or now if the license is expired or doesn't expire.
+
+The old token stops being valid unless --keep-old-token is given, which keeps it valid
+until its original expiry. Clients verifying with the old token are told a new token is
+available. Licenses inactivated by an admin or revoked are renewed only with --force, and
+renewals can't shorten licenses.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		l, err := licenseBackend.Renew(args[0], lcs.RenewOptions{
+			Duration:     renewDurationFlag,
+			ExpiresAt:    renewUntilFlag,
+			KeepOldToken: renewKeepOldTokenFlag,
+			Force:        renewForceFlag,
+		})
 		checkErr(err)
+
+		checkErr(printOutput(cmd, l, "id", "token", "claims.exp"))
 	},
 }
 
+func clearRenewFlags() {
+	renewDurationFlag = ""
+	renewUntilFlag = ""
+	renewKeepOldTokenFlag = false
+	renewForceFlag = false
+}
+
+func setRenewCMDFlags() {
+	renewCmd.Flags().StringVar(&renewDurationFlag, "duration", "", "Duration to extend the expiry by, e.g. 30d or 720h")
+	renewCmd.Flags().StringVar(&renewUntilFlag, "until", "", "New expiry, e.g. 2027-01-01 or 2027-01-01T00:00:00Z")
+	renewCmd.Flags().BoolVar(&renewKeepOldTokenFlag, "keep-old-token", false, "Keep the old token valid until its original expiry")
+	renewCmd.Flags().BoolVar(&renewForceFlag, "force", false, "Renew the license also if it is inactivated or revoked")
+}
+
 var getByIDFlag string
 var getByTokenFlag string
 
@@ -79,21 +176,18 @@ var getCmd = &cobra.Command{
 	Run: func(cmd *cobra.Command, args []string) {
 		var l lcs.License
 		if getByIDFlag != "" {
-			err := storage.LicenseHandler.GetByID(getByIDFlag, &l)
+			err := licenseBackend.GetByID(getByIDFlag, &l)
 			logrus.Info("Passed id value: ", getByIDFlag)
 			checkErr(err)
 		} else if getByTokenFlag != "" {
-			err := storage.LicenseHandler.GetByToken(getByTokenFlag, &l)
+			err := licenseBackend.GetByToken(getByTokenFlag, &l)
 			logrus.Info("Passed token value: ", getByTokenFlag)
 			checkErr(err)
 		} else {
 			checkErr(errors.New("pass id or token"))
 		}
 
-		licenseBytes, err := json.MarshalIndent(l, "", "    ")
-		checkErr(err)
-
-		_, _ = fmt.Fprintf(cmd.OutOrStdout(), string(licenseBytes))
+		checkErr(printOutput(cmd, l, licenseColumns...))
 	},
 }
 
@@ -112,8 +206,10 @@ var deleteCmd = &cobra.Command{
 	Short: "Delete license",
 	Args:  cobra.ExactArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
-		err := storage.LicenseHandler.DeleteByID(args[0])
+		err := licenseBackend.Delete(args[0])
 		checkErr(err)
+
+		checkErr(printMessage(cmd, "License successfully deleted"))
 	},
 }
 
@@ -122,35 +218,214 @@ var verifyCmd = &cobra.Command{
 	Short: "Verify license",
 	Args:  cobra.ExactArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
-		var l lcs.License
-		err := storage.LicenseHandler.GetByToken(args[0], &l)
+		valid, err := licenseBackend.Verify(args[0])
 		checkErr(err)
 
-		valid, err := l.IsLicenseValid(args[0])
+		checkErr(printOutput(cmd, valid))
+	},
+}
+
+// licenseColumns are the default table and CSV columns of licenses.
+var licenseColumns = []string{"id", "active", "headers.app", "headers.typ", "headers.alg"}
+
+var (
+	listAppFlag      string
+	listTypFlag      string
+	listActiveFlag   bool
+	listClaimFlag    []string
+	listCustomerFlag string
+	listOrderFlag    string
+	listOffsetFlag   int64
+	listLimitFlag    int64
+)
+
+var listCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List licenses",
+	Run: func(cmd *cobra.Command, args []string) {
+		opts := storage.ListOptions{
+			App:        listAppFlag,
+			Typ:        listTypFlag,
+			CustomerID: listCustomerFlag,
+			OrderID:    listOrderFlag,
+			Offset:     listOffsetFlag,
+			Limit:      listLimitFlag,
+		}
+
+		if cmd.Flags().Changed("active") {
+			opts.Active = &listActiveFlag
+		}
+
+		for _, claim := range listClaimFlag {
+			kv := strings.SplitN(claim, "=", 2)
+			if len(kv) != 2 || kv[0] == "" {
+				checkErr(fmt.Errorf("invalid claim filter, expected key=value: %s", claim))
+			}
+
+			if opts.Claims == nil {
+				opts.Claims = make(map[string]string)
+			}
+			opts.Claims[kv[0]] = kv[1]
+		}
+
+		licenses, err := licenseBackend.List(opts)
 		checkErr(err)
 
-		_, _ = fmt.Fprintf(cmd.OutOrStdout(), "%v", valid)
+		checkErr(printOutput(cmd, licenses, licenseColumns...))
 	},
 }
 
+func clearListFlags() {
+	listAppFlag = ""
+	listTypFlag = ""
+	listActiveFlag = false
+	listClaimFlag = nil
+	listCustomerFlag = ""
+	listOrderFlag = ""
+	listOffsetFlag = 0
+	listLimitFlag = 0
+}
+
+func setListCMDFlags() {
+	flags := listCmd.Flags()
+	flags.StringVar(&listAppFlag, "app", "", "Only licenses of the app")
+	flags.StringVar(&listTypFlag, "typ", "", "Only licenses of the type")
+	flags.BoolVar(&listActiveFlag, "active", false, "Only active licenses, or inactive ones with --active=false")
+	flags.StringArrayVar(&listClaimFlag, "claim", nil, "Only licenses having the claim, as key=value. Can be repeated")
+	flags.StringVar(&listCustomerFlag, "customer", "", "Only licenses of the customer ID")
+	flags.StringVar(&listOrderFlag, "order", "", "Only licenses of the order ID")
+	flags.Int64Var(&listOffsetFlag, "offset", 0, "Number of licenses to skip")
+	flags.Int64Var(&listLimitFlag, "limit", 0, "Maximum number of licenses, 0 means no limit")
+}
+
+func printMessage(cmd *cobra.Command, message string) error {
+	return printOutput(cmd, map[string]string{"message": message}, "message")
+}
+
+var (
+	serverFlag             string
+	apiKeyFlag             string
+	caFileFlag             string
+	certFileFlag           string
+	keyFileFlag            string
+	insecureSkipVerifyFlag bool
+	profileFlag            string
+	configFlag             string
+	tenantFlag             string
+)
+
+// standaloneAnnotation marks the commands which neither use the configured storage nor a
+// server, so that the root command doesn't set them up.
+const standaloneAnnotation = "standalone"
+
 var rootCmd = &cobra.Command{
 	Use:   "f-cli",
 	Short: "f-cli is the terminal tool for f-license",
+	Long: `f-cli is the terminal tool for f-license.
+
+By default it works directly on the database configured in config.json or --config. When a server
+is given with --server or a profile file, every command goes through the admin API of
+that server instead.`,
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		if cmd.Annotations[standaloneAnnotation] == "true" {
+			return
+		}
+
+		p, err := loadProfile(profileFlag)
+		checkErr(err)
+
+		overrideProfile(cmd, p)
+
+		if p.Server == "" {
+			c := &config.Config{}
+			checkErr(c.Load(config.FilePath(configFlag)))
+			config.Set(c)
+			storage.Connect()
+			licenseBackend = localBackend{tenant: p.Tenant}
+			return
+		}
+
+		licenseBackend, err = newRemoteBackend(p)
+		checkErr(err)
+	},
 }
 
-func main() {
-	config.Global.Load("config.json")
-	storage.Connect()
+func setRootCMDFlags() {
+	flags := rootCmd.PersistentFlags()
+	flags.StringVar(&serverFlag, "server", "", "Admin API URL of the f-license server, e.g. https://localhost:4242")
+	flags.StringVar(&apiKeyFlag, "api-key", "", "Admin secret of the server or an API key of a tenant")
+	flags.StringVar(&caFileFlag, "ca-file", "", "CA certificate file to verify the server")
+	flags.StringVar(&certFileFlag, "cert-file", "", "Client certificate file for mutual TLS")
+	flags.StringVar(&keyFileFlag, "key-file", "", "Client key file for mutual TLS")
+	flags.BoolVar(&insecureSkipVerifyFlag, "insecure-skip-verify", false, "Don't verify the server certificate")
+	flags.StringVar(&configFlag, "config", "", "Config file of local mode, "+config.ConfigFileEnv+" or config.json by default")
+	flags.StringVar(&profileFlag, "profile", "", "JSON file holding the server connection settings")
+	flags.StringVar(&tenantFlag, "tenant", "", "Tenant to act on, limiting the commands to its licenses and apps")
+	flags.StringVarP(&outputFlag, "output", "o", outputFlag, "Output format: "+strings.Join(outputFormats, "|"))
+	flags.StringSliceVar(&columnsFlag, "columns", nil, "Comma separated columns of table and CSV output, e.g. id,active,claims.name")
+}
 
+// overrideProfile applies the flags set in the command line over the profile values.
+func overrideProfile(cmd *cobra.Command, p *profile) {
+	flags := cmd.Flags()
+	if flags.Changed("server") {
+		p.Server = serverFlag
+	}
+	if flags.Changed("api-key") {
+		p.APIKey = apiKeyFlag
+	}
+	if flags.Changed("ca-file") {
+		p.CAFile = caFileFlag
+	}
+	if flags.Changed("cert-file") {
+		p.CertFile = certFileFlag
+	}
+	if flags.Changed("key-file") {
+		p.KeyFile = keyFileFlag
+	}
+	if flags.Changed("insecure-skip-verify") {
+		p.InsecureSkipVerify = insecureSkipVerifyFlag
+	}
+	if flags.Changed("tenant") {
+		p.Tenant = tenantFlag
+	}
+}
+
+func main() {
+	setRootCMDFlags()
+	setGenerateCMDFlags()
 	setGetCMDFlags()
+	setRenewCMDFlags()
+	setListCMDFlags()
+	setImportCMDFlags()
+	setMigrateCMDFlags()
+	setKeygenCMDFlags()
+	addAppsCommands()
+	addTenantsCommands()
+	addCustomersCommands()
 
 	rootCmd.AddCommand(activateCmd)
 	rootCmd.AddCommand(inactivateCmd)
+	rootCmd.AddCommand(renewCmd)
 	rootCmd.AddCommand(generateCmd)
 	rootCmd.AddCommand(getCmd)
+	rootCmd.AddCommand(listCmd)
 	rootCmd.AddCommand(deleteCmd)
 	rootCmd.AddCommand(verifyCmd)
-	checkErr(rootCmd.Execute())
+	rootCmd.AddCommand(exportCmd)
+	rootCmd.AddCommand(importCmd)
+	rootCmd.AddCommand(migrateCmd)
+	rootCmd.AddCommand(keygenCmd)
+	rootCmd.AddCommand(inspectCmd)
+	rootCmd.AddCommand(appsCmd)
+	rootCmd.AddCommand(tenantsCmd)
+	rootCmd.AddCommand(apiKeysCmd)
+	rootCmd.AddCommand(customersCmd)
+	rootCmd.AddCommand(ordersCmd)
+
+	err := rootCmd.Execute()
+	_ = storage.Disconnect(context.Background())
+	checkErr(err)
 }
 
 func checkErr(err error) {
@@ -2,25 +2,34 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/furkansenharputlu/f-license/config"
 	"github.com/furkansenharputlu/f-license/lcs"
 	"github.com/furkansenharputlu/f-license/storage"
 
 	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/spf13/cobra"
 	"github.com/stretchr/testify/assert"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 func TestMain(m *testing.M) {
-	config.Global.Load("../sample_config.json")
-	config.Global.DBName = "f-license_test"
+	c := &config.Config{}
+	_ = c.Load("../sample_config.json")
+	c.DBName = "f-license_test"
+	config.Set(c)
 	storage.Connect()
-	storage.LicenseHandler.DropDatabase()
+	storage.LicenseHandler.DropDatabase(context.Background())
 	os.Exit(m.Run())
 }
 
@@ -68,7 +77,7 @@ func generateLicense(l *lcs.License) (generatedLicense map[string]string) {
 }
 
 func TestGenerateCmd(t *testing.T) {
-	defer storage.LicenseHandler.DropDatabase()
+	defer storage.LicenseHandler.DropDatabase(context.Background())
 	l := sampleLicense()
 
 	generatedLicense := generateLicense(l)
@@ -78,7 +87,7 @@ func TestGenerateCmd(t *testing.T) {
 }
 
 func TestVerifyCmd(t *testing.T) {
-	defer storage.LicenseHandler.DropDatabase()
+	defer storage.LicenseHandler.DropDatabase(context.Background())
 	l := sampleLicense()
 
 	generatedLicense := generateLicense(l)
@@ -90,11 +99,11 @@ func TestVerifyCmd(t *testing.T) {
 
 	out, _ := ioutil.ReadAll(b)
 
-	assert.Equal(t, string(out), "true")
+	assert.Equal(t, "true\n", string(out))
 }
 
 func TestActivateCmd(t *testing.T) {
-	defer storage.LicenseHandler.DropDatabase()
+	defer storage.LicenseHandler.DropDatabase(context.Background())
 	l := sampleLicense()
 
 	generatedLicense := generateLicense(l)
@@ -105,7 +114,7 @@ func TestActivateCmd(t *testing.T) {
 	_ = verifyCmd.Execute()
 
 	out, _ := ioutil.ReadAll(b)
-	assert.Equal(t, string(out), "true")
+	assert.Equal(t, "true\n", string(out))
 
 	// Inactivate and check it is not verified
 	inactivateCmd.SetArgs([]string{generatedLicense["id"]})
@@ -114,7 +123,7 @@ func TestActivateCmd(t *testing.T) {
 	_ = verifyCmd.Execute()
 
 	out, _ = ioutil.ReadAll(b)
-	assert.Equal(t, string(out), "false")
+	assert.Equal(t, "false\n", string(out))
 
 	// Activate again and check it is verified
 	activateCmd.SetArgs([]string{generatedLicense["id"]})
@@ -123,11 +132,44 @@ func TestActivateCmd(t *testing.T) {
 	_ = verifyCmd.Execute()
 
 	out, _ = ioutil.ReadAll(b)
-	assert.Equal(t, string(out), "true")
+	assert.Equal(t, "true\n", string(out))
+}
+
+func TestRenewCmd(t *testing.T) {
+	defer storage.LicenseHandler.DropDatabase(context.Background())
+	expiresAt := time.Now().AddDate(0, 0, 10).Unix()
+	generatedLicense := generateLicense(sampleLicense(func(l *lcs.License) {
+		l.Claims["exp"] = expiresAt
+	}))
+
+	setRenewCMDFlags()
+	defer clearRenewFlags()
+
+	b := bytes.NewBufferString("")
+	renewCmd.SetOutput(b)
+	renewCmd.SetArgs([]string{generatedLicense["id"], "--duration", "30d", "--keep-old-token"})
+	_ = renewCmd.Execute()
+
+	var renewed lcs.License
+	out, _ := ioutil.ReadAll(b)
+	_ = json.Unmarshal(out, &renewed)
+
+	assert.NotEqual(t, generatedLicense["token"], renewed.Token)
+	assert.Equal(t, float64(time.Unix(expiresAt, 0).AddDate(0, 0, 30).Unix()), renewed.Claims["exp"])
+	if assert.Len(t, renewed.Renewals, 1) {
+		assert.True(t, renewed.Renewals[0].KeptOldToken)
+	}
+
+	// Both tokens are valid until the old one expires
+	for _, token := range []string{generatedLicense["token"], renewed.Token} {
+		valid, err := licenseBackend.Verify(token)
+		assert.NoError(t, err)
+		assert.True(t, valid)
+	}
 }
 
 func TestDeleteCmd(t *testing.T) {
-	defer storage.LicenseHandler.DropDatabase()
+	defer storage.LicenseHandler.DropDatabase(context.Background())
 	l := sampleLicense()
 
 	generatedLicense := generateLicense(l)
@@ -140,7 +182,7 @@ func TestDeleteCmd(t *testing.T) {
 }
 
 func TestGetCmd(t *testing.T) {
-	defer storage.LicenseHandler.DropDatabase()
+	defer storage.LicenseHandler.DropDatabase(context.Background())
 	l := sampleLicense(func(l *lcs.License) {
 		l.Headers["alg"] = "HS512"
 	})
@@ -182,3 +224,498 @@ func TestGetCmd(t *testing.T) {
 		assert.Equal(t, l, retLicense)
 	})
 }
+
+func TestRemoteMode(t *testing.T) {
+	const id = "5ea1f7c4d3b6a0a4c8e1b2f3"
+	const token = "ey.remote.token"
+
+	active := true
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		if strings.HasPrefix(r.URL.Path, "/admin") && r.Header.Get("Authorization") != "remote-secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(`{"message":"Authorization failed"}`))
+			return
+		}
+
+		switch r.Method + " " + r.URL.Path {
+		case "POST /admin/licenses":
+			_, _ = fmt.Fprintf(w, `{"id":"%s","token":"%s"}`, id, token)
+		case "GET /admin/licenses/" + id:
+			_, _ = fmt.Fprintf(w, `{"id":"%s","token":"%s","active":%v}`, id, token, active)
+		case "GET /admin/licenses:lookup":
+			assert.Equal(t, token, r.URL.Query().Get("token"))
+			_, _ = fmt.Fprintf(w, `{"id":"%s","token":"%s","active":%v}`, id, token, active)
+		case "POST /admin/licenses/" + id + "/renew":
+			var opts lcs.RenewOptions
+			_ = json.NewDecoder(r.Body).Decode(&opts)
+			assert.Equal(t, lcs.RenewOptions{ExpiresAt: "2030-01-01", KeepOldToken: true}, opts)
+			_, _ = fmt.Fprintf(w, `{"id":"%s","token":"%s.renewed","active":true}`, id, token)
+		case "PUT /admin/licenses/" + id + "/inactivate":
+			active = false
+			_, _ = w.Write([]byte(`{"message":"Inactivated"}`))
+		case "DELETE /admin/licenses/" + id + "/delete":
+			w.WriteHeader(http.StatusInternalServerError)
+			_, _ = w.Write([]byte(`{"error":"there is no license with ID: ` + id + `"}`))
+		case "POST /license/verify":
+			_, _ = fmt.Fprintf(w, `{"valid":%v}`, active && r.FormValue("token") == token)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	remote, err := newRemoteBackend(&profile{Server: server.URL + "/", APIKey: "remote-secret"})
+	assert.NoError(t, err)
+
+	oldBackend := licenseBackend
+	licenseBackend = remote
+	defer func() {
+		licenseBackend = oldBackend
+	}()
+
+	generatedLicense := generateLicense(sampleLicense())
+	assert.Equal(t, id, generatedLicense["id"])
+	assert.Equal(t, token, generatedLicense["token"])
+
+	var l lcs.License
+	assert.NoError(t, remote.GetByToken(token, &l))
+	assert.Equal(t, id, l.ID.Hex())
+
+	valid, err := remote.Verify(token)
+	assert.NoError(t, err)
+	assert.True(t, valid)
+
+	renewed, err := remote.Renew(id, lcs.RenewOptions{ExpiresAt: "2030-01-01", KeepOldToken: true})
+	assert.NoError(t, err)
+	assert.Equal(t, token+".renewed", renewed.Token)
+
+	assert.NoError(t, remote.Activate(id, true))
+
+	valid, err = remote.Verify(token)
+	assert.NoError(t, err)
+	assert.False(t, valid)
+
+	assert.EqualError(t, remote.Delete(id), "there is no license with ID: "+id)
+
+	unauthorized, _ := newRemoteBackend(&profile{Server: server.URL})
+	assert.EqualError(t, unauthorized.GetByID(id, &l), "Authorization failed")
+}
+
+func TestLoadProfile(t *testing.T) {
+	profileFile, _ := ioutil.TempFile("", "profile.json")
+	defer profileFile.Close()
+	_, _ = profileFile.Write([]byte(`{"server":"https://localhost:4242","api_key":"admin123","insecure_skip_verify":true}`))
+
+	p, err := loadProfile(profileFile.Name())
+	assert.NoError(t, err)
+	assert.Equal(t, &profile{Server: "https://localhost:4242", APIKey: "admin123", InsecureSkipVerify: true}, p)
+
+	_, err = loadProfile("non-existing.json")
+	assert.Error(t, err)
+}
+
+func TestListCmd(t *testing.T) {
+	defer storage.LicenseHandler.DropDatabase(context.Background())
+
+	first := generateLicense(sampleLicense(func(l *lcs.License) {
+		l.Headers["app"] = "test-app"
+	}))
+	second := generateLicense(sampleLicense(func(l *lcs.License) {
+		l.Claims["name"] = "Ahmet"
+	}))
+
+	inactivateCmd.SetArgs([]string{second["id"]})
+	inactivateCmd.SetOutput(bytes.NewBufferString(""))
+	_ = inactivateCmd.Execute()
+
+	setListCMDFlags()
+
+	list := func(args ...string) []*lcs.License {
+		clearListFlags()
+		b := bytes.NewBufferString("")
+		listCmd.SetOutput(b)
+		listCmd.SetArgs(args)
+		_ = listCmd.Execute()
+
+		var licenses []*lcs.License
+		_ = json.Unmarshal(b.Bytes(), &licenses)
+
+		return licenses
+	}
+
+	assert.Len(t, list(), 2)
+
+	licenses := list("--app", "test-app")
+	assert.Len(t, licenses, 1)
+	assert.Equal(t, first["id"], licenses[0].ID.Hex())
+
+	licenses = list("--active=false")
+	assert.Len(t, licenses, 1)
+	assert.Equal(t, second["id"], licenses[0].ID.Hex())
+
+	licenses = list("--claim", "name=Ahmet", "--typ", "Trial")
+	assert.Len(t, licenses, 1)
+	assert.Equal(t, second["id"], licenses[0].ID.Hex())
+
+	assert.Len(t, list("--claim", "name=Mehmet"), 0)
+	assert.Len(t, list("--offset", "1", "--limit", "1"), 1)
+}
+
+func TestPrintOutput(t *testing.T) {
+	defer func() {
+		outputFlag = "json"
+		columnsFlag = nil
+	}()
+
+	records := []map[string]interface{}{
+		{"id": "1", "active": true, "claims": map[string]interface{}{"name": "Furkan", "seats": 10}},
+		{"id": "2", "active": false, "claims": map[string]interface{}{"name": "Ahmet, Jr."}},
+	}
+
+	render := func(format string, v interface{}, columns ...string) string {
+		outputFlag = format
+		b := bytes.NewBufferString("")
+		cmd := &cobra.Command{}
+		cmd.SetOutput(b)
+
+		assert.NoError(t, printOutput(cmd, v, columns...))
+
+		return b.String()
+	}
+
+	assert.Equal(t, "true\n", render("json", true))
+	assert.Equal(t, "{\n    \"id\": \"1\"\n}\n", render("json", map[string]string{"id": "1"}))
+
+	assert.Equal(t, `{"active":true,"claims":{"name":"Furkan","seats":10},"id":"1"}
+{"active":false,"claims":{"name":"Ahmet, Jr."},"id":"2"}
+`, render("jsonl", records))
+
+	assert.Equal(t, `- active: true
+  claims:
+    name: Furkan
+    seats: 10
+  id: "1"
+- active: false
+  claims:
+    name: Ahmet, Jr.
+  id: "2"
+`, render("yaml", records))
+
+	assert.Equal(t, `ID  ACTIVE  CLAIMS.NAME
+1   true    Furkan
+2   false   Ahmet, Jr.
+`, render("table", records, "id", "active", "claims.name"))
+
+	columnsFlag = []string{"id", "claims.name", "claims.seats"}
+	assert.Equal(t, `id,claims.name,claims.seats
+1,Furkan,10
+2,"Ahmet, Jr.",
+`, render("csv", records, "id"))
+
+	outputFlag = "xml"
+	assert.EqualError(t, printOutput(&cobra.Command{}, records), `unknown output format "xml", should be one of: table, json, jsonl, csv, yaml`)
+}
+
+func TestReadBatch(t *testing.T) {
+	t.Run("CSV", func(t *testing.T) {
+		csvFile, _ := ioutil.TempFile("", "licenses*.csv")
+		defer csvFile.Close()
+		_, _ = csvFile.WriteString("headers.app,headers.typ,claims.name,claims.exp,active\n" +
+			"test-app,Trial,Furkan,1900000000,\n" +
+			",,\"Ahmet, Jr.\",,false\n")
+
+		licenses, err := readBatch(csvFile.Name())
+		assert.NoError(t, err)
+		assert.Equal(t, []*lcs.License{
+			{
+				Active:  true,
+				Headers: map[string]interface{}{"app": "test-app", "typ": "Trial"},
+				Claims:  jwt.MapClaims{"name": "Furkan", "exp": float64(1900000000)},
+			},
+			{
+				Active:  false,
+				Headers: map[string]interface{}{},
+				Claims:  jwt.MapClaims{"name": "Ahmet, Jr."},
+			},
+		}, licenses)
+	})
+
+	t.Run("JSONL", func(t *testing.T) {
+		jsonlFile, _ := ioutil.TempFile("", "licenses*.jsonl")
+		defer jsonlFile.Close()
+		_, _ = jsonlFile.WriteString(`{"headers":{"typ":"Trial"},"claims":{"name":"Furkan"},"active":true}` + "\n\n" +
+			`{"claims":{"name":"Ahmet"}}` + "\n")
+
+		licenses, err := readBatch(jsonlFile.Name())
+		assert.NoError(t, err)
+		assert.Len(t, licenses, 2)
+		assert.Equal(t, "Ahmet", licenses[1].Claims["name"])
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		csvFile, _ := ioutil.TempFile("", "licenses*.csv")
+		defer csvFile.Close()
+		_, _ = csvFile.WriteString("name\nFurkan\n")
+
+		_, err := readBatch(csvFile.Name())
+		assert.EqualError(t, err, `unknown column "name", should be active, headers.<name> or claims.<name>`)
+
+		_, err = readBatch("licenses.xml")
+		assert.Error(t, err)
+	})
+}
+
+func TestGenerateBatchCmd(t *testing.T) {
+	defer storage.LicenseHandler.DropDatabase(context.Background())
+	defer clearGenerateFlags()
+
+	jsonlFile, _ := ioutil.TempFile("", "licenses*.jsonl")
+	defer jsonlFile.Close()
+	_, _ = jsonlFile.WriteString(`{"claims":{"name":"Furkan"},"active":true}` + "\n" + `{"claims":{"name":"Ahmet"},"active":true}` + "\n")
+
+	setGenerateCMDFlags()
+
+	b := bytes.NewBufferString("")
+	generateCmd.SetOutput(b)
+	generateCmd.SetArgs([]string{"--batch", jsonlFile.Name()})
+	_ = generateCmd.Execute()
+
+	var results []storage.BatchResult
+	_ = json.Unmarshal(b.Bytes(), &results)
+
+	assert.Len(t, results, 2)
+	for i, res := range results {
+		assert.Equal(t, i, res.Index)
+		assert.NotEmpty(t, res.ID)
+		assert.NotEmpty(t, res.Token)
+		assert.Empty(t, res.Error)
+	}
+}
+
+func TestExportImportCmd(t *testing.T) {
+	defer storage.LicenseHandler.DropDatabase(context.Background())
+
+	generatedLicense := generateLicense(sampleLicense())
+
+	archiveFile, _ := ioutil.TempFile("", "archive*.jsonl")
+	defer archiveFile.Close()
+
+	exportCmd.SetArgs([]string{archiveFile.Name()})
+	_ = exportCmd.Execute()
+
+	// Change the stored license to see it is overwritten by the archived one
+	inactivateCmd.SetArgs([]string{generatedLicense["id"]})
+	inactivateCmd.SetOutput(bytes.NewBufferString(""))
+	_ = inactivateCmd.Execute()
+
+	setImportCMDFlags()
+	defer func() {
+		importOnConflictFlag = "fail"
+	}()
+
+	importAndCheck := func(onConflict string, expected map[string]int) {
+		b := bytes.NewBufferString("")
+		importCmd.SetOutput(b)
+		importCmd.SetArgs([]string{"--on-conflict", onConflict, archiveFile.Name()})
+		_ = importCmd.Execute()
+
+		var stats map[string]int
+		_ = json.Unmarshal(b.Bytes(), &stats)
+		assert.Equal(t, expected, stats)
+	}
+
+	importAndCheck("skip", map[string]int{"imported": 0, "skipped": 1, "overwritten": 0})
+
+	var l lcs.License
+	_ = storage.LicenseHandler.GetByToken(context.Background(), generatedLicense["token"], &l)
+	assert.False(t, l.Active)
+
+	importAndCheck("overwrite", map[string]int{"imported": 0, "skipped": 0, "overwritten": 1})
+
+	_ = storage.LicenseHandler.GetByToken(context.Background(), generatedLicense["token"], &l)
+	assert.True(t, l.Active)
+	assert.Equal(t, generatedLicense["id"], l.ID.Hex())
+
+	_ = storage.LicenseHandler.DropDatabase(context.Background())
+	importAndCheck("fail", map[string]int{"imported": 1, "skipped": 0, "overwritten": 0})
+
+	_ = storage.LicenseHandler.GetByToken(context.Background(), generatedLicense["token"], &l)
+	assert.Equal(t, generatedLicense["id"], l.ID.Hex())
+}
+
+func TestKeygen(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "keygen")
+	defer os.RemoveAll(dir)
+
+	configFile := dir + "/config.json"
+	_ = ioutil.WriteFile(configFile, []byte(`{"port": 4242, "apps": {"other-app": {"alg": "HS256"}}}`), 0640)
+
+	for _, alg := range []string{"RS256", "ES256", "EdDSA", "HS512"} {
+		t.Run(alg, func(t *testing.T) {
+			app := "app-" + strings.ToLower(alg)
+			res, err := keygen(app, alg, dir+"/keys", configFile, false, false)
+			assert.NoError(t, err)
+
+			c := &config.Config{}
+			c.Load(configFile)
+			assert.Equal(t, 4242, c.Port)
+			assert.Contains(t, c.Apps, "other-app")
+			assert.Equal(t, alg, c.Apps[app].Alg)
+
+			if alg == "HS512" {
+				assert.NotEmpty(t, c.Apps[app].Signature.HMACSecret)
+				assert.Nil(t, res.JWKS)
+			} else {
+				info, err := os.Stat(res.PrivateKeyFile)
+				assert.NoError(t, err)
+				assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+				assert.Equal(t, res.PrivateKeyFile, c.Apps[app].Signature.RSAPrivateKeyFile)
+				assert.Contains(t, res.PublicKey, "-----BEGIN PUBLIC KEY-----")
+				assert.Equal(t, alg, res.JWKS.Keys[0].Alg)
+				assert.Equal(t, app, res.JWKS.Keys[0].Kid)
+
+				_, err = keygen(app, alg, dir+"/keys", configFile, false, false)
+				assert.EqualError(t, err, res.PrivateKeyFile+" already exists, pass --force to overwrite it")
+			}
+
+			l := sampleLicense()
+			l.Headers["app"] = app
+			l.Signature = c.Apps[app].Signature
+			l.Headers["alg"] = alg
+			assert.NoError(t, lcs.CheckKeys(alg, l.Signature))
+
+			original := config.Get()
+			defer config.Set(original)
+
+			generated := original.Clone()
+			generated.Apps = c.Apps
+			config.Set(generated)
+
+			assert.NoError(t, l.Generate())
+			valid, err := l.IsLicenseValid(l.Token)
+			assert.NoError(t, err)
+			assert.True(t, valid)
+		})
+	}
+
+	info, _ := os.Stat(configFile)
+	assert.Equal(t, os.FileMode(0640), info.Mode().Perm())
+
+	_, err := keygen("app", "XX256", dir+"/keys", configFile, false, false)
+	assert.EqualError(t, err, "unsupported alg: XX256")
+
+	t.Run("keystore", func(t *testing.T) {
+		keyStore := config.KeyStore{File: dir + "/keystore.json", Passphrase: "passphrase"}
+		keyStoreConfig, _ := json.Marshal(map[string]interface{}{"key_store": keyStore})
+		_ = ioutil.WriteFile(configFile, keyStoreConfig, 0600)
+
+		original := config.Get()
+		defer config.Set(original)
+
+		for _, alg := range []string{"ES256", "HS512"} {
+			app := "keystore-app-" + strings.ToLower(alg)
+			res, err := keygen(app, alg, dir+"/keys", configFile, false, true)
+			assert.NoError(t, err)
+			assert.Equal(t, app, res.KeyID)
+			assert.Empty(t, res.PrivateKeyFile)
+
+			_, err = keygen(app, alg, dir+"/keys", configFile, false, true)
+			assert.EqualError(t, err, "key "+app+" already exists in the keystore, pass --force to overwrite it")
+
+			c := &config.Config{}
+			_ = c.Load(configFile)
+			assert.Equal(t, config.Signature{KeyProvider: config.KeyProviderKeyStore, KeyID: app}, c.Apps[app].Signature)
+
+			generated := original.Clone()
+			generated.KeyStore, generated.Apps = c.KeyStore, c.Apps
+			config.Set(generated)
+
+			l := sampleLicense()
+			l.Headers["app"] = app
+			assert.NoError(t, l.Generate())
+			valid, err := l.IsLicenseValid(l.Token)
+			assert.NoError(t, err)
+			assert.True(t, valid)
+		}
+	})
+}
+
+// tokenBackend serves GetByToken from a map for the commands which only look up licenses.
+type tokenBackend struct {
+	backend
+	licenses map[string]*lcs.License
+}
+
+func (b tokenBackend) GetByToken(token string, l *lcs.License) error {
+	stored, ok := b.licenses[token]
+	if !ok {
+		return fmt.Errorf("no documents in result")
+	}
+
+	*l = *stored
+
+	return nil
+}
+
+func TestInspect(t *testing.T) {
+	now := time.Now()
+
+	valid := sampleLicense()
+	valid.Claims["exp"] = now.Add(time.Hour).Unix()
+	_ = valid.Generate()
+
+	expired := sampleLicense()
+	expired.Active = false
+	expired.Claims["exp"] = now.Add(-time.Hour).Unix()
+	_ = expired.Generate()
+
+	appLicense := sampleLicense()
+	appLicense.Headers["app"] = "test-app"
+	_ = appLicense.Generate()
+
+	unknown := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"name": "Furkan"})
+	unknown.Header["app"] = "unknown-app"
+	unknownToken, _ := unknown.SignedString([]byte("test-secret"))
+
+	original := licenseBackend
+	licenseBackend = tokenBackend{licenses: map[string]*lcs.License{valid.Token: valid, expired.Token: expired}}
+	defer func() { licenseBackend = original }()
+
+	res, err := inspect(valid.Token, now)
+	assert.NoError(t, err)
+	assert.True(t, res.Valid)
+	assert.Equal(t, "HS256", res.Alg)
+	assert.Equal(t, "valid", res.Signature)
+	assert.Equal(t, valid.ID.Hex(), res.Stored.ID)
+	assert.Empty(t, res.Problems)
+
+	res, err = inspect(expired.Token, now)
+	assert.NoError(t, err)
+	assert.False(t, res.Valid)
+	assert.Equal(t, []string{
+		"token expired at " + time.Unix(now.Add(-time.Hour).Unix(), 0).UTC().Format(time.RFC3339),
+		"license is inactive",
+	}, res.Problems)
+
+	parts := strings.Split(valid.Token, ".")
+	res, err = inspect(parts[0]+"."+parts[1]+".c2lnbmF0dXJl", now)
+	assert.NoError(t, err)
+	assert.Equal(t, "invalid", res.Signature)
+	assert.Equal(t, []string{"signature is invalid: signature is invalid", "license is not stored: no documents in result"}, res.Problems)
+
+	res, err = inspect(unknownToken, now)
+	assert.NoError(t, err)
+	assert.Equal(t, "unknown-app", res.App)
+	assert.Contains(t, res.Problems, `app "unknown-app" is not configured`)
+
+	// test-app is configured with HS512, the default signature has the same secret
+	res, err = inspect(appLicense.Token, now)
+	assert.NoError(t, err)
+	assert.Equal(t, "HS512", res.ConfiguredAlg)
+	assert.Equal(t, "valid", res.Signature)
+
+	_, err = inspect("not-a-token", now)
+	assert.EqualError(t, err, "couldn't decode token: token contains an invalid number of segments")
+}
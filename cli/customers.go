@@ -0,0 +1,212 @@
+package main
+
+import (
+	"github.com/furkansenharputlu/f-license/lcs"
+	"github.com/furkansenharputlu/f-license/storage"
+
+	"github.com/spf13/cobra"
+)
+
+// customerColumns and orderColumns are the default table and CSV columns.
+var (
+	customerColumns = []string{"id", "name", "email", "company", "billing_id"}
+	orderColumns    = []string{"id", "customer_id", "billing_id", "app", "plan", "quantity"}
+)
+
+var (
+	customerNameFlag      string
+	customerEmailFlag     string
+	customerCompanyFlag   string
+	customerBillingIDFlag string
+	orderCustomerFlag     string
+	orderBillingIDFlag    string
+	orderAppFlag          string
+	orderPlanFlag         string
+	orderQuantityFlag     int
+)
+
+var customersCmd = &cobra.Command{
+	Use:   "customers",
+	Short: "Manage customers",
+	Long: `Manage customers licenses are issued to. A license references its customer with
+customer_id and its order with order_id.`,
+}
+
+var customersListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List customers",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		customers, err := licenseBackend.ListCustomers(storage.CustomerFilter{
+			Email:     customerEmailFlag,
+			Company:   customerCompanyFlag,
+			BillingID: customerBillingIDFlag,
+		})
+		checkErr(err)
+
+		checkErr(printOutput(cmd, customers, customerColumns...))
+	},
+}
+
+var customersGetCmd = &cobra.Command{
+	Use:   "get <id>",
+	Short: "Get a customer",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		c, err := licenseBackend.GetCustomer(args[0])
+		checkErr(err)
+
+		checkErr(printOutput(cmd, c, customerColumns...))
+	},
+}
+
+var customersCreateCmd = &cobra.Command{
+	Use:   "create --name <name> --email <email>",
+	Short: "Create a customer",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		c := &lcs.Customer{
+			Name:      customerNameFlag,
+			Email:     customerEmailFlag,
+			Company:   customerCompanyFlag,
+			BillingID: customerBillingIDFlag,
+		}
+		checkErr(licenseBackend.CreateCustomer(c))
+
+		checkErr(printOutput(cmd, c, customerColumns...))
+	},
+}
+
+var customersUpdateCmd = &cobra.Command{
+	Use:   "update <id>",
+	Short: "Update a customer",
+	Long:  `Update a customer. Only the fields given by flags are changed.`,
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		c, err := licenseBackend.GetCustomer(args[0])
+		checkErr(err)
+
+		flags := cmd.Flags()
+		if flags.Changed("name") {
+			c.Name = customerNameFlag
+		}
+		if flags.Changed("email") {
+			c.Email = customerEmailFlag
+		}
+		if flags.Changed("company") {
+			c.Company = customerCompanyFlag
+		}
+		if flags.Changed("billing-id") {
+			c.BillingID = customerBillingIDFlag
+		}
+
+		checkErr(licenseBackend.UpdateCustomer(c))
+
+		checkErr(printOutput(cmd, c, customerColumns...))
+	},
+}
+
+var customersDeleteCmd = &cobra.Command{
+	Use:   "delete <id>",
+	Short: "Delete a customer having no licenses or orders",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		checkErr(licenseBackend.DeleteCustomer(args[0]))
+
+		checkErr(printMessage(cmd, "Customer successfully deleted"))
+	},
+}
+
+var customersLicensesCmd = &cobra.Command{
+	Use:   "licenses <id>",
+	Short: "List licenses of a customer",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		_, err := licenseBackend.GetCustomer(args[0])
+		checkErr(err)
+
+		licenses, err := licenseBackend.List(storage.ListOptions{CustomerID: args[0]})
+		checkErr(err)
+
+		checkErr(printOutput(cmd, licenses, append(licenseColumns, "order_id")...))
+	},
+}
+
+var ordersCmd = &cobra.Command{
+	Use:   "orders",
+	Short: "Manage orders of customers",
+}
+
+var ordersListCmd = &cobra.Command{
+	Use:   "list [--customer <id>]",
+	Short: "List orders",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		orders, err := licenseBackend.ListOrders(orderCustomerFlag)
+		checkErr(err)
+
+		checkErr(printOutput(cmd, orders, orderColumns...))
+	},
+}
+
+var ordersGetCmd = &cobra.Command{
+	Use:   "get <id>",
+	Short: "Get an order",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		o, err := licenseBackend.GetOrder(args[0])
+		checkErr(err)
+
+		checkErr(printOutput(cmd, o, orderColumns...))
+	},
+}
+
+var ordersCreateCmd = &cobra.Command{
+	Use:   "create --customer <id>",
+	Short: "Create an order of a customer",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		o := &lcs.Order{
+			CustomerID: orderCustomerFlag,
+			BillingID:  orderBillingIDFlag,
+			App:        orderAppFlag,
+			Plan:       orderPlanFlag,
+			Quantity:   orderQuantityFlag,
+		}
+		checkErr(licenseBackend.CreateOrder(o))
+
+		checkErr(printOutput(cmd, o, orderColumns...))
+	},
+}
+
+func addCustomersCommands() {
+	for _, cmd := range []*cobra.Command{customersListCmd, customersCreateCmd, customersUpdateCmd} {
+		flags := cmd.Flags()
+		if cmd != customersListCmd {
+			flags.StringVar(&customerNameFlag, "name", "", "Name of the customer")
+		}
+		flags.StringVar(&customerEmailFlag, "email", "", "Email of the customer")
+		flags.StringVar(&customerCompanyFlag, "company", "", "Company of the customer")
+		flags.StringVar(&customerBillingIDFlag, "billing-id", "", "ID of the customer in the billing system")
+	}
+
+	ordersListCmd.Flags().StringVar(&orderCustomerFlag, "customer", "", "Only orders of the customer ID")
+
+	flags := ordersCreateCmd.Flags()
+	flags.StringVar(&orderCustomerFlag, "customer", "", "ID of the customer")
+	flags.StringVar(&orderBillingIDFlag, "billing-id", "", "ID of the order in the billing system")
+	flags.StringVar(&orderAppFlag, "app", "", "App the order is for")
+	flags.StringVar(&orderPlanFlag, "plan", "", "Plan the order is for")
+	flags.IntVar(&orderQuantityFlag, "quantity", 0, "Number of licenses the order is for")
+
+	customersCmd.AddCommand(customersListCmd)
+	customersCmd.AddCommand(customersGetCmd)
+	customersCmd.AddCommand(customersCreateCmd)
+	customersCmd.AddCommand(customersUpdateCmd)
+	customersCmd.AddCommand(customersDeleteCmd)
+	customersCmd.AddCommand(customersLicensesCmd)
+
+	ordersCmd.AddCommand(ordersListCmd)
+	ordersCmd.AddCommand(ordersGetCmd)
+	ordersCmd.AddCommand(ordersCreateCmd)
+}
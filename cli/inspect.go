@@ -0,0 +1,60 @@
+package main
+
+import (
+	"time"
+
+	"github.com/furkansenharputlu/f-license/lcs"
+
+	"github.com/spf13/cobra"
+)
+
+type storedState struct {
+	ID     string `json:"id"`
+	Active bool   `json:"active"`
+}
+
+type inspection struct {
+	*lcs.Inspection
+	Stored *storedState `json:"stored"`
+	// Valid is what verifying the token would answer.
+	Valid bool `json:"valid"`
+}
+
+var inspectCmd = &cobra.Command{
+	Use:   "inspect <token>",
+	Short: "Explain why a token is valid or not",
+	Long: `Decode the header and claims of a token, check its signature against the keys of its app,
+check its time claims and look up its stored license, then list every problem which makes
+verification fail. Keys are taken from the local config.json.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		res, err := inspect(args[0], time.Now())
+		checkErr(err)
+
+		checkErr(printOutput(cmd, res, "valid", "app", "alg", "signature", "problems"))
+	},
+}
+
+func inspect(token string, now time.Time) (*inspection, error) {
+	ins, err := lcs.Inspect(token, now)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &inspection{Inspection: ins}
+
+	var l lcs.License
+	err = licenseBackend.GetByToken(token, &l)
+	if err != nil {
+		ins.Problems = append(ins.Problems, "license is not stored: "+err.Error())
+	} else {
+		res.Stored = &storedState{ID: l.ID.Hex(), Active: l.Active}
+		if !l.Active {
+			ins.Problems = append(ins.Problems, "license is inactive")
+		}
+	}
+
+	res.Valid = len(ins.Problems) == 0
+
+	return res, nil
+}
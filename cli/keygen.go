@@ -0,0 +1,256 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/furkansenharputlu/f-license/config"
+	"github.com/furkansenharputlu/f-license/lcs"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	keygenAppFlag      string
+	keygenAlgFlag      = "RS256"
+	keygenDirFlag      = "keys"
+	keygenForceFlag    bool
+	keygenKeyStoreFlag bool
+)
+
+type keygenResult struct {
+	App            string    `json:"app"`
+	Alg            string    `json:"alg"`
+	PrivateKeyFile string    `json:"private_key_file,omitempty"`
+	PublicKeyFile  string    `json:"public_key_file,omitempty"`
+	KeyID          string    `json:"key_id,omitempty"`
+	PublicKey      string    `json:"public_key,omitempty"`
+	JWKS           *lcs.JWKS `json:"jwks,omitempty"`
+}
+
+var keygenCmd = &cobra.Command{
+	Use:   "keygen --app <name> --alg <RS256|ES256|EdDSA|HS512>",
+	Short: "Generate signing keys of an app and register them in the config file",
+	Long: `Generate signing keys of an app and register them in the config file.
+Private and public keys are written as PEM files to --dir, the private key readable only
+by the owner. HMAC secrets are written to the config file directly. With --keystore, the
+private key or secret is stored in the encrypted keystore configured in the config file
+instead. The public key and its JWKS are printed to embed in clients.`,
+	Args:        cobra.NoArgs,
+	Annotations: map[string]string{standaloneAnnotation: "true"},
+	Run: func(cmd *cobra.Command, args []string) {
+		res, err := keygen(keygenAppFlag, keygenAlgFlag, keygenDirFlag, config.FilePath(configFlag), keygenForceFlag, keygenKeyStoreFlag)
+		checkErr(err)
+
+		checkErr(printOutput(cmd, res, "app", "alg", "private_key_file", "public_key_file", "key_id"))
+	},
+}
+
+func keygen(app, alg, dir, configFile string, force, useKeyStore bool) (*keygenResult, error) {
+	if app == "" {
+		return nil, errors.New("app is required")
+	}
+
+	if !config.SupportedAlgs[alg] {
+		return nil, fmt.Errorf("unsupported alg: %s", alg)
+	}
+
+	keyPair, err := lcs.GenerateKey(alg)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &keygenResult{App: app, Alg: alg}
+	signature := map[string]interface{}{}
+
+	if keyPair.PublicKey != nil {
+		res.PublicKey = string(keyPair.PublicKey)
+
+		publicKey, err := lcs.ParsePublicKey(alg, keyPair.PublicKey)
+		if err != nil {
+			return nil, err
+		}
+
+		jwk, err := lcs.NewJWK(alg, app, publicKey)
+		if err != nil {
+			return nil, err
+		}
+		res.JWKS = &lcs.JWKS{Keys: []lcs.JWK{*jwk}}
+	}
+
+	switch {
+	case useKeyStore:
+		res.KeyID = app
+
+		key := keyPair.PrivateKey
+		if keyPair.HMACSecret != "" {
+			key = []byte(keyPair.HMACSecret)
+		}
+
+		if err := storeKey(configFile, res.KeyID, key, force); err != nil {
+			return nil, err
+		}
+
+		signature["key_provider"] = config.KeyProviderKeyStore
+		signature["key_id"] = res.KeyID
+	case keyPair.HMACSecret != "":
+		signature["hmac_secret"] = keyPair.HMACSecret
+	default:
+		res.PrivateKeyFile = filepath.Join(dir, app+"_private_key.pem")
+		res.PublicKeyFile = filepath.Join(dir, app+"_public_key.pem")
+
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return nil, err
+		}
+
+		if err := writeKeyFile(res.PrivateKeyFile, keyPair.PrivateKey, 0600, force); err != nil {
+			return nil, err
+		}
+
+		if err := writeKeyFile(res.PublicKeyFile, keyPair.PublicKey, 0644, force); err != nil {
+			return nil, err
+		}
+
+		signature["rsa_private_key_file"] = res.PrivateKeyFile
+		signature["rsa_public_key_file"] = res.PublicKeyFile
+	}
+
+	err = registerApp(configFile, app, alg, signature)
+	if err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// storeKey puts the key to the keystore configured in the config file. The passphrase can
+// be given by F_LICENSE_KEY_STORE_PASSPHRASE like for the server.
+func storeKey(configFile, keyID string, key []byte, force bool) error {
+	c := &config.Config{}
+	if err := c.Load(configFile); err != nil {
+		return err
+	}
+
+	if c.KeyStore.File == "" {
+		return errors.New("key_store.file is not configured")
+	}
+
+	passphrase, err := c.KeyStore.GetPassphrase()
+	if err != nil {
+		return err
+	}
+
+	ks, err := lcs.OpenKeyStore(c.KeyStore.File, passphrase)
+	if err != nil {
+		return err
+	}
+
+	if !force {
+		_, err := ks.Get(keyID)
+		if err == nil {
+			return fmt.Errorf("key %s already exists in the keystore, pass --force to overwrite it", keyID)
+		}
+		if err != lcs.ErrKeyNotFound {
+			return err
+		}
+	}
+
+	return ks.Put(keyID, key)
+}
+
+func writeKeyFile(filePath string, key []byte, perm os.FileMode, force bool) error {
+	flag := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	if !force {
+		flag |= os.O_EXCL
+	}
+
+	f, err := os.OpenFile(filePath, flag, perm)
+	if os.IsExist(err) {
+		return fmt.Errorf("%s already exists, pass --force to overwrite it", filePath)
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// The permissions of an existing file are not changed by OpenFile
+	if err := f.Chmod(perm); err != nil {
+		return err
+	}
+
+	_, err = f.Write(key)
+
+	return err
+}
+
+// registerApp sets the alg and signature of the app in the config file, keeping the other
+// fields of the file as they are.
+func registerApp(configFile, app, alg string, signature map[string]interface{}) error {
+	conf := map[string]json.RawMessage{}
+	mode := os.FileMode(0600)
+
+	configBytes, err := ioutil.ReadFile(configFile)
+	switch {
+	case err == nil:
+		if err := json.Unmarshal(configBytes, &conf); err != nil {
+			return fmt.Errorf("couldn't unmarshal config file: %s", err)
+		}
+		if info, err := os.Stat(configFile); err == nil {
+			mode = info.Mode().Perm()
+		}
+	case !os.IsNotExist(err):
+		return fmt.Errorf("couldn't read config file: %s", err)
+	}
+
+	apps := map[string]map[string]interface{}{}
+	if raw, ok := conf["apps"]; ok && string(raw) != "null" {
+		if err := json.Unmarshal(raw, &apps); err != nil {
+			return fmt.Errorf("couldn't unmarshal apps: %s", err)
+		}
+	}
+
+	entry := apps[app]
+	if entry == nil {
+		entry = map[string]interface{}{}
+	}
+
+	existingSignature, _ := entry["signature"].(map[string]interface{})
+	if existingSignature == nil {
+		existingSignature = map[string]interface{}{}
+	}
+	for k, v := range signature {
+		existingSignature[k] = v
+	}
+
+	entry["alg"] = alg
+	entry["signature"] = existingSignature
+	apps[app] = entry
+
+	conf["apps"], _ = json.Marshal(apps)
+
+	configBytes, err = json.MarshalIndent(conf, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file first so that a failure doesn't leave a broken config
+	tmpFile := configFile + ".tmp"
+	if err := ioutil.WriteFile(tmpFile, append(configBytes, '\n'), mode); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpFile, configFile)
+}
+
+func setKeygenCMDFlags() {
+	flags := keygenCmd.Flags()
+	flags.StringVar(&keygenAppFlag, "app", "", "Name of the app")
+	flags.StringVar(&keygenAlgFlag, "alg", keygenAlgFlag, "Signing alg, e.g. RS256, ES256, EdDSA or HS512")
+	flags.StringVar(&keygenDirFlag, "dir", keygenDirFlag, "Directory to write the PEM files to")
+	flags.BoolVar(&keygenForceFlag, "force", false, "Overwrite existing key files")
+	flags.BoolVar(&keygenKeyStoreFlag, "keystore", false, "Store the key in the keystore configured in the config file")
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"errors"
+
+	"github.com/furkansenharputlu/f-license/config"
+	"github.com/furkansenharputlu/f-license/migration"
+	"github.com/furkansenharputlu/f-license/storage"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var (
+	migrateFromFlag      string
+	migrateToFlag        string
+	migrateBatchSizeFlag int64 = migration.DefaultBatchSize
+	migrateStateFlag           = "migrate.state"
+)
+
+var migrateCmd = &cobra.Command{
+	Use:   "migrate --from <config.json> --to <config.json>",
+	Short: "Copy all licenses from one storage to another",
+	Long: `Copy all licenses from the storage of one configuration to the storage of another in batches,
+then verify both have the same licenses. The progress is kept in the state file, so running
+the same command again after an interruption continues where it stopped.`,
+	Args:        cobra.NoArgs,
+	Annotations: map[string]string{standaloneAnnotation: "true"},
+	Run: func(cmd *cobra.Command, args []string) {
+		if migrateFromFlag == "" || migrateToFlag == "" {
+			checkErr(errors.New("both --from and --to are required"))
+		}
+
+		ctx := context.Background()
+
+		from, fromEndpoint, err := openStorage(migrateFromFlag)
+		checkErr(err)
+		defer from.Close(ctx)
+
+		to, toEndpoint, err := openStorage(migrateToFlag)
+		checkErr(err)
+		defer to.Close(ctx)
+
+		res, err := migration.Run(ctx, from, to, migration.Options{
+			BatchSize: migrateBatchSizeFlag,
+			StateFile: migrateStateFlag,
+			From:      fromEndpoint,
+			To:        toEndpoint,
+			Progress: func(p migration.Progress) {
+				logrus.Infof("%d/%d licenses migrated, %d already existing", p.Copied+p.Skipped, p.Total, p.Skipped)
+			},
+		})
+		checkErr(err)
+
+		checkErr(printOutput(cmd, res, "copied", "skipped", "source_count", "target_count", "verified"))
+	},
+}
+
+// openStorage opens the storage of the configuration file, and returns its endpoint which
+// identifies it in the migration state.
+func openStorage(configFile string) (storage.Handler, string, error) {
+	c := &config.Config{}
+	if err := c.Load(configFile); err != nil {
+		return nil, "", err
+	}
+
+	h, err := storage.Open(c)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return h, c.MongoURL + "/" + c.DBName, nil
+}
+
+func setMigrateCMDFlags() {
+	flags := migrateCmd.Flags()
+	flags.StringVar(&migrateFromFlag, "from", "", "Configuration file of the source storage")
+	flags.StringVar(&migrateToFlag, "to", "", "Configuration file of the target storage")
+	flags.Int64Var(&migrateBatchSizeFlag, "batch-size", migrateBatchSizeFlag, "Number of licenses copied in a batch")
+	flags.StringVar(&migrateStateFlag, "state", migrateStateFlag, "File keeping the progress to resume an interrupted migration")
+}
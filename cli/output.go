@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v2"
+)
+
+var outputFlag = "json"
+var columnsFlag []string
+
+var outputFormats = []string{"table", "json", "jsonl", "csv", "yaml"}
+
+// printOutput writes v to the output of the command in the format selected with --output.
+// Slices are written as one record per row or line. Table and CSV cells are picked from
+// the JSON representation of the records by the dotted column paths given with
+// --columns, or by defaultColumns.
+func printOutput(cmd *cobra.Command, v interface{}, defaultColumns ...string) error {
+	w := cmd.OutOrStdout()
+
+	switch outputFlag {
+	case "json":
+		b, err := json.MarshalIndent(v, "", "    ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(w, string(b))
+		return err
+	case "jsonl":
+		for _, record := range records(v) {
+			b, err := json.Marshal(record)
+			if err != nil {
+				return err
+			}
+			if _, err = fmt.Fprintln(w, string(b)); err != nil {
+				return err
+			}
+		}
+		return nil
+	case "yaml":
+		generic, err := toGeneric(v, false)
+		if err != nil {
+			return err
+		}
+		b, err := yaml.Marshal(generic)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(b)
+		return err
+	case "table", "csv":
+		return printRows(w, v, defaultColumns)
+	}
+
+	return fmt.Errorf("unknown output format %q, should be one of: %s", outputFlag, strings.Join(outputFormats, ", "))
+}
+
+func printRows(w io.Writer, v interface{}, defaultColumns []string) error {
+	columns := columnsFlag
+	if len(columns) == 0 {
+		columns = defaultColumns
+	}
+
+	var rows [][]string
+	for _, record := range records(v) {
+		generic, err := toGeneric(record, true)
+		if err != nil {
+			return err
+		}
+
+		fields, ok := generic.(map[string]interface{})
+		if !ok || len(columns) == 0 {
+			rows = append(rows, []string{cell(generic)})
+			continue
+		}
+
+		row := make([]string, 0, len(columns))
+		for _, column := range columns {
+			row = append(row, cell(lookup(fields, column)))
+		}
+		rows = append(rows, row)
+	}
+
+	if outputFlag == "csv" {
+		cw := csv.NewWriter(w)
+		if len(columns) > 0 {
+			_ = cw.Write(columns)
+		}
+		_ = cw.WriteAll(rows)
+		return cw.Error()
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	if len(columns) > 0 {
+		_, _ = fmt.Fprintln(tw, strings.ToUpper(strings.Join(columns, "\t")))
+	}
+	for _, row := range rows {
+		_, _ = fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+
+	return tw.Flush()
+}
+
+// records returns the elements of v if it is a slice, or v itself.
+func records(v interface{}) []interface{} {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Slice {
+		return []interface{}{v}
+	}
+
+	res := make([]interface{}, 0, rv.Len())
+	for i := 0; i < rv.Len(); i++ {
+		res = append(res, rv.Index(i).Interface())
+	}
+
+	return res
+}
+
+// toGeneric converts v to maps, slices and scalars through its JSON representation.
+// With useNumber, numbers are kept as they are written in JSON instead of float64.
+func toGeneric(v interface{}, useNumber bool) (interface{}, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	var generic interface{}
+	d := json.NewDecoder(bytes.NewReader(b))
+	if useNumber {
+		d.UseNumber()
+	}
+	err = d.Decode(&generic)
+
+	return generic, err
+}
+
+func lookup(fields map[string]interface{}, path string) interface{} {
+	var cur interface{} = fields
+	for _, key := range strings.Split(path, ".") {
+		m, ok := cur.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		cur = m[key]
+	}
+
+	return cur
+}
+
+func cell(v interface{}) string {
+	switch val := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return val
+	case map[string]interface{}, []interface{}:
+		b, _ := json.Marshal(val)
+		return string(b)
+	}
+
+	return fmt.Sprint(v)
+}
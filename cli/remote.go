@@ -0,0 +1,367 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/furkansenharputlu/f-license/admin"
+	"github.com/furkansenharputlu/f-license/config"
+	"github.com/furkansenharputlu/f-license/lcs"
+	"github.com/furkansenharputlu/f-license/storage"
+
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// profile holds the connection settings of a remote server. It can be read from a JSON
+// file passed with --profile, and flags override its values.
+type profile struct {
+	Server             string `json:"server"`
+	APIKey             string `json:"api_key"`
+	CAFile             string `json:"ca_file"`
+	CertFile           string `json:"cert_file"`
+	KeyFile            string `json:"key_file"`
+	InsecureSkipVerify bool   `json:"insecure_skip_verify"`
+	Tenant             string `json:"tenant"`
+}
+
+func loadProfile(filePath string) (*profile, error) {
+	p := &profile{}
+	if filePath == "" {
+		return p, nil
+	}
+
+	profileBytes, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read profile: %s", err)
+	}
+
+	err = json.Unmarshal(profileBytes, p)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't unmarshal profile: %s", err)
+	}
+
+	return p, nil
+}
+
+const remoteTimeout = 30 * time.Second
+
+// remoteBackend calls the admin API of an f-license server.
+type remoteBackend struct {
+	server     string
+	admin      *admin.Client
+	httpClient *http.Client
+}
+
+func newRemoteBackend(p *profile) (*remoteBackend, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: p.InsecureSkipVerify}
+
+	if p.CAFile != "" {
+		caBytes, err := ioutil.ReadFile(p.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't read CA file: %s", err)
+		}
+
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(caBytes) {
+			return nil, errors.New("no certificate found in CA file")
+		}
+	}
+
+	if p.CertFile != "" || p.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(p.CertFile, p.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't load client certificate: %s", err)
+		}
+
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	httpClient := &http.Client{
+		Timeout:   remoteTimeout,
+		Transport: &http.Transport{TLSClientConfig: tlsConfig},
+	}
+
+	return &remoteBackend{
+		server:     strings.TrimSuffix(p.Server, "/"),
+		admin:      admin.NewClient(p.Server, p.APIKey, admin.WithHTTPClient(httpClient), admin.WithTenant(p.Tenant)),
+		httpClient: httpClient,
+	}, nil
+}
+
+func (b *remoteBackend) Generate(l *lcs.License) error {
+	res, err := b.admin.Create(context.Background(), l)
+	if err != nil {
+		return err
+	}
+
+	l.ID, err = primitive.ObjectIDFromHex(res.ID)
+	if err != nil {
+		return err
+	}
+
+	l.Token = res.Token
+
+	return nil
+}
+
+func (b *remoteBackend) GenerateBatch(licenses []*lcs.License, atomic bool) ([]storage.BatchResult, error) {
+	res, err := b.admin.CreateBatch(context.Background(), licenses, atomic)
+	if res == nil {
+		return nil, err
+	}
+
+	results := make([]storage.BatchResult, 0, len(res.Results))
+	for _, r := range res.Results {
+		results = append(results, storage.BatchResult(r))
+	}
+
+	return results, nil
+}
+
+func (b *remoteBackend) GetByID(id string, l *lcs.License) error {
+	res, err := b.admin.Get(context.Background(), id)
+	if err != nil {
+		return err
+	}
+
+	*l = *res
+
+	return nil
+}
+
+func (b *remoteBackend) GetByToken(token string, l *lcs.License) error {
+	res, err := b.admin.GetByToken(context.Background(), token)
+	if err != nil {
+		return err
+	}
+
+	*l = *res
+
+	return nil
+}
+
+func (b *remoteBackend) List(opts storage.ListOptions) ([]*lcs.License, error) {
+	page, err := b.admin.List(context.Background(), admin.ListOptions{
+		App:        opts.App,
+		Typ:        opts.Typ,
+		Active:     opts.Active,
+		Claims:     opts.Claims,
+		CustomerID: opts.CustomerID,
+		OrderID:    opts.OrderID,
+		Offset:     opts.Offset,
+		Limit:      opts.Limit,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return page.Licenses, nil
+}
+
+func (b *remoteBackend) Activate(id string, inactivate bool) error {
+	if inactivate {
+		return b.admin.Inactivate(context.Background(), id)
+	}
+
+	return b.admin.Activate(context.Background(), id)
+}
+
+func (b *remoteBackend) Renew(id string, opts lcs.RenewOptions) (*lcs.License, error) {
+	return b.admin.Renew(context.Background(), id, opts)
+}
+
+func (b *remoteBackend) Delete(id string) error {
+	return b.admin.Delete(context.Background(), id)
+}
+
+func (b *remoteBackend) CreateApp(app *config.App) error {
+	_, err := b.admin.CreateApp(context.Background(), app)
+	return err
+}
+
+func (b *remoteBackend) GetApp(name string) (*config.App, error) {
+	return b.admin.GetApp(context.Background(), name)
+}
+
+func (b *remoteBackend) ListApps() ([]*config.App, error) {
+	return b.admin.ListApps(context.Background())
+}
+
+func (b *remoteBackend) UpdateApp(app *config.App) error {
+	_, err := b.admin.UpdateApp(context.Background(), app)
+	return err
+}
+
+func (b *remoteBackend) DeleteApp(name string) error {
+	return b.admin.DeleteApp(context.Background(), name)
+}
+
+func (b *remoteBackend) CreateTenant(id, name string) (*storage.Tenant, error) {
+	t, err := b.admin.CreateTenant(context.Background(), id, name)
+	if err != nil {
+		return nil, err
+	}
+
+	return (*storage.Tenant)(t), nil
+}
+
+func (b *remoteBackend) GetTenant(id string) (*storage.Tenant, error) {
+	t, err := b.admin.GetTenant(context.Background(), id)
+	if err != nil {
+		return nil, err
+	}
+
+	return (*storage.Tenant)(t), nil
+}
+
+func (b *remoteBackend) ListTenants() ([]*storage.Tenant, error) {
+	res, err := b.admin.ListTenants(context.Background())
+	if err != nil {
+		return nil, err
+	}
+
+	tenants := make([]*storage.Tenant, 0, len(res))
+	for _, t := range res {
+		tenants = append(tenants, (*storage.Tenant)(t))
+	}
+
+	return tenants, nil
+}
+
+func (b *remoteBackend) CreateAPIKey(tenant, name, role string) (*storage.APIKey, error) {
+	k, err := b.admin.CreateAPIKey(context.Background(), tenant, name, role)
+	if err != nil {
+		return nil, err
+	}
+
+	return apiKeyOf(k), nil
+}
+
+func (b *remoteBackend) ListAPIKeys() ([]*storage.APIKey, error) {
+	res, err := b.admin.ListAPIKeys(context.Background())
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]*storage.APIKey, 0, len(res))
+	for _, k := range res {
+		keys = append(keys, apiKeyOf(k))
+	}
+
+	return keys, nil
+}
+
+func (b *remoteBackend) DeleteAPIKey(id string) error {
+	return b.admin.DeleteAPIKey(context.Background(), id)
+}
+
+func apiKeyOf(k *admin.APIKey) *storage.APIKey {
+	return &storage.APIKey{
+		ID:        k.ID,
+		Tenant:    k.Tenant,
+		Name:      k.Name,
+		Role:      k.Role,
+		CreatedAt: k.CreatedAt,
+		Key:       k.Key,
+	}
+}
+
+// Verify calls the public verification endpoint, which is not part of the admin API.
+func (b *remoteBackend) Verify(token string) (bool, error) {
+	form := url.Values{}
+	form.Add("token", token)
+
+	resp, err := b.httpClient.PostForm(b.server+"/license/verify", form)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	var res struct {
+		Valid             bool   `json:"valid"`
+		NewTokenAvailable bool   `json:"new_token_available"`
+		Message           string `json:"message"`
+		Error             string `json:"error"`
+	}
+
+	err = json.NewDecoder(resp.Body).Decode(&res)
+	if err != nil {
+		return false, fmt.Errorf("couldn't decode response: %s", err)
+	}
+
+	if res.NewTokenAvailable {
+		logrus.Warn(newTokenAvailable)
+	}
+
+	switch {
+	case res.Error != "":
+		return false, errors.New(res.Error)
+	case res.Message != "":
+		return false, errors.New(res.Message)
+	}
+
+	return res.Valid, nil
+}
+
+func (b *remoteBackend) CreateCustomer(c *lcs.Customer) error {
+	res, err := b.admin.CreateCustomer(context.Background(), c)
+	if err != nil {
+		return err
+	}
+
+	*c = *res
+
+	return nil
+}
+
+func (b *remoteBackend) GetCustomer(id string) (*lcs.Customer, error) {
+	return b.admin.GetCustomer(context.Background(), id)
+}
+
+func (b *remoteBackend) ListCustomers(f storage.CustomerFilter) ([]*lcs.Customer, error) {
+	return b.admin.ListCustomers(context.Background(), admin.CustomerFilter(f))
+}
+
+func (b *remoteBackend) UpdateCustomer(c *lcs.Customer) error {
+	res, err := b.admin.UpdateCustomer(context.Background(), c)
+	if err != nil {
+		return err
+	}
+
+	*c = *res
+
+	return nil
+}
+
+func (b *remoteBackend) DeleteCustomer(id string) error {
+	return b.admin.DeleteCustomer(context.Background(), id)
+}
+
+func (b *remoteBackend) CreateOrder(o *lcs.Order) error {
+	res, err := b.admin.CreateOrder(context.Background(), o)
+	if err != nil {
+		return err
+	}
+
+	*o = *res
+
+	return nil
+}
+
+func (b *remoteBackend) GetOrder(id string) (*lcs.Order, error) {
+	return b.admin.GetOrder(context.Background(), id)
+}
+
+func (b *remoteBackend) ListOrders(customerID string) ([]*lcs.Order, error) {
+	return b.admin.ListOrders(context.Background(), customerID)
+}
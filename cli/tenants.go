@@ -0,0 +1,118 @@
+package main
+
+import (
+	"github.com/spf13/cobra"
+)
+
+// tenantColumns and apiKeyColumns are the default table and CSV columns.
+var (
+	tenantColumns = []string{"id", "name", "created_at"}
+	apiKeyColumns = []string{"id", "tenant", "name", "role", "created_at"}
+)
+
+var (
+	tenantNameFlag string
+	apiKeyNameFlag string
+	apiKeyRoleFlag string
+)
+
+var tenantsCmd = &cobra.Command{
+	Use:   "tenants",
+	Short: "Manage tenants",
+	Long: `Manage tenants owning licenses, apps and API keys. Only the super-admin, authenticating
+with the admin secret, can create and list tenants.`,
+}
+
+var tenantsListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List tenants",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		tenants, err := licenseBackend.ListTenants()
+		checkErr(err)
+
+		checkErr(printOutput(cmd, tenants, tenantColumns...))
+	},
+}
+
+var tenantsGetCmd = &cobra.Command{
+	Use:   "get <id>",
+	Short: "Get a tenant",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		t, err := licenseBackend.GetTenant(args[0])
+		checkErr(err)
+
+		checkErr(printOutput(cmd, t, tenantColumns...))
+	},
+}
+
+var tenantsCreateCmd = &cobra.Command{
+	Use:   "create <id> --name <name>",
+	Short: "Create a tenant",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		t, err := licenseBackend.CreateTenant(args[0], tenantNameFlag)
+		checkErr(err)
+
+		checkErr(printOutput(cmd, t, tenantColumns...))
+	},
+}
+
+var apiKeysCmd = &cobra.Command{
+	Use:   "api-keys",
+	Short: "Manage API keys of tenants",
+	Long: `Manage API keys authenticating the admin API requests of tenants. The requests of a key
+are limited to the licenses, apps and API keys of its tenant.`,
+}
+
+var apiKeysListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List API keys of the tenant, or of all tenants for the super-admin",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		keys, err := licenseBackend.ListAPIKeys()
+		checkErr(err)
+
+		checkErr(printOutput(cmd, keys, apiKeyColumns...))
+	},
+}
+
+var apiKeysCreateCmd = &cobra.Command{
+	Use:   "create --name <name> [--role admin|read-only]",
+	Short: "Create an API key of the tenant given by --tenant",
+	Long: `Create an API key of the tenant given by --tenant. The key is printed only once, keep it
+safe.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		k, err := licenseBackend.CreateAPIKey(tenantFlag, apiKeyNameFlag, apiKeyRoleFlag)
+		checkErr(err)
+
+		checkErr(printOutput(cmd, k, append(apiKeyColumns, "key")...))
+	},
+}
+
+var apiKeysDeleteCmd = &cobra.Command{
+	Use:   "delete <id>",
+	Short: "Delete an API key",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		checkErr(licenseBackend.DeleteAPIKey(args[0]))
+
+		checkErr(printMessage(cmd, "API key successfully deleted"))
+	},
+}
+
+func addTenantsCommands() {
+	tenantsCreateCmd.Flags().StringVar(&tenantNameFlag, "name", "", "Name of the tenant")
+	apiKeysCreateCmd.Flags().StringVar(&apiKeyNameFlag, "name", "", "Name of the API key")
+	apiKeysCreateCmd.Flags().StringVar(&apiKeyRoleFlag, "role", "admin", "Role of the API key: admin|read-only")
+
+	tenantsCmd.AddCommand(tenantsListCmd)
+	tenantsCmd.AddCommand(tenantsGetCmd)
+	tenantsCmd.AddCommand(tenantsCreateCmd)
+
+	apiKeysCmd.AddCommand(apiKeysListCmd)
+	apiKeysCmd.AddCommand(apiKeysCreateCmd)
+	apiKeysCmd.AddCommand(apiKeysDeleteCmd)
+}
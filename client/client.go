@@ -15,6 +15,24 @@ import (
 )
 
 func VerifyRemotely(serverURL string, cert string, licenseKey string) (verified bool, err error) {
+	v, err := VerifyRemotelyWithResult(serverURL, cert, licenseKey)
+	if err != nil {
+		return false, err
+	}
+
+	return v.Valid, nil
+}
+
+// Verification is the outcome of a remote verification.
+type Verification struct {
+	Valid bool
+	// NewTokenAvailable reports that the license is renewed with a new token, which the
+	// verified token is replaced by.
+	NewTokenAvailable bool
+}
+
+// VerifyRemotelyWithResult is VerifyRemotely also telling whether a new token is available.
+func VerifyRemotelyWithResult(serverURL string, cert string, licenseKey string) (*Verification, error) {
 	form := url.Values{}
 	form.Add("token", licenseKey)
 
@@ -34,26 +52,30 @@ func VerifyRemotely(serverURL string, cert string, licenseKey string) (verified
 
 	resp, err := client.Do(request)
 	if err != nil {
-		return false, err
+		return nil, err
 	}
 
 	var res map[string]interface{}
 	bytes, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		return false, err
+		return nil, err
 	}
 
 	err = json.Unmarshal(bytes, &res)
 	if err != nil {
-		return false, err
+		return nil, err
 	}
 
 	errMsg, ok := res["error"]
 	if ok {
-		return false, errors.New(errMsg.(string))
+		return nil, errors.New(errMsg.(string))
 	}
 
-	return res["valid"].(bool), nil
+	v := &Verification{}
+	v.Valid, _ = res["valid"].(bool)
+	v.NewTokenAvailable, _ = res["new_token_available"].(bool)
+
+	return v, nil
 }
 
 func VerifyLocally(publicKey string, licenseKey string) (verified bool, err error) {
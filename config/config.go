@@ -2,14 +2,21 @@ package config
 
 import (
 	"crypto/tls"
-	"encoding/json"
+	"crypto/x509"
+	"errors"
+	"fmt"
 	"io/ioutil"
+	"net"
+	"net/mail"
+	"os"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
 
-	"github.com/sirupsen/logrus"
+	"github.com/furkansenharputlu/f-license/ratelimit"
 )
 
-var Global = &Config{}
-
 type Config struct {
 	Port             int             `json:"port"`
 	AdminSecret      string          `json:"admin_secret"`
@@ -18,35 +25,816 @@ type Config struct {
 	MongoURL         string          `json:"mongo_url"`
 	DBName           string          `json:"db_name"`
 	ServerOptions    ServerOptions   `json:"server_options"`
+	RateLimit        RateLimit       `json:"rate_limit"`
+	KeyStore         KeyStore        `json:"key_store"`
+	Webhooks         Webhooks        `json:"webhooks"`
+	Email            Email           `json:"email"`
+	Jobs             Jobs            `json:"jobs"`
+	Billing          Billing         `json:"billing"`
+	// MaxBatchSize limits the licenses generated by a batch request.
+	MaxBatchSize int `json:"max_batch_size"`
+}
+
+// DefaultMaxBatchSize is the maximum size of batches if max_batch_size is not set.
+const DefaultMaxBatchSize = 1000
+
+// GetMaxBatchSize returns max_batch_size, or DefaultMaxBatchSize if it is not set.
+func (c *Config) GetMaxBatchSize() int {
+	if c.MaxBatchSize == 0 {
+		return DefaultMaxBatchSize
+	}
+
+	return c.MaxBatchSize
 }
 
+const (
+	KeyProviderFile     = "file"
+	KeyProviderKeyStore = "keystore"
+	KeyProviderKMS      = "kms"
+)
+
+// Signature references the keys licenses are signed and verified with. By default the
+// key provider is file: keys are read from the PEM files and the HMAC secret is the one
+// given. The keystore and kms providers keep the keys themselves and find them by KeyID.
 type Signature struct {
-	HMACSecret        string `json:"hmac_secret"`
-	RSAPrivateKeyFile string `json:"rsa_private_key_file"`
-	RSAPublicKeyFile  string `json:"rsa_public_key_file"`
+	HMACSecret        string `json:"hmac_secret" bson:"hmac_secret"`
+	RSAPrivateKeyFile string `json:"rsa_private_key_file" bson:"rsa_private_key_file"`
+	RSAPublicKeyFile  string `json:"rsa_public_key_file" bson:"rsa_public_key_file"`
+	KeyProvider       string `json:"key_provider" bson:"key_provider"`
+	KeyID             string `json:"key_id" bson:"key_id"`
+}
+
+// KeyStore configures the encrypted local keystore used by the keystore key provider.
+// The passphrase is better given by F_LICENSE_KEY_STORE_PASSPHRASE or a file than written
+// in the configuration.
+type KeyStore struct {
+	File           string `json:"file"`
+	Passphrase     string `json:"passphrase"`
+	PassphraseFile string `json:"passphrase_file"`
+}
+
+// GetPassphrase returns the passphrase, reading it from the passphrase file if it is not given.
+func (k KeyStore) GetPassphrase() (string, error) {
+	if k.Passphrase != "" || k.PassphraseFile == "" {
+		return k.Passphrase, nil
+	}
+
+	passphrase, err := ioutil.ReadFile(k.PassphraseFile)
+	if err != nil {
+		return "", fmt.Errorf("couldn't read passphrase file: %s", err)
+	}
+
+	return strings.TrimSpace(string(passphrase)), nil
 }
 
-func (c *Config) Load(filePath string) {
+// Load reads the configuration file, then applies the overrides in the environment.
+// The file is decoded as YAML or TOML by its .yaml, .yml or .toml extension, else as JSON.
+func (c *Config) Load(filePath string) error {
 	configuration, err := ioutil.ReadFile(filePath)
 	if err != nil {
-		logrus.WithError(err).Error("Couldn't read config file")
+		return fmt.Errorf("couldn't read config file: %s", err)
 	}
 
-	err = json.Unmarshal(configuration, &c)
+	err = decode(filePath, configuration, c)
 	if err != nil {
-		logrus.WithError(err).Error("Couldn't unmarshal configuration")
+		return fmt.Errorf("couldn't unmarshal configuration: %s", err)
+	}
+
+	return c.ApplyEnv()
+}
+
+// FilePath returns the configuration file to load: the given flag value, else the file in
+// F_LICENSE_CONFIG, else config.json.
+func FilePath(flagValue string) string {
+	if flagValue != "" {
+		return flagValue
+	}
+
+	if filePath := os.Getenv(ConfigFileEnv); filePath != "" {
+		return filePath
 	}
+
+	return "config.json"
+}
+
+// SupportedAlgs are the signing algorithms licenses can be generated with.
+var SupportedAlgs = map[string]bool{
+	"HS256": true, "HS384": true, "HS512": true,
+	"RS256": true, "RS384": true, "RS512": true,
+	"PS256": true, "PS384": true, "PS512": true,
+	"ES256": true, "ES384": true, "ES512": true,
+	"EdDSA": true,
+}
+
+// ValidationError lists the problems found in a configuration.
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return "invalid configuration: " + strings.Join(e.Problems, "; ")
+}
+
+// Validate checks the configuration for problems that would prevent the server from working.
+func (c *Config) Validate() error {
+	var problems []string
+
+	if c.Port <= 0 || c.Port > 65535 {
+		problems = append(problems, fmt.Sprintf("invalid port: %d", c.Port))
+	}
+
+	if c.AdminSecret == "" {
+		problems = append(problems, "admin_secret is empty")
+	}
+
+	if c.MongoURL == "" {
+		problems = append(problems, "mongo_url is empty")
+	}
+
+	if c.DBName == "" {
+		problems = append(problems, "db_name is empty")
+	}
+
+	if c.MaxBatchSize < 0 {
+		problems = append(problems, fmt.Sprintf("invalid max_batch_size: %d", c.MaxBatchSize))
+	}
+
+	var appNames []string
+	for name := range c.Apps {
+		appNames = append(appNames, name)
+	}
+	sort.Strings(appNames)
+
+	for _, name := range appNames {
+		app := c.Apps[name]
+		if app == nil {
+			problems = append(problems, fmt.Sprintf("app %q is empty", name))
+			continue
+		}
+
+		if app.Alg != "" && !SupportedAlgs[app.Alg] {
+			problems = append(problems, fmt.Sprintf("unknown alg %q of app %q", app.Alg, name))
+			continue
+		}
+
+		problems = append(problems, app.Signature.problems(fmt.Sprintf("app %q", name), app.Alg)...)
+		problems = append(problems, templateProblems(fmt.Sprintf("app %q", name), app.Emails)...)
+	}
+
+	problems = append(problems, c.DefaultSignature.problems("default_signature", "")...)
+
+	if c.usesKeyStore() {
+		problems = append(problems, fileProblems("key_store.file", c.KeyStore.File)...)
+		if c.KeyStore.Passphrase == "" && c.KeyStore.PassphraseFile == "" {
+			problems = append(problems, "key_store passphrase is empty, set "+EnvPrefix+"KEY_STORE_PASSPHRASE or key_store.passphrase_file")
+		}
+	}
+
+	for _, network := range c.RateLimit.TrustedProxies {
+		if _, _, err := net.ParseCIDR(network); err != nil {
+			problems = append(problems, fmt.Sprintf("invalid rate_limit.trusted_proxies entry: %q", network))
+		}
+	}
+
+	if c.Webhooks.MaxAttempts < 0 || c.Webhooks.InitialBackoff < 0 || c.Webhooks.MaxBackoff < 0 || c.Webhooks.Timeout < 0 {
+		problems = append(problems, "webhooks settings can't be negative")
+	}
+
+	for _, network := range c.Webhooks.AllowedNetworks {
+		if _, _, err := net.ParseCIDR(network); err != nil {
+			problems = append(problems, fmt.Sprintf("invalid webhooks.allowed_networks entry: %q", network))
+		}
+	}
+
+	if c.Jobs.ExpiryInterval < 0 || c.Jobs.ReminderInterval < 0 || c.Jobs.PruneInterval < 0 || c.Jobs.DeliveryRetention < 0 {
+		problems = append(problems, "jobs settings can't be negative")
+	}
+
+	for _, days := range c.Jobs.ReminderDays {
+		if days <= 0 {
+			problems = append(problems, fmt.Sprintf("invalid jobs.reminder_days: %d", days))
+		}
+	}
+
+	problems = append(problems, c.Billing.problems()...)
+
+	if c.Email.Enable {
+		if c.Email.SMTP.Host == "" {
+			problems = append(problems, "email.smtp.host is empty")
+		}
+
+		if _, err := mail.ParseAddress(c.Email.From); err != nil {
+			problems = append(problems, fmt.Sprintf("invalid email.from: %q", c.Email.From))
+		}
+	}
+
+	if c.Email.MaxAttempts < 0 || c.Email.InitialBackoff < 0 || c.Email.MaxBackoff < 0 {
+		problems = append(problems, "email settings can't be negative")
+	}
+
+	problems = append(problems, templateProblems("email", c.Email.Templates)...)
+
+	if c.ServerOptions.EnableTLS {
+		problems = append(problems, fileProblems("server_options.cert_file", c.ServerOptions.CertFile)...)
+		problems = append(problems, fileProblems("server_options.key_file", c.ServerOptions.KeyFile)...)
+	}
+
+	if c.ServerOptions.ClientAuth.Enable {
+		// Client certificates are only presented in TLS handshakes
+		if !c.ServerOptions.EnableTLS {
+			problems = append(problems, "server_options.client_auth is enabled without server_options.enable_tls")
+		}
+
+		problems = append(problems, fileProblems("server_options.client_auth.ca_file", c.ServerOptions.ClientAuth.CAFile)...)
+
+		if len(c.ServerOptions.ClientAuth.Clients) == 0 {
+			problems = append(problems, "server_options.client_auth.clients is empty")
+		}
+	}
+
+	for i, client := range c.ServerOptions.ClientAuth.Clients {
+		if client.Role != "" && client.Role != RoleAdmin && client.Role != RoleReadOnly {
+			problems = append(problems, fmt.Sprintf("unknown role %q of server_options.client_auth.clients[%d]", client.Role, i))
+		}
+	}
+
+	if len(problems) > 0 {
+		return &ValidationError{Problems: problems}
+	}
+
+	return nil
+}
+
+func (c *Config) usesKeyStore() bool {
+	if c.DefaultSignature.KeyProvider == KeyProviderKeyStore {
+		return true
+	}
+
+	for _, app := range c.Apps {
+		if app != nil && app.Signature.KeyProvider == KeyProviderKeyStore {
+			return true
+		}
+	}
+
+	return false
+}
+
+// problems lists the missing keys of the signature for the given alg. Without an alg,
+// the keys which are configured must exist.
+func (s Signature) problems(owner, alg string) []string {
+	var problems []string
+
+	switch s.KeyProvider {
+	case "", KeyProviderFile:
+	case KeyProviderKeyStore, KeyProviderKMS:
+		if s.KeyID == "" {
+			problems = append(problems, fmt.Sprintf("key_id of %s is empty", owner))
+		}
+		return problems
+	default:
+		return append(problems, fmt.Sprintf("unknown key_provider %q of %s", s.KeyProvider, owner))
+	}
+
+	switch {
+	case strings.HasPrefix(alg, "HS"):
+		if s.HMACSecret == "" {
+			problems = append(problems, fmt.Sprintf("hmac_secret of %s is empty", owner))
+		}
+	case alg != "":
+		problems = append(problems, fileProblems("rsa_private_key_file of "+owner, s.RSAPrivateKeyFile)...)
+		problems = append(problems, fileProblems("rsa_public_key_file of "+owner, s.RSAPublicKeyFile)...)
+	default:
+		if s.HMACSecret == "" && s.RSAPrivateKeyFile == "" && s.RSAPublicKeyFile == "" {
+			problems = append(problems, fmt.Sprintf("%s has no key", owner))
+		}
+		if s.RSAPrivateKeyFile != "" {
+			problems = append(problems, fileProblems("rsa_private_key_file of "+owner, s.RSAPrivateKeyFile)...)
+		}
+		if s.RSAPublicKeyFile != "" {
+			problems = append(problems, fileProblems("rsa_public_key_file of "+owner, s.RSAPublicKeyFile)...)
+		}
+	}
+
+	return problems
+}
+
+func fileProblems(field, filePath string) []string {
+	if filePath == "" {
+		return []string{field + " is empty"}
+	}
+
+	if _, err := os.Stat(filePath); err != nil {
+		return []string{fmt.Sprintf("%s %s doesn't exist", field, filePath)}
+	}
+
+	return nil
 }
 
 type ServerOptions struct {
-	EnableTLS bool       `json:"enable_tls"`
-	CertFile  string     `json:"cert_file"`
-	KeyFile   string     `json:"key_file"`
-	TLSConfig tls.Config `json:"tls_config"`
+	EnableTLS  bool        `json:"enable_tls"`
+	CertFile   string      `json:"cert_file"`
+	KeyFile    string      `json:"key_file"`
+	TLSConfig  *tls.Config `json:"tls_config"`
+	ClientAuth ClientAuth  `json:"client_auth"`
 }
 
+// BuildTLSConfig returns the TLS configuration the server should listen with.
+// When client authentication is enabled, client certificates are requested and
+// verified against the configured CA bundle but not required at the handshake,
+// so that only admin routes reject connections without a certificate.
+func (s *ServerOptions) BuildTLSConfig() (*tls.Config, error) {
+	tlsConfig := s.TLSConfig.Clone()
+
+	if !s.ClientAuth.Enable {
+		return tlsConfig, nil
+	}
+
+	if tlsConfig == nil {
+		tlsConfig = &tls.Config{}
+	}
+
+	caBytes, err := ioutil.ReadFile(s.ClientAuth.CAFile)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read client CA file: %s", err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caBytes) {
+		return nil, errors.New("no certificate found in client CA file")
+	}
+
+	tlsConfig.ClientCAs = pool
+	tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+
+	return tlsConfig, nil
+}
+
+const (
+	RoleAdmin    = "admin"
+	RoleReadOnly = "read-only"
+)
+
+// ClientAuth configures mutual TLS for admin routes.
+type ClientAuth struct {
+	Enable  bool     `json:"enable"`
+	CAFile  string   `json:"ca_file"`
+	Clients []Client `json:"clients"`
+}
+
+// Client is an allowed admin client. A certificate matches if its subject common name
+// equals Subject or one of its DNS, email or URI SANs is listed in SANs.
+type Client struct {
+	Subject string   `json:"subject"`
+	SANs    []string `json:"sans"`
+	Role    string   `json:"role"`
+}
+
+// Match returns the client matching the given certificate.
+func (c ClientAuth) Match(cert *x509.Certificate) (*Client, bool) {
+	var names []string
+	names = append(names, cert.DNSNames...)
+	names = append(names, cert.EmailAddresses...)
+	for _, uri := range cert.URIs {
+		names = append(names, uri.String())
+	}
+
+	for i := range c.Clients {
+		client := &c.Clients[i]
+		if client.Subject != "" && client.Subject == cert.Subject.CommonName {
+			return client, true
+		}
+
+		for _, san := range client.SANs {
+			for _, name := range names {
+				if san == name {
+					return client, true
+				}
+			}
+		}
+	}
+
+	return nil, false
+}
+
+// GetRole returns the role of the client, admin by default.
+func (c *Client) GetRole() string {
+	if c.Role == "" {
+		return RoleAdmin
+	}
+
+	return c.Role
+}
+
+// RateLimit configures throttling of the public license endpoints. Requests are limited
+// per client IP and per license token.
+type RateLimit struct {
+	Enable   bool            `json:"enable"`
+	PerIP    ratelimit.Limit `json:"per_ip"`
+	PerToken ratelimit.Limit `json:"per_token"`
+	// TrustForwardedFor makes the client IP be taken from X-Forwarded-For header.
+	// Enable it only when the server is behind a trusted proxy.
+	TrustForwardedFor bool `json:"trust_forwarded_for"`
+	// TrustedProxies are the CIDRs of the proxies in front of the server. The client IP is
+	// the rightmost X-Forwarded-For entry that isn't one of them. If it is empty, only the
+	// proxy connecting to the server is trusted and the rightmost entry is taken.
+	TrustedProxies []string `json:"trusted_proxies,omitempty"`
+}
+
+// GetTrustedProxies returns the parsed trusted_proxies, skipping the invalid ones.
+func (r RateLimit) GetTrustedProxies() []*net.IPNet {
+	var networks []*net.IPNet
+	for _, network := range r.TrustedProxies {
+		if _, ipNet, err := net.ParseCIDR(network); err == nil {
+			networks = append(networks, ipNet)
+		}
+	}
+
+	return networks
+}
+
+// Webhooks configures the delivery of webhook events. A failed delivery is retried with
+// exponential backoff from InitialBackoff up to MaxBackoff, and moved to the dead-letter
+// list after MaxAttempts. Durations are in seconds and zero values mean the defaults.
+type Webhooks struct {
+	MaxAttempts    int `json:"max_attempts"`
+	InitialBackoff int `json:"initial_backoff"`
+	MaxBackoff     int `json:"max_backoff"`
+	// Timeout is how long a receiver is waited for.
+	Timeout int `json:"timeout"`
+	// AllowedNetworks are the CIDRs of internal networks receivers can be in, e.g.
+	// "10.1.0.0/16". Receivers in loopback, link-local and private networks are refused
+	// otherwise.
+	AllowedNetworks []string `json:"allowed_networks,omitempty"`
+}
+
+func (w Webhooks) GetMaxAttempts() int {
+	if w.MaxAttempts == 0 {
+		return 8
+	}
+
+	return w.MaxAttempts
+}
+
+func (w Webhooks) GetInitialBackoff() time.Duration {
+	return seconds(w.InitialBackoff, 30*time.Second)
+}
+
+func (w Webhooks) GetMaxBackoff() time.Duration {
+	return seconds(w.MaxBackoff, time.Hour)
+}
+
+func (w Webhooks) GetTimeout() time.Duration {
+	return seconds(w.Timeout, 10*time.Second)
+}
+
+// GetAllowedNetworks returns the parsed allowed_networks, skipping the invalid ones.
+func (w Webhooks) GetAllowedNetworks() []*net.IPNet {
+	var networks []*net.IPNet
+	for _, network := range w.AllowedNetworks {
+		if _, ipNet, err := net.ParseCIDR(network); err == nil {
+			networks = append(networks, ipNet)
+		}
+	}
+
+	return networks
+}
+
+// Jobs configures the jobs run in the background. Intervals are in seconds.
+type Jobs struct {
+	// ExpiryInterval is how often expired licenses are inactivated and expired leases of
+	// floating licenses are reclaimed.
+	ExpiryInterval int `json:"expiry_interval"`
+	// ReminderDays are the days before the expiry of licenses when the expiring events are
+	// emitted and customers are emailed. No reminders are sent if it is empty.
+	ReminderDays     []int `json:"reminder_days,omitempty"`
+	ReminderInterval int   `json:"reminder_interval"`
+	PruneInterval    int   `json:"prune_interval"`
+	// DeliveryRetention is how many days delivered and dead webhook deliveries, sent and dead
+	// emails, and processed billing events are kept.
+	DeliveryRetention int `json:"delivery_retention"`
+}
+
+func (j Jobs) GetExpiryInterval() time.Duration {
+	return seconds(j.ExpiryInterval, time.Minute)
+}
+
+func (j Jobs) GetReminderInterval() time.Duration {
+	return seconds(j.ReminderInterval, time.Hour)
+}
+
+func (j Jobs) GetPruneInterval() time.Duration {
+	return seconds(j.PruneInterval, 24*time.Hour)
+}
+
+func (j Jobs) GetDeliveryRetention() time.Duration {
+	days := j.DeliveryRetention
+	if days == 0 {
+		days = 30
+	}
+
+	return time.Duration(days) * 24 * time.Hour
+}
+
+func seconds(n int, defaultDuration time.Duration) time.Duration {
+	if n == 0 {
+		return defaultDuration
+	}
+
+	return time.Duration(n) * time.Second
+}
+
+const BillingProviderStripe = "stripe"
+
+// Kinds of the billing events rules map to actions.
+const (
+	BillingSubscriptionCreated   = "subscription.created"
+	BillingSubscriptionRenewed   = "subscription.renewed"
+	BillingSubscriptionCancelled = "subscription.cancelled"
+	BillingPaymentFailed         = "payment.failed"
+)
+
+// Actions taken on the licenses of a subscription. Suspended licenses are inactivated
+// until the subscription is renewed, and revoked ones are inactivated for good.
+const (
+	ActionGenerate = "generate"
+	ActionRenew    = "renew"
+	ActionSuspend  = "suspend"
+	ActionRevoke   = "revoke"
+	ActionIgnore   = "ignore"
+)
+
+// DefaultBillingRules are the actions of the events which the rules of a plan don't have.
+var DefaultBillingRules = map[string]string{
+	BillingSubscriptionCreated:   ActionGenerate,
+	BillingSubscriptionRenewed:   ActionRenew,
+	BillingSubscriptionCancelled: ActionRevoke,
+	BillingPaymentFailed:         ActionSuspend,
+}
+
+// Billing configures the webhook of the payment provider, which issues and revokes the
+// licenses of subscriptions. Plans are keyed by the plan ID in the provider, e.g. the
+// price ID in Stripe.
+type Billing struct {
+	Enable        bool   `json:"enable"`
+	Provider      string `json:"provider"`
+	WebhookSecret string `json:"webhook_secret"`
+	// Tolerance is how many seconds the signature timestamp of events can differ from now.
+	Tolerance int                     `json:"tolerance"`
+	Plans     map[string]*BillingPlan `json:"plans,omitempty"`
+}
+
+func (b Billing) GetTolerance() time.Duration {
+	return seconds(b.Tolerance, 5*time.Minute)
+}
+
+func (b Billing) problems() []string {
+	if !b.Enable {
+		return nil
+	}
+
+	var problems []string
+	if b.Provider != BillingProviderStripe {
+		problems = append(problems, fmt.Sprintf("unknown billing.provider %q", b.Provider))
+	}
+
+	if b.WebhookSecret == "" {
+		problems = append(problems, "billing.webhook_secret is empty")
+	}
+
+	if b.Tolerance < 0 {
+		problems = append(problems, "billing.tolerance can't be negative")
+	}
+
+	var names []string
+	for name := range b.Plans {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		plan := b.Plans[name]
+		if plan == nil {
+			problems = append(problems, fmt.Sprintf("billing plan %q is empty", name))
+			continue
+		}
+
+		if plan.App == "" {
+			problems = append(problems, fmt.Sprintf("app of billing plan %q is empty", name))
+		}
+
+		if plan.GraceDays < 0 {
+			problems = append(problems, fmt.Sprintf("grace_days of billing plan %q can't be negative", name))
+		}
+
+		var kinds []string
+		for kind := range plan.Rules {
+			kinds = append(kinds, kind)
+		}
+		sort.Strings(kinds)
+
+		for _, kind := range kinds {
+			if _, ok := DefaultBillingRules[kind]; !ok {
+				problems = append(problems, fmt.Sprintf("unknown billing event %q in rules of billing plan %q", kind, name))
+				continue
+			}
+
+			switch plan.Rules[kind] {
+			case ActionGenerate, ActionRenew, ActionSuspend, ActionRevoke, ActionIgnore:
+			default:
+				problems = append(problems, fmt.Sprintf("unknown action %q of %q in rules of billing plan %q", plan.Rules[kind], kind, name))
+			}
+		}
+	}
+
+	return problems
+}
+
+// BillingPlan is a plan of the payment provider. Licenses of its subscriptions are generated
+// for the app with the type and the claims of the plan of the app, and expire GraceDays
+// after the paid period ends.
+type BillingPlan struct {
+	App       string `json:"app"`
+	Plan      string `json:"plan,omitempty"`
+	GraceDays int    `json:"grace_days"`
+	// Rules map the kinds of billing events to actions, replacing DefaultBillingRules.
+	Rules map[string]string `json:"rules,omitempty"`
+}
+
+// Action returns the action taken on the billing event of the kind.
+func (p *BillingPlan) Action(kind string) string {
+	if action, ok := p.Rules[kind]; ok {
+		return action
+	}
+
+	if action, ok := DefaultBillingRules[kind]; ok {
+		return action
+	}
+
+	return ActionIgnore
+}
+
+// Kinds of the emails sent to customers.
+const (
+	EmailIssued   = "issued"
+	EmailExpiring = "expiring"
+	EmailRevoked  = "revoked"
+)
+
+// Email configures the emails sent to customers when their licenses are issued, revoked or
+// about to expire. Templates replace the default templates of every app by kind, and the
+// templates of an app replace these. Emails are queued in an outbox, and a failed send is
+// retried like a webhook delivery. Durations are in seconds and zero values mean the
+// defaults.
+type Email struct {
+	Enable         bool                      `json:"enable"`
+	From           string                    `json:"from"`
+	SMTP           SMTP                      `json:"smtp"`
+	Templates      map[string]*EmailTemplate `json:"templates,omitempty"`
+	MaxAttempts    int                       `json:"max_attempts"`
+	InitialBackoff int                       `json:"initial_backoff"`
+	MaxBackoff     int                       `json:"max_backoff"`
+}
+
+func (e Email) GetMaxAttempts() int {
+	if e.MaxAttempts == 0 {
+		return 8
+	}
+
+	return e.MaxAttempts
+}
+
+func (e Email) GetInitialBackoff() time.Duration {
+	return seconds(e.InitialBackoff, time.Minute)
+}
+
+func (e Email) GetMaxBackoff() time.Duration {
+	return seconds(e.MaxBackoff, time.Hour)
+}
+
+// SMTP configures the server emails are sent through. STARTTLS is used when the server
+// supports it, and TLS connects with implicit TLS instead, usually on port 465.
+type SMTP struct {
+	Host     string `json:"host"`
+	Port     int    `json:"port"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+	TLS      bool   `json:"tls"`
+}
+
+// GetPort returns the port, 587 by default or 465 with TLS.
+func (s SMTP) GetPort() int {
+	switch {
+	case s.Port != 0:
+		return s.Port
+	case s.TLS:
+		return 465
+	default:
+		return 587
+	}
+}
+
+// EmailTemplate is a text/template of the subject and the body of an email.
+type EmailTemplate struct {
+	Subject string `json:"subject" bson:"subject"`
+	Body    string `json:"body" bson:"body"`
+}
+
+func templateProblems(owner string, templates map[string]*EmailTemplate) []string {
+	var kinds []string
+	for kind := range templates {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+
+	var problems []string
+	for _, kind := range kinds {
+		switch kind {
+		case EmailIssued, EmailExpiring, EmailRevoked:
+		default:
+			problems = append(problems, fmt.Sprintf("unknown email template %q of %s", kind, owner))
+			continue
+		}
+
+		t := templates[kind]
+		if t == nil {
+			problems = append(problems, fmt.Sprintf("email template %q of %s is empty", kind, owner))
+			continue
+		}
+
+		if _, err := template.New("subject").Parse(t.Subject); err != nil {
+			problems = append(problems, fmt.Sprintf("invalid subject of email template %q of %s: %s", kind, owner, err))
+		}
+
+		if _, err := template.New("body").Parse(t.Body); err != nil {
+			problems = append(problems, fmt.Sprintf("invalid body of email template %q of %s: %s", kind, owner, err))
+		}
+	}
+
+	return problems
+}
+
+// App is a product licenses are generated for. Apps are configured in the apps map or
+// stored at runtime, where the name is their ID.
 type App struct {
-	Name      string    `json:"name"`
-	Alg       string    `json:"alg"`
-	Signature Signature `json:"signature"`
+	Name      string    `json:"name" bson:"_id"`
+	Alg       string    `json:"alg" bson:"alg"`
+	Signature Signature `json:"signature" bson:"signature"`
+	Plans     []Plan    `json:"plans,omitempty" bson:"plans,omitempty"`
+	// Emails replace the templates of the emails sent to the customers of the app by kind.
+	Emails map[string]*EmailTemplate `json:"emails,omitempty" bson:"emails,omitempty"`
+	// Tenant owns a stored app. Apps of the configuration have no tenant and are shared
+	// by all tenants.
+	Tenant string `json:"tenant,omitempty" bson:"tenant,omitempty"`
+}
+
+// Plan is an offering of an app, e.g. a subscription tier.
+type Plan struct {
+	Name string `json:"name" bson:"name"`
+	// Typ is the license type of the plan, e.g. Trial.
+	Typ string `json:"typ,omitempty" bson:"typ,omitempty"`
+	// Claims are included in the licenses of the plan.
+	Claims map[string]interface{} `json:"claims,omitempty" bson:"claims,omitempty"`
+}
+
+// GetPlan returns the plan with the name, or nil if the app has no such plan.
+func (a *App) GetPlan(name string) *Plan {
+	for i := range a.Plans {
+		if a.Plans[i].Name == name {
+			return &a.Plans[i]
+		}
+	}
+
+	return nil
+}
+
+// Validate checks the app can be used to sign licenses, except its keys.
+func (a *App) Validate() error {
+	var problems []string
+	if a.Name == "" {
+		problems = append(problems, "name is empty")
+	}
+
+	if a.Alg == "" {
+		problems = append(problems, "alg is empty")
+	} else if !SupportedAlgs[a.Alg] {
+		problems = append(problems, fmt.Sprintf("unknown alg %q", a.Alg))
+	}
+
+	planNames := map[string]bool{}
+	for _, plan := range a.Plans {
+		if plan.Name == "" {
+			problems = append(problems, "plan name is empty")
+		} else if planNames[plan.Name] {
+			problems = append(problems, fmt.Sprintf("plan %q is duplicated", plan.Name))
+		}
+		planNames[plan.Name] = true
+	}
+
+	problems = append(problems, templateProblems("the app", a.Emails)...)
+
+	if len(problems) > 0 {
+		return errors.New("invalid app: " + strings.Join(problems, "; "))
+	}
+
+	return nil
 }
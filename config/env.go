@@ -0,0 +1,124 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// EnvPrefix is the prefix of the environment variables overriding configuration fields.
+// A field is overridden by the variable named after its JSON path, e.g. mongo_url by
+// F_LICENSE_MONGO_URL and server_options.client_auth.enable by
+// F_LICENSE_SERVER_OPTIONS_CLIENT_AUTH_ENABLE. Fields of apps are overridden by their
+// app name in upper case with dashes replaced, e.g. F_LICENSE_APPS_TEST_APP_ALG.
+const EnvPrefix = "F_LICENSE_"
+
+// ConfigFileEnv is the environment variable pointing to the configuration file.
+const ConfigFileEnv = EnvPrefix + "CONFIG"
+
+// ApplyEnv overrides the fields of the configuration which have an environment variable set.
+func (c *Config) ApplyEnv() error {
+	return c.applyEnv(os.LookupEnv)
+}
+
+func (c *Config) applyEnv(lookup func(key string) (string, bool)) error {
+	var problems []string
+	applyEnvToStruct(reflect.ValueOf(c).Elem(), strings.TrimSuffix(EnvPrefix, "_"), lookup, &problems)
+
+	if len(problems) > 0 {
+		return &ValidationError{Problems: problems}
+	}
+
+	return nil
+}
+
+func applyEnvToStruct(v reflect.Value, prefix string, lookup func(key string) (string, bool), problems *[]string) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		tag := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		if tag == "" || tag == "-" {
+			continue
+		}
+
+		applyEnvToValue(v.Field(i), envName(prefix, tag), lookup, problems)
+	}
+}
+
+func applyEnvToValue(v reflect.Value, name string, lookup func(key string) (string, bool), problems *[]string) {
+	switch v.Kind() {
+	case reflect.Struct:
+		applyEnvToStruct(v, name, lookup, problems)
+		return
+	case reflect.Ptr:
+		if !v.IsNil() {
+			applyEnvToValue(v.Elem(), name, lookup, problems)
+		}
+		return
+	case reflect.Map:
+		var keys []string
+		for _, key := range v.MapKeys() {
+			keys = append(keys, key.String())
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			applyEnvToValue(v.MapIndex(reflect.ValueOf(key)), envName(name, key), lookup, problems)
+		}
+		return
+	}
+
+	value, ok := lookup(name)
+	if !ok {
+		return
+	}
+
+	var err error
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(value)
+	case reflect.Bool:
+		var b bool
+		b, err = strconv.ParseBool(value)
+		v.SetBool(b)
+	case reflect.Int, reflect.Int64:
+		var n int64
+		n, err = strconv.ParseInt(value, 10, 64)
+		v.SetInt(n)
+	case reflect.Float64:
+		var f float64
+		f, err = strconv.ParseFloat(value, 64)
+		v.SetFloat(f)
+	case reflect.Slice:
+		// Lists of numbers are separated by commas
+		if v.Type().Elem().Kind() != reflect.Int {
+			err = fmt.Errorf("%s fields can't be set from the environment", v.Kind())
+			break
+		}
+
+		numbers := reflect.MakeSlice(v.Type(), 0, 0)
+		for _, s := range strings.Split(value, ",") {
+			var n int
+			if n, err = strconv.Atoi(strings.TrimSpace(s)); err != nil {
+				break
+			}
+			numbers = reflect.Append(numbers, reflect.ValueOf(n))
+		}
+
+		if err == nil {
+			v.Set(numbers)
+		}
+	default:
+		err = fmt.Errorf("%s fields can't be set from the environment", v.Kind())
+	}
+
+	if err != nil {
+		*problems = append(*problems, fmt.Sprintf("invalid %s: %s", name, err))
+	}
+}
+
+func envName(prefix, name string) string {
+	return prefix + "_" + strings.ToUpper(strings.NewReplacer("-", "_", ".", "_").Replace(name))
+}
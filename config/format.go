@@ -0,0 +1,87 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v2"
+)
+
+// toJSON converts configuration files of other formats to JSON, so that every format is
+// decoded through the same JSON tags into identical configurations. Files with unknown
+// extensions are read as JSON.
+var toJSON = map[string]func(data []byte) ([]byte, error){
+	".yaml": yamlToJSON,
+	".yml":  yamlToJSON,
+	".toml": tomlToJSON,
+}
+
+func decode(filePath string, data []byte, c *Config) error {
+	if convert, ok := toJSON[strings.ToLower(filepath.Ext(filePath))]; ok {
+		var err error
+		data, err = convert(data)
+		if err != nil {
+			return err
+		}
+	}
+
+	return json.Unmarshal(data, c)
+}
+
+func yamlToJSON(data []byte) ([]byte, error) {
+	var v interface{}
+	if err := yaml.Unmarshal(data, &v); err != nil {
+		return nil, err
+	}
+
+	v, err := stringKeys(v)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(v)
+}
+
+// stringKeys converts the maps decoded from YAML, which have interface{} keys, to maps
+// with string keys which can be encoded to JSON.
+func stringKeys(v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			k, ok := key.(string)
+			if !ok {
+				return nil, fmt.Errorf("key %v is not a string", key)
+			}
+
+			converted, err := stringKeys(value)
+			if err != nil {
+				return nil, err
+			}
+			m[k] = converted
+		}
+		return m, nil
+	case []interface{}:
+		for i, value := range v {
+			converted, err := stringKeys(value)
+			if err != nil {
+				return nil, err
+			}
+			v[i] = converted
+		}
+	}
+
+	return v, nil
+}
+
+func tomlToJSON(data []byte) ([]byte, error) {
+	var v map[string]interface{}
+	if _, err := toml.Decode(string(data), &v); err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(v)
+}
@@ -0,0 +1,320 @@
+package config
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// current holds the configuration returned by Get.
+var current atomic.Value
+
+func init() {
+	current.Store(&Config{})
+}
+
+// Get returns the current configuration, which can be reloaded concurrently. The returned
+// configuration must not be modified; a Clone of it can be changed and Set instead.
+func Get() *Config {
+	return current.Load().(*Config)
+}
+
+// Set replaces the current configuration atomically.
+func Set(c *Config) {
+	current.Store(c)
+}
+
+// Clone returns a copy of the configuration which can be changed without changing the
+// configuration in use: its apps, maps, lists and TLS configuration are copied too.
+func (c *Config) Clone() *Config {
+	clone := *c
+
+	if c.Apps != nil {
+		clone.Apps = make(map[string]*App, len(c.Apps))
+		for name, app := range c.Apps {
+			clone.Apps[name] = app.clone()
+		}
+	}
+
+	clone.ServerOptions.TLSConfig = c.ServerOptions.TLSConfig.Clone()
+	clone.ServerOptions.ClientAuth.Clients = cloneClients(c.ServerOptions.ClientAuth.Clients)
+	clone.RateLimit.TrustedProxies = cloneStrings(c.RateLimit.TrustedProxies)
+	clone.Webhooks.AllowedNetworks = cloneStrings(c.Webhooks.AllowedNetworks)
+	clone.Email.Templates = cloneTemplates(c.Email.Templates)
+	clone.Jobs.ReminderDays = cloneInts(c.Jobs.ReminderDays)
+	clone.Billing.Plans = cloneBillingPlans(c.Billing.Plans)
+
+	return &clone
+}
+
+func (a *App) clone() *App {
+	if a == nil {
+		return nil
+	}
+
+	clone := *a
+
+	if a.Plans != nil {
+		clone.Plans = make([]Plan, len(a.Plans))
+		for i, plan := range a.Plans {
+			if claims := plan.Claims; claims != nil {
+				plan.Claims = make(map[string]interface{}, len(claims))
+				for k, v := range claims {
+					plan.Claims[k] = v
+				}
+			}
+			clone.Plans[i] = plan
+		}
+	}
+
+	clone.Emails = cloneTemplates(a.Emails)
+
+	return &clone
+}
+
+func cloneClients(clients []Client) []Client {
+	if clients == nil {
+		return nil
+	}
+
+	clone := make([]Client, len(clients))
+	for i, client := range clients {
+		client.SANs = cloneStrings(client.SANs)
+		clone[i] = client
+	}
+
+	return clone
+}
+
+func cloneTemplates(templates map[string]*EmailTemplate) map[string]*EmailTemplate {
+	if templates == nil {
+		return nil
+	}
+
+	clone := make(map[string]*EmailTemplate, len(templates))
+	for kind, template := range templates {
+		if template != nil {
+			templateClone := *template
+			template = &templateClone
+		}
+		clone[kind] = template
+	}
+
+	return clone
+}
+
+func cloneBillingPlans(plans map[string]*BillingPlan) map[string]*BillingPlan {
+	if plans == nil {
+		return nil
+	}
+
+	clone := make(map[string]*BillingPlan, len(plans))
+	for id, plan := range plans {
+		if plan != nil {
+			planClone := *plan
+			if plan.Rules != nil {
+				planClone.Rules = make(map[string]string, len(plan.Rules))
+				for kind, action := range plan.Rules {
+					planClone.Rules[kind] = action
+				}
+			}
+			plan = &planClone
+		}
+		clone[id] = plan
+	}
+
+	return clone
+}
+
+func cloneStrings(s []string) []string {
+	if s == nil {
+		return nil
+	}
+
+	return append(make([]string, 0, len(s)), s...)
+}
+
+func cloneInts(n []int) []int {
+	if n == nil {
+		return nil
+	}
+
+	return append(make([]int, 0, len(n)), n...)
+}
+
+// restartFields are the fields which are applied only when the server starts.
+var restartFields = []string{"port", "mongo_url", "db_name", "server_options"}
+
+// Change is a difference between two configurations.
+type Change struct {
+	Field string
+	// Description tells how the field changed. Values are included only for numbers,
+	// booleans and the strings known not to be secret.
+	Description string
+	// RequiresRestart tells the change is not applied until the server is restarted.
+	RequiresRestart bool
+}
+
+func (c Change) String() string {
+	return c.Field + " " + c.Description
+}
+
+// Diff lists the differences from old to new configuration sorted by field.
+func Diff(old, new *Config) []Change {
+	oldFields, newFields := map[string]reflect.Value{}, map[string]reflect.Value{}
+	oldEntries, newEntries := map[string]bool{}, map[string]bool{}
+	flatten(reflect.ValueOf(old).Elem(), "", oldFields, oldEntries)
+	flatten(reflect.ValueOf(new).Elem(), "", newFields, newEntries)
+
+	var changes []Change
+	var changedEntries []string
+	for entry := range oldEntries {
+		if !newEntries[entry] {
+			changes = append(changes, Change{Field: entry, Description: "removed"})
+			changedEntries = append(changedEntries, entry)
+		}
+	}
+
+	for entry := range newEntries {
+		if !oldEntries[entry] {
+			changes = append(changes, Change{Field: entry, Description: "added"})
+			changedEntries = append(changedEntries, entry)
+		}
+	}
+
+	// Fields of added or removed map entries are covered by the entry itself
+	covered := func(field string) bool {
+		for _, entry := range changedEntries {
+			if field == entry || strings.HasPrefix(field, entry+".") {
+				return true
+			}
+		}
+		return false
+	}
+
+	for field, oldValue := range oldFields {
+		newValue, ok := newFields[field]
+		if !ok || covered(field) || reflect.DeepEqual(oldValue.Interface(), newValue.Interface()) {
+			continue
+		}
+
+		description := "changed"
+		if isLoggable(field, oldValue) {
+			description = fmt.Sprintf("changed from %v to %v", oldValue.Interface(), newValue.Interface())
+		}
+		changes = append(changes, Change{Field: field, Description: description})
+	}
+
+	for i := range changes {
+		for _, restartField := range restartFields {
+			if changes[i].Field == restartField || strings.HasPrefix(changes[i].Field, restartField+".") {
+				changes[i].RequiresRestart = true
+			}
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Field < changes[j].Field })
+
+	return changes
+}
+
+// flatten collects the fields of v by their JSON paths, and the keys of its maps as entries.
+func flatten(v reflect.Value, prefix string, fields map[string]reflect.Value, entries map[string]bool) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		tag := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		if tag == "" || tag == "-" {
+			continue
+		}
+
+		path := tag
+		if prefix != "" {
+			path = prefix + "." + tag
+		}
+
+		field := v.Field(i)
+		switch {
+		case field.Kind() == reflect.Struct && hasJSONTags(field.Type()):
+			flatten(field, path, fields, entries)
+		case field.Kind() == reflect.Map:
+			for _, key := range field.MapKeys() {
+				entry := field.MapIndex(key)
+				entryPath := path + "." + key.String()
+				entries[entryPath] = true
+
+				if entry.Kind() == reflect.Ptr && !entry.IsNil() && entry.Elem().Kind() == reflect.Struct {
+					flatten(entry.Elem(), entryPath, fields, entries)
+					continue
+				}
+				fields[entryPath] = entry
+			}
+		default:
+			fields[path] = field
+		}
+	}
+}
+
+func hasJSONTags(t reflect.Type) bool {
+	for i := 0; i < t.NumField(); i++ {
+		if t.Field(i).Tag.Get("json") != "" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// loggableStrings are the string fields whose values Diff shows. Other strings can hold
+// credentials, like the user info of mongo_url, SMTP usernames or key IDs.
+var loggableStrings = map[string]bool{"alg": true, "db_name": true, "key_provider": true, "provider": true}
+
+func isLoggable(field string, v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Bool, reflect.Int, reflect.Int64, reflect.Float64:
+		return true
+	case reflect.String:
+		return loggableStrings[field[strings.LastIndex(field, ".")+1:]]
+	}
+
+	return false
+}
+
+// Watch calls onChange whenever the content of the file changes, checking it every
+// interval until stop is closed.
+func Watch(filePath string, interval time.Duration, stop <-chan struct{}, onChange func()) {
+	sum := fileSum(filePath)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			newSum := fileSum(filePath)
+			if newSum == nil || (sum != nil && *newSum == *sum) {
+				continue
+			}
+
+			sum = newSum
+			onChange()
+		}
+	}
+}
+
+func fileSum(filePath string) *[sha256.Size]byte {
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return nil
+	}
+
+	sum := sha256.Sum256(data)
+
+	return &sum
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/furkansenharputlu/f-license/lcs"
+	"github.com/furkansenharputlu/f-license/storage"
+
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func CreateCustomer(w http.ResponseWriter, r *http.Request) {
+	c, ok := readCustomer(w, r)
+	if !ok {
+		return
+	}
+
+	err := storage.LicenseHandler.CreateCustomer(r.Context(), c)
+	if err != nil {
+		logrus.WithError(err).Error("Customer couldn't be stored")
+		ReturnError(w, customerErrorStatus(err), err.Error())
+		return
+	}
+
+	logrus.Infof("Customer is successfully created: %s", c.ID.Hex())
+
+	ReturnResponse(w, http.StatusOK, c)
+}
+
+func GetCustomers(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	customers, err := storage.LicenseHandler.ListCustomers(r.Context(), storage.CustomerFilter{
+		Email:     query.Get("email"),
+		Company:   query.Get("company"),
+		BillingID: query.Get("billing_id"),
+	})
+	if err != nil {
+		ReturnError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	ReturnResponse(w, http.StatusOK, customers)
+}
+
+func GetCustomer(w http.ResponseWriter, r *http.Request) {
+	c, err := storage.LicenseHandler.GetCustomer(r.Context(), mux.Vars(r)["id"])
+	if err != nil {
+		ReturnError(w, customerErrorStatus(err), err.Error())
+		return
+	}
+
+	ReturnResponse(w, http.StatusOK, c)
+}
+
+func UpdateCustomer(w http.ResponseWriter, r *http.Request) {
+	c, ok := readCustomer(w, r)
+	if !ok {
+		return
+	}
+
+	var err error
+	c.ID, err = primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+	if err != nil {
+		ReturnError(w, http.StatusBadRequest, "ID format error: "+err.Error())
+		return
+	}
+
+	err = storage.LicenseHandler.UpdateCustomer(r.Context(), c)
+	if err != nil {
+		logrus.WithError(err).Error("Customer couldn't be updated")
+		ReturnError(w, customerErrorStatus(err), err.Error())
+		return
+	}
+
+	logrus.Infof("Customer is successfully updated: %s", c.ID.Hex())
+
+	ReturnResponse(w, http.StatusOK, c)
+}
+
+func DeleteCustomer(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	err := storage.LicenseHandler.DeleteCustomer(r.Context(), id)
+	if err != nil {
+		logrus.WithError(err).Error("Customer couldn't be deleted")
+		ReturnError(w, customerErrorStatus(err), err.Error())
+		return
+	}
+
+	logrus.Infof("Customer is successfully deleted: %s", id)
+
+	ReturnResponse(w, http.StatusOK, map[string]interface{}{
+		"message": "Customer successfully deleted",
+	})
+}
+
+// GetCustomerLicenses returns the customer with its orders and licenses.
+func GetCustomerLicenses(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	c, err := storage.LicenseHandler.GetCustomer(r.Context(), id)
+	if err != nil {
+		ReturnError(w, customerErrorStatus(err), err.Error())
+		return
+	}
+
+	orders, err := storage.LicenseHandler.ListOrders(r.Context(), id)
+	if err != nil {
+		ReturnError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	licenses := make([]*lcs.License, 0)
+	total, err := storage.LicenseHandler.List(r.Context(), storage.ListOptions{CustomerID: id}, &licenses)
+	if err != nil {
+		ReturnError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	w.Header().Set("X-Total-Count", strconv.FormatInt(total, 10))
+	ReturnResponse(w, http.StatusOK, map[string]interface{}{
+		"customer": c,
+		"orders":   orders,
+		"licenses": licenses,
+	})
+}
+
+func CreateOrder(w http.ResponseWriter, r *http.Request) {
+	var o lcs.Order
+	if err := json.NewDecoder(r.Body).Decode(&o); err != nil {
+		ReturnError(w, http.StatusBadRequest, "invalid order: "+err.Error())
+		return
+	}
+
+	if err := o.Validate(); err != nil {
+		ReturnError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	err := storage.LicenseHandler.CreateOrder(r.Context(), &o)
+	if err != nil {
+		logrus.WithError(err).Error("Order couldn't be stored")
+		ReturnError(w, customerErrorStatus(err), err.Error())
+		return
+	}
+
+	logrus.Infof("Order is successfully created: %s", o.ID.Hex())
+
+	ReturnResponse(w, http.StatusOK, o)
+}
+
+func GetOrders(w http.ResponseWriter, r *http.Request) {
+	orders, err := storage.LicenseHandler.ListOrders(r.Context(), r.URL.Query().Get("customer_id"))
+	if err != nil {
+		ReturnError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	ReturnResponse(w, http.StatusOK, orders)
+}
+
+func GetOrder(w http.ResponseWriter, r *http.Request) {
+	o, err := storage.LicenseHandler.GetOrder(r.Context(), mux.Vars(r)["id"])
+	if err != nil {
+		ReturnError(w, customerErrorStatus(err), err.Error())
+		return
+	}
+
+	ReturnResponse(w, http.StatusOK, o)
+}
+
+func readCustomer(w http.ResponseWriter, r *http.Request) (*lcs.Customer, bool) {
+	var c lcs.Customer
+	if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
+		ReturnError(w, http.StatusBadRequest, "invalid customer: "+err.Error())
+		return nil, false
+	}
+
+	if err := c.Validate(); err != nil {
+		ReturnError(w, http.StatusBadRequest, err.Error())
+		return nil, false
+	}
+
+	return &c, true
+}
+
+func customerErrorStatus(err error) int {
+	switch err {
+	case storage.ErrCustomerNotFound, storage.ErrOrderNotFound:
+		return http.StatusNotFound
+	case storage.ErrCustomerInUse:
+		return http.StatusConflict
+	case storage.ErrOrderMismatch:
+		return http.StatusBadRequest
+	}
+
+	return tenantErrorStatus(err)
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/furkansenharputlu/f-license/lcs"
+	"github.com/furkansenharputlu/f-license/storage"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCustomers(t *testing.T) {
+	defer storage.LicenseHandler.DropDatabase(context.Background())
+
+	resp := tr.Run(t, &TestCase{Method: http.MethodPost, Path: "/admin/customers",
+		Data:      map[string]string{"name": "Furkan", "email": "furkan@example.com", "company": "Acme", "billing_id": "cus_1"},
+		BodyMatch: `"name":"Furkan","email":"furkan@example.com","company":"Acme","billing_id":"cus_1"`})
+
+	var customer lcs.Customer
+	_ = json.NewDecoder(resp.Body).Decode(&customer)
+	customerID := customer.ID.Hex()
+
+	resp = tr.Run(t, &TestCase{Method: http.MethodPost, Path: "/admin/customers", Data: map[string]string{"name": "Ahmet"},
+		BodyMatch: `"error":"invalid customer: email is empty"`})
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	resp = tr.Run(t, &TestCase{Method: http.MethodPost, Path: "/admin/customers", Data: map[string]string{"email": "not an email"},
+		BodyMatch: `"error":"invalid customer: name is empty; invalid email: not an email"`})
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	tr.Run(t, &TestCase{Method: http.MethodPost, Path: "/admin/customers",
+		Data: map[string]string{"name": "Mehmet", "email": "mehmet@example.com"}, BodyMatch: `"name":"Mehmet"`})
+
+	t.Run("get, list and update", func(t *testing.T) {
+		tr.Run(t, &TestCase{Method: http.MethodGet, Path: "/admin/customers/" + customerID, BodyMatch: `"id":"` + customerID})
+		tr.Run(t, &TestCase{Method: http.MethodGet, Path: "/admin/customers", BodyMatch: `^\[{"id":"` + customerID + `".*"name":"Mehmet"`})
+		tr.Run(t, &TestCase{Method: http.MethodGet, Path: "/admin/customers?billing_id=cus_1", BodyMatch: `^\[{"id":"` + customerID + `"[^\]]*\]$`})
+		tr.Run(t, &TestCase{Method: http.MethodGet, Path: "/admin/customers?company=Initech", BodyMatch: `^\[\]$`})
+
+		resp := tr.Run(t, &TestCase{Method: http.MethodGet, Path: "/admin/customers/5e8f1d3b8c9d3f0001a1b2c3", BodyMatch: `"error":"customer not found"`})
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+		resp = tr.Run(t, &TestCase{Method: http.MethodPut, Path: "/admin/customers/bad-id",
+			Data: map[string]string{"name": "Furkan", "email": "furkan@example.com"}, BodyMatch: `"error":"ID format error: `})
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+		tr.Run(t, &TestCase{Method: http.MethodPut, Path: "/admin/customers/" + customerID,
+			Data:      map[string]string{"name": "Furkan S.", "email": "furkan@acme.com", "company": "Acme"},
+			BodyMatch: `"name":"Furkan S.","email":"furkan@acme.com","company":"Acme","created_at"`})
+	})
+
+	var order lcs.Order
+	t.Run("orders", func(t *testing.T) {
+		resp := tr.Run(t, &TestCase{Method: http.MethodPost, Path: "/admin/orders",
+			Data:      map[string]interface{}{"customer_id": customerID, "billing_id": "ord_1", "app": "test-app", "plan": "pro", "quantity": 2},
+			BodyMatch: `"customer_id":"` + customerID + `","billing_id":"ord_1","app":"test-app","plan":"pro","quantity":2`})
+		_ = json.NewDecoder(resp.Body).Decode(&order)
+
+		resp = tr.Run(t, &TestCase{Method: http.MethodPost, Path: "/admin/orders",
+			Data: map[string]interface{}{"customer_id": "5e8f1d3b8c9d3f0001a1b2c3"}, BodyMatch: `"error":"customer not found"`})
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+		resp = tr.Run(t, &TestCase{Method: http.MethodPost, Path: "/admin/orders", Data: map[string]interface{}{"quantity": -1},
+			BodyMatch: `"error":"invalid order: customer_id is empty; quantity is negative"`})
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+		tr.Run(t, &TestCase{Method: http.MethodGet, Path: "/admin/orders/" + order.ID.Hex(), BodyMatch: `"billing_id":"ord_1"`})
+		tr.Run(t, &TestCase{Method: http.MethodGet, Path: "/admin/orders?customer_id=" + customerID, BodyMatch: `^\[{"id":"` + order.ID.Hex()})
+	})
+
+	t.Run("licenses reference customers and orders", func(t *testing.T) {
+		// The customer is taken from the order
+		l := sampleLicense(func(l *lcs.License) { l.OrderID = order.ID.Hex() })
+		resp := tr.Run(t, &TestCase{Method: http.MethodPost, Path: "/admin/licenses", Data: l, BodyMatch: `"id":"`})
+		var created struct{ ID string }
+		_ = json.NewDecoder(resp.Body).Decode(&created)
+
+		tr.Run(t, &TestCase{Method: http.MethodGet, Path: "/admin/licenses/" + created.ID,
+			BodyMatch: `"customer_id":"` + customerID + `","order_id":"` + order.ID.Hex() + `"`})
+
+		l = sampleLicense(func(l *lcs.License) {
+			l.Claims["name"] = "Second"
+			l.CustomerID = customerID
+		})
+		tr.Run(t, &TestCase{Method: http.MethodPost, Path: "/admin/licenses", Data: l, BodyMatch: `"id":"`})
+
+		l = sampleLicense(func(l *lcs.License) { l.CustomerID = "5e8f1d3b8c9d3f0001a1b2c3" })
+		resp = tr.Run(t, &TestCase{Method: http.MethodPost, Path: "/admin/licenses", Data: l, BodyMatch: `"error":"customer not found"`})
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+		resp = tr.Run(t, &TestCase{Method: http.MethodGet, Path: "/admin/customers", BodyMatch: `"name":"Mehmet"`})
+		var customers []*lcs.Customer
+		_ = json.NewDecoder(resp.Body).Decode(&customers)
+
+		l = sampleLicense(func(l *lcs.License) {
+			l.CustomerID = customers[1].ID.Hex()
+			l.OrderID = order.ID.Hex()
+		})
+		resp = tr.Run(t, &TestCase{Method: http.MethodPost, Path: "/admin/licenses", Data: l, BodyMatch: `"error":"order belongs to another customer"`})
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+		resp = tr.Run(t, &TestCase{Method: http.MethodGet, Path: "/admin/customers/" + customerID + "/licenses"})
+		assert.Equal(t, "2", resp.Header.Get("X-Total-Count"))
+
+		var res struct {
+			Customer *lcs.Customer
+			Orders   []*lcs.Order
+			Licenses []*lcs.License
+		}
+		_ = json.NewDecoder(resp.Body).Decode(&res)
+		assert.Equal(t, customerID, res.Customer.ID.Hex())
+		assert.Len(t, res.Orders, 1)
+		assert.Len(t, res.Licenses, 2)
+
+		tr.Run(t, &TestCase{Method: http.MethodGet, Path: "/admin/licenses?order_id=" + order.ID.Hex(), BodyMatch: `^\[{"id":"` + created.ID + `"[^\]]*\]$`})
+	})
+
+	t.Run("customers in use aren't deleted", func(t *testing.T) {
+		resp := tr.Run(t, &TestCase{Method: http.MethodDelete, Path: "/admin/customers/" + customerID, BodyMatch: `"error":"customer has licenses or orders"`})
+		assert.Equal(t, http.StatusConflict, resp.StatusCode)
+
+		resp = tr.Run(t, &TestCase{Method: http.MethodGet, Path: "/admin/customers?email=mehmet@example.com"})
+		var customers []*lcs.Customer
+		_ = json.NewDecoder(resp.Body).Decode(&customers)
+		assert.Len(t, customers, 1)
+
+		tr.Run(t, &TestCase{Method: http.MethodDelete, Path: "/admin/customers/" + customers[0].ID.Hex(), BodyMatch: `"message":"Customer successfully deleted"`})
+	})
+}
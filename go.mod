@@ -1,15 +1,19 @@
 module github.com/furkansenharputlu/f-license
 
-go 1.12
+go 1.13
 
 require (
+	github.com/BurntSushi/toml v0.4.1
 	github.com/dgrijalva/jwt-go v0.0.0-20190620180102-5e25c22bd5d6
 	github.com/gorilla/mux v0.0.0-20191121170500-49c01487a141
 	github.com/inconshreveable/mousetrap v1.0.0 // indirect
+	github.com/prometheus/client_golang v1.2.1
 	github.com/sirupsen/logrus v1.4.2
 	github.com/spf13/cobra v0.0.3
 	github.com/spf13/pflag v1.0.5 // indirect
 	github.com/stretchr/testify v1.3.0
 	github.com/urfave/cli/v2 v2.2.0 // indirect
 	go.mongodb.org/mongo-driver v0.0.0-20200313205211-32aba96df4f5
+	golang.org/x/crypto v0.0.0-20190530122614-20be4c3c3ed5
+	gopkg.in/yaml.v2 v2.2.8
 )
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/furkansenharputlu/f-license/config"
+	"github.com/furkansenharputlu/f-license/scheduler"
+	"github.com/furkansenharputlu/f-license/storage"
+	"github.com/furkansenharputlu/f-license/webhook"
+
+	"github.com/sirupsen/logrus"
+)
+
+// jobBatchSize is the number of licenses a job changes at once.
+const jobBatchSize = 100
+
+// jobs are run in the background by one of the server instances sharing the database.
+func jobs() []scheduler.Job {
+	return []scheduler.Job{
+		{Name: "expire_licenses", Interval: func() time.Duration { return config.Get().Jobs.GetExpiryInterval() }, Run: expireLicenses},
+		{Name: "remind_expiring", Interval: func() time.Duration { return config.Get().Jobs.GetReminderInterval() }, Run: remindExpiring},
+		{Name: "reclaim_leases", Interval: func() time.Duration { return config.Get().Jobs.GetExpiryInterval() }, Run: reclaimLeases},
+		{Name: "prune_deliveries", Interval: func() time.Duration { return config.Get().Jobs.GetPruneInterval() }, Run: pruneDeliveries},
+	}
+}
+
+// expireLicenses inactivates the expired licenses.
+func expireLicenses(ctx context.Context) error {
+	for {
+		licenses, err := storage.LicenseHandler.ExpireLicenses(ctx, time.Now(), jobBatchSize)
+		for _, l := range licenses {
+			logrus.Infof("License is expired: %s", l.ID.Hex())
+			publish(ctx, webhook.EventExpired, l)
+		}
+
+		if err != nil || len(licenses) < jobBatchSize {
+			return err
+		}
+	}
+}
+
+// remindExpiring emits the expiring events of the licenses expiring in the configured
+// days. A license is reminded once at every configured day, and only at the fewest days
+// if it already expires in fewer days than the others.
+func remindExpiring(ctx context.Context) error {
+	days := append([]int(nil), config.Get().Jobs.ReminderDays...)
+	sort.Ints(days)
+
+	for _, d := range days {
+		for {
+			licenses, err := storage.LicenseHandler.ClaimReminders(ctx, time.Now(), d, jobBatchSize)
+			for _, l := range licenses {
+				publish(ctx, webhook.EventExpiring, l)
+			}
+
+			if err != nil {
+				return err
+			}
+
+			if len(licenses) < jobBatchSize {
+				break
+			}
+		}
+	}
+
+	return nil
+}
+
+// reclaimLeases frees the seats of the floating licenses leased to instances which didn't
+// extend their leases.
+func reclaimLeases(ctx context.Context) error {
+	n, err := storage.LicenseHandler.ReclaimLeases(ctx, time.Now())
+	if err != nil {
+		return err
+	}
+
+	if n > 0 {
+		logrus.Infof("%d expired leases are reclaimed", n)
+	}
+
+	return nil
+}
+
+// pruneDeliveries deletes the delivered and dead webhook deliveries, the sent and dead
+// emails, and the done billing events older than the retention.
+func pruneDeliveries(ctx context.Context) error {
+	before := time.Now().Add(-config.Get().Jobs.GetDeliveryRetention())
+
+	n, err := storage.LicenseHandler.PruneDeliveries(ctx, before)
+	if err != nil {
+		return err
+	}
+
+	if n > 0 {
+		logrus.Infof("%d webhook deliveries are pruned", n)
+	}
+
+	n, err = storage.LicenseHandler.PruneEmails(ctx, before)
+	if err != nil {
+		return err
+	}
+
+	if n > 0 {
+		logrus.Infof("%d emails are pruned", n)
+	}
+
+	n, err = storage.LicenseHandler.PruneBillingEvents(ctx, before)
+	if err != nil {
+		return err
+	}
+
+	if n > 0 {
+		logrus.Infof("%d billing events are pruned", n)
+	}
+
+	return nil
+}
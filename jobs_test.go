@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/furkansenharputlu/f-license/config"
+	"github.com/furkansenharputlu/f-license/lcs"
+	"github.com/furkansenharputlu/f-license/notify"
+	"github.com/furkansenharputlu/f-license/storage"
+	"github.com/furkansenharputlu/f-license/webhook"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestJobs(t *testing.T) {
+	defer storage.LicenseHandler.DropDatabase(context.Background())
+
+	defer setTestConfig(func(c *config.Config) {
+		c.Jobs = config.Jobs{ReminderDays: []int{30, 7, 1}}
+	})()
+
+	ctx := context.Background()
+
+	generate := func(t *testing.T, expiresIn time.Duration) string {
+		resp := tr.Run(t, &TestCase{Method: http.MethodPost, Path: "/admin/licenses", Data: sampleLicense(func(l *lcs.License) {
+			l.Claims["exp"] = time.Now().Add(expiresIn).Unix()
+		}), BodyMatch: `"id":"`})
+
+		var created struct{ ID string }
+		_ = json.NewDecoder(resp.Body).Decode(&created)
+
+		return created.ID
+	}
+
+	// Events are checked through the outbox of a catch-all webhook
+	tr.Run(t, &TestCase{Method: http.MethodPost, Path: "/admin/webhooks", Data: map[string]interface{}{"url": "http://localhost/hook"}, BodyMatch: `"id":"`})
+	events := func(t *testing.T, event string) []string {
+		deliveries, err := storage.LicenseHandler.ListDeliveries(ctx, storage.DeliveryFilter{})
+		assert.NoError(t, err)
+
+		var ids []string
+		for _, d := range deliveries {
+			if d.Event != event {
+				continue
+			}
+
+			var e struct{ Data lcs.License }
+			_ = json.Unmarshal([]byte(d.Payload), &e)
+			ids = append(ids, e.Data.ID.Hex())
+		}
+
+		return ids
+	}
+
+	expired := generate(t, -time.Minute)
+	in2Days := generate(t, 2*24*time.Hour)
+	in10Days := generate(t, 10*24*time.Hour)
+	in60Days := generate(t, 60*24*time.Hour)
+
+	t.Run("expired licenses are inactivated", func(t *testing.T) {
+		assert.NoError(t, expireLicenses(ctx))
+
+		tr.Run(t, &TestCase{Method: http.MethodGet, Path: "/admin/licenses/" + expired, BodyMatch: `"active":false,.*"expired_at":"`})
+		tr.Run(t, &TestCase{Method: http.MethodGet, Path: "/admin/licenses/" + in2Days, BodyMatch: `"active":true`})
+		assert.Equal(t, []string{expired}, events(t, webhook.EventExpired))
+
+		// Already inactivated ones are not expired again
+		assert.NoError(t, expireLicenses(ctx))
+		assert.Len(t, events(t, webhook.EventExpired), 1)
+	})
+
+	t.Run("expiring licenses are reminded once", func(t *testing.T) {
+		assert.NoError(t, remindExpiring(ctx))
+		assert.ElementsMatch(t, []string{in2Days, in10Days}, events(t, webhook.EventExpiring))
+
+		// Reminded at the fewest days only
+		tr.Run(t, &TestCase{Method: http.MethodGet, Path: "/admin/licenses/" + in2Days, BodyMatch: `"reminded_days":7`})
+		tr.Run(t, &TestCase{Method: http.MethodGet, Path: "/admin/licenses/" + in10Days, BodyMatch: `"reminded_days":30`})
+
+		var l lcs.License
+		assert.NoError(t, storage.LicenseHandler.GetByID(ctx, in60Days, &l))
+		assert.Zero(t, l.RemindedDays)
+
+		assert.NoError(t, remindExpiring(ctx))
+		assert.Len(t, events(t, webhook.EventExpiring), 2)
+	})
+
+	t.Run("old deliveries are pruned", func(t *testing.T) {
+		deliveries, err := storage.LicenseHandler.ListDeliveries(ctx, storage.DeliveryFilter{})
+		assert.NoError(t, err)
+
+		old := deliveries[0]
+		old.Status = webhook.StatusDelivered
+		old.CreatedAt = time.Now().Add(-31 * 24 * time.Hour)
+		assert.NoError(t, storage.LicenseHandler.UpdateDelivery(ctx, old))
+
+		oldPending := deliveries[1]
+		oldPending.CreatedAt = old.CreatedAt
+		assert.NoError(t, storage.LicenseHandler.UpdateDelivery(ctx, oldPending))
+
+		assert.NoError(t, pruneDeliveries(ctx))
+
+		_, err = storage.LicenseHandler.GetDelivery(ctx, old.ID)
+		assert.Equal(t, storage.ErrDeliveryNotFound, err)
+
+		_, err = storage.LicenseHandler.GetDelivery(ctx, oldPending.ID)
+		assert.NoError(t, err)
+
+		sent := notify.NewEmail(config.EmailIssued, &lcs.License{})
+		sent.Status = notify.StatusSent
+		sent.CreatedAt = old.CreatedAt
+		assert.NoError(t, storage.LicenseHandler.CreateEmail(ctx, sent))
+
+		pending := notify.NewEmail(config.EmailIssued, &lcs.License{})
+		pending.CreatedAt = old.CreatedAt
+		assert.NoError(t, storage.LicenseHandler.CreateEmail(ctx, pending))
+
+		assert.NoError(t, pruneDeliveries(ctx))
+
+		emails, err := storage.LicenseHandler.ClaimEmails(ctx, time.Now(), time.Minute, 10)
+		assert.NoError(t, err)
+		if assert.Len(t, emails, 1) {
+			assert.Equal(t, pending.ID, emails[0].ID)
+		}
+	})
+
+	t.Run("expired leases are reclaimed", func(t *testing.T) {
+		l := &lcs.License{ID: primitive.NewObjectID(), Seats: 2}
+		_, err := storage.LicenseHandler.AcquireLease(ctx, l, "stale", -time.Second)
+		assert.NoError(t, err)
+		_, err = storage.LicenseHandler.AcquireLease(ctx, l, "alive", time.Hour)
+		assert.NoError(t, err)
+
+		assert.NoError(t, reclaimLeases(ctx))
+
+		assert.Equal(t, storage.ErrLeaseNotFound, storage.LicenseHandler.ReleaseLease(ctx, l.ID.Hex(), "stale"))
+		assert.NoError(t, storage.LicenseHandler.ReleaseLease(ctx, l.ID.Hex(), "alive"))
+	})
+
+	t.Run("locks", func(t *testing.T) {
+		locked, err := storage.LicenseHandler.TryLock(ctx, "job", "replica-1", time.Hour)
+		assert.NoError(t, err)
+		assert.True(t, locked)
+
+		locked, err = storage.LicenseHandler.TryLock(ctx, "job", "replica-2", time.Hour)
+		assert.NoError(t, err)
+		assert.False(t, locked)
+
+		locked, _ = storage.LicenseHandler.TryLock(ctx, "job", "replica-1", -time.Second)
+		assert.True(t, locked)
+
+		// Expired locks are taken over
+		locked, _ = storage.LicenseHandler.TryLock(ctx, "job", "replica-2", time.Hour)
+		assert.True(t, locked)
+	})
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/furkansenharputlu/f-license/config"
+	"github.com/furkansenharputlu/f-license/lcs"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeyStore(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "keystore")
+	defer os.RemoveAll(dir)
+
+	keyStoreFile := filepath.Join(dir, "keystore.json")
+
+	ks, err := lcs.OpenKeyStore(keyStoreFile, "passphrase")
+	assert.NoError(t, err)
+
+	pair, _ := lcs.GenerateKey("RS256")
+	assert.NoError(t, ks.Put("rsa-key", pair.PrivateKey))
+	assert.NoError(t, ks.Put("hmac-key", []byte("hmac-secret")))
+
+	fileBytes, _ := ioutil.ReadFile(keyStoreFile)
+	assert.NotContains(t, string(fileBytes), "hmac-secret")
+
+	info, _ := os.Stat(keyStoreFile)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	t.Run("reopen", func(t *testing.T) {
+		ks, err := lcs.OpenKeyStore(keyStoreFile, "passphrase")
+		assert.NoError(t, err)
+
+		key, err := ks.Get("rsa-key")
+		assert.NoError(t, err)
+		assert.Equal(t, pair.PrivateKey, key)
+
+		_, err = ks.Get("non-existing-key")
+		assert.Equal(t, lcs.ErrKeyNotFound, err)
+	})
+
+	t.Run("wrong passphrase", func(t *testing.T) {
+		_, err := lcs.OpenKeyStore(keyStoreFile, "wrong")
+		assert.EqualError(t, err, "wrong keystore passphrase")
+	})
+
+	t.Run("generate and verify", func(t *testing.T) {
+		defer setTestConfig(func(c *config.Config) {
+			c.KeyStore = config.KeyStore{File: keyStoreFile, Passphrase: "passphrase"}
+		})()
+
+		for alg, keyID := range map[string]string{"RS256": "rsa-key", "HS256": "hmac-key"} {
+			defer setTestConfig(func(c *config.Config) {
+				c.Apps["keystore-app"] = &config.App{
+					Alg:       alg,
+					Signature: config.Signature{KeyProvider: config.KeyProviderKeyStore, KeyID: keyID},
+				}
+			})()
+
+			assertGeneratesValidLicense(t, "keystore-app")
+		}
+	})
+}
+
+func TestKMSKeyProvider(t *testing.T) {
+	kms := lcs.NewLocalKMS()
+	lcs.DefaultKMS = kms
+	defer func() {
+		lcs.DefaultKMS = nil
+	}()
+
+	for _, alg := range []string{"RS256", "PS384", "ES256", "ES512", "EdDSA"} {
+		t.Run(alg, func(t *testing.T) {
+			publicKey, err := kms.CreateKey("key-"+alg, alg)
+			assert.NoError(t, err)
+
+			defer setTestConfig(func(c *config.Config) {
+				c.Apps["kms-app"] = &config.App{
+					Alg:       alg,
+					Signature: config.Signature{KeyProvider: config.KeyProviderKMS, KeyID: "key-" + alg},
+				}
+			})()
+
+			l := assertGeneratesValidLicense(t, "kms-app")
+
+			// The token verifies with the public key kept out of the KMS
+			_, err = jwt.Parse(l.Token, func(token *jwt.Token) (interface{}, error) {
+				return publicKey, nil
+			})
+			assert.NoError(t, err)
+		})
+	}
+
+	_, err := kms.CreateKey("hmac-key", "HS256")
+	assert.EqualError(t, err, "KMS keys can't be HMAC secrets")
+
+	err = lcs.CheckKeys("RS256", config.Signature{KeyProvider: config.KeyProviderKMS, KeyID: "non-existing-key"})
+	assert.EqualError(t, err, "couldn't get signer of key non-existing-key: key not found in keystore")
+}
+
+func assertGeneratesValidLicense(t *testing.T, app string) *lcs.License {
+	l := sampleLicense(func(l *lcs.License) {
+		l.Headers["app"] = app
+	})
+	assert.NoError(t, l.Generate())
+
+	verified := sampleLicense(func(l *lcs.License) {
+		l.Headers["app"] = app
+	})
+	valid, err := verified.IsLicenseValid(l.Token)
+	assert.NoError(t, err)
+	assert.True(t, valid)
+
+	return l
+}
@@ -0,0 +1,71 @@
+package lcs
+
+import (
+	"errors"
+	"net/mail"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Customer is the buyer licenses are issued to. BillingID is the ID of the customer in the
+// billing system.
+type Customer struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Name      string             `bson:"name" json:"name"`
+	Email     string             `bson:"email" json:"email"`
+	Company   string             `bson:"company,omitempty" json:"company,omitempty"`
+	BillingID string             `bson:"billing_id,omitempty" json:"billing_id,omitempty"`
+	Tenant    string             `bson:"tenant,omitempty" json:"tenant,omitempty"`
+	CreatedAt time.Time          `bson:"created_at" json:"created_at"`
+}
+
+func (c *Customer) Validate() error {
+	var problems []string
+	if c.Name == "" {
+		problems = append(problems, "name is empty")
+	}
+
+	if c.Email == "" {
+		problems = append(problems, "email is empty")
+	} else if _, err := mail.ParseAddress(c.Email); err != nil {
+		problems = append(problems, "invalid email: "+c.Email)
+	}
+
+	if len(problems) > 0 {
+		return errors.New("invalid customer: " + strings.Join(problems, "; "))
+	}
+
+	return nil
+}
+
+// Order is a purchase of a customer licenses are issued for. BillingID is the ID of the
+// order in the billing system.
+type Order struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	CustomerID string             `bson:"customer_id" json:"customer_id"`
+	BillingID  string             `bson:"billing_id,omitempty" json:"billing_id,omitempty"`
+	App        string             `bson:"app,omitempty" json:"app,omitempty"`
+	Plan       string             `bson:"plan,omitempty" json:"plan,omitempty"`
+	Quantity   int                `bson:"quantity,omitempty" json:"quantity,omitempty"`
+	Tenant     string             `bson:"tenant,omitempty" json:"tenant,omitempty"`
+	CreatedAt  time.Time          `bson:"created_at" json:"created_at"`
+}
+
+func (o *Order) Validate() error {
+	var problems []string
+	if o.CustomerID == "" {
+		problems = append(problems, "customer_id is empty")
+	}
+
+	if o.Quantity < 0 {
+		problems = append(problems, "quantity is negative")
+	}
+
+	if len(problems) > 0 {
+		return errors.New("invalid order: " + strings.Join(problems, "; "))
+	}
+
+	return nil
+}
@@ -0,0 +1,53 @@
+package lcs
+
+import (
+	"crypto/ed25519"
+	"errors"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+// SigningMethodEdDSA implements the EdDSA signing method with Ed25519 keys,
+// which jwt-go doesn't provide.
+type SigningMethodEdDSA struct{}
+
+var EdDSA = &SigningMethodEdDSA{}
+
+var errEdDSAVerification = errors.New("ed25519: verification error")
+
+func init() {
+	jwt.RegisterSigningMethod(EdDSA.Alg(), func() jwt.SigningMethod {
+		return EdDSA
+	})
+}
+
+func (m *SigningMethodEdDSA) Alg() string {
+	return "EdDSA"
+}
+
+func (m *SigningMethodEdDSA) Verify(signingString, signature string, key interface{}) error {
+	publicKey, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return jwt.ErrInvalidKeyType
+	}
+
+	sig, err := jwt.DecodeSegment(signature)
+	if err != nil {
+		return err
+	}
+
+	if !ed25519.Verify(publicKey, []byte(signingString), sig) {
+		return errEdDSAVerification
+	}
+
+	return nil
+}
+
+func (m *SigningMethodEdDSA) Sign(signingString string, key interface{}) (string, error) {
+	privateKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return "", jwt.ErrInvalidKeyType
+	}
+
+	return jwt.EncodeSegment(ed25519.Sign(privateKey, []byte(signingString))), nil
+}
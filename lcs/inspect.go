@@ -0,0 +1,163 @@
+package lcs
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+const signatureValid = "valid"
+
+// Inspection explains a token: what it carries and which checks of IsLicenseValid
+// it fails. It doesn't cover the stored state of the license.
+type Inspection struct {
+	Header map[string]interface{} `json:"header"`
+	Claims jwt.MapClaims          `json:"claims"`
+	App    string                 `json:"app"`
+	Alg    string                 `json:"alg"`
+	// ConfiguredAlg is the alg the app of the token is configured with, which decides
+	// the key the token is verified with.
+	ConfiguredAlg string     `json:"configured_alg"`
+	Signature     string     `json:"signature"`
+	IssuedAt      *time.Time `json:"issued_at,omitempty"`
+	NotBefore     *time.Time `json:"not_before,omitempty"`
+	ExpiresAt     *time.Time `json:"expires_at,omitempty"`
+	Problems      []string   `json:"problems"`
+}
+
+// Inspect decodes the token without trusting it, then checks its signature against
+// the configured keys and its time claims against now.
+func Inspect(tokenString string, now time.Time) (*Inspection, error) {
+	parser := &jwt.Parser{UseJSONNumber: true}
+	claims := jwt.MapClaims{}
+	token, parts, err := parser.ParseUnverified(tokenString, claims)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't decode token: %s", err)
+	}
+
+	ins := &Inspection{
+		Header:   token.Header,
+		Claims:   claims,
+		Problems: []string{},
+	}
+
+	l := &License{Headers: map[string]interface{}{}}
+	for k, v := range token.Header {
+		l.Headers[k] = v
+	}
+
+	ins.App = l.GetAppName()
+	ins.Alg = l.GetAlg()
+
+	ins.checkSignature(l, parts)
+	ins.checkTimes(now)
+
+	return ins, nil
+}
+
+func (ins *Inspection) addProblem(format string, a ...interface{}) {
+	ins.Problems = append(ins.Problems, fmt.Sprintf(format, a...))
+}
+
+func (ins *Inspection) checkSignature(l *License, parts []string) {
+	if err := l.ApplyApp(ins.App); err != nil {
+		ins.Signature = "not checked"
+		ins.addProblem("app %q is not configured", ins.App)
+		return
+	}
+
+	ins.ConfiguredAlg = l.GetAlg()
+	if ins.ConfiguredAlg != ins.Alg {
+		ins.addProblem("token is signed with %s but the app is configured with %s", ins.Alg, ins.ConfiguredAlg)
+	}
+
+	verifyKey, err := parseVerifyKey(ins.ConfiguredAlg, l.Signature)
+	if err != nil {
+		ins.Signature = "not checked"
+		ins.addProblem("couldn't load verify key: %s", err)
+		return
+	}
+
+	method := jwt.GetSigningMethod(ins.Alg)
+	if method == nil {
+		ins.Signature = "not checked"
+		ins.addProblem("unknown signing method: %s", ins.Alg)
+		return
+	}
+
+	err = method.Verify(strings.Join(parts[0:2], "."), parts[2], verifyKey)
+	if err != nil {
+		ins.Signature = "invalid"
+		ins.addProblem("signature is invalid: %s", err)
+		return
+	}
+
+	ins.Signature = signatureValid
+}
+
+func (ins *Inspection) checkTimes(now time.Time) {
+	var err error
+
+	ins.IssuedAt, err = timeClaim(ins.Claims, "iat")
+	if err != nil {
+		ins.addProblem("%s", err)
+	} else if ins.IssuedAt != nil && ins.IssuedAt.After(now) {
+		ins.addProblem("token is issued in the future at %s", ins.IssuedAt.Format(time.RFC3339))
+	}
+
+	ins.NotBefore, err = timeClaim(ins.Claims, "nbf")
+	if err != nil {
+		ins.addProblem("%s", err)
+	} else if ins.NotBefore != nil && ins.NotBefore.After(now) {
+		ins.addProblem("token is not valid before %s", ins.NotBefore.Format(time.RFC3339))
+	}
+
+	ins.ExpiresAt, err = timeClaim(ins.Claims, "exp")
+	if err != nil {
+		ins.addProblem("%s", err)
+	} else if ins.ExpiresAt != nil && now.After(*ins.ExpiresAt) {
+		ins.addProblem("token expired at %s", ins.ExpiresAt.Format(time.RFC3339))
+	}
+}
+
+func timeClaim(claims jwt.MapClaims, name string) (*time.Time, error) {
+	v, ok := claims[name]
+	if !ok {
+		return nil, nil
+	}
+
+	var seconds int64
+	var err error
+	switch n := v.(type) {
+	case json.Number:
+		seconds, err = n.Int64()
+		if err != nil {
+			var f float64
+			f, err = n.Float64()
+			seconds = int64(f)
+		}
+	case float64:
+		seconds = int64(n)
+	// Claims decoded from the database can be integers
+	case int64:
+		seconds = n
+	case int32:
+		seconds = int64(n)
+	case int:
+		seconds = int64(n)
+	default:
+		err = errors.New("not a number")
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("%s claim is not a number of seconds", name)
+	}
+
+	t := time.Unix(seconds, 0).UTC()
+
+	return &t, nil
+}
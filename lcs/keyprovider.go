@@ -0,0 +1,218 @@
+package lcs
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"strings"
+
+	"github.com/furkansenharputlu/f-license/config"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+// KeyProvider supplies the keys referenced by a signature. Sign keys are private keys,
+// crypto.Signers whose private keys are kept elsewhere, or HMAC secrets. Verify keys are
+// public keys or HMAC secrets. An empty alg asks for the key whatever its kind is.
+type KeyProvider interface {
+	SignKey(alg string, signature config.Signature) (interface{}, error)
+	VerifyKey(alg string, signature config.Signature) (interface{}, error)
+}
+
+// KMS is a key management service or a PKCS#11 module keeping private keys, which are
+// used only through the returned signers.
+type KMS interface {
+	Signer(keyID string) (crypto.Signer, error)
+}
+
+// DefaultKMS is used by the signatures having the kms key provider. It is set by the
+// program integrating a KMS.
+var DefaultKMS KMS
+
+func isFileProvider(signature config.Signature) bool {
+	return signature.KeyProvider == "" || signature.KeyProvider == config.KeyProviderFile
+}
+
+func keyProviderOf(signature config.Signature) (KeyProvider, error) {
+	switch signature.KeyProvider {
+	case "", config.KeyProviderFile:
+		return FileKeyProvider{}, nil
+	case config.KeyProviderKeyStore:
+		return openConfiguredKeyStore(config.Get().KeyStore)
+	case config.KeyProviderKMS:
+		if DefaultKMS == nil {
+			return nil, errors.New("no KMS is set up")
+		}
+		return KMSKeyProvider{DefaultKMS}, nil
+	}
+
+	return nil, fmt.Errorf("unknown key provider: %s", signature.KeyProvider)
+}
+
+func parseSignKey(alg string, signature config.Signature) (interface{}, error) {
+	provider, err := keyProviderOf(signature)
+	if err != nil {
+		return nil, err
+	}
+
+	return provider.SignKey(alg, signature)
+}
+
+func parseVerifyKey(alg string, signature config.Signature) (interface{}, error) {
+	provider, err := keyProviderOf(signature)
+	if err != nil {
+		return nil, err
+	}
+
+	return provider.VerifyKey(alg, signature)
+}
+
+// FileKeyProvider reads keys from the PEM files of the signature and takes the HMAC
+// secret as it is.
+type FileKeyProvider struct{}
+
+func (FileKeyProvider) SignKey(alg string, signature config.Signature) (interface{}, error) {
+	if strings.HasPrefix(alg, "HS") {
+		return []byte(signature.HMACSecret), nil
+	}
+
+	signBytes, err := ioutil.ReadFile(signature.RSAPrivateKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read rsa private key file: %s", err)
+	}
+
+	signKey, err := ParsePrivateKey(alg, signBytes)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't parse private key: %s", err)
+	}
+
+	return signKey, nil
+}
+
+func (FileKeyProvider) VerifyKey(alg string, signature config.Signature) (interface{}, error) {
+	if strings.HasPrefix(alg, "HS") {
+		return []byte(signature.HMACSecret), nil
+	}
+
+	verifyBytes, err := ioutil.ReadFile(signature.RSAPublicKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read public key: %s", err)
+	}
+
+	verifyKey, err := ParsePublicKey(alg, verifyBytes)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't parse public key: %s", err)
+	}
+
+	return verifyKey, nil
+}
+
+// KMSKeyProvider signs with the keys of a KMS. HMAC algs are not supported.
+type KMSKeyProvider struct {
+	KMS KMS
+}
+
+func (p KMSKeyProvider) SignKey(alg string, signature config.Signature) (interface{}, error) {
+	if strings.HasPrefix(alg, "HS") {
+		return nil, errors.New("kms key provider doesn't support HMAC algs")
+	}
+
+	signer, err := p.KMS.Signer(signature.KeyID)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't get signer of key %s: %s", signature.KeyID, err)
+	}
+
+	return signer, nil
+}
+
+func (p KMSKeyProvider) VerifyKey(alg string, signature config.Signature) (interface{}, error) {
+	signer, err := p.SignKey(alg, signature)
+	if err != nil {
+		return nil, err
+	}
+
+	return signer.(crypto.Signer).Public(), nil
+}
+
+// signToken signs the token with the key. Keys which are only crypto.Signers, like the
+// ones of a KMS, are signed with outside of jwt-go, which needs the private keys.
+func signToken(token *jwt.Token, key interface{}) (string, error) {
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return token.SignedString(key)
+	}
+
+	switch signer.(type) {
+	case *rsa.PrivateKey, *ecdsa.PrivateKey, ed25519.PrivateKey:
+		return token.SignedString(key)
+	}
+
+	signingString, err := token.SigningString()
+	if err != nil {
+		return "", err
+	}
+
+	sig, err := signWithSigner(token.Method.Alg(), signingString, signer)
+	if err != nil {
+		return "", err
+	}
+
+	return signingString + "." + jwt.EncodeSegment(sig), nil
+}
+
+var hashes = map[string]crypto.Hash{
+	"256": crypto.SHA256,
+	"384": crypto.SHA384,
+	"512": crypto.SHA512,
+}
+
+func signWithSigner(alg, signingString string, signer crypto.Signer) ([]byte, error) {
+	if alg == EdDSA.Alg() {
+		return signer.Sign(rand.Reader, []byte(signingString), crypto.Hash(0))
+	}
+
+	hash, ok := hashes[strings.TrimLeft(alg, "ABCDEFGHIJKLMNOPQRSTUVWXYZ")]
+	if !ok || !hash.Available() {
+		return nil, fmt.Errorf("unsupported alg: %s", alg)
+	}
+
+	h := hash.New()
+	h.Write([]byte(signingString))
+	digest := h.Sum(nil)
+
+	switch {
+	case strings.HasPrefix(alg, "RS"):
+		return signer.Sign(rand.Reader, digest, hash)
+	case strings.HasPrefix(alg, "PS"):
+		return signer.Sign(rand.Reader, digest, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: hash})
+	case strings.HasPrefix(alg, "ES"):
+		publicKey, ok := signer.Public().(*ecdsa.PublicKey)
+		if !ok {
+			return nil, errors.New("key is not an ECDSA key")
+		}
+
+		der, err := signer.Sign(rand.Reader, digest, hash)
+		if err != nil {
+			return nil, err
+		}
+
+		// Signers return ASN.1 encoded signatures while JWS has r and s concatenated
+		var rs struct{ R, S *big.Int }
+		if _, err := asn1.Unmarshal(der, &rs); err != nil {
+			return nil, err
+		}
+
+		size := (publicKey.Curve.Params().BitSize + 7) / 8
+
+		return append(padLeft(rs.R.Bytes(), size), padLeft(rs.S.Bytes(), size)...), nil
+	}
+
+	return nil, fmt.Errorf("unsupported alg: %s", alg)
+}
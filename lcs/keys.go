@@ -0,0 +1,195 @@
+package lcs
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+const rsaKeyBits = 2048
+
+// KeyPair is the key material generated for an alg. HMAC algs have only a secret,
+// the others have PEM encoded private and public keys.
+type KeyPair struct {
+	HMACSecret string
+	PrivateKey []byte
+	PublicKey  []byte
+}
+
+// JWK is the JSON Web Key form of a public key.
+type JWK struct {
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Kid string `json:"kid,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// JWKS is a JSON Web Key Set.
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+var ecCurves = map[string]elliptic.Curve{
+	"ES256": elliptic.P256(),
+	"ES384": elliptic.P384(),
+	"ES512": elliptic.P521(),
+}
+
+// GenerateKey generates new key material for the given alg.
+func GenerateKey(alg string) (*KeyPair, error) {
+	var privateKey interface{}
+	var privateBlock *pem.Block
+
+	switch {
+	case strings.HasPrefix(alg, "HS"):
+		secret := make([]byte, 64)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, err
+		}
+		return &KeyPair{HMACSecret: base64.RawURLEncoding.EncodeToString(secret)}, nil
+	case strings.HasPrefix(alg, "RS"), strings.HasPrefix(alg, "PS"):
+		key, err := rsa.GenerateKey(rand.Reader, rsaKeyBits)
+		if err != nil {
+			return nil, err
+		}
+		privateKey = key
+		privateBlock = &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}
+	case strings.HasPrefix(alg, "ES"):
+		curve, ok := ecCurves[alg]
+		if !ok {
+			return nil, fmt.Errorf("unsupported alg: %s", alg)
+		}
+		key, err := ecdsa.GenerateKey(curve, rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		der, err := x509.MarshalECPrivateKey(key)
+		if err != nil {
+			return nil, err
+		}
+		privateKey = key
+		privateBlock = &pem.Block{Type: "EC PRIVATE KEY", Bytes: der}
+	case alg == EdDSA.Alg():
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			return nil, err
+		}
+		privateKey = key
+		privateBlock = &pem.Block{Type: "PRIVATE KEY", Bytes: der}
+	default:
+		return nil, fmt.Errorf("unsupported alg: %s", alg)
+	}
+
+	publicDER, err := x509.MarshalPKIXPublicKey(publicKeyOf(privateKey))
+	if err != nil {
+		return nil, err
+	}
+
+	return &KeyPair{
+		PrivateKey: pem.EncodeToMemory(privateBlock),
+		PublicKey:  pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER}),
+	}, nil
+}
+
+func publicKeyOf(privateKey interface{}) interface{} {
+	switch key := privateKey.(type) {
+	case *rsa.PrivateKey:
+		return &key.PublicKey
+	case *ecdsa.PrivateKey:
+		return &key.PublicKey
+	case ed25519.PrivateKey:
+		return key.Public()
+	}
+
+	return nil
+}
+
+// NewJWK returns the JWK of a public key parsed for the given alg.
+func NewJWK(alg, kid string, publicKey interface{}) (*JWK, error) {
+	jwk := &JWK{Use: "sig", Alg: alg, Kid: kid}
+
+	switch key := publicKey.(type) {
+	case *rsa.PublicKey:
+		jwk.Kty = "RSA"
+		jwk.N = base64.RawURLEncoding.EncodeToString(key.N.Bytes())
+		jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes())
+	case *ecdsa.PublicKey:
+		size := (key.Curve.Params().BitSize + 7) / 8
+		jwk.Kty = "EC"
+		jwk.Crv = key.Curve.Params().Name
+		jwk.X = base64.RawURLEncoding.EncodeToString(padLeft(key.X.Bytes(), size))
+		jwk.Y = base64.RawURLEncoding.EncodeToString(padLeft(key.Y.Bytes(), size))
+	case ed25519.PublicKey:
+		jwk.Kty = "OKP"
+		jwk.Crv = "Ed25519"
+		jwk.X = base64.RawURLEncoding.EncodeToString(key)
+	default:
+		return nil, errors.New("public key type is not supported")
+	}
+
+	return jwk, nil
+}
+
+func padLeft(b []byte, size int) []byte {
+	if len(b) >= size {
+		return b
+	}
+
+	return append(make([]byte, size-len(b)), b...)
+}
+
+func parseEdPrivateKeyFromPEM(key []byte) (ed25519.PrivateKey, error) {
+	block, _ := pem.Decode(key)
+	if block == nil {
+		return nil, errors.New("key must be PEM encoded")
+	}
+
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	privateKey, ok := parsed.(ed25519.PrivateKey)
+	if !ok {
+		return nil, errors.New("key is not a valid Ed25519 private key")
+	}
+
+	return privateKey, nil
+}
+
+func parseEdPublicKeyFromPEM(key []byte) (ed25519.PublicKey, error) {
+	block, _ := pem.Decode(key)
+	if block == nil {
+		return nil, errors.New("key must be PEM encoded")
+	}
+
+	parsed, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	publicKey, ok := parsed.(ed25519.PublicKey)
+	if !ok {
+		return nil, errors.New("key is not a valid Ed25519 public key")
+	}
+
+	return publicKey, nil
+}
@@ -0,0 +1,326 @@
+package lcs
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/furkansenharputlu/f-license/config"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+const keyStoreVersion = 1
+
+// scrypt parameters deriving the master key from the passphrase
+const (
+	scryptN      = 1 << 15
+	scryptR      = 8
+	scryptP      = 1
+	masterKeyLen = 32
+)
+
+// keyStoreCheck is encrypted with the master key to tell a wrong passphrase apart.
+const keyStoreCheck = "f-license keystore"
+
+var ErrKeyNotFound = errors.New("key not found in keystore")
+
+// KeyStore keeps keys in a local file with envelope encryption: every key is encrypted
+// with its own data key, and data keys are encrypted with the master key derived from
+// the passphrase. It is a KeyProvider finding keys by the key ID of signatures.
+type KeyStore struct {
+	path      string
+	masterKey []byte
+	// mu serializes writes of the keystore file
+	mu sync.Mutex
+}
+
+type keyStoreFile struct {
+	Version int                      `json:"version"`
+	Salt    []byte                   `json:"salt"`
+	Check   []byte                   `json:"check"`
+	Keys    map[string]keyStoreEntry `json:"keys"`
+}
+
+type keyStoreEntry struct {
+	// DataKey is the data key encrypted with the master key.
+	DataKey []byte `json:"data_key"`
+	// Key is the key material encrypted with the data key.
+	Key []byte `json:"key"`
+}
+
+// OpenKeyStore opens the keystore file, creating it if it doesn't exist.
+func OpenKeyStore(path, passphrase string) (*KeyStore, error) {
+	if passphrase == "" {
+		return nil, errors.New("keystore passphrase is empty")
+	}
+
+	f, err := readKeyStoreFile(path)
+	if os.IsNotExist(err) {
+		return createKeyStore(path, passphrase)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if f.Version != keyStoreVersion {
+		return nil, fmt.Errorf("unsupported keystore version: %d", f.Version)
+	}
+
+	masterKey, err := scrypt.Key([]byte(passphrase), f.Salt, scryptN, scryptR, scryptP, masterKeyLen)
+	if err != nil {
+		return nil, err
+	}
+
+	check, err := decrypt(masterKey, f.Check, "check")
+	if err != nil || string(check) != keyStoreCheck {
+		return nil, errors.New("wrong keystore passphrase")
+	}
+
+	return &KeyStore{path: path, masterKey: masterKey}, nil
+}
+
+func createKeyStore(path, passphrase string) (*KeyStore, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+
+	masterKey, err := scrypt.Key([]byte(passphrase), salt, scryptN, scryptR, scryptP, masterKeyLen)
+	if err != nil {
+		return nil, err
+	}
+
+	check, err := encrypt(masterKey, []byte(keyStoreCheck), "check")
+	if err != nil {
+		return nil, err
+	}
+
+	ks := &KeyStore{path: path, masterKey: masterKey}
+	err = ks.write(&keyStoreFile{Version: keyStoreVersion, Salt: salt, Check: check, Keys: map[string]keyStoreEntry{}})
+	if err != nil {
+		return nil, err
+	}
+
+	return ks, nil
+}
+
+// Put stores the key material with the given ID, replacing the existing one. Private keys
+// are PEM encoded and HMAC secrets are raw bytes.
+func (ks *KeyStore) Put(id string, key []byte) error {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	f, err := readKeyStoreFile(ks.path)
+	if err != nil {
+		return err
+	}
+
+	dataKey := make([]byte, 32)
+	if _, err := rand.Read(dataKey); err != nil {
+		return err
+	}
+
+	// The ID is authenticated with both keys so that entries can't be swapped
+	encryptedKey, err := encrypt(dataKey, key, id)
+	if err != nil {
+		return err
+	}
+
+	encryptedDataKey, err := encrypt(ks.masterKey, dataKey, id)
+	if err != nil {
+		return err
+	}
+
+	if f.Keys == nil {
+		f.Keys = map[string]keyStoreEntry{}
+	}
+	f.Keys[id] = keyStoreEntry{DataKey: encryptedDataKey, Key: encryptedKey}
+
+	return ks.write(f)
+}
+
+// Get returns the key material with the given ID.
+func (ks *KeyStore) Get(id string) ([]byte, error) {
+	f, err := readKeyStoreFile(ks.path)
+	if err != nil {
+		return nil, err
+	}
+
+	entry, ok := f.Keys[id]
+	if !ok {
+		return nil, ErrKeyNotFound
+	}
+
+	dataKey, err := decrypt(ks.masterKey, entry.DataKey, id)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't decrypt data key of %s: %s", id, err)
+	}
+
+	key, err := decrypt(dataKey, entry.Key, id)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't decrypt key %s: %s", id, err)
+	}
+
+	return key, nil
+}
+
+func (ks *KeyStore) SignKey(alg string, signature config.Signature) (interface{}, error) {
+	key, err := ks.Get(signature.KeyID)
+	if err != nil {
+		return nil, err
+	}
+
+	if strings.HasPrefix(alg, "HS") || (alg == "" && !isPEM(key)) {
+		return key, nil
+	}
+
+	if alg == "" {
+		return parseAnyPrivateKey(key)
+	}
+
+	return ParsePrivateKey(alg, key)
+}
+
+func (ks *KeyStore) VerifyKey(alg string, signature config.Signature) (interface{}, error) {
+	key, err := ks.SignKey(alg, signature)
+	if err != nil {
+		return nil, err
+	}
+
+	if secret, ok := key.([]byte); ok {
+		return secret, nil
+	}
+
+	return publicKeyOf(key), nil
+}
+
+func (ks *KeyStore) write(f *keyStoreFile) error {
+	fileBytes, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file first so that a failure doesn't leave a broken keystore
+	tmpFile := ks.path + ".tmp"
+	if err := ioutil.WriteFile(tmpFile, fileBytes, 0600); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpFile, ks.path)
+}
+
+func readKeyStoreFile(path string) (*keyStoreFile, error) {
+	fileBytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var f keyStoreFile
+	if err := json.Unmarshal(fileBytes, &f); err != nil {
+		return nil, fmt.Errorf("couldn't unmarshal keystore: %s", err)
+	}
+
+	return &f, nil
+}
+
+// encrypt seals the plaintext with AES-GCM, prepending the nonce.
+func encrypt(key, plaintext []byte, additionalData string) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+
+	return gcm.Seal(nonce, nonce, plaintext, []byte(additionalData)), nil
+}
+
+func decrypt(key, ciphertext []byte, additionalData string) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(ciphertext) < gcm.NonceSize() {
+		return nil, errors.New("ciphertext is too short")
+	}
+
+	nonce, sealed := ciphertext[:gcm.NonceSize()], ciphertext[gcm.NonceSize():]
+
+	return gcm.Open(nil, nonce, sealed, []byte(additionalData))
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+func isPEM(key []byte) bool {
+	block, _ := pem.Decode(key)
+	return block != nil
+}
+
+func parseAnyPrivateKey(key []byte) (interface{}, error) {
+	block, _ := pem.Decode(key)
+	if block == nil {
+		return nil, errors.New("key must be PEM encoded")
+	}
+
+	if privateKey, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return privateKey, nil
+	}
+
+	if privateKey, err := x509.ParseECPrivateKey(block.Bytes); err == nil {
+		return privateKey, nil
+	}
+
+	return x509.ParsePKCS8PrivateKey(block.Bytes)
+}
+
+// openKeyStores caches the keystores by their configuration, since deriving the master
+// key is deliberately slow.
+var (
+	openKeyStores   = map[config.KeyStore]*KeyStore{}
+	openKeyStoresMu sync.Mutex
+)
+
+func openConfiguredKeyStore(c config.KeyStore) (*KeyStore, error) {
+	openKeyStoresMu.Lock()
+	defer openKeyStoresMu.Unlock()
+
+	if ks, ok := openKeyStores[c]; ok {
+		return ks, nil
+	}
+
+	passphrase, err := c.GetPassphrase()
+	if err != nil {
+		return nil, err
+	}
+
+	ks, err := OpenKeyStore(c.File, passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	openKeyStores[c] = ks
+
+	return ks, nil
+}
@@ -0,0 +1,83 @@
+package lcs
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"errors"
+	"io"
+	"sync"
+)
+
+// LocalKMS is an in-memory KMS. It stands in for a real KMS or PKCS#11 module in tests
+// and development: its keys are generated inside it and used only through signers.
+type LocalKMS struct {
+	keys map[string]crypto.Signer
+	mu   sync.RWMutex
+}
+
+func NewLocalKMS() *LocalKMS {
+	return &LocalKMS{keys: map[string]crypto.Signer{}}
+}
+
+// CreateKey generates a private key for alg with the given ID and returns its public key.
+func (k *LocalKMS) CreateKey(keyID, alg string) (crypto.PublicKey, error) {
+	pair, err := GenerateKey(alg)
+	if err != nil {
+		return nil, err
+	}
+
+	if pair.PrivateKey == nil {
+		return nil, errors.New("KMS keys can't be HMAC secrets")
+	}
+
+	privateKey, err := ParsePrivateKey(alg, pair.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	var signer crypto.Signer
+	switch key := privateKey.(type) {
+	case *rsa.PrivateKey:
+		signer = key
+	case *ecdsa.PrivateKey:
+		signer = key
+	case ed25519.PrivateKey:
+		signer = key
+	default:
+		return nil, errors.New("unsupported key type")
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	k.keys[keyID] = signer
+
+	return signer.Public(), nil
+}
+
+func (k *LocalKMS) Signer(keyID string) (crypto.Signer, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	signer, ok := k.keys[keyID]
+	if !ok {
+		return nil, ErrKeyNotFound
+	}
+
+	// Hide the private key like a real KMS does
+	return kmsSigner{signer}, nil
+}
+
+type kmsSigner struct {
+	signer crypto.Signer
+}
+
+func (s kmsSigner) Public() crypto.PublicKey {
+	return s.signer.Public()
+}
+
+func (s kmsSigner) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	return s.signer.Sign(rand, digest, opts)
+}
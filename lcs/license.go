@@ -1,35 +1,44 @@
 package lcs
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"hash/fnv"
-	"io/ioutil"
 	"strings"
+	"time"
 
 	"github.com/furkansenharputlu/f-license/config"
 
 	jwt "github.com/dgrijalva/jwt-go"
-	"github.com/sirupsen/logrus"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
-func fatalf(format string, err error) {
-	if err != nil {
-		logrus.Fatalf(format, err)
-	}
-}
-
 type License struct {
-	ID        primitive.ObjectID     `bson:"_id,omitempty" json:"id"`
-	Headers   map[string]interface{} `bson:"headers" json:"headers"`
-	Hash      string                 `bson:"hash" json:"-"`
-	Token     string                 `bson:"token" json:"token"`
-	Claims    jwt.MapClaims          `bson:"claims" json:"claims"`
-	Active    bool                   `bson:"active" json:"active"`
-	Signature config.Signature       `bson:"-" json:"-"`
-	signKey   interface{}
-	verifyKey interface{}
+	ID      primitive.ObjectID     `bson:"_id,omitempty" json:"id"`
+	Headers map[string]interface{} `bson:"headers" json:"headers"`
+	Hash    string                 `bson:"hash" json:"-"`
+	Token   string                 `bson:"token" json:"token"`
+	Claims  jwt.MapClaims          `bson:"claims" json:"claims"`
+	Active  bool                   `bson:"active" json:"active"`
+	Seats   int                    `bson:"seats,omitempty" json:"seats,omitempty"`
+	Tenant  string                 `bson:"tenant,omitempty" json:"tenant,omitempty"`
+	// CustomerID and OrderID reference the customer and the order the license is issued for.
+	CustomerID string `bson:"customer_id,omitempty" json:"customer_id,omitempty"`
+	OrderID    string `bson:"order_id,omitempty" json:"order_id,omitempty"`
+	// ExpiredAt is when the license is inactivated for being expired.
+	ExpiredAt *time.Time `bson:"expired_at,omitempty" json:"expired_at,omitempty"`
+	// RevokedAt is when the license is revoked. Revoked licenses are not renewed.
+	RevokedAt *time.Time `bson:"revoked_at,omitempty" json:"revoked_at,omitempty"`
+	// RemindedDays is the fewest days before the expiry the customer is reminded at.
+	RemindedDays int `bson:"reminded_days,omitempty" json:"reminded_days,omitempty"`
+	// Renewals is the history of the renewals of the license, oldest first.
+	Renewals []Renewal `bson:"renewals,omitempty" json:"renewals,omitempty"`
+	// PreviousTokens are the tokens replaced by renewals, until they expire.
+	PreviousTokens []PreviousToken  `bson:"previous_tokens,omitempty" json:"-"`
+	Signature      config.Signature `bson:"-" json:"-"`
+	signKey        interface{}
+	verifyKey      interface{}
 }
 
 func (l *License) GetAppName() (appName string) {
@@ -53,13 +62,39 @@ func (l *License) GetAlg() (alg string) {
 	return alg
 }
 
+// ErrAppNotFound is returned by an AppStore which has no app with the name.
+var ErrAppNotFound = errors.New("app not found")
+
+// AppStore looks up the apps managed at runtime.
+type AppStore interface {
+	// GetApp returns the stored app of the tenant with the name.
+	GetApp(ctx context.Context, tenant, name string) (*config.App, error)
+}
+
+// Apps is where GetApp looks up the apps which are not configured. It is set when the
+// storage is connected.
+var Apps AppStore
+
+const appLookupTimeout = 5 * time.Second
+
+// GetApp returns the configured app with the given name, or else the stored app of the
+// tenant of the license. Errors of looking up the stored app are returned as they are.
 func (l *License) GetApp(appName string) (*config.App, error) {
-	app, ok := config.Global.Apps[appName]
-	if !ok {
-		return nil, errors.New("app not found with given name")
+	if app, ok := config.Get().Apps[appName]; ok {
+		return app, nil
+	}
+
+	if Apps != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), appLookupTimeout)
+		defer cancel()
+
+		app, err := Apps.GetApp(ctx, l.Tenant, appName)
+		if err != ErrAppNotFound {
+			return app, err
+		}
 	}
 
-	return app, nil
+	return nil, errors.New("app not found with given name")
 }
 
 func (l *License) ApplyApp(appName string) error {
@@ -68,7 +103,7 @@ func (l *License) ApplyApp(appName string) error {
 
 	if appName == "" {
 		alg = l.GetAlg()
-		signature = config.Global.DefaultSignature
+		signature = config.Get().DefaultSignature
 	} else {
 		app, err := l.GetApp(appName)
 		if err != nil {
@@ -103,48 +138,133 @@ func (l *License) Generate() error {
 	token := jwt.NewWithClaims(jwt.GetSigningMethod(l.GetAlg()), l.Claims)
 	token.Header = l.Headers
 
-	l.LoadSignKey()
-	l.LoadVerifyKey()
+	if err := l.LoadSignKey(); err != nil {
+		return err
+	}
 
-	signedString, err := token.SignedString(l.signKey)
+	if err := l.LoadVerifyKey(); err != nil {
+		return err
+	}
+
+	signedString, err := signToken(token, l.signKey)
 	if err != nil {
 		return err
 	}
 
 	l.Token = signedString
+	l.Hash = HashToken(signedString)
+
+	return nil
+}
 
+// HashToken returns the hash licenses are looked up by their tokens with.
+func HashToken(token string) string {
 	h := fnv.New64a()
-	h.Write([]byte(signedString))
-	l.Hash = fmt.Sprintf("%v", h.Sum64())
+	h.Write([]byte(token))
+
+	return fmt.Sprintf("%v", h.Sum64())
+}
+
+// ExpiresAt returns the time of the exp claim, or nil if the license doesn't expire.
+func (l *License) ExpiresAt() (*time.Time, error) {
+	return timeClaim(l.Claims, "exp")
+}
+
+func (l *License) LoadSignKey() error {
+	var err error
+	l.signKey, err = parseSignKey(l.GetAlg(), l.Signature)
+	if err != nil {
+		return fmt.Errorf("couldn't load sign key: %s", err)
+	}
 
 	return nil
 }
 
-func (l *License) LoadSignKey() {
+func (l *License) LoadVerifyKey() error {
+	var err error
+	l.verifyKey, err = parseVerifyKey(l.GetAlg(), l.Signature)
+	if err != nil {
+		return fmt.Errorf("couldn't load verify key: %s", err)
+	}
 
-	if strings.HasPrefix(l.GetAlg(), "HS") {
-		l.signKey = []byte(l.Signature.HMACSecret)
-	} else {
-		signBytes, err := ioutil.ReadFile(l.Signature.RSAPrivateKeyFile)
-		fatalf("Couldn't read rsa private key file: %s", err)
+	return nil
+}
+
+// ParsePrivateKey parses the PEM encoded private key of the given alg. The key files
+// configured as rsa_private_key_file hold ECDSA or Ed25519 keys for ES and EdDSA algs.
+func ParsePrivateKey(alg string, key []byte) (interface{}, error) {
+	switch {
+	case strings.HasPrefix(alg, "ES"):
+		return jwt.ParseECPrivateKeyFromPEM(key)
+	case alg == EdDSA.Alg():
+		return parseEdPrivateKeyFromPEM(key)
+	default:
+		return jwt.ParseRSAPrivateKeyFromPEM(key)
+	}
+}
 
-		l.signKey, err = jwt.ParseRSAPrivateKeyFromPEM(signBytes)
-		fatalf("Couldn't parse private key: %s", err)
+// ParsePublicKey parses the PEM encoded public key of the given alg.
+func ParsePublicKey(alg string, key []byte) (interface{}, error) {
+	switch {
+	case strings.HasPrefix(alg, "ES"):
+		return jwt.ParseECPublicKeyFromPEM(key)
+	case alg == EdDSA.Alg():
+		return parseEdPublicKeyFromPEM(key)
+	default:
+		return jwt.ParseRSAPublicKeyFromPEM(key)
 	}
 }
 
-func (l *License) LoadVerifyKey() {
+// CheckKeys checks the keys of the signature can be loaded for the given alg.
+// If alg is empty, keys of every configured kind are checked.
+func CheckKeys(alg string, signature config.Signature) error {
+	var algs []string
+	switch {
+	case !isFileProvider(signature):
+		// Providers keeping the keys tell the kind of the key themselves
+		algs = []string{alg}
+	case alg != "":
+		algs = []string{alg}
+	case signature.RSAPrivateKeyFile != "" || signature.RSAPublicKeyFile != "":
+		algs = []string{"RS256"}
+	}
 
-	if strings.HasPrefix(l.GetAlg(), "HS") {
+	if alg == "" && signature.HMACSecret != "" {
+		algs = append(algs, "HS256")
+	}
 
-		l.verifyKey = []byte(l.Signature.HMACSecret)
-	} else {
-		verifyBytes, err := ioutil.ReadFile(l.Signature.RSAPublicKeyFile)
-		fatalf("Couldn't read public key: %s", err)
+	if len(algs) == 0 {
+		return errors.New("no key configured")
+	}
+
+	for _, alg := range algs {
+		if strings.HasPrefix(alg, "HS") && isFileProvider(signature) && signature.HMACSecret == "" {
+			return errors.New("hmac secret is empty")
+		}
+
+		if _, err := parseSignKey(alg, signature); err != nil {
+			return err
+		}
 
-		l.verifyKey, err = jwt.ParseRSAPublicKeyFromPEM(verifyBytes)
-		fatalf("Couldn't parse public key: %s", err)
+		if _, err := parseVerifyKey(alg, signature); err != nil {
+			return err
+		}
 	}
+
+	return nil
+}
+
+// CheckApp checks the app is valid and its keys can be loaded.
+func CheckApp(app *config.App) error {
+	if err := app.Validate(); err != nil {
+		return err
+	}
+
+	if err := CheckKeys(app.Alg, app.Signature); err != nil {
+		return fmt.Errorf("invalid app keys: %s", err)
+	}
+
+	return nil
 }
 
 func (l *License) IsLicenseValid(tokenString string) (bool, error) {
@@ -152,12 +272,18 @@ func (l *License) IsLicenseValid(tokenString string) (bool, error) {
 		return false, nil
 	}
 
+	if previous := l.PreviousToken(tokenString); previous != nil && !previous.Kept {
+		return false, nil
+	}
+
 	if l.verifyKey == nil {
 		err := l.ApplyApp(l.GetAppName())
 		if err != nil {
 			return false, nil
 		}
-		l.LoadVerifyKey()
+		if err := l.LoadVerifyKey(); err != nil {
+			return false, err
+		}
 	}
 
 	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
@@ -165,8 +291,7 @@ func (l *License) IsLicenseValid(tokenString string) (bool, error) {
 		switch token.Method.(type) {
 		case *jwt.SigningMethodHMAC:
 			return l.verifyKey, nil
-		case *jwt.SigningMethodRSA:
-
+		case *jwt.SigningMethodRSA, *jwt.SigningMethodRSAPSS, *jwt.SigningMethodECDSA, *SigningMethodEdDSA:
 			return l.verifyKey, nil
 		default:
 			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
@@ -0,0 +1,228 @@
+package lcs
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+// ErrOldTokenNotExpiring is returned when the old token of a license which doesn't expire
+// is asked to be kept, as it would stay valid forever.
+var ErrOldTokenNotExpiring = errors.New("old token can't be kept as the license doesn't expire")
+
+var (
+	// ErrLicenseInactive is returned when a license inactivated by an admin is renewed
+	// without force. Licenses inactivated for being expired are renewed.
+	ErrLicenseInactive = errors.New("license is inactivated, renew it with force to activate it")
+	// ErrLicenseRevoked is returned when a revoked license is renewed without force.
+	ErrLicenseRevoked = errors.New("license is revoked, renew it with force to restore it")
+	// ErrExpiryNotExtended is returned when a renewal would shorten the license.
+	ErrExpiryNotExtended = errors.New("new expiry must be later than the current expiry")
+)
+
+// Renewal records a renewal of a license.
+type Renewal struct {
+	RenewedAt time.Time `bson:"renewed_at" json:"renewed_at"`
+	// PreviousExpiresAt is nil if the license didn't expire before the renewal.
+	PreviousExpiresAt *time.Time `bson:"previous_expires_at,omitempty" json:"previous_expires_at,omitempty"`
+	ExpiresAt         time.Time  `bson:"expires_at" json:"expires_at"`
+	KeptOldToken      bool       `bson:"kept_old_token" json:"kept_old_token"`
+}
+
+// PreviousToken is a token replaced by a renewal. Clients verifying with it are told a
+// new token is available, and it is still valid until its expiry if it is kept.
+type PreviousToken struct {
+	Hash      string     `bson:"hash" json:"hash"`
+	ExpiresAt *time.Time `bson:"expires_at,omitempty" json:"expires_at,omitempty"`
+	Kept      bool       `bson:"kept" json:"kept"`
+}
+
+// RenewOptions tells how a license is renewed. Either Duration or ExpiresAt is given.
+type RenewOptions struct {
+	// Duration extends the expiry, e.g. "30d" or "720h". Licenses which are expired or
+	// don't expire are extended from now.
+	Duration string `json:"duration,omitempty"`
+	// ExpiresAt is the new expiry, e.g. "2027-01-01" or "2027-01-01T00:00:00Z".
+	ExpiresAt string `json:"expires_at,omitempty"`
+	// KeepOldToken keeps the current token valid until its expiry.
+	KeepOldToken bool `json:"keep_old_token,omitempty"`
+	// Force renews licenses which are inactivated by an admin or revoked, activating them.
+	Force bool `json:"force,omitempty"`
+}
+
+// ExpiryOf returns the new expiry of the license renewed at now.
+func (o RenewOptions) ExpiryOf(l *License, now time.Time) (time.Time, error) {
+	switch {
+	case o.Duration == "" && o.ExpiresAt == "":
+		return time.Time{}, errors.New("either duration or expires_at is required")
+	case o.Duration != "" && o.ExpiresAt != "":
+		return time.Time{}, errors.New("duration and expires_at can't be given together")
+	case o.ExpiresAt != "":
+		expiresAt, err := ParseTime(o.ExpiresAt)
+		if err != nil {
+			return time.Time{}, err
+		}
+
+		if !expiresAt.After(now) {
+			return time.Time{}, errors.New("expires_at must be in the future")
+		}
+
+		return expiresAt, nil
+	}
+
+	d, err := ParseDuration(o.Duration)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	if d <= 0 {
+		return time.Time{}, errors.New("duration must be positive")
+	}
+
+	from := now
+	expiresAt, err := l.ExpiresAt()
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	if expiresAt != nil && expiresAt.After(now) {
+		from = *expiresAt
+	}
+
+	return from.Add(d).UTC(), nil
+}
+
+// ParseDuration parses a duration of days like "30d", or of time.ParseDuration.
+func ParseDuration(s string) (time.Duration, error) {
+	if strings.HasSuffix(s, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(s, "d"))
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration: %q", s)
+		}
+
+		return time.Duration(days) * 24 * time.Hour, nil
+	}
+
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration: %q", s)
+	}
+
+	return d, nil
+}
+
+// ParseTime parses a date like "2027-01-01", which is the start of the day in UTC, or a
+// RFC 3339 time.
+func ParseTime(s string) (time.Time, error) {
+	if t, err := time.Parse("2006-01-02", s); err == nil {
+		return t, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time: %q", s)
+	}
+
+	return t.UTC(), nil
+}
+
+// CheckRenewal checks the license can be renewed until expiresAt. Licenses which are
+// inactivated by an admin or revoked are renewed only with force, and renewals never
+// shorten licenses.
+func (l *License) CheckRenewal(expiresAt time.Time, force bool) error {
+	if !force {
+		switch {
+		case l.RevokedAt != nil:
+			return ErrLicenseRevoked
+		case !l.Active && l.ExpiredAt == nil:
+			return ErrLicenseInactive
+		}
+	}
+
+	current, err := l.ExpiresAt()
+	if err != nil {
+		return err
+	}
+
+	if current != nil && !expiresAt.After(*current) {
+		return ErrExpiryNotExtended
+	}
+
+	return nil
+}
+
+// Renew extends the license until expiresAt and signs it again, activating it if it is
+// inactive or revoked. The renewal is recorded and the old token is kept valid until its
+// expiry if keepOldToken is true. Its customer is reminded again before the new expiry.
+func (l *License) Renew(expiresAt time.Time, keepOldToken bool) error {
+	previousExpiresAt, err := l.ExpiresAt()
+	if err != nil {
+		return err
+	}
+
+	if keepOldToken && previousExpiresAt == nil {
+		return ErrOldTokenNotExpiring
+	}
+
+	if l.Claims == nil {
+		l.Claims = jwt.MapClaims{}
+	}
+
+	previousHash := l.Hash
+
+	// Numbers of the claims are float64 as in the claims decoded from JSON
+	l.Claims["exp"] = float64(expiresAt.Unix())
+	l.Active = true
+	l.ExpiredAt = nil
+	l.RevokedAt = nil
+	l.RemindedDays = 0
+
+	if err := l.Generate(); err != nil {
+		return err
+	}
+
+	now := time.Now().UTC().Truncate(time.Millisecond)
+
+	// Tokens which are expired can't be verified anyway
+	previousTokens := l.PreviousTokens[:0]
+	for _, t := range l.PreviousTokens {
+		if t.ExpiresAt == nil || t.ExpiresAt.After(now) {
+			previousTokens = append(previousTokens, t)
+		}
+	}
+
+	if previousHash != "" && previousHash != l.Hash {
+		previousTokens = append(previousTokens, PreviousToken{Hash: previousHash, ExpiresAt: previousExpiresAt, Kept: keepOldToken})
+	}
+
+	l.PreviousTokens = previousTokens
+	l.Renewals = append(l.Renewals, Renewal{
+		RenewedAt:         now,
+		PreviousExpiresAt: previousExpiresAt,
+		ExpiresAt:         time.Unix(expiresAt.Unix(), 0).UTC(),
+		KeptOldToken:      keepOldToken,
+	})
+
+	return nil
+}
+
+// PreviousToken returns the previous token the token is, or nil if it is not replaced by
+// a renewal.
+func (l *License) PreviousToken(token string) *PreviousToken {
+	hash := HashToken(token)
+	if hash == l.Hash {
+		return nil
+	}
+
+	for i := range l.PreviousTokens {
+		if l.PreviousTokens[i].Hash == hash {
+			return &l.PreviousTokens[i]
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/furkansenharputlu/f-license/lcs"
+	"github.com/furkansenharputlu/f-license/storage"
+	"github.com/furkansenharputlu/f-license/webhook"
+
+	"github.com/sirupsen/logrus"
+)
+
+// leaseTTL is how long a lease lasts unless its instance extends it.
+const leaseTTL = 10 * time.Minute
+
+// AcquireLease leases a seat of a floating license to the instance, or extends its lease.
+func AcquireLease(w http.ResponseWriter, r *http.Request) {
+	l, ok := floatingLicense(w, r)
+	if !ok {
+		return
+	}
+
+	lease, err := storage.LicenseHandler.AcquireLease(r.Context(), l, r.FormValue("instance"), leaseTTL)
+	if err != nil {
+		if err == storage.ErrSeatsExceeded {
+			publish(r.Context(), webhook.EventSeatsExceeded, l)
+			ReturnError(w, http.StatusConflict, err.Error())
+			return
+		}
+
+		logrus.WithError(err).Error("Error while leasing seat")
+		ReturnError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	ReturnResponse(w, http.StatusOK, lease)
+}
+
+// ReleaseLease frees the seat leased to the instance.
+func ReleaseLease(w http.ResponseWriter, r *http.Request) {
+	l, ok := floatingLicense(w, r)
+	if !ok {
+		return
+	}
+
+	err := storage.LicenseHandler.ReleaseLease(r.Context(), l.ID.Hex(), r.FormValue("instance"))
+	if err != nil {
+		if err == storage.ErrLeaseNotFound {
+			ReturnError(w, http.StatusNotFound, err.Error())
+			return
+		}
+
+		logrus.WithError(err).Error("Error while releasing lease")
+		ReturnError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	ReturnResponse(w, http.StatusOK, map[string]interface{}{
+		"message": "Lease successfully released",
+	})
+}
+
+// floatingLicense returns the valid floating license of the token of a lease request.
+func floatingLicense(w http.ResponseWriter, r *http.Request) (*lcs.License, bool) {
+	token := r.FormValue("token")
+	if r.FormValue("instance") == "" {
+		ReturnError(w, http.StatusBadRequest, "instance is empty")
+		return nil, false
+	}
+
+	var l lcs.License
+	err := storage.LicenseHandler.GetByToken(r.Context(), token, &l)
+	if err != nil {
+		if err == storage.ErrLicenseNotFound {
+			ReturnError(w, http.StatusNotFound, err.Error())
+			return nil, false
+		}
+
+		logrus.WithError(err).Error("Error while getting license")
+		ReturnError(w, http.StatusInternalServerError, err.Error())
+		return nil, false
+	}
+
+	valid, err := l.IsLicenseValid(token)
+	if err != nil || !valid {
+		ReturnError(w, http.StatusUnauthorized, "license is not valid")
+		return nil, false
+	}
+
+	if l.Seats <= 0 {
+		ReturnError(w, http.StatusBadRequest, "license is not floating")
+		return nil, false
+	}
+
+	return &l, true
+}
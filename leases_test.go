@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/furkansenharputlu/f-license/lcs"
+	"github.com/furkansenharputlu/f-license/storage"
+	"github.com/furkansenharputlu/f-license/webhook"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLeases(t *testing.T) {
+	defer storage.LicenseHandler.DropDatabase(context.Background())
+
+	generate := func(seats int) string {
+		l := sampleLicense(func(l *lcs.License) {
+			l.Seats = seats
+			l.Claims["seats"] = seats
+		})
+		resp := tr.Run(t, &TestCase{Method: http.MethodPost, Path: "/admin/licenses", Data: l, BodyMatch: `"token":"ey.*"`})
+
+		var created map[string]string
+		_ = json.NewDecoder(resp.Body).Decode(&created)
+
+		return created["token"]
+	}
+
+	token := generate(1)
+	lease := func(path, instance, bodyMatch string) {
+		tr.Run(t, &TestCase{Method: http.MethodPost, Path: path, FormParams: map[string]string{"token": token, "instance": instance}, BodyMatch: bodyMatch})
+	}
+
+	lease("/license/lease", "host-a", `"seat":0,"instance":"host-a"`)
+	lease("/license/lease", "host-a", `"seat":0,"instance":"host-a"`)
+	lease("/license/lease", "host-b", `{"error":"all seats of the license are leased"}`)
+
+	tr.Run(t, &TestCase{Method: http.MethodGet, Path: "/metrics", BodyMatch: `f_license_active_leases 1`})
+
+	lease("/license/release", "host-a", `{"message":"Lease successfully released"}`)
+	lease("/license/release", "host-a", `{"error":"lease not found"}`)
+	lease("/license/lease", "host-b", `"seat":0,"instance":"host-b"`)
+	lease("/license/lease", "", `{"error":"instance is empty"}`)
+
+	token = generate(0)
+	lease("/license/lease", "host-a", `{"error":"license is not floating"}`)
+
+	token = "invalid-token"
+	lease("/license/lease", "host-a", `{"error":"license not found"}`)
+}
+
+func TestLeases_SeatsExceededEvent(t *testing.T) {
+	defer storage.LicenseHandler.DropDatabase(context.Background())
+
+	tr.Run(t, &TestCase{Method: http.MethodPost, Path: "/admin/webhooks",
+		Data: map[string]interface{}{"url": "https://crm.example.com/hook", "events": []string{"license.seats_exceeded"}}, BodyMatch: `"id":"`})
+
+	resp := tr.Run(t, &TestCase{Method: http.MethodPost, Path: "/admin/licenses", Data: sampleLicense(func(l *lcs.License) { l.Seats = 1 }), BodyMatch: `"token":"ey.*"`})
+	var created map[string]string
+	_ = json.NewDecoder(resp.Body).Decode(&created)
+
+	for _, instance := range []string{"host-a", "host-b"} {
+		tr.Run(t, &TestCase{Method: http.MethodPost, Path: "/license/lease", FormParams: map[string]string{"token": created["token"], "instance": instance}})
+	}
+
+	deliveries, err := storage.LicenseHandler.ListDeliveries(context.Background(), storage.DeliveryFilter{Status: webhook.StatusPending})
+	assert.NoError(t, err)
+	if assert.Len(t, deliveries, 1) {
+		assert.Equal(t, webhook.EventSeatsExceeded, deliveries[0].Event)
+		assert.Contains(t, deliveries[0].Payload, created["id"])
+	}
+}
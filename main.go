@@ -1,13 +1,24 @@
 package main
 
 import (
+	"context"
 	"crypto/tls"
+	"flag"
 	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/furkansenharputlu/f-license/config"
+	"github.com/furkansenharputlu/f-license/notify"
+	"github.com/furkansenharputlu/f-license/scheduler"
 	"github.com/furkansenharputlu/f-license/storage"
+	"github.com/furkansenharputlu/f-license/webhook"
 
 	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/sirupsen/logrus"
 
 	"log"
@@ -22,47 +33,227 @@ func intro() {
 	logrus.Info("https://f-license.com")
 }
 
+// shutdownTimeout is how long in-flight requests are waited for while shutting down.
+const shutdownTimeout = 30 * time.Second
+
+// configWatchInterval is how often the configuration file is checked for changes.
+const configWatchInterval = 5 * time.Second
+
 func main() {
+	configFile := flag.String("config", "", "Config file, "+config.ConfigFileEnv+" or config.json by default")
+	flag.Parse()
 
 	intro()
 
-	config.Global.Load("config.json")
+	configFilePath := config.FilePath(*configFile)
+	loadConfig(configFilePath)
 	storage.Connect()
 
 	router := GenerateRouter()
 
-	addr := fmt.Sprintf(":%d", config.Global.Port)
-	certFile := config.Global.ServerOptions.CertFile
-	keyFile := config.Global.ServerOptions.KeyFile
+	c := config.Get()
+	addr := fmt.Sprintf(":%d", c.Port)
+	certFile := c.ServerOptions.CertFile
+	keyFile := c.ServerOptions.KeyFile
+
+	srv := &http.Server{
+		Addr:    addr,
+		Handler: router,
+	}
+
+	if c.ServerOptions.EnableTLS {
+		tlsConfig, err := c.ServerOptions.BuildTLSConfig()
+		if err != nil {
+			logrus.WithError(err).Fatal("Couldn't build TLS config")
+		}
+
+		srv.TLSConfig = tlsConfig
+		srv.TLSNextProto = make(map[string]func(*http.Server, *tls.Conn, http.Handler), 0)
+	}
+
+	go func() {
+		var err error
+		if c.ServerOptions.EnableTLS {
+			err = srv.ListenAndServeTLS(certFile, keyFile)
+		} else {
+			err = srv.ListenAndServe()
+		}
+
+		if err != nil && err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+
+	backgroundCtx, stopBackground := context.WithCancel(context.Background())
+	go webhook.NewDispatcher(storage.LicenseHandler).Run(backgroundCtx, webhookDeliveryInterval)
+	go notify.NewOutbox(storage.LicenseHandler, EmailSender).Run(backgroundCtx, emailSendInterval)
+	go scheduler.New(storage.LicenseHandler, jobs()...).Run(backgroundCtx)
+
+	stopWatch := make(chan struct{})
+	go config.Watch(configFilePath, configWatchInterval, stopWatch, func() {
+		reload(configFilePath, "file change")
+	})
+
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+
+	var sig os.Signal
+	for sig = range quit {
+		if sig != syscall.SIGHUP {
+			break
+		}
+
+		reload(configFilePath, "SIGHUP")
+	}
+
+	close(stopWatch)
+	stopBackground()
+	logrus.Infof("Received %s, shutting down", sig)
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	if err := srv.Shutdown(ctx); err != nil {
+		logrus.WithError(err).Error("Couldn't drain in-flight requests")
+	}
+
+	if err := storage.Disconnect(ctx); err != nil {
+		logrus.WithError(err).Error("Couldn't close storage connection")
+	}
+
+	logrus.Info("Server stopped")
+}
+
+// loadConfig loads the configuration and exits listing every problem found in it.
+func loadConfig(filePath string) {
+	c := &config.Config{}
+	err := c.Load(filePath)
+	if err == nil {
+		err = c.Validate()
+	}
+
+	if err != nil {
+		if validationErr, ok := err.(*config.ValidationError); ok {
+			for _, problem := range validationErr.Problems {
+				logrus.Error(problem)
+			}
+		}
+
+		logrus.WithError(err).Fatalf("Couldn't load configuration from %s", filePath)
+	}
+
+	config.Set(c)
+	logrus.Infof("Configuration loaded from %s", filePath)
+}
+
+// reloadMu serializes reloads triggered by signals and file changes.
+var reloadMu sync.Mutex
+
+// reloadConfig loads the configuration file again and swaps it with the current one if
+// it is valid and all of its keys can be loaded. Keys are read from their files whenever
+// a license is signed or verified, so the new keys are used right after the swap.
+func reloadConfig(filePath string) ([]config.Change, error) {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+
+	c := &config.Config{}
+	if err := c.Load(filePath); err != nil {
+		return nil, err
+	}
 
-	if config.Global.ServerOptions.EnableTLS {
-		srv := &http.Server{
-			Addr:         addr,
-			Handler:      router,
-			TLSConfig:    &config.Global.ServerOptions.TLSConfig,
-			TLSNextProto: make(map[string]func(*http.Server, *tls.Conn, http.Handler), 0),
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+
+	if err := checkKeys(c); err != nil {
+		return nil, fmt.Errorf("couldn't load keys: %s", err)
+	}
+
+	changes := config.Diff(config.Get(), c)
+	config.Set(c)
+
+	return changes, nil
+}
+
+// reload reloads the configuration and logs the changes as audit entries.
+func reload(filePath, trigger string) {
+	changes, err := reloadConfig(filePath)
+	if err != nil {
+		logrus.WithError(err).WithField("trigger", trigger).Error("Configuration is not reloaded")
+		return
+	}
+
+	logrus.WithFields(logrus.Fields{"trigger": trigger, "changes": len(changes)}).Info("Configuration reloaded")
+
+	for _, change := range changes {
+		entry := logrus.WithFields(logrus.Fields{"audit": "config", "field": change.Field})
+		if change.RequiresRestart {
+			entry.Warnf("%s, restart the server to apply it", change)
+			continue
 		}
-		log.Fatal(srv.ListenAndServeTLS(certFile, keyFile))
-	} else {
-		log.Fatal(http.ListenAndServe(addr, router))
+
+		entry.Info(change)
 	}
 }
 
 func GenerateRouter() *mux.Router {
 	r := mux.NewRouter()
+	r.Use(MetricsMiddleware)
 	// Endpoints called by product owners
 	adminRouter := r.PathPrefix("/admin").Subrouter()
+	adminRouter.Use(ClientCertMiddleware)
 	adminRouter.Use(AuthenticationMiddleware)
 	adminRouter.HandleFunc("/licenses", GetAllLicenses).Methods(http.MethodGet)
 	adminRouter.HandleFunc("/licenses", GenerateLicense).Methods(http.MethodPost)
+	adminRouter.HandleFunc("/licenses:batch", GenerateLicenses).Methods(http.MethodPost)
+	adminRouter.HandleFunc("/licenses:lookup", GetLicenseByToken).Methods(http.MethodGet)
 	adminRouter.HandleFunc("/licenses/{id}", GetLicense).Methods(http.MethodGet)
 	adminRouter.HandleFunc("/licenses/{id}/activate", ChangeLicenseActiveness).Methods(http.MethodPut)
 	adminRouter.HandleFunc("/licenses/{id}/inactivate", ChangeLicenseActiveness).Methods(http.MethodPut)
 	adminRouter.HandleFunc("/licenses/{id}/delete", DeleteLicense).Methods(http.MethodDelete)
+	adminRouter.HandleFunc("/licenses/{id}/renew", RenewLicense).Methods(http.MethodPost)
+	adminRouter.HandleFunc("/apps", GetApps).Methods(http.MethodGet)
+	adminRouter.HandleFunc("/apps", CreateApp).Methods(http.MethodPost)
+	adminRouter.HandleFunc("/apps/{name}", GetApp).Methods(http.MethodGet)
+	adminRouter.HandleFunc("/apps/{name}", UpdateApp).Methods(http.MethodPut)
+	adminRouter.HandleFunc("/apps/{name}", DeleteApp).Methods(http.MethodDelete)
+	adminRouter.HandleFunc("/tenants", GetTenants).Methods(http.MethodGet)
+	adminRouter.HandleFunc("/tenants", CreateTenant).Methods(http.MethodPost)
+	adminRouter.HandleFunc("/tenants/{id}", GetTenant).Methods(http.MethodGet)
+	adminRouter.HandleFunc("/api-keys", GetAPIKeys).Methods(http.MethodGet)
+	adminRouter.HandleFunc("/api-keys", CreateAPIKey).Methods(http.MethodPost)
+	adminRouter.HandleFunc("/api-keys/{id}", DeleteAPIKey).Methods(http.MethodDelete)
+	adminRouter.HandleFunc("/customers", GetCustomers).Methods(http.MethodGet)
+	adminRouter.HandleFunc("/customers", CreateCustomer).Methods(http.MethodPost)
+	adminRouter.HandleFunc("/customers/{id}", GetCustomer).Methods(http.MethodGet)
+	adminRouter.HandleFunc("/customers/{id}", UpdateCustomer).Methods(http.MethodPut)
+	adminRouter.HandleFunc("/customers/{id}", DeleteCustomer).Methods(http.MethodDelete)
+	adminRouter.HandleFunc("/customers/{id}/licenses", GetCustomerLicenses).Methods(http.MethodGet)
+	adminRouter.HandleFunc("/orders", GetOrders).Methods(http.MethodGet)
+	adminRouter.HandleFunc("/orders", CreateOrder).Methods(http.MethodPost)
+	adminRouter.HandleFunc("/orders/{id}", GetOrder).Methods(http.MethodGet)
+	adminRouter.HandleFunc("/webhooks", GetWebhooks).Methods(http.MethodGet)
+	adminRouter.HandleFunc("/webhooks", CreateWebhook).Methods(http.MethodPost)
+	adminRouter.HandleFunc("/webhooks/{id}", GetWebhook).Methods(http.MethodGet)
+	adminRouter.HandleFunc("/webhooks/{id}", DeleteWebhook).Methods(http.MethodDelete)
+	adminRouter.HandleFunc("/deliveries", GetDeliveries).Methods(http.MethodGet)
+	adminRouter.HandleFunc("/deliveries/{id}", GetDelivery).Methods(http.MethodGet)
+	adminRouter.HandleFunc("/deliveries/{id}/redeliver", Redeliver).Methods(http.MethodPost)
 
 	// Endpoints called by product instances having license
-	r.HandleFunc("/license/verify", VerifyLicense).Methods(http.MethodPost)
-	r.HandleFunc("/license/ping", Ping).Methods(http.MethodPost)
+	licenseRouter := r.PathPrefix("/license").Subrouter()
+	licenseRouter.Use(RateLimitMiddleware)
+	licenseRouter.HandleFunc("/verify", VerifyLicense).Methods(http.MethodPost)
+	licenseRouter.HandleFunc("/ping", Ping).Methods(http.MethodPost)
+	licenseRouter.HandleFunc("/lease", AcquireLease).Methods(http.MethodPost)
+	licenseRouter.HandleFunc("/release", ReleaseLease).Methods(http.MethodPost)
+
+	// Events of the payment provider, authenticated by their signatures
+	r.HandleFunc("/billing/webhook", BillingWebhook).Methods(http.MethodPost)
+
+	r.Handle("/metrics", promhttp.Handler()).Methods(http.MethodGet)
+	r.HandleFunc("/healthz", Healthz).Methods(http.MethodGet)
+	r.HandleFunc("/readyz", Readyz).Methods(http.MethodGet)
 
 	return r
 }
@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
@@ -51,10 +52,6 @@ func NewTestRunner() *TestRunner {
 
 func TestMain(m *testing.M) {
 	tr = NewTestRunner()
-	config.Global.Load("sample_config.json")
-	config.Global.DBName = "f-license_test"
-	storage.Connect()
-	_ = storage.LicenseHandler.DropDatabase()
 
 	publicKeyFile, privateKeyFile := genKeys()
 	defer func() {
@@ -62,27 +59,48 @@ func TestMain(m *testing.M) {
 		_ = publicKeyFile.Close()
 	}()
 
-	app := config.Global.Apps["test-app"]
+	c := &config.Config{}
+	_ = c.Load("sample_config.json")
+	c.DBName = "f-license_test"
+
+	app := c.Apps["test-app"]
 	app.Signature.RSAPrivateKeyFile = privateKeyFile.Name()
 	app.Signature.RSAPublicKeyFile = publicKeyFile.Name()
 	app.Alg = "RS512"
-	config.Global.Apps["test-app"] = app
+	config.Set(c)
+
+	storage.Connect()
+	_ = storage.LicenseHandler.DropDatabase(context.Background())
 
 	ret := m.Run()
 	tr.server.Close()
-	_ = storage.LicenseHandler.DropDatabase()
+	_ = storage.LicenseHandler.DropDatabase(context.Background())
 	os.Exit(ret)
 }
 
 func Reset() {
 	ResetTestConfig()
-	_ = storage.LicenseHandler.DropDatabase()
+	_ = storage.LicenseHandler.DropDatabase(context.Background())
 }
 
 func ResetTestConfig() {
-	app := config.Global.Apps["test-app"]
-	app.Alg = "RS512"
-	config.Global.Apps["test-app"] = app
+	c := config.Get().Clone()
+	c.Apps["test-app"].Alg = "RS512"
+	config.Set(c)
+}
+
+// setTestConfig sets a clone of the configuration changed by change, and returns a func
+// setting the original configuration back.
+func setTestConfig(change func(c *config.Config)) (restore func()) {
+	original := config.Get()
+
+	c := original.Clone()
+	change(c)
+	config.Set(c)
+
+	return func() {
+		config.Set(original)
+	}
 }
 
 func (tr *TestRunner) Run(t *testing.T, tc *TestCase) *http.Response {
@@ -105,7 +123,10 @@ func (tr *TestRunner) Run(t *testing.T, tc *TestCase) *http.Response {
 	r, err := http.NewRequest(tc.Method, tr.server.URL+tc.Path, reader)
 	assert.NoError(t, err)
 
-	r.Header.Set("Authorization", config.Global.AdminSecret)
+	r.Header.Set("Authorization", config.Get().AdminSecret)
+	for k, v := range tc.Headers {
+		r.Header.Set(k, v)
+	}
 
 	if len(formParams) != 0 {
 		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
@@ -163,10 +184,12 @@ func TestClientVerifyLocally(t *testing.T) {
 			_ = publicKeyFile.Close()
 		}()
 
-		config.Global.DefaultSignature = config.Signature{
-			RSAPrivateKeyFile: privateKeyFile.Name(),
-			RSAPublicKeyFile:  publicKeyFile.Name(),
-		}
+		defer setTestConfig(func(c *config.Config) {
+			c.DefaultSignature = config.Signature{
+				RSAPrivateKeyFile: privateKeyFile.Name(),
+				RSAPublicKeyFile:  publicKeyFile.Name(),
+			}
+		})()
 
 		l := sampleLicense(func(l *lcs.License) {
 			l.Headers["alg"] = "RS256"
@@ -206,10 +229,12 @@ func TestClientVerifyRemotely(t *testing.T) {
 			_ = privateKeyFile.Close()
 			_ = publicKeyFile.Close()
 		}()
-		config.Global.DefaultSignature = config.Signature{
-			RSAPrivateKeyFile: privateKeyFile.Name(),
-			RSAPublicKeyFile:  publicKeyFile.Name(),
-		}
+		defer setTestConfig(func(c *config.Config) {
+			c.DefaultSignature = config.Signature{
+				RSAPrivateKeyFile: privateKeyFile.Name(),
+				RSAPublicKeyFile:  publicKeyFile.Name(),
+			}
+		})()
 
 		l := sampleLicense(func(l *lcs.License) {
 			l.Headers["alg"] = "RS256"
@@ -231,7 +256,7 @@ func TestLicense_GetApp(t *testing.T) {
 	l := sampleLicense()
 
 	app, _ := l.GetApp("test-app")
-	assert.Equal(t, config.Global.Apps["test-app"], app)
+	assert.Equal(t, config.Get().Apps["test-app"], app)
 
 	_, err := l.GetApp("non-existing-app")
 	assert.EqualError(t, err, "app not found with given name")
@@ -255,7 +280,7 @@ func TestLicense_ApplyApp(t *testing.T) {
 		_ = l.ApplyApp(l.GetAppName())
 
 		assert.Equal(t, "RS512", l.GetAlg())
-		assert.Equal(t, config.Global.Apps["test-app"].Alg, l.GetAlg())
+		assert.Equal(t, config.Get().Apps["test-app"].Alg, l.GetAlg())
 	})
 }
 
@@ -284,3 +309,46 @@ func genKeys() (publicKeyFile *os.File, privateKeyFile *os.File) {
 
 	return publicKeyFile, privateKeyFile
 }
+
+func TestReloadConfig(t *testing.T) {
+	original := config.Get()
+	defer config.Set(original)
+
+	configBytes, _ := ioutil.ReadFile("sample_config.json")
+	var c map[string]interface{}
+	_ = json.Unmarshal(configBytes, &c)
+
+	apps := c["apps"].(map[string]interface{})
+	apps["new-app"] = map[string]interface{}{"alg": "HS256", "signature": map[string]interface{}{"hmac_secret": "new-secret"}}
+	c["admin_secret"] = "rotated"
+	c["port"] = 8080
+
+	configFile, _ := ioutil.TempFile("", "config*.json")
+	defer os.Remove(configFile.Name())
+	configBytes, _ = json.Marshal(c)
+	_, _ = configFile.Write(configBytes)
+
+	config.Set(&config.Config{})
+	assert.NoError(t, config.Get().Load("sample_config.json"))
+
+	changes, err := reloadConfig(configFile.Name())
+	assert.NoError(t, err)
+	assert.Equal(t, []config.Change{
+		{Field: "admin_secret", Description: "changed"},
+		{Field: "apps.new-app", Description: "added"},
+		{Field: "port", Description: "changed from 4242 to 8080", RequiresRestart: true},
+	}, changes)
+	assert.Equal(t, "rotated", config.Get().AdminSecret)
+	assert.Equal(t, "HS256", config.Get().Apps["new-app"].Alg)
+
+	// An invalid configuration is not swapped in
+	c["port"] = 0
+	apps["new-app"].(map[string]interface{})["alg"] = "XX256"
+	configBytes, _ = json.Marshal(c)
+	_ = ioutil.WriteFile(configFile.Name(), configBytes, 0600)
+
+	reloaded := config.Get()
+	_, err = reloadConfig(configFile.Name())
+	assert.EqualError(t, err, `invalid configuration: invalid port: 0; unknown alg "XX256" of app "new-app"`)
+	assert.Equal(t, reloaded, config.Get())
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/furkansenharputlu/f-license/storage"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	httpRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "f_license_http_requests_total",
+		Help: "Number of HTTP requests by route, method and status code.",
+	}, []string{"route", "method", "status"})
+	httpRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "f_license_http_request_duration_seconds",
+		Help: "Latency of HTTP requests by route and method.",
+	}, []string{"route", "method"})
+	verifications = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "f_license_verifications_total",
+		Help: "Number of license verifications by app, outcome and reason.",
+	}, []string{"app", "outcome", "reason"})
+	licensesGenerated = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "f_license_licenses_generated_total",
+		Help: "Number of generated licenses by app.",
+	}, []string{"app"})
+	licensesRevoked = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "f_license_licenses_revoked_total",
+		Help: "Number of revoked licenses by reason.",
+	}, []string{"reason"})
+)
+
+func init() {
+	prometheus.MustRegister(leasesCollector{
+		desc: prometheus.NewDesc("f_license_active_leases", "Number of active floating license leases.", nil, nil),
+	})
+}
+
+// leasesScrapeTimeout bounds counting the leases when the metrics are scraped.
+const leasesScrapeTimeout = 2 * time.Second
+
+// leasesCollector counts the active leases in the storage when the metrics are scraped,
+// so that the leases of every server instance sharing the storage are counted.
+type leasesCollector struct {
+	desc *prometheus.Desc
+}
+
+func (c leasesCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+func (c leasesCollector) Collect(ch chan<- prometheus.Metric) {
+	if storage.LicenseHandler == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), leasesScrapeTimeout)
+	defer cancel()
+
+	count, err := storage.LicenseHandler.CountLeases(ctx, time.Now())
+	if err != nil {
+		logrus.WithError(err).Error("Error while counting leases")
+		return
+	}
+
+	ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, float64(count))
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// MetricsMiddleware records status and latency of requests by their route template.
+func MetricsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := r.URL.Path
+		if current := mux.CurrentRoute(r); current != nil {
+			if tmpl, err := current.GetPathTemplate(); err == nil {
+				route = tmpl
+			}
+		}
+
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+
+		httpRequests.WithLabelValues(route, r.Method, strconv.Itoa(rec.status)).Inc()
+		httpRequestDuration.WithLabelValues(route, r.Method).Observe(time.Since(start).Seconds())
+	})
+}
@@ -0,0 +1,255 @@
+// Package migration copies licenses from one storage to another.
+package migration
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/furkansenharputlu/f-license/lcs"
+	"github.com/furkansenharputlu/f-license/storage"
+)
+
+const DefaultBatchSize = 500
+
+// maxReportedMismatches bounds the IDs listed in a verification error.
+const maxReportedMismatches = 10
+
+type Options struct {
+	BatchSize int64
+	// StateFile keeps the progress so that an interrupted migration continues where it
+	// stopped when it is run again. It is removed after a successful migration.
+	StateFile string
+	// From and To identify the source and the target storages, e.g. by their URLs. They
+	// are recorded in the state file, which isn't resumed for other storages.
+	From, To string
+	// Progress is called after every copied batch.
+	Progress func(p Progress)
+}
+
+type Progress struct {
+	Copied  int64 `json:"copied"`
+	Skipped int64 `json:"skipped"`
+	Total   int64 `json:"total"`
+}
+
+type state struct {
+	// From and To are the digests of Options.From and Options.To, so that no credentials
+	// in them are written to the state file.
+	From    string `json:"from"`
+	To      string `json:"to"`
+	LastID  string `json:"last_id"`
+	Copied  int64  `json:"copied"`
+	Skipped int64  `json:"skipped"`
+}
+
+// Result reports a finished migration. Skipped licenses were already in the target
+// with the same digest, e.g. copied before an interruption.
+type Result struct {
+	Copied      int64 `json:"copied"`
+	Skipped     int64 `json:"skipped"`
+	SourceCount int64 `json:"source_count"`
+	TargetCount int64 `json:"target_count"`
+	Verified    int64 `json:"verified"`
+}
+
+// Run copies every license of from into to in batches, then verifies both storages have
+// the same number of licenses and every license has the same digest in both.
+func Run(ctx context.Context, from, to storage.Handler, opts Options) (*Result, error) {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = DefaultBatchSize
+	}
+
+	st, err := loadState(opts.StateFile)
+	if err != nil {
+		return nil, err
+	}
+
+	fromDigest, toDigest := endpointDigest(opts.From), endpointDigest(opts.To)
+	if st.LastID != "" && (st.From != fromDigest || st.To != toDigest) {
+		return nil, fmt.Errorf("state file %s belongs to a migration between other storages, remove it to start over", opts.StateFile)
+	}
+
+	st.From, st.To = fromDigest, toDigest
+
+	total, err := from.Count(ctx, storage.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("couldn't count source licenses: %s", err)
+	}
+
+	for {
+		var licenses []*lcs.License
+		_, err := from.List(ctx, storage.ListOptions{AfterID: st.LastID, Limit: opts.BatchSize}, &licenses)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't read source licenses: %s", err)
+		}
+
+		if len(licenses) == 0 {
+			break
+		}
+
+		for _, l := range licenses {
+			copied, err := copyLicense(ctx, to, l)
+			if err != nil {
+				return nil, err
+			}
+
+			if copied {
+				st.Copied++
+			} else {
+				st.Skipped++
+			}
+		}
+
+		st.LastID = licenses[len(licenses)-1].ID.Hex()
+		if err := saveState(opts.StateFile, st); err != nil {
+			return nil, err
+		}
+
+		if opts.Progress != nil {
+			opts.Progress(Progress{Copied: st.Copied, Skipped: st.Skipped, Total: total})
+		}
+	}
+
+	res, err := verify(ctx, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	res.Copied, res.Skipped = st.Copied, st.Skipped
+
+	if opts.StateFile != "" {
+		_ = os.Remove(opts.StateFile)
+	}
+
+	return res, nil
+}
+
+func copyLicense(ctx context.Context, to storage.Handler, l *lcs.License) (copied bool, err error) {
+	err = to.Insert(ctx, l)
+	if err == nil {
+		return true, nil
+	}
+
+	if err != storage.ErrLicenseExists {
+		return false, fmt.Errorf("couldn't copy license %s: %s", l.ID.Hex(), err)
+	}
+
+	var existing lcs.License
+	if err := to.GetByID(ctx, l.ID.Hex(), &existing); err != nil {
+		return false, fmt.Errorf("license %s conflicts with another license in the target", l.ID.Hex())
+	}
+
+	if Digest(&existing) != Digest(l) {
+		return false, fmt.Errorf("license %s already exists in the target with different content", l.ID.Hex())
+	}
+
+	return false, nil
+}
+
+func verify(ctx context.Context, from, to storage.Handler) (*Result, error) {
+	res := &Result{}
+
+	var err error
+	res.SourceCount, err = from.Count(ctx, storage.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	res.TargetCount, err = to.Count(ctx, storage.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	if res.SourceCount != res.TargetCount {
+		return nil, fmt.Errorf("verification failed: source has %d licenses, target has %d", res.SourceCount, res.TargetCount)
+	}
+
+	var mismatches []string
+	err = from.Iterate(ctx, func(l *lcs.License) error {
+		var target lcs.License
+		if err := to.GetByID(ctx, l.ID.Hex(), &target); err != nil || Digest(&target) != Digest(l) {
+			mismatches = append(mismatches, l.ID.Hex())
+			return nil
+		}
+
+		res.Verified++
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(mismatches) > 0 {
+		reported := mismatches
+		if len(reported) > maxReportedMismatches {
+			reported = reported[:maxReportedMismatches]
+		}
+		return nil, fmt.Errorf("verification failed: %d licenses differ in the target: %s", len(mismatches), strings.Join(reported, ", "))
+	}
+
+	return res, nil
+}
+
+// Digest returns a digest of the stored content of the license which doesn't depend on
+// how a storage represents it.
+func Digest(l *lcs.License) string {
+	// Hash is not part of the JSON form of a license
+	b, _ := json.Marshal(struct {
+		License *lcs.License `json:"license"`
+		Hash    string       `json:"hash"`
+	}{l, l.Hash})
+
+	sum := sha256.Sum256(b)
+
+	return hex.EncodeToString(sum[:])
+}
+
+func endpointDigest(endpoint string) string {
+	sum := sha256.Sum256([]byte(endpoint))
+
+	return hex.EncodeToString(sum[:])
+}
+
+func loadState(filePath string) (*state, error) {
+	st := &state{}
+	if filePath == "" {
+		return st, nil
+	}
+
+	stateBytes, err := ioutil.ReadFile(filePath)
+	if os.IsNotExist(err) {
+		return st, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read state file: %s", err)
+	}
+
+	err = json.Unmarshal(stateBytes, st)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't unmarshal state file: %s", err)
+	}
+
+	return st, nil
+}
+
+func saveState(filePath string, st *state) error {
+	if filePath == "" {
+		return nil
+	}
+
+	stateBytes, _ := json.Marshal(st)
+
+	// Write to a temporary file first so that an interruption doesn't leave a broken state
+	tmpFile := filePath + ".tmp"
+	if err := ioutil.WriteFile(tmpFile, stateBytes, 0600); err != nil {
+		return fmt.Errorf("couldn't write state file: %s", err)
+	}
+
+	return os.Rename(tmpFile, filePath)
+}
@@ -0,0 +1,186 @@
+package migration
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/furkansenharputlu/f-license/lcs"
+	"github.com/furkansenharputlu/f-license/storage"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// memoryHandler implements the parts of storage.Handler used by migrations.
+type memoryHandler struct {
+	storage.Handler
+	licenses []*lcs.License
+	// failAfter makes Insert fail after that many inserted licenses when positive.
+	failAfter int
+}
+
+func (h *memoryHandler) Count(ctx context.Context, opts storage.ListOptions) (int64, error) {
+	return int64(len(h.licenses)), nil
+}
+
+func (h *memoryHandler) List(ctx context.Context, opts storage.ListOptions, licenses *[]*lcs.License) (int64, error) {
+	sorted := append([]*lcs.License{}, h.licenses...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].ID.Hex() < sorted[j].ID.Hex() })
+
+	for _, l := range sorted {
+		if l.ID.Hex() <= opts.AfterID {
+			continue
+		}
+		if opts.Limit > 0 && int64(len(*licenses)) == opts.Limit {
+			break
+		}
+		*licenses = append(*licenses, l)
+	}
+
+	return int64(len(h.licenses)), nil
+}
+
+func (h *memoryHandler) Iterate(ctx context.Context, fn func(l *lcs.License) error) error {
+	for _, l := range h.licenses {
+		if err := fn(l); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (h *memoryHandler) GetByID(ctx context.Context, id string, l *lcs.License) error {
+	for _, existing := range h.licenses {
+		if existing.ID.Hex() == id {
+			*l = *existing
+			return nil
+		}
+	}
+
+	return errors.New("not found")
+}
+
+func (h *memoryHandler) Insert(ctx context.Context, l *lcs.License) error {
+	for _, existing := range h.licenses {
+		if existing.ID == l.ID || existing.Hash == l.Hash {
+			return storage.ErrLicenseExists
+		}
+	}
+
+	if h.failAfter > 0 && len(h.licenses) == h.failAfter {
+		return errors.New("connection lost")
+	}
+
+	h.licenses = append(h.licenses, l)
+
+	return nil
+}
+
+func sampleLicenses(n int) []*lcs.License {
+	var licenses []*lcs.License
+	for i := 0; i < n; i++ {
+		name := string(rune('a' + i))
+		licenses = append(licenses, &lcs.License{
+			ID:      primitive.NewObjectID(),
+			Headers: map[string]interface{}{"typ": "Trial", "alg": "HS256"},
+			Claims:  jwt.MapClaims{"name": name},
+			Token:   "ey." + name,
+			Hash:    name,
+			Active:  true,
+		})
+	}
+
+	return licenses
+}
+
+func TestRun(t *testing.T) {
+	ctx := context.Background()
+	source := &memoryHandler{licenses: sampleLicenses(5)}
+	target := &memoryHandler{}
+
+	var progress []Progress
+	res, err := Run(ctx, source, target, Options{BatchSize: 2, Progress: func(p Progress) {
+		progress = append(progress, p)
+	}})
+	assert.NoError(t, err)
+	assert.Equal(t, &Result{Copied: 5, SourceCount: 5, TargetCount: 5, Verified: 5}, res)
+	assert.Equal(t, []Progress{{Copied: 2, Total: 5}, {Copied: 4, Total: 5}, {Copied: 5, Total: 5}}, progress)
+	assert.Len(t, target.licenses, 5)
+}
+
+func TestRun_Resume(t *testing.T) {
+	ctx := context.Background()
+	dir, _ := ioutil.TempDir("", "migration")
+	defer os.RemoveAll(dir)
+	stateFile := filepath.Join(dir, "state.json")
+
+	source := &memoryHandler{licenses: sampleLicenses(5)}
+	target := &memoryHandler{failAfter: 3}
+
+	_, err := Run(ctx, source, target, Options{BatchSize: 2, StateFile: stateFile})
+	assert.EqualError(t, err, "couldn't copy license "+source.licenses[3].ID.Hex()+": connection lost")
+
+	st, err := loadState(stateFile)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), st.Copied)
+
+	// The license copied in the interrupted batch is skipped
+	target.failAfter = 0
+	res, err := Run(ctx, source, target, Options{BatchSize: 2, StateFile: stateFile})
+	assert.NoError(t, err)
+	assert.Equal(t, &Result{Copied: 4, Skipped: 1, SourceCount: 5, TargetCount: 5, Verified: 5}, res)
+
+	_, err = os.Stat(stateFile)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestRun_ResumeOtherStorages(t *testing.T) {
+	ctx := context.Background()
+	dir, _ := ioutil.TempDir("", "migration")
+	defer os.RemoveAll(dir)
+	stateFile := filepath.Join(dir, "state.json")
+
+	source := &memoryHandler{licenses: sampleLicenses(5)}
+	target := &memoryHandler{failAfter: 3}
+
+	opts := Options{BatchSize: 2, StateFile: stateFile, From: "mongodb://a/f-license", To: "mongodb://b/f-license"}
+	_, err := Run(ctx, source, target, opts)
+	assert.Error(t, err)
+
+	target.failAfter = 0
+	opts.To = "mongodb://c/f-license"
+	_, err = Run(ctx, source, target, opts)
+	assert.EqualError(t, err, "state file "+stateFile+" belongs to a migration between other storages, remove it to start over")
+
+	st, err := loadState(stateFile)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), st.Copied)
+}
+
+func TestRun_Conflict(t *testing.T) {
+	ctx := context.Background()
+	source := &memoryHandler{licenses: sampleLicenses(2)}
+
+	changed := *source.licenses[1]
+	changed.Active = false
+	target := &memoryHandler{licenses: []*lcs.License{&changed}}
+
+	_, err := Run(ctx, source, target, Options{})
+	assert.EqualError(t, err, "license "+changed.ID.Hex()+" already exists in the target with different content")
+}
+
+func TestRun_CountMismatch(t *testing.T) {
+	ctx := context.Background()
+	source := &memoryHandler{licenses: sampleLicenses(2)}
+	target := &memoryHandler{licenses: sampleLicenses(3)[2:]}
+
+	_, err := Run(ctx, source, target, Options{})
+	assert.EqualError(t, err, "verification failed: source has 2 licenses, target has 3")
+}
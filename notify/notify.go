@@ -0,0 +1,192 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/mail"
+	"text/template"
+	"time"
+
+	"github.com/furkansenharputlu/f-license/config"
+	"github.com/furkansenharputlu/f-license/lcs"
+	"github.com/furkansenharputlu/f-license/webhook"
+)
+
+// CustomerStore looks up the customers licenses are issued for.
+type CustomerStore interface {
+	GetCustomer(ctx context.Context, id string) (*lcs.Customer, error)
+}
+
+// Data is what the templates are executed with.
+type Data struct {
+	Customer *lcs.Customer
+	License  *lcs.License
+	App      string
+	// Key is the license key, the token of the license.
+	Key string
+	// ExpiresAt is nil if the license doesn't expire.
+	ExpiresAt *time.Time
+	// DaysLeft is the number of whole days until the license expires.
+	DaysLeft int
+}
+
+// DefaultTemplates are used for the kinds which neither the app nor the configuration
+// has a template for.
+var DefaultTemplates = map[string]*config.EmailTemplate{
+	config.EmailIssued: {
+		Subject: `Your {{with .App}}{{.}} {{end}}license`,
+		Body: `Hello {{.Customer.Name}},
+
+Your {{with .App}}{{.}} {{end}}license has been issued. Your license key is:
+
+{{.Key}}
+{{with .ExpiresAt}}
+It is valid until {{.Format "January 2, 2006"}}.
+{{end}}`,
+	},
+	config.EmailExpiring: {
+		Subject: `Your {{with .App}}{{.}} {{end}}license expires in {{.DaysLeft}} day{{if ne .DaysLeft 1}}s{{end}}`,
+		Body: `Hello {{.Customer.Name}},
+
+Your {{with .App}}{{.}} {{end}}license expires on {{.ExpiresAt.Format "January 2, 2006"}}. Please renew it to keep using the product.
+`,
+	},
+	config.EmailRevoked: {
+		Subject: `Your {{with .App}}{{.}} {{end}}license is revoked`,
+		Body: `Hello {{.Customer.Name}},
+
+Your {{with .App}}{{.}} {{end}}license has been revoked and can't be used anymore.
+`,
+	},
+}
+
+// KindOf returns the kind of the email the customer is sent on the webhook event, or ""
+// if the customer isn't emailed on it.
+func KindOf(event string) string {
+	switch event {
+	case webhook.EventGenerated:
+		return config.EmailIssued
+	case webhook.EventExpiring:
+		return config.EmailExpiring
+	case webhook.EventInactivated, webhook.EventDeleted:
+		return config.EmailRevoked
+	default:
+		return ""
+	}
+}
+
+// Template returns the template of the kind of emails sent to the customers of the app.
+// The template of the app is preferred over the configured one, which is preferred over
+// the default one.
+func Template(kind string, app *config.App) (*config.EmailTemplate, error) {
+	if app != nil {
+		if t, ok := app.Emails[kind]; ok && t != nil {
+			return t, nil
+		}
+	}
+
+	if t, ok := config.Get().Email.Templates[kind]; ok && t != nil {
+		return t, nil
+	}
+
+	if t, ok := DefaultTemplates[kind]; ok {
+		return t, nil
+	}
+
+	return nil, fmt.Errorf("unknown email kind: %s", kind)
+}
+
+// Render executes the subject and the body of the template with the data.
+func Render(t *config.EmailTemplate, data *Data) (subject, body string, err error) {
+	subject, err = execute("subject", t.Subject, data)
+	if err != nil {
+		return "", "", err
+	}
+
+	body, err = execute("body", t.Body, data)
+	if err != nil {
+		return "", "", err
+	}
+
+	return subject, body, nil
+}
+
+func execute(name, text string, data *Data) (string, error) {
+	tmpl, err := template.New(name).Parse(text)
+	if err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", err
+	}
+
+	return buf.String(), nil
+}
+
+// Notifier emails the customers of licenses.
+type Notifier struct {
+	// Sender is an SMTP sender of the configured server if nil.
+	Sender    Sender
+	Customers CustomerStore
+}
+
+func NewNotifier(sender Sender, customers CustomerStore) *Notifier {
+	return &Notifier{Sender: sender, Customers: customers}
+}
+
+// Notify emails the customer of the license the email of the kind. Nothing is sent if
+// emails are disabled or the license has no customer.
+func (n *Notifier) Notify(ctx context.Context, kind string, l *lcs.License) error {
+	c := config.Get().Email
+	if !c.Enable || l.CustomerID == "" {
+		return nil
+	}
+
+	customer, err := n.Customers.GetCustomer(ctx, l.CustomerID)
+	if err != nil {
+		return err
+	}
+
+	data := &Data{Customer: customer, License: l, App: l.GetAppName(), Key: l.Token}
+
+	data.ExpiresAt, err = l.ExpiresAt()
+	if err != nil {
+		return err
+	}
+
+	if data.ExpiresAt != nil {
+		data.DaysLeft = int(time.Until(*data.ExpiresAt).Hours() / 24)
+	} else if kind == config.EmailExpiring {
+		return errors.New("license doesn't expire")
+	}
+
+	var app *config.App
+	if data.App != "" {
+		// Default templates are used for the apps which are deleted since
+		app, _ = l.GetApp(data.App)
+	}
+
+	t, err := Template(kind, app)
+	if err != nil {
+		return err
+	}
+
+	subject, body, err := Render(t, data)
+	if err != nil {
+		return err
+	}
+
+	to := &mail.Address{Name: customer.Name, Address: customer.Email}
+	m := &Message{From: c.From, To: to.String(), Subject: subject, Body: body}
+
+	sender := n.Sender
+	if sender == nil {
+		sender = NewSMTPSender(c.SMTP)
+	}
+
+	return sender.Send(ctx, m)
+}
@@ -0,0 +1,294 @@
+package notify
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io/ioutil"
+	"mime/quotedprintable"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/furkansenharputlu/f-license/config"
+	"github.com/furkansenharputlu/f-license/lcs"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/stretchr/testify/assert"
+)
+
+// received is an email received by fakeSMTP.
+type received struct {
+	Auth string
+	From string
+	To   []string
+	Data string
+}
+
+// fakeSMTP is an SMTP server keeping the emails it receives in memory.
+type fakeSMTP struct {
+	listener net.Listener
+	mu       sync.Mutex
+	mails    []received
+}
+
+func newFakeSMTP(t *testing.T) *fakeSMTP {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &fakeSMTP{listener: l}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+
+			go s.serve(conn)
+		}
+	}()
+
+	return s
+}
+
+func (s *fakeSMTP) config() config.SMTP {
+	addr := s.listener.Addr().(*net.TCPAddr)
+	return config.SMTP{Host: addr.IP.String(), Port: addr.Port}
+}
+
+func (s *fakeSMTP) serve(conn net.Conn) {
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+	reply := func(line string) {
+		_, _ = conn.Write([]byte(line + "\r\n"))
+	}
+
+	var m received
+	reply("220 localhost ESMTP")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+
+		line = strings.TrimRight(line, "\r\n")
+		verb := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+		switch verb {
+		case "EHLO":
+			reply("250-localhost")
+			reply("250 AUTH PLAIN")
+		case "AUTH":
+			m.Auth = line
+			reply("235 Authenticated")
+		case "MAIL":
+			m.From = strings.TrimPrefix(line, "MAIL FROM:")
+			reply("250 OK")
+		case "RCPT":
+			m.To = append(m.To, strings.TrimPrefix(line, "RCPT TO:"))
+			reply("250 OK")
+		case "DATA":
+			reply("354 Go ahead")
+
+			var data []string
+			for {
+				line, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+
+				if line == ".\r\n" {
+					break
+				}
+
+				data = append(data, line)
+			}
+
+			m.Data = strings.Join(data, "")
+			s.mu.Lock()
+			s.mails = append(s.mails, m)
+			s.mu.Unlock()
+			m = received{}
+
+			reply("250 Queued")
+		case "QUIT":
+			reply("221 Bye")
+			return
+		default:
+			reply("502 Unknown command")
+		}
+	}
+}
+
+func (s *fakeSMTP) received() []received {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]received(nil), s.mails...)
+}
+
+// body decodes the quoted-printable body of the email.
+func body(t *testing.T, data string) string {
+	parts := strings.SplitN(data, "\r\n\r\n", 2)
+	decoded, err := ioutil.ReadAll(quotedprintable.NewReader(strings.NewReader(parts[1])))
+	assert.NoError(t, err)
+
+	return strings.Replace(string(decoded), "\r\n", "\n", -1)
+}
+
+type customers map[string]*lcs.Customer
+
+func (c customers) GetCustomer(ctx context.Context, id string) (*lcs.Customer, error) {
+	customer, ok := c[id]
+	if !ok {
+		return nil, errors.New("customer not found")
+	}
+
+	return customer, nil
+}
+
+// recorder is a Sender keeping the messages.
+type recorder struct {
+	messages []*Message
+}
+
+func (r *recorder) Send(ctx context.Context, m *Message) error {
+	r.messages = append(r.messages, m)
+	return nil
+}
+
+func TestSMTPSender(t *testing.T) {
+	server := newFakeSMTP(t)
+	defer server.listener.Close()
+
+	c := server.config()
+	c.Username = "user"
+	c.Password = "pass"
+
+	m := &Message{From: "F License <noreply@example.com>", To: `"Jane Doe" <jane@example.com>`, Subject: "Lizenz für Sie", Body: "Your key:\n\nabc.def\n"}
+	assert.NoError(t, NewSMTPSender(c).Send(context.Background(), m))
+
+	mails := server.received()
+	if assert.Len(t, mails, 1) {
+		assert.Equal(t, "AUTH PLAIN AHVzZXIAcGFzcw==", mails[0].Auth)
+		assert.Equal(t, "<noreply@example.com>", mails[0].From)
+		assert.Equal(t, []string{"<jane@example.com>"}, mails[0].To)
+		assert.Contains(t, mails[0].Data, "From: F License <noreply@example.com>\r\n")
+		assert.Contains(t, mails[0].Data, "To: \"Jane Doe\" <jane@example.com>\r\n")
+		assert.Contains(t, mails[0].Data, "Subject: =?utf-8?q?Lizenz_f=C3=BCr_Sie?=\r\n")
+		assert.Contains(t, mails[0].Data, "Content-Type: text/plain; charset=UTF-8\r\n")
+		assert.Equal(t, "Your key:\n\nabc.def\n", body(t, mails[0].Data))
+	}
+
+	err := NewSMTPSender(c).Send(context.Background(), &Message{From: "noreply", To: "jane@example.com"})
+	assert.EqualError(t, err, "invalid from address: mail: missing '@' or angle-addr")
+
+	// Nothing is listening on the port anymore
+	server.listener.Close()
+	assert.Error(t, NewSMTPSender(c).Send(context.Background(), m))
+}
+
+func TestNotifier(t *testing.T) {
+	original := config.Get()
+	defer config.Set(original)
+
+	server := newFakeSMTP(t)
+	defer server.listener.Close()
+
+	config.Set(&config.Config{
+		Email: config.Email{Enable: true, From: "noreply@example.com", SMTP: server.config(), Templates: map[string]*config.EmailTemplate{
+			config.EmailRevoked: {Subject: "Revoked", Body: "License of {{.Customer.Company}} is revoked."},
+		}},
+		Apps: map[string]*config.App{
+			"piano": {Name: "piano", Emails: map[string]*config.EmailTemplate{
+				config.EmailIssued: {Subject: "Welcome to {{.App}}", Body: "{{.Customer.Name}}: {{.Key}}"},
+			}},
+		},
+	})
+
+	store := customers{"jane": {Name: "Jane", Email: "jane@example.com", Company: "Acme"}}
+	expiresAt := time.Now().Add(72*time.Hour + time.Minute)
+	license := func(app string) *lcs.License {
+		return &lcs.License{
+			Headers:    map[string]interface{}{"app": app},
+			Token:      "abc.def.ghi",
+			Claims:     jwt.MapClaims{"exp": float64(expiresAt.Unix())},
+			CustomerID: "jane",
+		}
+	}
+
+	ctx := context.Background()
+
+	t.Run("through smtp", func(t *testing.T) {
+		assert.NoError(t, NewNotifier(nil, store).Notify(ctx, config.EmailIssued, license("guitar")))
+
+		mails := server.received()
+		if assert.Len(t, mails, 1) {
+			assert.Equal(t, []string{"<jane@example.com>"}, mails[0].To)
+			assert.Contains(t, mails[0].Data, "Subject: Your guitar license\r\n")
+			assert.Equal(t, "Hello Jane,\n\nYour guitar license has been issued. Your license key is:\n\nabc.def.ghi\n\nIt is valid until "+
+				expiresAt.Format("January 2, 2006")+".\n", body(t, mails[0].Data))
+		}
+	})
+
+	t.Run("templates", func(t *testing.T) {
+		r := &recorder{}
+		n := NewNotifier(r, store)
+
+		// Template of the app
+		assert.NoError(t, n.Notify(ctx, config.EmailIssued, license("piano")))
+		// Configured template
+		assert.NoError(t, n.Notify(ctx, config.EmailRevoked, license("piano")))
+		// Default template
+		assert.NoError(t, n.Notify(ctx, config.EmailExpiring, license("")))
+
+		if assert.Len(t, r.messages, 3) {
+			assert.Equal(t, &Message{From: "noreply@example.com", To: `"Jane" <jane@example.com>`, Subject: "Welcome to piano", Body: "Jane: abc.def.ghi"}, r.messages[0])
+			assert.Equal(t, "Revoked", r.messages[1].Subject)
+			assert.Equal(t, "License of Acme is revoked.", r.messages[1].Body)
+			assert.Equal(t, "Your license expires in 3 days", r.messages[2].Subject)
+			assert.Contains(t, r.messages[2].Body, "Your license expires on "+expiresAt.Format("January 2, 2006")+".")
+		}
+	})
+
+	t.Run("not sent", func(t *testing.T) {
+		r := &recorder{}
+		n := NewNotifier(r, store)
+
+		l := license("")
+		l.CustomerID = ""
+		assert.NoError(t, n.Notify(ctx, config.EmailIssued, l))
+
+		l = license("")
+		delete(l.Claims, "exp")
+		assert.EqualError(t, n.Notify(ctx, config.EmailExpiring, l), "license doesn't expire")
+
+		l = license("")
+		l.CustomerID = "john"
+		assert.EqualError(t, n.Notify(ctx, config.EmailIssued, l), "customer not found")
+
+		assert.EqualError(t, n.Notify(ctx, "renewed", license("")), "unknown email kind: renewed")
+
+		disabled := config.Get().Clone()
+		disabled.Email.Enable = false
+		config.Set(disabled)
+		assert.NoError(t, n.Notify(ctx, config.EmailIssued, license("")))
+
+		assert.Empty(t, r.messages)
+	})
+}
+
+func TestMessageBytes(t *testing.T) {
+	m := &Message{From: "a@example.com", To: "b@example.com", Subject: "Hi", Body: "Key: " + strings.Repeat("x", 100)}
+	data := string(m.Bytes(time.Unix(0, 0).UTC()))
+
+	assert.True(t, strings.HasPrefix(data, "From: a@example.com\r\nTo: b@example.com\r\nSubject: Hi\r\nDate: Thu, 01 Jan 1970 00:00:00 +0000\r\n"))
+	// Long lines are soft broken
+	assert.Contains(t, data, "=\r\n")
+	assert.Equal(t, "Key: "+strings.Repeat("x", 100), body(t, data))
+	assert.Equal(t, 1, strings.Count(data, "\r\n\r\n"))
+}
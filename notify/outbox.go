@@ -0,0 +1,147 @@
+package notify
+
+import (
+	"context"
+	"time"
+
+	"github.com/furkansenharputlu/f-license/config"
+	"github.com/furkansenharputlu/f-license/lcs"
+	"github.com/furkansenharputlu/f-license/webhook"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Statuses of the emails in the outbox. Sending an email is given up after the last
+// attempt, and the email is dead.
+const (
+	StatusPending = "pending"
+	StatusSent    = "sent"
+	StatusDead    = "dead"
+)
+
+// Email is an email to the customer of a license waiting in the outbox, or the record of
+// its sending. The license is kept as it was when the email was queued, so that customers
+// of deleted licenses are emailed too.
+type Email struct {
+	ID            string       `json:"id" bson:"_id"`
+	Kind          string       `json:"kind" bson:"kind"`
+	License       *lcs.License `json:"license" bson:"license"`
+	Tenant        string       `json:"tenant,omitempty" bson:"tenant,omitempty"`
+	Status        string       `json:"status" bson:"status"`
+	Attempts      int          `json:"attempts" bson:"attempts"`
+	NextAttemptAt time.Time    `json:"next_attempt_at" bson:"next_attempt_at"`
+	LastError     string       `json:"last_error,omitempty" bson:"last_error,omitempty"`
+	CreatedAt     time.Time    `json:"created_at" bson:"created_at"`
+	SentAt        *time.Time   `json:"sent_at,omitempty" bson:"sent_at,omitempty"`
+}
+
+// NewEmail returns a pending email of the kind to the customer of the license.
+func NewEmail(kind string, l *lcs.License) *Email {
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	return &Email{
+		ID:            webhook.NewID(),
+		Kind:          kind,
+		License:       l,
+		Tenant:        l.Tenant,
+		Status:        StatusPending,
+		NextAttemptAt: now,
+		CreatedAt:     now,
+	}
+}
+
+// Store is the outbox emails are taken from.
+type Store interface {
+	CustomerStore
+	// ClaimEmails returns up to limit pending emails due at now, and postpones them by
+	// lease so that other outboxes don't take them while they are being sent.
+	ClaimEmails(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]*Email, error)
+	UpdateEmail(ctx context.Context, e *Email) error
+}
+
+// sendTimeout is how long sending an email is waited for.
+const sendTimeout = 30 * time.Second
+
+// batchSize is the number of emails claimed at once.
+const batchSize = 50
+
+// Outbox sends the due emails of the store. Several outboxes can share a store, e.g. one in
+// every server instance.
+type Outbox struct {
+	Store Store
+	// Sender is an SMTP sender of the configured server if nil.
+	Sender Sender
+}
+
+func NewOutbox(store Store, sender Sender) *Outbox {
+	return &Outbox{Store: store, Sender: sender}
+}
+
+// Run sends the due emails every interval until the context is canceled.
+func (o *Outbox) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := o.SendDue(ctx); err != nil && ctx.Err() == nil {
+			logrus.WithError(err).Error("Error while sending emails")
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// SendDue makes an attempt for every due email and returns the number of attempts.
+func (o *Outbox) SendDue(ctx context.Context) (int, error) {
+	attempts := 0
+	for {
+		conf := config.Get().Email
+		// The lease covers the attempts of the whole batch
+		lease := time.Duration(batchSize+1) * sendTimeout
+
+		emails, err := o.Store.ClaimEmails(ctx, time.Now(), lease, batchSize)
+		if err != nil {
+			return attempts, err
+		}
+
+		for _, e := range emails {
+			if err := o.attempt(ctx, e, conf); err != nil {
+				return attempts, err
+			}
+			attempts++
+		}
+
+		if len(emails) < batchSize {
+			return attempts, nil
+		}
+	}
+}
+
+// attempt sends the email and records the outcome. It returns only storage errors.
+func (o *Outbox) attempt(ctx context.Context, e *Email, conf config.Email) error {
+	e.Attempts++
+
+	sendCtx, cancel := context.WithTimeout(ctx, sendTimeout)
+	err := NewNotifier(o.Sender, o.Store).Notify(sendCtx, e.Kind, e.License)
+	cancel()
+
+	switch {
+	case err == nil:
+		now := time.Now().UTC()
+		e.Status = StatusSent
+		e.SentAt = &now
+		e.LastError = ""
+	case e.Attempts >= conf.GetMaxAttempts():
+		e.Status = StatusDead
+		e.LastError = err.Error()
+		logrus.WithError(err).WithFields(logrus.Fields{"email": e.ID, "kind": e.Kind}).Warn("Sending email is given up")
+	default:
+		e.LastError = err.Error()
+		e.NextAttemptAt = time.Now().UTC().Add(webhook.Backoff(e.Attempts, conf.GetInitialBackoff(), conf.GetMaxBackoff()))
+	}
+
+	return o.Store.UpdateEmail(ctx, e)
+}
@@ -0,0 +1,117 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/furkansenharputlu/f-license/config"
+	"github.com/furkansenharputlu/f-license/lcs"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// memoryOutbox is an outbox store keeping the emails in memory.
+type memoryOutbox struct {
+	customers
+	emails map[string]*Email
+}
+
+func (s *memoryOutbox) ClaimEmails(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]*Email, error) {
+	var claimed []*Email
+	for _, e := range s.emails {
+		if len(claimed) == limit {
+			break
+		}
+
+		if e.Status == StatusPending && !e.NextAttemptAt.After(now) {
+			e.NextAttemptAt = now.Add(lease)
+			copied := *e
+			claimed = append(claimed, &copied)
+		}
+	}
+
+	return claimed, nil
+}
+
+func (s *memoryOutbox) UpdateEmail(ctx context.Context, e *Email) error {
+	s.emails[e.ID] = e
+	return nil
+}
+
+// failingSender fails the first failures sends.
+type failingSender struct {
+	recorder
+	failures int
+}
+
+func (s *failingSender) Send(ctx context.Context, m *Message) error {
+	if s.failures > 0 {
+		s.failures--
+		return errors.New("connection refused")
+	}
+
+	return s.recorder.Send(ctx, m)
+}
+
+func TestOutbox(t *testing.T) {
+	original := config.Get()
+	defer config.Set(original)
+
+	config.Set(&config.Config{
+		Email: config.Email{Enable: true, From: "noreply@example.com", MaxAttempts: 2, InitialBackoff: 60},
+	})
+
+	store := &memoryOutbox{
+		customers: customers{"jane": {Name: "Jane", Email: "jane@example.com"}},
+		emails:    map[string]*Email{},
+	}
+
+	queue := func(customerID string) *Email {
+		e := NewEmail(config.EmailRevoked, &lcs.License{Token: "abc.def.ghi", CustomerID: customerID})
+		store.emails[e.ID] = e
+		return e
+	}
+
+	ctx := context.Background()
+	sender := &failingSender{failures: 1}
+	outbox := NewOutbox(store, sender)
+
+	sent := queue("jane")
+	dead := queue("john")
+
+	attempts, err := outbox.SendDue(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, attempts)
+	assert.Empty(t, sender.messages)
+
+	// Failed sends are retried after the backoff
+	for _, e := range store.emails {
+		assert.Equal(t, StatusPending, e.Status)
+		assert.Equal(t, 1, e.Attempts)
+		assert.NotEmpty(t, e.LastError)
+		assert.True(t, e.NextAttemptAt.After(time.Now().Add(50*time.Second)))
+		e.NextAttemptAt = time.Now()
+	}
+
+	attempts, err = outbox.SendDue(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, attempts)
+
+	if assert.Len(t, sender.messages, 1) {
+		assert.Equal(t, `"Jane" <jane@example.com>`, sender.messages[0].To)
+	}
+
+	assert.Equal(t, StatusSent, store.emails[sent.ID].Status)
+	assert.NotNil(t, store.emails[sent.ID].SentAt)
+	assert.Empty(t, store.emails[sent.ID].LastError)
+
+	// Sending is given up after the last attempt
+	assert.Equal(t, StatusDead, store.emails[dead.ID].Status)
+	assert.Equal(t, "customer not found", store.emails[dead.ID].LastError)
+
+	attempts, err = outbox.SendDue(ctx)
+	assert.NoError(t, err)
+	assert.Zero(t, attempts)
+}
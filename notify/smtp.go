@@ -0,0 +1,152 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strconv"
+	"time"
+
+	"github.com/furkansenharputlu/f-license/config"
+)
+
+// Message is an email to a single recipient. From and To are addresses as in RFC 5322,
+// e.g. "F License <noreply@example.com>".
+type Message struct {
+	From    string
+	To      string
+	Subject string
+	Body    string
+}
+
+// Bytes returns the message with its headers, the body encoded as quoted-printable UTF-8
+// text.
+func (m *Message) Bytes(date time.Time) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", m.From)
+	fmt.Fprintf(&buf, "To: %s\r\n", m.To)
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", m.Subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", date.Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	buf.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+
+	w := quotedprintable.NewWriter(&buf)
+	_, _ = w.Write([]byte(m.Body))
+	_ = w.Close()
+
+	return buf.Bytes()
+}
+
+// Sender sends emails.
+type Sender interface {
+	Send(ctx context.Context, m *Message) error
+}
+
+// dialTimeout is how long connecting to the SMTP server is waited for if the context has
+// no deadline.
+const dialTimeout = 10 * time.Second
+
+// SMTPSender sends emails through an SMTP server.
+type SMTPSender struct {
+	SMTP config.SMTP
+}
+
+func NewSMTPSender(s config.SMTP) *SMTPSender {
+	return &SMTPSender{SMTP: s}
+}
+
+func (s *SMTPSender) Send(ctx context.Context, m *Message) error {
+	from, err := mail.ParseAddress(m.From)
+	if err != nil {
+		return fmt.Errorf("invalid from address: %s", err)
+	}
+
+	to, err := mail.ParseAddress(m.To)
+	if err != nil {
+		return fmt.Errorf("invalid to address: %s", err)
+	}
+
+	c, err := s.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	if s.SMTP.Username != "" {
+		if err := c.Auth(smtp.PlainAuth("", s.SMTP.Username, s.SMTP.Password, s.SMTP.Host)); err != nil {
+			return err
+		}
+	}
+
+	if err := c.Mail(from.Address); err != nil {
+		return err
+	}
+
+	if err := c.Rcpt(to.Address); err != nil {
+		return err
+	}
+
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+
+	if _, err := w.Write(m.Bytes(time.Now())); err != nil {
+		return err
+	}
+
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	return c.Quit()
+}
+
+// dial connects to the server, with implicit TLS if configured or upgrading the connection
+// with STARTTLS if the server supports it.
+func (s *SMTPSender) dial(ctx context.Context) (*smtp.Client, error) {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, dialTimeout)
+		defer cancel()
+	}
+
+	addr := net.JoinHostPort(s.SMTP.Host, strconv.Itoa(s.SMTP.GetPort()))
+	conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	tlsConfig := &tls.Config{ServerName: s.SMTP.Host}
+	if s.SMTP.TLS {
+		conn = tls.Client(conn, tlsConfig)
+	}
+
+	c, err := smtp.NewClient(conn, s.SMTP.Host)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	if !s.SMTP.TLS {
+		if ok, _ := c.Extension("STARTTLS"); ok {
+			if err := c.StartTLS(tlsConfig); err != nil {
+				c.Close()
+				return nil, err
+			}
+		}
+	}
+
+	return c, nil
+}
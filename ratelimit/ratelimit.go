@@ -0,0 +1,93 @@
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// Store keeps the token buckets. Implementations backed by a shared database can be
+// used to apply the same limits across several server instances.
+type Store interface {
+	// Take removes a token from the bucket of the key. If there is no token left,
+	// it returns false and the duration after which a token will be available.
+	Take(key string, limit Limit, now time.Time) (allowed bool, retryAfter time.Duration, err error)
+}
+
+// Limit defines a token bucket refilled with Rate tokens per second up to Burst tokens.
+type Limit struct {
+	Rate  float64 `json:"rate"`
+	Burst int     `json:"burst"`
+}
+
+// Enabled reports whether the limit should be applied.
+func (l Limit) Enabled() bool {
+	return l.Rate > 0 && l.Burst > 0
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+	limit  Limit
+}
+
+// MemoryStore is a Store keeping buckets in process memory.
+type MemoryStore struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+// sweepInterval is how often buckets that are full again are dropped to bound memory usage.
+const sweepInterval = time.Minute
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{buckets: make(map[string]*bucket)}
+}
+
+func (s *MemoryStore) Take(key string, limit Limit, now time.Time) (bool, time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if now.Sub(s.lastSweep) > sweepInterval {
+		s.sweep(now)
+	}
+
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(limit.Burst), last: now}
+		s.buckets[key] = b
+	}
+
+	b.limit = limit
+	b.tokens = b.refill(now)
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0, nil
+	}
+
+	retryAfter := time.Duration((1 - b.tokens) / limit.Rate * float64(time.Second))
+
+	return false, retryAfter, nil
+}
+
+func (s *MemoryStore) sweep(now time.Time) {
+	for key, b := range s.buckets {
+		if b.refill(now) >= float64(b.limit.Burst) {
+			delete(s.buckets, key)
+		}
+	}
+
+	s.lastSweep = now
+}
+
+func (b *bucket) refill(now time.Time) float64 {
+	elapsed := now.Sub(b.last).Seconds()
+	if elapsed < 0 {
+		elapsed = 0
+	}
+
+	return math.Min(float64(b.limit.Burst), b.tokens+elapsed*b.limit.Rate)
+}
@@ -0,0 +1,48 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryStore_Take(t *testing.T) {
+	s := NewMemoryStore()
+	limit := Limit{Rate: 2, Burst: 3}
+	now := time.Now()
+
+	for i := 0; i < 3; i++ {
+		allowed, _, err := s.Take("ip:1.2.3.4", limit, now)
+		assert.NoError(t, err)
+		assert.True(t, allowed)
+	}
+
+	allowed, retryAfter, _ := s.Take("ip:1.2.3.4", limit, now)
+	assert.False(t, allowed)
+	assert.Equal(t, 500*time.Millisecond, retryAfter)
+
+	t.Run("other keys have their own bucket", func(t *testing.T) {
+		allowed, _, _ := s.Take("ip:5.6.7.8", limit, now)
+		assert.True(t, allowed)
+	})
+
+	t.Run("refill", func(t *testing.T) {
+		allowed, _, _ := s.Take("ip:1.2.3.4", limit, now.Add(500*time.Millisecond))
+		assert.True(t, allowed)
+
+		allowed, _, _ = s.Take("ip:1.2.3.4", limit, now.Add(500*time.Millisecond))
+		assert.False(t, allowed)
+	})
+
+	t.Run("sweep full buckets", func(t *testing.T) {
+		_, _, _ = s.Take("ip:9.9.9.9", limit, now.Add(2*sweepInterval))
+		assert.Len(t, s.buckets, 1)
+	})
+}
+
+func TestLimit_Enabled(t *testing.T) {
+	assert.True(t, Limit{Rate: 1, Burst: 1}.Enabled())
+	assert.False(t, Limit{Rate: 1}.Enabled())
+	assert.False(t, Limit{}.Enabled())
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/furkansenharputlu/f-license/lcs"
+	"github.com/furkansenharputlu/f-license/storage"
+
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+)
+
+// RenewLicense extends the expiry of the license by a duration or until a time and signs
+// it again, optionally keeping the old token valid until its expiry. Clients verifying
+// with the old token are told a new token is available. Licenses inactivated by an admin
+// or revoked are renewed only with force.
+func RenewLicense(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	var opts lcs.RenewOptions
+	if err := json.NewDecoder(r.Body).Decode(&opts); err != nil {
+		ReturnError(w, http.StatusBadRequest, "couldn't decode renewal: "+err.Error())
+		return
+	}
+
+	var l lcs.License
+	if err := storage.LicenseHandler.GetByID(r.Context(), id, &l); err != nil {
+		ReturnError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	expiresAt, err := opts.ExpiryOf(&l, time.Now())
+	if err != nil {
+		ReturnError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	err = storage.RenewLicense(r.Context(), storage.LicenseHandler, &l, expiresAt, opts.KeepOldToken, opts.Force)
+	switch err {
+	case nil:
+	case lcs.ErrOldTokenNotExpiring, lcs.ErrExpiryNotExtended:
+		ReturnError(w, http.StatusBadRequest, err.Error())
+		return
+	case lcs.ErrLicenseInactive, lcs.ErrLicenseRevoked, storage.ErrLicenseChanged:
+		ReturnError(w, http.StatusConflict, err.Error())
+		return
+	default:
+		logrus.WithError(err).Error("License couldn't be renewed")
+		ReturnError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	ReturnResponse(w, http.StatusOK, l)
+}
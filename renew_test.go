@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/furkansenharputlu/f-license/client"
+	"github.com/furkansenharputlu/f-license/lcs"
+	"github.com/furkansenharputlu/f-license/storage"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRenewLicense(t *testing.T) {
+	defer storage.LicenseHandler.DropDatabase(context.Background())
+
+	expiresAt := time.Now().Add(10 * 24 * time.Hour).Truncate(time.Second).UTC()
+	l := sampleLicense(func(l *lcs.License) {
+		l.Claims["exp"] = float64(expiresAt.Unix())
+	})
+
+	resp := tr.Run(t, &TestCase{Method: http.MethodPost, Path: "/admin/licenses", Data: l, BodyMatch: `"id":.*"token":"ey.*"`})
+	var created map[string]string
+	_ = json.NewDecoder(resp.Body).Decode(&created)
+
+	renewPath := fmt.Sprintf("/admin/licenses/%s/renew", created["id"])
+
+	renew := func(t *testing.T, opts lcs.RenewOptions) *lcs.License {
+		resp := tr.Run(t, &TestCase{Method: http.MethodPost, Path: renewPath, Data: opts, BodyMatch: `"token":"ey.*"`})
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var renewed lcs.License
+		_ = json.NewDecoder(resp.Body).Decode(&renewed)
+
+		return &renewed
+	}
+
+	verify := func(t *testing.T, token string) *client.Verification {
+		v, err := client.VerifyRemotelyWithResult(tr.server.URL, "", token)
+		assert.NoError(t, err)
+
+		return v
+	}
+
+	var renewedToken string
+	t.Run("by duration keeping the old token", func(t *testing.T) {
+		renewed := renew(t, lcs.RenewOptions{Duration: "30d", KeepOldToken: true})
+		assert.NotEqual(t, created["token"], renewed.Token)
+
+		newExpiresAt, _ := renewed.ExpiresAt()
+		assert.Equal(t, expiresAt.AddDate(0, 0, 30), *newExpiresAt)
+
+		if assert.Len(t, renewed.Renewals, 1) {
+			assert.Equal(t, expiresAt, *renewed.Renewals[0].PreviousExpiresAt)
+			assert.Equal(t, *newExpiresAt, renewed.Renewals[0].ExpiresAt)
+			assert.True(t, renewed.Renewals[0].KeptOldToken)
+		}
+
+		assert.Equal(t, &client.Verification{Valid: true, NewTokenAvailable: true}, verify(t, created["token"]))
+		assert.Equal(t, &client.Verification{Valid: true}, verify(t, renewed.Token))
+
+		renewedToken = renewed.Token
+	})
+
+	t.Run("until a date", func(t *testing.T) {
+		until := time.Now().AddDate(2, 0, 0).Format("2006-01-02")
+		renewed := renew(t, lcs.RenewOptions{ExpiresAt: until})
+
+		newExpiresAt, _ := renewed.ExpiresAt()
+		assert.Equal(t, until, newExpiresAt.Format("2006-01-02"))
+		assert.Len(t, renewed.Renewals, 2)
+
+		// The token replaced without being kept is not valid anymore
+		assert.Equal(t, &client.Verification{Valid: false, NewTokenAvailable: true}, verify(t, renewedToken))
+		assert.Equal(t, &client.Verification{Valid: true, NewTokenAvailable: true}, verify(t, created["token"]))
+		assert.Equal(t, &client.Verification{Valid: true}, verify(t, renewed.Token))
+
+		var stored lcs.License
+		assert.NoError(t, storage.LicenseHandler.GetByToken(context.Background(), renewedToken, &stored))
+		assert.Equal(t, renewed.Token, stored.Token)
+	})
+
+	t.Run("invalid requests", func(t *testing.T) {
+		tests := []struct {
+			opts    lcs.RenewOptions
+			message string
+		}{
+			{lcs.RenewOptions{}, "either duration or expires_at is required"},
+			{lcs.RenewOptions{Duration: "30d", ExpiresAt: "2030-01-01"}, "duration and expires_at can't be given together"},
+			{lcs.RenewOptions{Duration: "a month"}, `invalid duration: .*a month`},
+			{lcs.RenewOptions{Duration: "-1h"}, "duration must be positive"},
+			{lcs.RenewOptions{ExpiresAt: "2020-01-01"}, "expires_at must be in the future"},
+			{lcs.RenewOptions{ExpiresAt: "01/01/2030"}, `invalid time: .*01/01/2030`},
+			{lcs.RenewOptions{ExpiresAt: time.Now().AddDate(1, 0, 0).Format("2006-01-02")}, "new expiry must be later than the current expiry"},
+		}
+
+		for _, test := range tests {
+			resp := tr.Run(t, &TestCase{Method: http.MethodPost, Path: renewPath, Data: test.opts, BodyMatch: test.message})
+			assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+		}
+
+		// The old token of a license which doesn't expire would be valid forever
+		resp := tr.Run(t, &TestCase{Method: http.MethodPost, Path: "/admin/licenses", Data: sampleLicense(func(l *lcs.License) {
+			l.Claims["name"] = "Ahmet"
+		}), BodyMatch: `"id":.*"token":"ey.*"`})
+		var unlimited map[string]string
+		_ = json.NewDecoder(resp.Body).Decode(&unlimited)
+
+		resp = tr.Run(t, &TestCase{Method: http.MethodPost, Path: fmt.Sprintf("/admin/licenses/%s/renew", unlimited["id"]),
+			Data: lcs.RenewOptions{Duration: "30d", KeepOldToken: true}, BodyMatch: lcs.ErrOldTokenNotExpiring.Error()})
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	})
+
+	t.Run("inactivated and revoked licenses", func(t *testing.T) {
+		tr.Run(t, &TestCase{Method: http.MethodPut, Path: "/admin/licenses/" + created["id"] + "/inactivate", BodyMatch: `"message":"Inactivated"`})
+
+		resp := tr.Run(t, &TestCase{Method: http.MethodPost, Path: renewPath, Data: lcs.RenewOptions{Duration: "30d"}, BodyMatch: lcs.ErrLicenseInactive.Error()})
+		assert.Equal(t, http.StatusConflict, resp.StatusCode)
+		assert.True(t, renew(t, lcs.RenewOptions{Duration: "30d", Force: true}).Active)
+
+		assert.NoError(t, storage.LicenseHandler.Revoke(context.Background(), created["id"], time.Now()))
+
+		resp = tr.Run(t, &TestCase{Method: http.MethodPost, Path: renewPath, Data: lcs.RenewOptions{Duration: "30d"}, BodyMatch: lcs.ErrLicenseRevoked.Error()})
+		assert.Equal(t, http.StatusConflict, resp.StatusCode)
+
+		renewed := renew(t, lcs.RenewOptions{Duration: "30d", Force: true})
+		assert.True(t, renewed.Active)
+		assert.Nil(t, renewed.RevokedAt)
+	})
+
+	t.Run("concurrent changes are not lost", func(t *testing.T) {
+		ctx := context.Background()
+
+		var l lcs.License
+		assert.NoError(t, storage.LicenseHandler.GetByID(ctx, created["id"], &l))
+		token := l.Token
+
+		// The license is inactivated after it is read for the renewal
+		assert.NoError(t, storage.LicenseHandler.Activate(ctx, created["id"], true))
+		assert.Equal(t, storage.ErrLicenseChanged, storage.RenewLicense(ctx, storage.LicenseHandler, &l, time.Now().AddDate(5, 0, 0), false, true))
+
+		var stored lcs.License
+		assert.NoError(t, storage.LicenseHandler.GetByID(ctx, created["id"], &stored))
+		assert.False(t, stored.Active)
+		assert.Equal(t, token, stored.Token)
+	})
+}
@@ -0,0 +1,152 @@
+package scheduler
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Locker grants the locks of the jobs so that a job runs in only one of the processes
+// sharing the locker, e.g. the replicas of the server sharing a database.
+type Locker interface {
+	// TryLock locks the job for the holder until ttl passes. It reports false if another
+	// holder has the lock, and extends the lock if the holder already has it.
+	TryLock(ctx context.Context, job, holder string, ttl time.Duration) (bool, error)
+}
+
+// Job is a function run every interval.
+type Job struct {
+	Name     string
+	Interval func() time.Duration
+	Run      func(ctx context.Context) error
+}
+
+// Scheduler runs the jobs every interval in the process holding the lock of the job.
+// A lock is held for an interval and not released after the run, so that a job runs
+// once per interval however many schedulers share the locker.
+type Scheduler struct {
+	Locker Locker
+	// Holder identifies the scheduler in the locks.
+	Holder string
+	Jobs   []Job
+}
+
+func New(locker Locker, jobs ...Job) *Scheduler {
+	return &Scheduler{Locker: locker, Holder: NewHolder(), Jobs: jobs}
+}
+
+// NewHolder returns an ID unique to the process, the host name followed by random bytes.
+func NewHolder() string {
+	host, _ := os.Hostname()
+
+	b := make([]byte, 6)
+	_, _ = rand.Read(b)
+
+	return host + "-" + hex.EncodeToString(b)
+}
+
+// Run runs the jobs until the context is canceled.
+func (s *Scheduler) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, job := range s.Jobs {
+		wg.Add(1)
+		go func(job Job) {
+			defer wg.Done()
+			s.loop(ctx, job)
+		}(job)
+	}
+
+	wg.Wait()
+}
+
+func (s *Scheduler) loop(ctx context.Context, job Job) {
+	for {
+		// Interval is read every time so that configuration reloads change it
+		interval := job.Interval()
+		s.RunOnce(ctx, job, interval)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+// RunOnce runs the job if the lock of the job can be taken for the interval. It reports
+// whether the job is run.
+func (s *Scheduler) RunOnce(ctx context.Context, job Job, interval time.Duration) bool {
+	entry := logrus.WithField("job", job.Name)
+
+	locked, err := s.Locker.TryLock(ctx, job.Name, s.Holder, interval)
+	if err != nil {
+		if ctx.Err() == nil {
+			entry.WithError(err).Error("Couldn't lock job")
+		}
+		return false
+	}
+
+	if !locked {
+		entry.Debug("Job is locked by another process")
+		return false
+	}
+
+	// The lock is extended while the job runs, and the run is canceled if it is lost
+	runCtx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.keepLock(runCtx, cancel, job, interval)
+	}()
+
+	start := time.Now()
+	err = job.Run(runCtx)
+	cancel()
+	<-done
+
+	if err != nil && ctx.Err() == nil {
+		entry.WithError(err).Error("Job failed")
+		return true
+	}
+
+	entry.WithField("duration", time.Since(start)).Debug("Job completed")
+
+	return true
+}
+
+// keepLock extends the lock of the running job for the interval every third of the
+// interval until the context is canceled. It cancels the run if another holder takes the
+// lock, which happens only if the lock couldn't be extended before it expired.
+func (s *Scheduler) keepLock(ctx context.Context, cancel context.CancelFunc, job Job, interval time.Duration) {
+	entry := logrus.WithField("job", job.Name)
+
+	ticker := time.NewTicker(interval / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		locked, err := s.Locker.TryLock(ctx, job.Name, s.Holder, interval)
+		if err != nil {
+			if ctx.Err() == nil {
+				entry.WithError(err).Warn("Couldn't extend lock of job")
+			}
+			continue
+		}
+
+		if !locked {
+			entry.Warn("Lock of job is taken by another process, canceling the run")
+			cancel()
+			return
+		}
+	}
+}
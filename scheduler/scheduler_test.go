@@ -0,0 +1,163 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type lock struct {
+	holder    string
+	expiresAt time.Time
+}
+
+// memoryLocker is a Locker keeping the locks in memory.
+type memoryLocker struct {
+	mu    sync.Mutex
+	locks map[string]lock
+	err   error
+}
+
+func (l *memoryLocker) TryLock(ctx context.Context, job, holder string, ttl time.Duration) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.err != nil {
+		return false, l.err
+	}
+
+	now := time.Now()
+	if current, ok := l.locks[job]; ok && current.holder != holder && current.expiresAt.After(now) {
+		return false, nil
+	}
+
+	l.locks[job] = lock{holder: holder, expiresAt: now.Add(ttl)}
+
+	return true, nil
+}
+
+func TestScheduler_RunOnce(t *testing.T) {
+	locker := &memoryLocker{locks: map[string]lock{}}
+	replica1 := New(locker)
+	replica2 := New(locker)
+	assert.NotEqual(t, replica1.Holder, replica2.Holder)
+
+	runs := 0
+	job := Job{Name: "count", Run: func(ctx context.Context) error {
+		runs++
+		return nil
+	}}
+
+	ctx := context.Background()
+
+	assert.True(t, replica1.RunOnce(ctx, job, time.Hour))
+	assert.False(t, replica2.RunOnce(ctx, job, time.Hour))
+	// The holder extends its lock
+	assert.True(t, replica1.RunOnce(ctx, job, time.Hour))
+	assert.Equal(t, 2, runs)
+
+	// Expired locks are taken over
+	locker.locks["count"] = lock{holder: replica1.Holder, expiresAt: time.Now().Add(-time.Second)}
+	assert.True(t, replica2.RunOnce(ctx, job, time.Hour))
+	assert.False(t, replica1.RunOnce(ctx, job, time.Hour))
+	assert.Equal(t, 3, runs)
+
+	// Failures are only logged
+	failing := Job{Name: "fail", Run: func(ctx context.Context) error { return errors.New("failed") }}
+	assert.True(t, replica1.RunOnce(ctx, failing, time.Hour))
+
+	locker.err = errors.New("database is down")
+	assert.False(t, replica1.RunOnce(ctx, job, time.Hour))
+	assert.Equal(t, 3, runs)
+}
+
+func TestScheduler_Run(t *testing.T) {
+	locker := &memoryLocker{locks: map[string]lock{}}
+
+	var mu sync.Mutex
+	runs := map[string]int{}
+	job := func(name string) Job {
+		return Job{
+			Name:     name,
+			Interval: func() time.Duration { return 10 * time.Millisecond },
+			Run: func(ctx context.Context) error {
+				mu.Lock()
+				defer mu.Unlock()
+
+				runs[name]++
+				return nil
+			},
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	replicas := []*Scheduler{New(locker, job("a"), job("b")), New(locker, job("a"), job("b"))}
+
+	var wg sync.WaitGroup
+	for _, s := range replicas {
+		wg.Add(1)
+		go func(s *Scheduler) {
+			defer wg.Done()
+			s.Run(ctx)
+		}(s)
+	}
+	wg.Wait()
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	// Both jobs run about every interval, not twice as often
+	for _, name := range []string{"a", "b"} {
+		assert.True(t, runs[name] >= 3, "%s ran %d times", name, runs[name])
+		assert.True(t, runs[name] <= 12, "%s ran %d times", name, runs[name])
+	}
+}
+
+func TestScheduler_KeepLock(t *testing.T) {
+	locker := &memoryLocker{locks: map[string]lock{}}
+	replica1 := New(locker)
+	replica2 := New(locker)
+
+	interval := 30 * time.Millisecond
+	slow := Job{Name: "slow", Run: func(ctx context.Context) error {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(3 * interval):
+			return nil
+		}
+	}}
+
+	// The lock outlives the interval while the job runs
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		assert.True(t, replica1.RunOnce(context.Background(), slow, interval))
+	}()
+
+	time.Sleep(2 * interval)
+	assert.False(t, replica2.RunOnce(context.Background(), slow, interval))
+	wg.Wait()
+
+	// The run is canceled when another holder takes the lock
+	var runErr error
+	canceled := Job{Name: "canceled", Run: func(ctx context.Context) error {
+		locker.mu.Lock()
+		locker.locks["canceled"] = lock{holder: replica2.Holder, expiresAt: time.Now().Add(time.Hour)}
+		locker.mu.Unlock()
+
+		<-ctx.Done()
+		runErr = ctx.Err()
+		return runErr
+	}}
+
+	assert.True(t, replica1.RunOnce(context.Background(), canceled, interval))
+	assert.Equal(t, context.Canceled, runErr)
+}
@@ -0,0 +1,188 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/furkansenharputlu/f-license/config"
+	"github.com/furkansenharputlu/f-license/lcs"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrAppExists   = errors.New("app already exists")
+	ErrAppNotFound = lcs.ErrAppNotFound
+	// ErrAppInUse is returned when deleting an app which still has licenses.
+	ErrAppInUse = errors.New("app has licenses")
+)
+
+// deletingField marks an app while DeleteApp counts its licenses. Apps being deleted are
+// not found, so that no licenses are generated with them meanwhile.
+const deletingField = "deleting"
+
+func (h licenseMongoHandler) apps() *mongo.Collection {
+	return h.col.Database().Collection("apps")
+}
+
+// notDeleting excludes the apps being deleted from the filter.
+func notDeleting(filter bson.M) bson.M {
+	filter[deletingField] = bson.M{"$ne": true}
+	return filter
+}
+
+// licenseApps looks up the stored apps of licenses for lcs. Licenses use only the stored
+// apps of their own tenant.
+type licenseApps struct {
+	h Handler
+}
+
+func (a licenseApps) GetApp(ctx context.Context, tenant, name string) (*config.App, error) {
+	app, err := a.h.GetApp(WithTenant(ctx, tenant), name)
+	if err != nil {
+		return nil, err
+	}
+
+	// Lookups without a tenant aren't scoped, so apps of tenants are found too
+	if app.Tenant != tenant {
+		return nil, ErrAppNotFound
+	}
+
+	return app, nil
+}
+
+func (h licenseMongoHandler) CreateApp(ctx context.Context, app *config.App) error {
+	// Names of configured apps are taken, since the configured apps are used first
+	if _, ok := config.Get().Apps[app.Name]; ok {
+		return ErrAppExists
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, operationTimeout)
+	defer cancel()
+
+	assignTenant(ctx, &app.Tenant)
+
+	_, err := h.apps().InsertOne(ctx, app)
+	if isDuplicateKey(err) {
+		return ErrAppExists
+	}
+	if err != nil {
+		return fmt.Errorf("error while inserting app: %s", err)
+	}
+
+	return nil
+}
+
+func (h licenseMongoHandler) GetApp(ctx context.Context, name string) (*config.App, error) {
+	ctx, cancel := context.WithTimeout(ctx, operationTimeout)
+	defer cancel()
+
+	res := h.apps().FindOne(ctx, scoped(ctx, notDeleting(bson.M{"_id": name})))
+	if err := res.Err(); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrAppNotFound
+		}
+		return nil, fmt.Errorf("error while getting app: %s", err)
+	}
+
+	var app config.App
+	if err := res.Decode(&app); err != nil {
+		return nil, err
+	}
+
+	return &app, nil
+}
+
+func (h licenseMongoHandler) ListApps(ctx context.Context) ([]*config.App, error) {
+	ctx, cancel := context.WithTimeout(ctx, operationTimeout)
+	defer cancel()
+
+	cur, err := h.apps().Find(ctx, scoped(ctx, notDeleting(bson.M{})), options.Find().SetSort(bson.M{"_id": 1}))
+	if err != nil {
+		return nil, err
+	}
+
+	defer cur.Close(ctx)
+
+	apps := make([]*config.App, 0)
+	for cur.Next(ctx) {
+		var app config.App
+		if err := cur.Decode(&app); err != nil {
+			return nil, err
+		}
+
+		apps = append(apps, &app)
+	}
+
+	return apps, cur.Err()
+}
+
+func (h licenseMongoHandler) UpdateApp(ctx context.Context, app *config.App) error {
+	ctx, cancel := context.WithTimeout(ctx, operationTimeout)
+	defer cancel()
+
+	assignTenant(ctx, &app.Tenant)
+
+	res, err := h.apps().ReplaceOne(ctx, scoped(ctx, notDeleting(bson.M{"_id": app.Name})), app)
+	if err != nil {
+		return fmt.Errorf("error while updating app: %s", err)
+	}
+
+	if res.MatchedCount == 0 {
+		return ErrAppNotFound
+	}
+
+	return nil
+}
+
+// DeleteApp marks the app as being deleted before counting its licenses, so that no
+// license of it is generated between the count and the deletion.
+func (h licenseMongoHandler) DeleteApp(ctx context.Context, name string) error {
+	ctx, cancel := context.WithTimeout(ctx, operationTimeout)
+	defer cancel()
+
+	// Apps of other tenants are not found before their licenses are counted
+	res, err := h.apps().UpdateOne(ctx, scoped(ctx, notDeleting(bson.M{"_id": name})), bson.M{"$set": bson.M{deletingField: true}})
+	if err != nil {
+		return fmt.Errorf("error while deleting app: %s", err)
+	}
+
+	if res.MatchedCount == 0 {
+		return ErrAppNotFound
+	}
+
+	count, err := h.col.CountDocuments(ctx, bson.M{"headers.app": name}, options.Count().SetLimit(1))
+	if err == nil && count > 0 {
+		err = ErrAppInUse
+	}
+
+	if err != nil {
+		_, _ = h.apps().UpdateOne(ctx, bson.M{"_id": name}, bson.M{"$unset": bson.M{deletingField: ""}})
+		return err
+	}
+
+	_, err = h.apps().DeleteOne(ctx, bson.M{"_id": name, deletingField: true})
+	if err != nil {
+		return fmt.Errorf("error while deleting app: %s", err)
+	}
+
+	return nil
+}
+
+func isDuplicateKey(err error) bool {
+	writeErr, ok := err.(mongo.WriteException)
+	if !ok {
+		return false
+	}
+
+	for _, e := range writeErr.WriteErrors {
+		if e.Code == 11000 {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,99 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/furkansenharputlu/f-license/lcs"
+)
+
+// BatchResult is the outcome of generating one license of a batch.
+type BatchResult struct {
+	Index int    `json:"index"`
+	ID    string `json:"id,omitempty"`
+	Token string `json:"token,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// GenerateBatch generates and stores the licenses, reporting the outcome of each one.
+// Nil licenses fail with an error of their own, and duplicates are reported by the error
+// of AddIfNotExisting. If atomic is true, either all licenses are stored or none: the
+// stored ones are deleted again after the first failure.
+func GenerateBatch(ctx context.Context, h Handler, licenses []*lcs.License, atomic bool) (results []BatchResult, failed int) {
+	results = make([]BatchResult, len(licenses))
+	for i := range results {
+		results[i].Index = i
+	}
+
+	for i, l := range licenses {
+		if l == nil {
+			results[i].Error = "license is empty"
+			failed++
+			continue
+		}
+
+		if err := PrepareLicense(ctx, h, l); err != nil {
+			results[i].Error = err.Error()
+			failed++
+			continue
+		}
+
+		if err := l.Generate(); err != nil {
+			results[i].Error = err.Error()
+			failed++
+		}
+	}
+
+	if atomic && failed > 0 {
+		for i := range results {
+			if results[i].Error == "" {
+				results[i].Error = "not stored because of other failures in the batch"
+				failed++
+			}
+		}
+		return results, failed
+	}
+
+	var stored []int
+	for i, l := range licenses {
+		if results[i].Error != "" {
+			continue
+		}
+
+		if err := h.AddIfNotExisting(ctx, l); err != nil {
+			results[i].Error = err.Error()
+			failed++
+
+			if atomic {
+				return rollback(ctx, h, licenses, results, stored, i), len(licenses)
+			}
+			continue
+		}
+
+		results[i].ID = l.ID.Hex()
+		results[i].Token = l.Token
+		stored = append(stored, i)
+	}
+
+	return results, failed
+}
+
+// rollback deletes the stored licenses of an atomic batch which failed at index failedAt.
+func rollback(ctx context.Context, h Handler, licenses []*lcs.License, results []BatchResult, stored []int, failedAt int) []BatchResult {
+	for _, i := range stored {
+		if err := h.DeleteByID(ctx, licenses[i].ID.Hex()); err != nil {
+			results[i].Error = fmt.Sprintf("couldn't be rolled back: %s", err)
+			continue
+		}
+
+		results[i].ID = ""
+		results[i].Token = ""
+		results[i].Error = "rolled back because of other failures in the batch"
+	}
+
+	for i := failedAt + 1; i < len(results); i++ {
+		results[i].Error = "not stored because of other failures in the batch"
+	}
+
+	return results
+}
@@ -0,0 +1,134 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/furkansenharputlu/f-license/lcs"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	// ErrBillingEventExists is returned when the event of the payment provider is already
+	// processed, i.e. it is redelivered.
+	ErrBillingEventExists = errors.New("billing event already processed")
+	// ErrBillingEventProcessing is returned when another delivery of the event is being
+	// processed.
+	ErrBillingEventProcessing = errors.New("billing event is being processed")
+)
+
+// Statuses of billing events.
+const (
+	BillingEventProcessing = "processing"
+	BillingEventDone       = "done"
+)
+
+// BillingEvent records an event of the payment provider, so that redeliveries of the event
+// are not processed again. An event is processing until its lease expires, after which it
+// is taken to have failed and can be processed again.
+type BillingEvent struct {
+	ID         string     `json:"id" bson:"_id"`
+	Type       string     `json:"type" bson:"type"`
+	ReceivedAt time.Time  `json:"received_at" bson:"received_at"`
+	Status     string     `json:"status" bson:"status"`
+	LeaseUntil *time.Time `json:"lease_until,omitempty" bson:"lease_until,omitempty"`
+}
+
+func (h licenseMongoHandler) billingEvents() *mongo.Collection {
+	return h.col.Database().Collection("billing_events")
+}
+
+func (h licenseMongoHandler) ClaimBillingEvent(ctx context.Context, e *BillingEvent, lease time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, operationTimeout)
+	defer cancel()
+
+	now := time.Now().UTC()
+	leaseUntil := now.Add(lease)
+	filter := bson.M{"_id": e.ID, "status": BillingEventProcessing, "lease_until": bson.M{"$lte": now}}
+	update := bson.M{"$set": bson.M{"type": e.Type, "received_at": e.ReceivedAt, "status": BillingEventProcessing, "lease_until": leaseUntil}}
+
+	// The event is inserted if it doesn't exist, and the insert fails if it is done or
+	// its lease hasn't expired
+	_, err := h.billingEvents().UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+	if err == nil {
+		e.Status, e.LeaseUntil = BillingEventProcessing, &leaseUntil
+		return nil
+	}
+
+	if !isDuplicateKey(err) {
+		return fmt.Errorf("error while claiming billing event: %s", err)
+	}
+
+	var existing BillingEvent
+	if err := h.billingEvents().FindOne(ctx, bson.M{"_id": e.ID}).Decode(&existing); err != nil {
+		return fmt.Errorf("error while getting billing event: %s", err)
+	}
+
+	if existing.Status == BillingEventProcessing {
+		return ErrBillingEventProcessing
+	}
+
+	return ErrBillingEventExists
+}
+
+func (h licenseMongoHandler) CompleteBillingEvent(ctx context.Context, id string) error {
+	ctx, cancel := context.WithTimeout(ctx, operationTimeout)
+	defer cancel()
+
+	update := bson.M{"$set": bson.M{"status": BillingEventDone}, "$unset": bson.M{"lease_until": ""}}
+	_, err := h.billingEvents().UpdateOne(ctx, bson.M{"_id": id}, update)
+	if err != nil {
+		return fmt.Errorf("error while completing billing event: %s", err)
+	}
+
+	return nil
+}
+
+func (h licenseMongoHandler) DeleteBillingEvent(ctx context.Context, id string) error {
+	ctx, cancel := context.WithTimeout(ctx, operationTimeout)
+	defer cancel()
+
+	_, err := h.billingEvents().DeleteOne(ctx, bson.M{"_id": id})
+	if err != nil {
+		return fmt.Errorf("error while deleting billing event: %s", err)
+	}
+
+	return nil
+}
+
+func (h licenseMongoHandler) PruneBillingEvents(ctx context.Context, before time.Time) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, operationTimeout)
+	defer cancel()
+
+	res, err := h.billingEvents().DeleteMany(ctx, bson.M{"status": BillingEventDone, "received_at": bson.M{"$lt": before}})
+	if err != nil {
+		return 0, fmt.Errorf("error while pruning billing events: %s", err)
+	}
+
+	return res.DeletedCount, nil
+}
+
+func (h licenseMongoHandler) GetOrderByBillingID(ctx context.Context, billingID string) (*lcs.Order, error) {
+	ctx, cancel := context.WithTimeout(ctx, operationTimeout)
+	defer cancel()
+
+	res := h.orders().FindOne(ctx, scoped(ctx, bson.M{"billing_id": billingID}))
+	if err := res.Err(); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrOrderNotFound
+		}
+		return nil, fmt.Errorf("error while getting order: %s", err)
+	}
+
+	var o lcs.Order
+	if err := res.Decode(&o); err != nil {
+		return nil, err
+	}
+
+	return &o, nil
+}
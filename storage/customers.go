@@ -0,0 +1,287 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/furkansenharputlu/f-license/lcs"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrCustomerNotFound = errors.New("customer not found")
+	// ErrCustomerInUse is returned when deleting a customer which still has licenses or orders.
+	ErrCustomerInUse = errors.New("customer has licenses or orders")
+	ErrOrderNotFound = errors.New("order not found")
+	// ErrOrderMismatch is returned when a license references an order of another customer.
+	ErrOrderMismatch = errors.New("order belongs to another customer")
+)
+
+// CustomerFilter selects customers. Empty fields match every customer.
+type CustomerFilter struct {
+	Email     string
+	Company   string
+	BillingID string
+}
+
+func (f CustomerFilter) filter() bson.M {
+	filter := bson.M{}
+	if f.Email != "" {
+		filter["email"] = f.Email
+	}
+
+	if f.Company != "" {
+		filter["company"] = f.Company
+	}
+
+	if f.BillingID != "" {
+		filter["billing_id"] = f.BillingID
+	}
+
+	return filter
+}
+
+// PrepareLicense assigns the tenant of the license and checks the customer and the order
+// it references before it is generated. The customer of the order is used if the license
+// references only the order.
+func PrepareLicense(ctx context.Context, h Handler, l *lcs.License) error {
+	if err := AssignTenant(ctx, h, l); err != nil {
+		return err
+	}
+
+	// References are looked up in the tenant of the license
+	ctx = WithTenant(ctx, l.Tenant)
+
+	if l.OrderID != "" {
+		o, err := h.GetOrder(ctx, l.OrderID)
+		if err != nil {
+			return err
+		}
+
+		if l.CustomerID == "" {
+			l.CustomerID = o.CustomerID
+		}
+
+		if l.CustomerID != o.CustomerID {
+			return ErrOrderMismatch
+		}
+	}
+
+	if l.CustomerID != "" {
+		if _, err := h.GetCustomer(ctx, l.CustomerID); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (h licenseMongoHandler) customers() *mongo.Collection {
+	return h.col.Database().Collection("customers")
+}
+
+func (h licenseMongoHandler) orders() *mongo.Collection {
+	return h.col.Database().Collection("orders")
+}
+
+func (h licenseMongoHandler) CreateCustomer(ctx context.Context, c *lcs.Customer) error {
+	ctx, cancel := context.WithTimeout(ctx, operationTimeout)
+	defer cancel()
+
+	assignTenant(ctx, &c.Tenant)
+	c.ID = primitive.NewObjectID()
+	c.CreatedAt = time.Now().UTC().Truncate(time.Millisecond)
+
+	_, err := h.customers().InsertOne(ctx, c)
+	if err != nil {
+		return fmt.Errorf("error while inserting customer: %s", err)
+	}
+
+	return nil
+}
+
+func (h licenseMongoHandler) GetCustomer(ctx context.Context, id string) (*lcs.Customer, error) {
+	customerID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("ID format error: %s", err))
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, operationTimeout)
+	defer cancel()
+
+	res := h.customers().FindOne(ctx, scoped(ctx, bson.M{"_id": customerID}))
+	if err := res.Err(); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrCustomerNotFound
+		}
+		return nil, fmt.Errorf("error while getting customer: %s", err)
+	}
+
+	var c lcs.Customer
+	if err := res.Decode(&c); err != nil {
+		return nil, err
+	}
+
+	return &c, nil
+}
+
+func (h licenseMongoHandler) ListCustomers(ctx context.Context, f CustomerFilter) ([]*lcs.Customer, error) {
+	ctx, cancel := context.WithTimeout(ctx, operationTimeout)
+	defer cancel()
+
+	cur, err := h.customers().Find(ctx, scoped(ctx, f.filter()), options.Find().SetSort(bson.M{"_id": 1}))
+	if err != nil {
+		return nil, err
+	}
+
+	defer cur.Close(ctx)
+
+	customers := make([]*lcs.Customer, 0)
+	for cur.Next(ctx) {
+		var c lcs.Customer
+		if err := cur.Decode(&c); err != nil {
+			return nil, err
+		}
+
+		customers = append(customers, &c)
+	}
+
+	return customers, cur.Err()
+}
+
+// UpdateCustomer replaces the name, email, company and billing ID of the customer.
+func (h licenseMongoHandler) UpdateCustomer(ctx context.Context, c *lcs.Customer) error {
+	ctx, cancel := context.WithTimeout(ctx, operationTimeout)
+	defer cancel()
+
+	update := bson.M{"$set": bson.M{
+		"name":       c.Name,
+		"email":      c.Email,
+		"company":    c.Company,
+		"billing_id": c.BillingID,
+	}}
+
+	res := h.customers().FindOneAndUpdate(ctx, scoped(ctx, bson.M{"_id": c.ID}), update,
+		options.FindOneAndUpdate().SetReturnDocument(options.After))
+	if err := res.Err(); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return ErrCustomerNotFound
+		}
+		return fmt.Errorf("error while updating customer: %s", err)
+	}
+
+	return res.Decode(c)
+}
+
+func (h licenseMongoHandler) DeleteCustomer(ctx context.Context, id string) error {
+	c, err := h.GetCustomer(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, operationTimeout)
+	defer cancel()
+
+	licenses, err := h.col.CountDocuments(ctx, bson.M{"customer_id": id}, options.Count().SetLimit(1))
+	if err != nil {
+		return err
+	}
+
+	orders, err := h.orders().CountDocuments(ctx, bson.M{"customer_id": id}, options.Count().SetLimit(1))
+	if err != nil {
+		return err
+	}
+
+	if licenses > 0 || orders > 0 {
+		return ErrCustomerInUse
+	}
+
+	_, err = h.customers().DeleteOne(ctx, bson.M{"_id": c.ID})
+	if err != nil {
+		return fmt.Errorf("error while deleting customer: %s", err)
+	}
+
+	return nil
+}
+
+// CreateOrder returns ErrCustomerNotFound if the customer of the order doesn't exist.
+func (h licenseMongoHandler) CreateOrder(ctx context.Context, o *lcs.Order) error {
+	if _, err := h.GetCustomer(ctx, o.CustomerID); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, operationTimeout)
+	defer cancel()
+
+	assignTenant(ctx, &o.Tenant)
+	o.ID = primitive.NewObjectID()
+	o.CreatedAt = time.Now().UTC().Truncate(time.Millisecond)
+
+	_, err := h.orders().InsertOne(ctx, o)
+	if err != nil {
+		return fmt.Errorf("error while inserting order: %s", err)
+	}
+
+	return nil
+}
+
+func (h licenseMongoHandler) GetOrder(ctx context.Context, id string) (*lcs.Order, error) {
+	orderID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("ID format error: %s", err))
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, operationTimeout)
+	defer cancel()
+
+	res := h.orders().FindOne(ctx, scoped(ctx, bson.M{"_id": orderID}))
+	if err := res.Err(); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrOrderNotFound
+		}
+		return nil, fmt.Errorf("error while getting order: %s", err)
+	}
+
+	var o lcs.Order
+	if err := res.Decode(&o); err != nil {
+		return nil, err
+	}
+
+	return &o, nil
+}
+
+// ListOrders returns the orders of the customer, or every order if customerID is empty.
+func (h licenseMongoHandler) ListOrders(ctx context.Context, customerID string) ([]*lcs.Order, error) {
+	ctx, cancel := context.WithTimeout(ctx, operationTimeout)
+	defer cancel()
+
+	filter := bson.M{}
+	if customerID != "" {
+		filter["customer_id"] = customerID
+	}
+
+	cur, err := h.orders().Find(ctx, scoped(ctx, filter), options.Find().SetSort(bson.M{"_id": 1}))
+	if err != nil {
+		return nil, err
+	}
+
+	defer cur.Close(ctx)
+
+	orders := make([]*lcs.Order, 0)
+	for cur.Next(ctx) {
+		var o lcs.Order
+		if err := cur.Decode(&o); err != nil {
+			return nil, err
+		}
+
+		orders = append(orders, &o)
+	}
+
+	return orders, cur.Err()
+}
@@ -0,0 +1,100 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/furkansenharputlu/f-license/config"
+	"github.com/furkansenharputlu/f-license/lcs"
+	"github.com/furkansenharputlu/f-license/notify"
+
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func (h licenseMongoHandler) emails() *mongo.Collection {
+	return h.col.Database().Collection("emails")
+}
+
+// PublishEvent puts the event of the license to the webhook outbox, and the email to the
+// customer of the license about it to the email outbox. A failure is only logged as the
+// license is already changed.
+func PublishEvent(ctx context.Context, h Handler, event string, l *lcs.License) {
+	if err := Publish(ctx, h, event, l); err != nil {
+		logrus.WithError(err).WithField("event", event).Error("Webhook event couldn't be published")
+	}
+
+	if err := QueueEmail(ctx, h, event, l); err != nil {
+		logrus.WithError(err).WithFields(logrus.Fields{"event": event, "license": l.ID.Hex()}).Error("Email couldn't be queued")
+	}
+}
+
+// QueueEmail puts the email to the customer of the license about the event to the outbox
+// of emails, if emails are enabled and the customer is emailed about the event. The emails
+// are sent by a notify.Outbox.
+func QueueEmail(ctx context.Context, h Handler, event string, l *lcs.License) error {
+	kind := notify.KindOf(event)
+	if kind == "" || !config.Get().Email.Enable || l.CustomerID == "" {
+		return nil
+	}
+
+	return h.CreateEmail(ctx, notify.NewEmail(kind, l))
+}
+
+func (h licenseMongoHandler) CreateEmail(ctx context.Context, e *notify.Email) error {
+	ctx, cancel := context.WithTimeout(ctx, operationTimeout)
+	defer cancel()
+
+	_, err := h.emails().InsertOne(ctx, e)
+	if err != nil {
+		return fmt.Errorf("error while inserting email: %s", err)
+	}
+
+	return nil
+}
+
+// ClaimEmails takes the due emails one by one so that an email is claimed by only one of
+// the outboxes sharing the database.
+func (h licenseMongoHandler) ClaimEmails(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]*notify.Email, error) {
+	ctx, cancel := context.WithTimeout(ctx, operationTimeout)
+	defer cancel()
+
+	filter := bson.M{"status": notify.StatusPending, "next_attempt_at": bson.M{"$lte": now}}
+	update := bson.M{"$set": bson.M{"next_attempt_at": now.Add(lease)}}
+	opts := options.FindOneAndUpdate().SetSort(bson.M{"next_attempt_at": 1}).SetReturnDocument(options.After)
+
+	emails := make([]*notify.Email, 0)
+	for len(emails) < limit {
+		res := h.emails().FindOneAndUpdate(ctx, filter, update, opts)
+		if err := res.Err(); err != nil {
+			if err == mongo.ErrNoDocuments {
+				break
+			}
+			return emails, fmt.Errorf("error while claiming email: %s", err)
+		}
+
+		var e notify.Email
+		if err := res.Decode(&e); err != nil {
+			return emails, err
+		}
+
+		emails = append(emails, &e)
+	}
+
+	return emails, nil
+}
+
+func (h licenseMongoHandler) UpdateEmail(ctx context.Context, e *notify.Email) error {
+	ctx, cancel := context.WithTimeout(ctx, operationTimeout)
+	defer cancel()
+
+	_, err := h.emails().ReplaceOne(ctx, bson.M{"_id": e.ID}, e)
+	if err != nil {
+		return fmt.Errorf("error while updating email: %s", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,136 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/furkansenharputlu/f-license/lcs"
+	"github.com/furkansenharputlu/f-license/notify"
+	"github.com/furkansenharputlu/f-license/webhook"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func (h licenseMongoHandler) locks() *mongo.Collection {
+	return h.col.Database().Collection("locks")
+}
+
+func (h licenseMongoHandler) TryLock(ctx context.Context, job, holder string, ttl time.Duration) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, operationTimeout)
+	defer cancel()
+
+	now := time.Now()
+	filter := bson.M{"_id": job, "$or": []bson.M{{"holder": holder}, {"expires_at": bson.M{"$lte": now}}}}
+	update := bson.M{"$set": bson.M{"holder": holder, "expires_at": now.Add(ttl)}}
+
+	// The lock is inserted if it doesn't exist, and the insert fails if another holder has it
+	_, err := h.locks().UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+	if err != nil {
+		if isDuplicateKey(err) {
+			return false, nil
+		}
+
+		return false, fmt.Errorf("error while locking job: %s", err)
+	}
+
+	return true, nil
+}
+
+func (h licenseMongoHandler) ExpireLicenses(ctx context.Context, now time.Time, limit int) ([]*lcs.License, error) {
+	filter := scoped(ctx, bson.M{"active": true, "claims.exp": bson.M{"$lte": now.Unix()}})
+	update := bson.M{"$set": bson.M{"active": false, "expired_at": now}}
+
+	return h.claimLicenses(ctx, filter, update, limit)
+}
+
+func (h licenseMongoHandler) ClaimReminders(ctx context.Context, now time.Time, days, limit int) ([]*lcs.License, error) {
+	filter := scoped(ctx, bson.M{
+		"active":     true,
+		"claims.exp": bson.M{"$gt": now.Unix(), "$lte": now.Add(time.Duration(days) * 24 * time.Hour).Unix()},
+		"$or": []bson.M{
+			{"reminded_days": bson.M{"$exists": false}},
+			{"reminded_days": bson.M{"$gt": days}},
+		},
+	})
+	update := bson.M{"$set": bson.M{"reminded_days": days}}
+
+	return h.claimLicenses(ctx, filter, update, limit)
+}
+
+// claimLicenses updates up to limit licenses matching the filter one by one, so that a
+// license is returned to only one of the concurrent callers.
+func (h licenseMongoHandler) claimLicenses(ctx context.Context, filter, update bson.M, limit int) ([]*lcs.License, error) {
+	ctx, cancel := context.WithTimeout(ctx, operationTimeout)
+	defer cancel()
+
+	opts := options.FindOneAndUpdate().SetSort(bson.M{"_id": 1}).SetReturnDocument(options.After)
+
+	licenses := make([]*lcs.License, 0)
+	for len(licenses) < limit {
+		res := h.col.FindOneAndUpdate(ctx, filter, update, opts)
+		if err := res.Err(); err != nil {
+			if err == mongo.ErrNoDocuments {
+				break
+			}
+			return licenses, fmt.Errorf("error while updating license: %s", err)
+		}
+
+		var l lcs.License
+		if err := res.Decode(&l); err != nil {
+			return licenses, err
+		}
+
+		licenses = append(licenses, &l)
+	}
+
+	return licenses, nil
+}
+
+func (h licenseMongoHandler) PruneDeliveries(ctx context.Context, before time.Time) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, operationTimeout)
+	defer cancel()
+
+	filter := scoped(ctx, bson.M{
+		"status":     bson.M{"$in": []string{webhook.StatusDelivered, webhook.StatusDead}},
+		"created_at": bson.M{"$lt": before},
+	})
+
+	res, err := h.deliveries().DeleteMany(ctx, filter)
+	if err != nil {
+		return 0, fmt.Errorf("error while pruning deliveries: %s", err)
+	}
+
+	return res.DeletedCount, nil
+}
+
+func (h licenseMongoHandler) PruneEmails(ctx context.Context, before time.Time) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, operationTimeout)
+	defer cancel()
+
+	filter := scoped(ctx, bson.M{
+		"status":     bson.M{"$in": []string{notify.StatusSent, notify.StatusDead}},
+		"created_at": bson.M{"$lt": before},
+	})
+
+	res, err := h.emails().DeleteMany(ctx, filter)
+	if err != nil {
+		return 0, fmt.Errorf("error while pruning emails: %s", err)
+	}
+
+	return res.DeletedCount, nil
+}
+
+func (h licenseMongoHandler) ReclaimLeases(ctx context.Context, now time.Time) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, operationTimeout)
+	defer cancel()
+
+	res, err := h.leases().DeleteMany(ctx, bson.M{"expires_at": bson.M{"$lte": now}})
+	if err != nil {
+		return 0, fmt.Errorf("error while reclaiming leases: %s", err)
+	}
+
+	return res.DeletedCount, nil
+}
@@ -0,0 +1,113 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/furkansenharputlu/f-license/lcs"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrSeatsExceeded is returned by AcquireLease if every seat of the license is leased.
+var ErrSeatsExceeded = errors.New("all seats of the license are leased")
+
+// ErrLeaseNotFound is returned by ReleaseLease if the instance has no lease.
+var ErrLeaseNotFound = errors.New("lease not found")
+
+// Lease is a seat of a floating license used by an instance until it expires. Instances
+// extend their leases before they expire, and expired leases are taken by other instances.
+type Lease struct {
+	ID        string    `bson:"_id" json:"-"`
+	LicenseID string    `bson:"license_id" json:"license_id"`
+	Seat      int       `bson:"seat" json:"seat"`
+	Instance  string    `bson:"instance" json:"instance"`
+	ExpiresAt time.Time `bson:"expires_at" json:"expires_at"`
+}
+
+func (h licenseMongoHandler) leases() *mongo.Collection {
+	return h.col.Database().Collection("leases")
+}
+
+func (h licenseMongoHandler) AcquireLease(ctx context.Context, l *lcs.License, instance string, ttl time.Duration) (*Lease, error) {
+	ctx, cancel := context.WithTimeout(ctx, operationTimeout)
+	defer cancel()
+
+	now := time.Now()
+	licenseID := l.ID.Hex()
+
+	// The instance keeps its seat if its lease hasn't expired yet
+	filter := bson.M{"license_id": licenseID, "instance": instance, "expires_at": bson.M{"$gt": now}}
+	update := bson.M{"$set": bson.M{"expires_at": now.Add(ttl)}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var lease Lease
+	err := h.leases().FindOneAndUpdate(ctx, filter, update, opts).Decode(&lease)
+	if err == nil {
+		return &lease, nil
+	}
+
+	if err != mongo.ErrNoDocuments {
+		return nil, fmt.Errorf("error while extending lease: %s", err)
+	}
+
+	// Every seat is a document which is inserted if it doesn't exist, and the insert fails
+	// if another instance leases it, like the locks of jobs
+	for seat := 0; seat < l.Seats; seat++ {
+		lease = Lease{
+			ID:        fmt.Sprintf("%s/%d", licenseID, seat),
+			LicenseID: licenseID,
+			Seat:      seat,
+			Instance:  instance,
+			ExpiresAt: now.Add(ttl),
+		}
+
+		filter := bson.M{"_id": lease.ID, "$or": []bson.M{{"instance": instance}, {"expires_at": bson.M{"$lte": now}}}}
+		update := bson.M{"$set": bson.M{"license_id": licenseID, "seat": seat, "instance": instance, "expires_at": lease.ExpiresAt}}
+
+		_, err := h.leases().UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+		if err != nil {
+			if isDuplicateKey(err) {
+				continue
+			}
+
+			return nil, fmt.Errorf("error while leasing seat: %s", err)
+		}
+
+		return &lease, nil
+	}
+
+	return nil, ErrSeatsExceeded
+}
+
+func (h licenseMongoHandler) ReleaseLease(ctx context.Context, licenseID, instance string) error {
+	ctx, cancel := context.WithTimeout(ctx, operationTimeout)
+	defer cancel()
+
+	res, err := h.leases().DeleteMany(ctx, bson.M{"license_id": licenseID, "instance": instance})
+	if err != nil {
+		return fmt.Errorf("error while releasing lease: %s", err)
+	}
+
+	if res.DeletedCount == 0 {
+		return ErrLeaseNotFound
+	}
+
+	return nil
+}
+
+func (h licenseMongoHandler) CountLeases(ctx context.Context, now time.Time) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, operationTimeout)
+	defer cancel()
+
+	count, err := h.leases().CountDocuments(ctx, bson.M{"expires_at": bson.M{"$gt": now}})
+	if err != nil {
+		return 0, fmt.Errorf("error while counting leases: %s", err)
+	}
+
+	return count, nil
+}
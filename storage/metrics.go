@@ -0,0 +1,355 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/furkansenharputlu/f-license/config"
+	"github.com/furkansenharputlu/f-license/lcs"
+	"github.com/furkansenharputlu/f-license/notify"
+	"github.com/furkansenharputlu/f-license/webhook"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	operationDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "f_license_storage_operation_duration_seconds",
+		Help: "Duration of storage operations.",
+	}, []string{"operation"})
+	operationErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "f_license_storage_operation_errors_total",
+		Help: "Number of failed storage operations.",
+	}, []string{"operation"})
+	licensesRenewed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "f_license_licenses_renewed_total",
+		Help: "Number of renewed licenses by app.",
+	}, []string{"app"})
+)
+
+// instrumentedHandler records latency and errors of the wrapped handler's operations.
+type instrumentedHandler struct {
+	h Handler
+}
+
+// Instrument wraps the handler to expose its operation metrics.
+func Instrument(h Handler) Handler {
+	return instrumentedHandler{h}
+}
+
+func observe(operation string, start time.Time, err error) {
+	operationDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+	if err != nil {
+		operationErrors.WithLabelValues(operation).Inc()
+	}
+}
+
+func (i instrumentedHandler) AddIfNotExisting(ctx context.Context, l *lcs.License) (err error) {
+	defer func(start time.Time) { observe("add_if_not_existing", start, err) }(time.Now())
+	return i.h.AddIfNotExisting(ctx, l)
+}
+
+func (i instrumentedHandler) CreateApp(ctx context.Context, app *config.App) (err error) {
+	defer func(start time.Time) { observe("create_app", start, err) }(time.Now())
+	return i.h.CreateApp(ctx, app)
+}
+
+func (i instrumentedHandler) GetApp(ctx context.Context, name string) (app *config.App, err error) {
+	defer func(start time.Time) { observe("get_app", start, err) }(time.Now())
+	return i.h.GetApp(ctx, name)
+}
+
+func (i instrumentedHandler) ListApps(ctx context.Context) (apps []*config.App, err error) {
+	defer func(start time.Time) { observe("list_apps", start, err) }(time.Now())
+	return i.h.ListApps(ctx)
+}
+
+func (i instrumentedHandler) UpdateApp(ctx context.Context, app *config.App) (err error) {
+	defer func(start time.Time) { observe("update_app", start, err) }(time.Now())
+	return i.h.UpdateApp(ctx, app)
+}
+
+func (i instrumentedHandler) DeleteApp(ctx context.Context, name string) (err error) {
+	defer func(start time.Time) { observe("delete_app", start, err) }(time.Now())
+	return i.h.DeleteApp(ctx, name)
+}
+
+func (i instrumentedHandler) Activate(ctx context.Context, id string, inactivate bool) (err error) {
+	defer func(start time.Time) { observe("activate", start, err) }(time.Now())
+	return i.h.Activate(ctx, id, inactivate)
+}
+
+func (i instrumentedHandler) Revoke(ctx context.Context, id string, now time.Time) (err error) {
+	defer func(start time.Time) { observe("revoke", start, err) }(time.Now())
+	return i.h.Revoke(ctx, id, now)
+}
+
+func (i instrumentedHandler) GetByID(ctx context.Context, id string, l *lcs.License) (err error) {
+	defer func(start time.Time) { observe("get_by_id", start, err) }(time.Now())
+	return i.h.GetByID(ctx, id, l)
+}
+
+func (i instrumentedHandler) GetAll(ctx context.Context, licenses *[]*lcs.License) (err error) {
+	defer func(start time.Time) { observe("get_all", start, err) }(time.Now())
+	return i.h.GetAll(ctx, licenses)
+}
+
+func (i instrumentedHandler) List(ctx context.Context, opts ListOptions, licenses *[]*lcs.License) (total int64, err error) {
+	defer func(start time.Time) { observe("list", start, err) }(time.Now())
+	return i.h.List(ctx, opts, licenses)
+}
+
+func (i instrumentedHandler) Count(ctx context.Context, opts ListOptions) (total int64, err error) {
+	defer func(start time.Time) { observe("count", start, err) }(time.Now())
+	return i.h.Count(ctx, opts)
+}
+
+func (i instrumentedHandler) GetByToken(ctx context.Context, token string, l *lcs.License) (err error) {
+	defer func(start time.Time) { observe("get_by_token", start, err) }(time.Now())
+	return i.h.GetByToken(ctx, token, l)
+}
+
+func (i instrumentedHandler) DeleteByID(ctx context.Context, id string) (err error) {
+	defer func(start time.Time) { observe("delete_by_id", start, err) }(time.Now())
+	return i.h.DeleteByID(ctx, id)
+}
+
+func (i instrumentedHandler) Iterate(ctx context.Context, fn func(l *lcs.License) error) (err error) {
+	defer func(start time.Time) { observe("iterate", start, err) }(time.Now())
+	return i.h.Iterate(ctx, fn)
+}
+
+func (i instrumentedHandler) Insert(ctx context.Context, l *lcs.License) (err error) {
+	defer func(start time.Time) { observe("insert", start, err) }(time.Now())
+	return i.h.Insert(ctx, l)
+}
+
+func (i instrumentedHandler) Replace(ctx context.Context, l *lcs.License) (err error) {
+	defer func(start time.Time) { observe("replace", start, err) }(time.Now())
+	return i.h.Replace(ctx, l)
+}
+
+func (i instrumentedHandler) ReplaceUnchanged(ctx context.Context, l, previous *lcs.License) (err error) {
+	defer func(start time.Time) { observe("replace_unchanged", start, err) }(time.Now())
+	return i.h.ReplaceUnchanged(ctx, l, previous)
+}
+
+func (i instrumentedHandler) AcquireLease(ctx context.Context, l *lcs.License, instance string, ttl time.Duration) (lease *Lease, err error) {
+	defer func(start time.Time) { observe("acquire_lease", start, err) }(time.Now())
+	return i.h.AcquireLease(ctx, l, instance, ttl)
+}
+
+func (i instrumentedHandler) ReleaseLease(ctx context.Context, licenseID, instance string) (err error) {
+	defer func(start time.Time) { observe("release_lease", start, err) }(time.Now())
+	return i.h.ReleaseLease(ctx, licenseID, instance)
+}
+
+func (i instrumentedHandler) CountLeases(ctx context.Context, now time.Time) (count int64, err error) {
+	defer func(start time.Time) { observe("count_leases", start, err) }(time.Now())
+	return i.h.CountLeases(ctx, now)
+}
+
+func (i instrumentedHandler) DropDatabase(ctx context.Context) (err error) {
+	defer func(start time.Time) { observe("drop_database", start, err) }(time.Now())
+	return i.h.DropDatabase(ctx)
+}
+
+func (i instrumentedHandler) Ping(ctx context.Context) (err error) {
+	defer func(start time.Time) { observe("ping", start, err) }(time.Now())
+	return i.h.Ping(ctx)
+}
+
+func (i instrumentedHandler) Close(ctx context.Context) error {
+	return i.h.Close(ctx)
+}
+
+func (i instrumentedHandler) CreateTenant(ctx context.Context, t *Tenant) (err error) {
+	defer func(start time.Time) { observe("create_tenant", start, err) }(time.Now())
+	return i.h.CreateTenant(ctx, t)
+}
+
+func (i instrumentedHandler) GetTenant(ctx context.Context, id string) (t *Tenant, err error) {
+	defer func(start time.Time) { observe("get_tenant", start, err) }(time.Now())
+	return i.h.GetTenant(ctx, id)
+}
+
+func (i instrumentedHandler) ListTenants(ctx context.Context) (tenants []*Tenant, err error) {
+	defer func(start time.Time) { observe("list_tenants", start, err) }(time.Now())
+	return i.h.ListTenants(ctx)
+}
+
+func (i instrumentedHandler) CreateAPIKey(ctx context.Context, k *APIKey) (err error) {
+	defer func(start time.Time) { observe("create_api_key", start, err) }(time.Now())
+	return i.h.CreateAPIKey(ctx, k)
+}
+
+func (i instrumentedHandler) GetAPIKeyByHash(ctx context.Context, hash string) (k *APIKey, err error) {
+	defer func(start time.Time) { observe("get_api_key_by_hash", start, err) }(time.Now())
+	return i.h.GetAPIKeyByHash(ctx, hash)
+}
+
+func (i instrumentedHandler) ListAPIKeys(ctx context.Context) (keys []*APIKey, err error) {
+	defer func(start time.Time) { observe("list_api_keys", start, err) }(time.Now())
+	return i.h.ListAPIKeys(ctx)
+}
+
+func (i instrumentedHandler) DeleteAPIKey(ctx context.Context, id string) (err error) {
+	defer func(start time.Time) { observe("delete_api_key", start, err) }(time.Now())
+	return i.h.DeleteAPIKey(ctx, id)
+}
+
+func (i instrumentedHandler) CreateCustomer(ctx context.Context, c *lcs.Customer) (err error) {
+	defer func(start time.Time) { observe("create_customer", start, err) }(time.Now())
+	return i.h.CreateCustomer(ctx, c)
+}
+
+func (i instrumentedHandler) GetCustomer(ctx context.Context, id string) (c *lcs.Customer, err error) {
+	defer func(start time.Time) { observe("get_customer", start, err) }(time.Now())
+	return i.h.GetCustomer(ctx, id)
+}
+
+func (i instrumentedHandler) ListCustomers(ctx context.Context, f CustomerFilter) (customers []*lcs.Customer, err error) {
+	defer func(start time.Time) { observe("list_customers", start, err) }(time.Now())
+	return i.h.ListCustomers(ctx, f)
+}
+
+func (i instrumentedHandler) UpdateCustomer(ctx context.Context, c *lcs.Customer) (err error) {
+	defer func(start time.Time) { observe("update_customer", start, err) }(time.Now())
+	return i.h.UpdateCustomer(ctx, c)
+}
+
+func (i instrumentedHandler) DeleteCustomer(ctx context.Context, id string) (err error) {
+	defer func(start time.Time) { observe("delete_customer", start, err) }(time.Now())
+	return i.h.DeleteCustomer(ctx, id)
+}
+
+func (i instrumentedHandler) CreateOrder(ctx context.Context, o *lcs.Order) (err error) {
+	defer func(start time.Time) { observe("create_order", start, err) }(time.Now())
+	return i.h.CreateOrder(ctx, o)
+}
+
+func (i instrumentedHandler) GetOrder(ctx context.Context, id string) (o *lcs.Order, err error) {
+	defer func(start time.Time) { observe("get_order", start, err) }(time.Now())
+	return i.h.GetOrder(ctx, id)
+}
+
+func (i instrumentedHandler) ListOrders(ctx context.Context, customerID string) (orders []*lcs.Order, err error) {
+	defer func(start time.Time) { observe("list_orders", start, err) }(time.Now())
+	return i.h.ListOrders(ctx, customerID)
+}
+
+func (i instrumentedHandler) CreateWebhook(ctx context.Context, s *webhook.Subscription) (err error) {
+	defer func(start time.Time) { observe("create_webhook", start, err) }(time.Now())
+	return i.h.CreateWebhook(ctx, s)
+}
+
+func (i instrumentedHandler) GetWebhook(ctx context.Context, id string) (s *webhook.Subscription, err error) {
+	defer func(start time.Time) { observe("get_webhook", start, err) }(time.Now())
+	return i.h.GetWebhook(ctx, id)
+}
+
+func (i instrumentedHandler) ListWebhooks(ctx context.Context) (subs []*webhook.Subscription, err error) {
+	defer func(start time.Time) { observe("list_webhooks", start, err) }(time.Now())
+	return i.h.ListWebhooks(ctx)
+}
+
+func (i instrumentedHandler) DeleteWebhook(ctx context.Context, id string) (err error) {
+	defer func(start time.Time) { observe("delete_webhook", start, err) }(time.Now())
+	return i.h.DeleteWebhook(ctx, id)
+}
+
+func (i instrumentedHandler) CreateDeliveries(ctx context.Context, deliveries []*webhook.Delivery) (err error) {
+	defer func(start time.Time) { observe("create_deliveries", start, err) }(time.Now())
+	return i.h.CreateDeliveries(ctx, deliveries)
+}
+
+func (i instrumentedHandler) GetDelivery(ctx context.Context, id string) (d *webhook.Delivery, err error) {
+	defer func(start time.Time) { observe("get_delivery", start, err) }(time.Now())
+	return i.h.GetDelivery(ctx, id)
+}
+
+func (i instrumentedHandler) ListDeliveries(ctx context.Context, f DeliveryFilter) (deliveries []*webhook.Delivery, err error) {
+	defer func(start time.Time) { observe("list_deliveries", start, err) }(time.Now())
+	return i.h.ListDeliveries(ctx, f)
+}
+
+func (i instrumentedHandler) ClaimDeliveries(ctx context.Context, now time.Time, lease time.Duration, limit int) (deliveries []*webhook.Delivery, err error) {
+	defer func(start time.Time) { observe("claim_deliveries", start, err) }(time.Now())
+	return i.h.ClaimDeliveries(ctx, now, lease, limit)
+}
+
+func (i instrumentedHandler) UpdateDelivery(ctx context.Context, d *webhook.Delivery) (err error) {
+	defer func(start time.Time) { observe("update_delivery", start, err) }(time.Now())
+	return i.h.UpdateDelivery(ctx, d)
+}
+
+func (i instrumentedHandler) GetOrderByBillingID(ctx context.Context, billingID string) (o *lcs.Order, err error) {
+	defer func(start time.Time) { observe("get_order_by_billing_id", start, err) }(time.Now())
+	return i.h.GetOrderByBillingID(ctx, billingID)
+}
+
+func (i instrumentedHandler) ClaimBillingEvent(ctx context.Context, e *BillingEvent, lease time.Duration) (err error) {
+	defer func(start time.Time) { observe("claim_billing_event", start, err) }(time.Now())
+	return i.h.ClaimBillingEvent(ctx, e, lease)
+}
+
+func (i instrumentedHandler) CompleteBillingEvent(ctx context.Context, id string) (err error) {
+	defer func(start time.Time) { observe("complete_billing_event", start, err) }(time.Now())
+	return i.h.CompleteBillingEvent(ctx, id)
+}
+
+func (i instrumentedHandler) DeleteBillingEvent(ctx context.Context, id string) (err error) {
+	defer func(start time.Time) { observe("delete_billing_event", start, err) }(time.Now())
+	return i.h.DeleteBillingEvent(ctx, id)
+}
+
+func (i instrumentedHandler) PruneBillingEvents(ctx context.Context, before time.Time) (n int64, err error) {
+	defer func(start time.Time) { observe("prune_billing_events", start, err) }(time.Now())
+	return i.h.PruneBillingEvents(ctx, before)
+}
+
+func (i instrumentedHandler) PruneDeliveries(ctx context.Context, before time.Time) (n int64, err error) {
+	defer func(start time.Time) { observe("prune_deliveries", start, err) }(time.Now())
+	return i.h.PruneDeliveries(ctx, before)
+}
+
+func (i instrumentedHandler) CreateEmail(ctx context.Context, e *notify.Email) (err error) {
+	defer func(start time.Time) { observe("create_email", start, err) }(time.Now())
+	return i.h.CreateEmail(ctx, e)
+}
+
+func (i instrumentedHandler) ClaimEmails(ctx context.Context, now time.Time, lease time.Duration, limit int) (emails []*notify.Email, err error) {
+	defer func(start time.Time) { observe("claim_emails", start, err) }(time.Now())
+	return i.h.ClaimEmails(ctx, now, lease, limit)
+}
+
+func (i instrumentedHandler) UpdateEmail(ctx context.Context, e *notify.Email) (err error) {
+	defer func(start time.Time) { observe("update_email", start, err) }(time.Now())
+	return i.h.UpdateEmail(ctx, e)
+}
+
+func (i instrumentedHandler) PruneEmails(ctx context.Context, before time.Time) (n int64, err error) {
+	defer func(start time.Time) { observe("prune_emails", start, err) }(time.Now())
+	return i.h.PruneEmails(ctx, before)
+}
+
+func (i instrumentedHandler) ReclaimLeases(ctx context.Context, now time.Time) (n int64, err error) {
+	defer func(start time.Time) { observe("reclaim_leases", start, err) }(time.Now())
+	return i.h.ReclaimLeases(ctx, now)
+}
+
+func (i instrumentedHandler) TryLock(ctx context.Context, job, holder string, ttl time.Duration) (locked bool, err error) {
+	defer func(start time.Time) { observe("try_lock", start, err) }(time.Now())
+	return i.h.TryLock(ctx, job, holder, ttl)
+}
+
+func (i instrumentedHandler) ExpireLicenses(ctx context.Context, now time.Time, limit int) (licenses []*lcs.License, err error) {
+	defer func(start time.Time) { observe("expire_licenses", start, err) }(time.Now())
+	return i.h.ExpireLicenses(ctx, now, limit)
+}
+
+func (i instrumentedHandler) ClaimReminders(ctx context.Context, now time.Time, days, limit int) (licenses []*lcs.License, err error) {
+	defer func(start time.Time) { observe("claim_reminders", start, err) }(time.Now())
+	return i.h.ClaimReminders(ctx, now, days, limit)
+}
@@ -0,0 +1,33 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/furkansenharputlu/f-license/lcs"
+	"github.com/furkansenharputlu/f-license/webhook"
+)
+
+// RenewLicense extends the license until expiresAt and signs it again, and stores it if it
+// isn't changed since it was read. The renewed event is published and the renewal is
+// counted in the metrics. See lcs.License.CheckRenewal for the licenses which can be
+// renewed.
+func RenewLicense(ctx context.Context, h Handler, l *lcs.License, expiresAt time.Time, keepOldToken, force bool) error {
+	if err := l.CheckRenewal(expiresAt, force); err != nil {
+		return err
+	}
+
+	previous := *l
+	if err := l.Renew(expiresAt, keepOldToken); err != nil {
+		return err
+	}
+
+	if err := h.ReplaceUnchanged(ctx, l, &previous); err != nil {
+		return err
+	}
+
+	licensesRenewed.WithLabelValues(l.GetAppName()).Inc()
+	PublishEvent(ctx, h, webhook.EventRenewed, l)
+
+	return nil
+}
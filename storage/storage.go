@@ -4,11 +4,12 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"hash/fnv"
 	"time"
 
 	"github.com/furkansenharputlu/f-license/config"
 	"github.com/furkansenharputlu/f-license/lcs"
+	"github.com/furkansenharputlu/f-license/notify"
+	"github.com/furkansenharputlu/f-license/webhook"
 
 	"github.com/sirupsen/logrus"
 	"go.mongodb.org/mongo-driver/bson"
@@ -17,24 +18,222 @@ import (
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+// Handler stores licenses, their leases, apps, tenants, customers, webhooks, billing events
+// and job locks. Every method takes a context, usually derived from the HTTP request, so
+// that canceled requests and deadlines stop the database work. Operations on licenses,
+// apps, API keys, customers and webhooks are limited to the tenant of the context, see
+// WithTenant.
 type Handler interface {
-	AddIfNotExisting(l *lcs.License) error
-	Activate(id string, inactivate bool) error
-	GetByID(id string, l *lcs.License) error
-	GetAll(licenses *[]*lcs.License) error
-	GetByToken(token string, l *lcs.License) error
-	DeleteByID(id string) error
-	DropDatabase() error
+	AddIfNotExisting(ctx context.Context, l *lcs.License) error
+	Activate(ctx context.Context, id string, inactivate bool) error
+	// Revoke inactivates the license and records it as revoked, so that it is not renewed.
+	Revoke(ctx context.Context, id string, now time.Time) error
+	GetByID(ctx context.Context, id string, l *lcs.License) error
+	GetAll(ctx context.Context, licenses *[]*lcs.License) error
+	List(ctx context.Context, opts ListOptions, licenses *[]*lcs.License) (total int64, err error)
+	Count(ctx context.Context, opts ListOptions) (int64, error)
+	// GetByToken also finds the licenses by their previous tokens replaced by renewals.
+	GetByToken(ctx context.Context, token string, l *lcs.License) error
+	DeleteByID(ctx context.Context, id string) error
+	// Iterate calls fn for every license in creation order until fn returns an error.
+	Iterate(ctx context.Context, fn func(l *lcs.License) error) error
+	// Insert stores the license keeping its ID and hash. It returns ErrLicenseExists
+	// if there is a license with the same ID or hash.
+	Insert(ctx context.Context, l *lcs.License) error
+	// Replace stores the license keeping its ID and hash, replacing the licenses
+	// having the same ID or hash.
+	Replace(ctx context.Context, l *lcs.License) error
+	// ReplaceUnchanged stores the license if its token, activeness and revocation are still
+	// as in the previous version of it. It returns ErrLicenseChanged otherwise.
+	ReplaceUnchanged(ctx context.Context, l, previous *lcs.License) error
+	// AcquireLease leases a seat of the floating license to the instance for ttl, or extends
+	// the lease of the instance. It returns ErrSeatsExceeded if other instances lease
+	// every seat.
+	AcquireLease(ctx context.Context, l *lcs.License, instance string, ttl time.Duration) (*Lease, error)
+	// ReleaseLease returns ErrLeaseNotFound if the instance has no lease of the license.
+	ReleaseLease(ctx context.Context, licenseID, instance string) error
+	// CountLeases returns the number of leases not expired at now.
+	CountLeases(ctx context.Context, now time.Time) (int64, error)
+	// CreateApp stores an app managed at runtime. It returns ErrAppExists if there is a
+	// stored or configured app with the same name.
+	CreateApp(ctx context.Context, app *config.App) error
+	// GetApp returns ErrAppNotFound if there is no stored app with the name. Apps of the
+	// configuration are not stored.
+	GetApp(ctx context.Context, name string) (*config.App, error)
+	ListApps(ctx context.Context) ([]*config.App, error)
+	UpdateApp(ctx context.Context, app *config.App) error
+	// DeleteApp returns ErrAppInUse if there are licenses of the app.
+	DeleteApp(ctx context.Context, name string) error
+	// CreateTenant returns ErrTenantExists if there is a tenant with the same ID.
+	CreateTenant(ctx context.Context, t *Tenant) error
+	// GetTenant returns ErrTenantNotFound if there is no tenant with the ID.
+	GetTenant(ctx context.Context, id string) (*Tenant, error)
+	ListTenants(ctx context.Context) ([]*Tenant, error)
+	// CreateAPIKey returns ErrTenantNotFound if the tenant of the key doesn't exist.
+	CreateAPIKey(ctx context.Context, k *APIKey) error
+	// GetAPIKeyByHash finds the key of any tenant to authenticate a request. It returns
+	// ErrAPIKeyNotFound if there is no key with the hash.
+	GetAPIKeyByHash(ctx context.Context, hash string) (*APIKey, error)
+	ListAPIKeys(ctx context.Context) ([]*APIKey, error)
+	DeleteAPIKey(ctx context.Context, id string) error
+	CreateCustomer(ctx context.Context, c *lcs.Customer) error
+	// GetCustomer returns ErrCustomerNotFound if there is no customer with the ID.
+	GetCustomer(ctx context.Context, id string) (*lcs.Customer, error)
+	ListCustomers(ctx context.Context, f CustomerFilter) ([]*lcs.Customer, error)
+	UpdateCustomer(ctx context.Context, c *lcs.Customer) error
+	// DeleteCustomer returns ErrCustomerInUse if there are licenses or orders of the customer.
+	DeleteCustomer(ctx context.Context, id string) error
+	CreateOrder(ctx context.Context, o *lcs.Order) error
+	// GetOrder returns ErrOrderNotFound if there is no order with the ID.
+	GetOrder(ctx context.Context, id string) (*lcs.Order, error)
+	ListOrders(ctx context.Context, customerID string) ([]*lcs.Order, error)
+	// GetOrderByBillingID returns ErrOrderNotFound if there is no order with the billing ID.
+	GetOrderByBillingID(ctx context.Context, billingID string) (*lcs.Order, error)
+	// ClaimBillingEvent records the event as processing for the lease. It returns
+	// ErrBillingEventExists if the event is done, and ErrBillingEventProcessing if the
+	// lease of another delivery of it hasn't expired.
+	ClaimBillingEvent(ctx context.Context, e *BillingEvent, lease time.Duration) error
+	// CompleteBillingEvent records the event as done.
+	CompleteBillingEvent(ctx context.Context, id string) error
+	DeleteBillingEvent(ctx context.Context, id string) error
+	// PruneBillingEvents deletes the done events received before the time.
+	PruneBillingEvents(ctx context.Context, before time.Time) (int64, error)
+	CreateWebhook(ctx context.Context, s *webhook.Subscription) error
+	// GetWebhook returns webhook.ErrWebhookNotFound if there is no webhook with the ID.
+	GetWebhook(ctx context.Context, id string) (*webhook.Subscription, error)
+	ListWebhooks(ctx context.Context) ([]*webhook.Subscription, error)
+	DeleteWebhook(ctx context.Context, id string) error
+	// CreateDeliveries puts the deliveries to the outbox of webhooks.
+	CreateDeliveries(ctx context.Context, deliveries []*webhook.Delivery) error
+	// GetDelivery returns ErrDeliveryNotFound if there is no delivery with the ID.
+	GetDelivery(ctx context.Context, id string) (*webhook.Delivery, error)
+	ListDeliveries(ctx context.Context, f DeliveryFilter) ([]*webhook.Delivery, error)
+	// ClaimDeliveries implements webhook.Store.
+	ClaimDeliveries(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]*webhook.Delivery, error)
+	UpdateDelivery(ctx context.Context, d *webhook.Delivery) error
+	// PruneDeliveries deletes the delivered and dead deliveries created before the time.
+	PruneDeliveries(ctx context.Context, before time.Time) (int64, error)
+	// PruneEmails deletes the sent and dead emails created before the time.
+	PruneEmails(ctx context.Context, before time.Time) (int64, error)
+	// ReclaimLeases deletes the leases expired at now, freeing their seats.
+	ReclaimLeases(ctx context.Context, now time.Time) (int64, error)
+	// CreateEmail puts the email to the outbox of emails.
+	CreateEmail(ctx context.Context, e *notify.Email) error
+	// ClaimEmails implements notify.Store.
+	ClaimEmails(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]*notify.Email, error)
+	UpdateEmail(ctx context.Context, e *notify.Email) error
+	// TryLock implements scheduler.Locker.
+	TryLock(ctx context.Context, job, holder string, ttl time.Duration) (bool, error)
+	// ExpireLicenses inactivates up to limit active licenses expired at now, and returns them.
+	ExpireLicenses(ctx context.Context, now time.Time, limit int) ([]*lcs.License, error)
+	// ClaimReminders returns up to limit active licenses expiring in the days after now
+	// whose customers are not reminded yet at that many days or fewer, and marks them as
+	// reminded at the days.
+	ClaimReminders(ctx context.Context, now time.Time, days, limit int) ([]*lcs.License, error)
+	DropDatabase(ctx context.Context) error
+	Ping(ctx context.Context) error
+	// Close closes the connections of the storage.
+	Close(ctx context.Context) error
 }
 
 var LicenseHandler Handler
 
+var ErrLicenseExists = errors.New("license already exists")
+
+// ErrLicenseNotFound is returned by GetByToken if there is no license with the token.
+var ErrLicenseNotFound = errors.New("license not found")
+
+// ErrLicenseChanged is returned when the license is changed by another request while it is
+// being updated.
+var ErrLicenseChanged = errors.New("license is changed meanwhile, try again")
+
+// ListOptions filters licenses and selects a page of them ordered by creation.
+// Empty filters match every license and zero Limit means no limit. AfterID selects the
+// licenses created after the one with the given ID, for paging without offsets.
+type ListOptions struct {
+	App        string
+	Typ        string
+	Active     *bool
+	Claims     map[string]string
+	CustomerID string
+	OrderID    string
+	AfterID    string
+	Offset     int64
+	Limit      int64
+}
+
+func (o ListOptions) filter() (bson.M, error) {
+	filter := bson.M{}
+	if o.AfterID != "" {
+		afterID, err := primitive.ObjectIDFromHex(o.AfterID)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("ID format error: %s", err))
+		}
+		filter["_id"] = bson.M{"$gt": afterID}
+	}
+
+	if o.App != "" {
+		filter["headers.app"] = o.App
+	}
+
+	if o.Typ != "" {
+		filter["headers.typ"] = o.Typ
+	}
+
+	if o.Active != nil {
+		filter["active"] = *o.Active
+	}
+
+	for k, v := range o.Claims {
+		filter["claims."+k] = v
+	}
+
+	if o.CustomerID != "" {
+		filter["customer_id"] = o.CustomerID
+	}
+
+	if o.OrderID != "" {
+		filter["order_id"] = o.OrderID
+	}
+
+	return filter, nil
+}
+
+const (
+	connectTimeout = 10 * time.Second
+	// operationTimeout bounds a single database operation in addition to the passed context.
+	operationTimeout = 5 * time.Second
+)
+
+// Connect sets LicenseHandler to the storage configured in the global config.
 func Connect() {
-	ctx, _ := context.WithTimeout(context.Background(), 10*time.Second)
-	MongoClient, err := mongo.Connect(ctx, options.Client().ApplyURI(config.Global.MongoURL))
+	var err error
+	LicenseHandler, err = Open(config.Get())
 	fatalf("Problem while connecting to Mongo: %s", err)
 
-	LicenseHandler = licenseMongoHandler{MongoClient.Database(config.Global.DBName).Collection("licenses")}
+	lcs.Apps = licenseApps{LicenseHandler}
+}
+
+// Open returns a handler of the storage configured in c.
+func Open(c *config.Config) (Handler, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), connectTimeout)
+	defer cancel()
+
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(c.MongoURL))
+	if err != nil {
+		return nil, err
+	}
+
+	return Instrument(licenseMongoHandler{client.Database(c.DBName).Collection("licenses")}), nil
+}
+
+// Disconnect closes the connections of LicenseHandler.
+func Disconnect(ctx context.Context) error {
+	if LicenseHandler == nil {
+		return nil
+	}
+
+	return LicenseHandler.Close(ctx)
 }
 
 func fatalf(format string, err error) {
@@ -47,10 +246,13 @@ type licenseMongoHandler struct {
 	col *mongo.Collection
 }
 
-func (h licenseMongoHandler) AddIfNotExisting(l *lcs.License) error {
-	ctx, _ := context.WithTimeout(context.Background(), 5*time.Second)
+func (h licenseMongoHandler) AddIfNotExisting(ctx context.Context, l *lcs.License) error {
+	ctx, cancel := context.WithTimeout(ctx, operationTimeout)
+	defer cancel()
+
+	assignTenant(ctx, &l.Tenant)
 
-	filter := bson.M{"hash": l.Hash}
+	filter := scoped(ctx, bson.M{"hash": l.Hash})
 	res := h.col.FindOne(ctx, filter)
 	err := res.Err()
 	if err != nil {
@@ -74,15 +276,25 @@ func (h licenseMongoHandler) AddIfNotExisting(l *lcs.License) error {
 	return nil
 }
 
-func (h licenseMongoHandler) Activate(id string, inactivate bool) error {
+func (h licenseMongoHandler) Activate(ctx context.Context, id string, inactivate bool) error {
 	licenseID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
 		return errors.New(fmt.Sprintf("ID format error: %s", err))
 	}
 
-	filter := bson.M{"_id": bson.M{"$eq": licenseID}}
+	ctx, cancel := context.WithTimeout(ctx, operationTimeout)
+	defer cancel()
+
+	filter := scoped(ctx, bson.M{"_id": bson.M{"$eq": licenseID}})
 	update := bson.M{"$set": bson.M{"active": !inactivate}}
-	res, err := h.col.UpdateOne(context.Background(), filter, update)
+	if !inactivate {
+		update["$unset"] = bson.M{"expired_at": "", "revoked_at": ""}
+	}
+	res, err := h.col.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return errors.New("license cannot be updated")
+	}
+
 	if res.MatchedCount == 0 {
 		return errors.New("there is no matching license")
 	}
@@ -95,10 +307,6 @@ func (h licenseMongoHandler) Activate(id string, inactivate bool) error {
 		}
 	}
 
-	if err != nil {
-		return errors.New("license cannot be updated")
-	}
-
 	if inactivate {
 		logrus.Infof(`License is successfully inactivated: %s`, id)
 	} else {
@@ -108,36 +316,63 @@ func (h licenseMongoHandler) Activate(id string, inactivate bool) error {
 	return nil
 }
 
-func (h licenseMongoHandler) DeleteByID(id string) error {
+func (h licenseMongoHandler) Revoke(ctx context.Context, id string, now time.Time) error {
 	licenseID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
 		return errors.New(fmt.Sprintf("ID format error: %s", err))
 	}
 
-	ctx, _ := context.WithTimeout(context.Background(), 5*time.Second)
-	filter := bson.M{"_id": licenseID}
-	res, err := h.col.DeleteOne(ctx, filter)
-	if res.DeletedCount == 0 {
-		return errors.New(fmt.Sprintf("there is no license with ID: %s", id))
+	ctx, cancel := context.WithTimeout(ctx, operationTimeout)
+	defer cancel()
+
+	update := bson.M{"$set": bson.M{"active": false, "revoked_at": now}}
+	res, err := h.col.UpdateOne(ctx, scoped(ctx, bson.M{"_id": licenseID}), update)
+	if err != nil {
+		return fmt.Errorf("error while revoking license: %s", err)
+	}
+
+	if res.MatchedCount == 0 {
+		return ErrLicenseNotFound
 	}
 
+	return nil
+}
+
+func (h licenseMongoHandler) DeleteByID(ctx context.Context, id string) error {
+	licenseID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return errors.New(fmt.Sprintf("ID format error: %s", err))
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, operationTimeout)
+	defer cancel()
+
+	filter := scoped(ctx, bson.M{"_id": licenseID})
+	res, err := h.col.DeleteOne(ctx, filter)
 	if err != nil {
 		return errors.New("license cannot be deleted")
 	}
 
+	if res.DeletedCount == 0 {
+		return errors.New(fmt.Sprintf("there is no license with ID: %s", id))
+	}
+
 	logrus.Info("License successfully deleted")
 
 	return nil
 }
 
-func (h licenseMongoHandler) GetByID(id string, l *lcs.License) error {
+func (h licenseMongoHandler) GetByID(ctx context.Context, id string, l *lcs.License) error {
 	licenseID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
 		return errors.New(fmt.Sprintf("ID format error: %s", err))
 	}
 
-	filter := bson.M{"_id": licenseID}
-	res := h.col.FindOne(context.Background(), filter)
+	ctx, cancel := context.WithTimeout(ctx, operationTimeout)
+	defer cancel()
+
+	filter := scoped(ctx, bson.M{"_id": licenseID})
+	res := h.col.FindOne(ctx, filter)
 	err = res.Err()
 	if err != nil {
 		return err
@@ -148,15 +383,15 @@ func (h licenseMongoHandler) GetByID(id string, l *lcs.License) error {
 	return nil
 }
 
-func (h licenseMongoHandler) GetAll(licenses *[]*lcs.License) error {
-	cur, err := h.col.Find(context.Background(), bson.D{})
+func (h licenseMongoHandler) GetAll(ctx context.Context, licenses *[]*lcs.License) error {
+	cur, err := h.col.Find(ctx, scoped(ctx, bson.M{}))
 	if err != nil {
 		return err
 	}
 
-	defer cur.Close(context.Background())
+	defer cur.Close(ctx)
 
-	for cur.Next(context.Background()) {
+	for cur.Next(ctx) {
 
 		var l lcs.License
 		err := cur.Decode(&l)
@@ -171,19 +406,66 @@ func (h licenseMongoHandler) GetAll(licenses *[]*lcs.License) error {
 	return cur.Err()
 }
 
-func (h licenseMongoHandler) GetByToken(token string, l *lcs.License) error {
-	h64 := fnv.New64a()
-	h64.Write([]byte(token))
-	hash := h64.Sum64()
-	hashStr := fmt.Sprintf("%v", hash)
+func (h licenseMongoHandler) List(ctx context.Context, opts ListOptions, licenses *[]*lcs.License) (int64, error) {
+	filter, err := opts.filter()
+	if err != nil {
+		return 0, err
+	}
+	filter = scoped(ctx, filter)
+
+	total, err := h.col.CountDocuments(ctx, filter)
+	if err != nil {
+		return 0, err
+	}
+
+	findOpts := options.Find().SetSort(bson.M{"_id": 1}).SetSkip(opts.Offset)
+	if opts.Limit > 0 {
+		findOpts.SetLimit(opts.Limit)
+	}
+
+	cur, err := h.col.Find(ctx, filter, findOpts)
+	if err != nil {
+		return 0, err
+	}
+
+	defer cur.Close(ctx)
+
+	for cur.Next(ctx) {
+		var l lcs.License
+		err := cur.Decode(&l)
+		if err != nil {
+			return 0, err
+		}
+
+		*licenses = append(*licenses, &l)
+	}
+
+	return total, cur.Err()
+}
+
+func (h licenseMongoHandler) Count(ctx context.Context, opts ListOptions) (int64, error) {
+	filter, err := opts.filter()
+	if err != nil {
+		return 0, err
+	}
+	filter = scoped(ctx, filter)
+
+	return h.col.CountDocuments(ctx, filter)
+}
+
+func (h licenseMongoHandler) GetByToken(ctx context.Context, token string, l *lcs.License) error {
+	hash := lcs.HashToken(token)
+
+	ctx, cancel := context.WithTimeout(ctx, operationTimeout)
+	defer cancel()
 
-	ctx, _ := context.WithTimeout(context.Background(), 5*time.Second)
-	filter := bson.M{"hash": hashStr}
+	// Tokens replaced by renewals still find their license
+	filter := scoped(ctx, bson.M{"$or": []bson.M{{"hash": hash}, {"previous_tokens.hash": hash}}})
 	res := h.col.FindOne(ctx, filter)
 	err := res.Err()
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
-			return fmt.Errorf("license not found")
+			return ErrLicenseNotFound
 		}
 		return fmt.Errorf("error while getting license: %s", err)
 	}
@@ -193,6 +475,104 @@ func (h licenseMongoHandler) GetByToken(token string, l *lcs.License) error {
 	return nil
 }
 
-func (h licenseMongoHandler) DropDatabase() error {
-	return h.col.Database().Drop(context.Background())
+func (h licenseMongoHandler) Iterate(ctx context.Context, fn func(l *lcs.License) error) error {
+	cur, err := h.col.Find(ctx, scoped(ctx, bson.M{}), options.Find().SetSort(bson.M{"_id": 1}))
+	if err != nil {
+		return err
+	}
+
+	defer cur.Close(ctx)
+
+	for cur.Next(ctx) {
+		var l lcs.License
+		err := cur.Decode(&l)
+		if err != nil {
+			return err
+		}
+
+		err = fn(&l)
+		if err != nil {
+			return err
+		}
+	}
+
+	return cur.Err()
+}
+
+func (h licenseMongoHandler) Insert(ctx context.Context, l *lcs.License) error {
+	ctx, cancel := context.WithTimeout(ctx, operationTimeout)
+	defer cancel()
+
+	assignTenant(ctx, &l.Tenant)
+
+	filter := bson.M{"$or": []bson.M{{"_id": l.ID}, {"hash": l.Hash}}}
+	count, err := h.col.CountDocuments(ctx, filter)
+	if err != nil {
+		return err
+	}
+
+	if count > 0 {
+		return ErrLicenseExists
+	}
+
+	_, err = h.col.InsertOne(ctx, l)
+	if err != nil {
+		return errors.New(fmt.Sprintf("error while inserting license: %s", err))
+	}
+
+	return nil
+}
+
+func (h licenseMongoHandler) Replace(ctx context.Context, l *lcs.License) error {
+	ctx, cancel := context.WithTimeout(ctx, operationTimeout)
+	defer cancel()
+
+	assignTenant(ctx, &l.Tenant)
+
+	_, err := h.col.DeleteMany(ctx, scoped(ctx, bson.M{"hash": l.Hash, "_id": bson.M{"$ne": l.ID}}))
+	if err != nil {
+		return err
+	}
+
+	_, err = h.col.ReplaceOne(ctx, scoped(ctx, bson.M{"_id": l.ID}), l, options.Replace().SetUpsert(true))
+	if err != nil {
+		return errors.New(fmt.Sprintf("error while replacing license: %s", err))
+	}
+
+	return nil
+}
+
+func (h licenseMongoHandler) ReplaceUnchanged(ctx context.Context, l, previous *lcs.License) error {
+	ctx, cancel := context.WithTimeout(ctx, operationTimeout)
+	defer cancel()
+
+	filter := scoped(ctx, bson.M{
+		"_id":        l.ID,
+		"hash":       previous.Hash,
+		"active":     previous.Active,
+		"revoked_at": bson.M{"$exists": previous.RevokedAt != nil},
+	})
+
+	res, err := h.col.ReplaceOne(ctx, filter, l)
+	if err != nil {
+		return fmt.Errorf("error while replacing license: %s", err)
+	}
+
+	if res.MatchedCount == 0 {
+		return ErrLicenseChanged
+	}
+
+	return nil
+}
+
+func (h licenseMongoHandler) DropDatabase(ctx context.Context) error {
+	return h.col.Database().Drop(ctx)
+}
+
+func (h licenseMongoHandler) Ping(ctx context.Context) error {
+	return h.col.Database().Client().Ping(ctx, nil)
+}
+
+func (h licenseMongoHandler) Close(ctx context.Context) error {
+	return h.col.Database().Client().Disconnect(ctx)
 }
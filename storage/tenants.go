@@ -0,0 +1,304 @@
+package storage
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/furkansenharputlu/f-license/config"
+	"github.com/furkansenharputlu/f-license/lcs"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrTenantExists   = errors.New("tenant already exists")
+	ErrTenantNotFound = errors.New("tenant not found")
+	ErrAPIKeyNotFound = errors.New("api key not found")
+)
+
+// Tenant is an organisation, e.g. a business unit or a reseller, owning licenses, apps
+// and API keys.
+type Tenant struct {
+	ID        string    `json:"id" bson:"_id"`
+	Name      string    `json:"name" bson:"name"`
+	CreatedAt time.Time `json:"created_at" bson:"created_at"`
+}
+
+var tenantIDPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`)
+
+func (t *Tenant) Validate() error {
+	if !tenantIDPattern.MatchString(t.ID) {
+		return fmt.Errorf("invalid tenant: id must consist of lower case letters, digits and dashes: %q", t.ID)
+	}
+
+	return nil
+}
+
+// APIKey authenticates the admin API requests of a tenant. Only the hash of the key is
+// stored, and the key itself is returned once when it is created.
+type APIKey struct {
+	ID        string    `json:"id" bson:"_id"`
+	Tenant    string    `json:"tenant" bson:"tenant"`
+	Name      string    `json:"name" bson:"name"`
+	Role      string    `json:"role" bson:"role"`
+	Hash      string    `json:"-" bson:"hash"`
+	CreatedAt time.Time `json:"created_at" bson:"created_at"`
+	// Key is set only when the key is created.
+	Key string `json:"key,omitempty" bson:"-"`
+}
+
+// NewAPIKey generates a key of the tenant with the given role, admin by default.
+func NewAPIKey(tenant, name, role string) (*APIKey, error) {
+	if tenant == "" {
+		return nil, errors.New("tenant is required")
+	}
+
+	switch role {
+	case "":
+		role = config.RoleAdmin
+	case config.RoleAdmin, config.RoleReadOnly:
+	default:
+		return nil, fmt.Errorf("unknown role: %s", role)
+	}
+
+	id := make([]byte, 8)
+	secret := make([]byte, 32)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+
+	k := &APIKey{
+		ID:        hex.EncodeToString(id),
+		Tenant:    tenant,
+		Name:      name,
+		Role:      role,
+		CreatedAt: time.Now().UTC().Truncate(time.Millisecond),
+	}
+	k.Key = k.ID + "." + base64.RawURLEncoding.EncodeToString(secret)
+	k.Hash = HashAPIKey(k.Key)
+
+	return k, nil
+}
+
+// HashAPIKey returns the hash API keys are stored and looked up with.
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+type tenantContextKey struct{}
+
+// WithTenant scopes the storage operations done with the returned context to the tenant:
+// only the licenses, apps and API keys of the tenant are found, and the stored ones are
+// assigned to it. An empty tenant removes the scope, as for the super-admin.
+func WithTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantContextKey{}, tenant)
+}
+
+// TenantOf returns the tenant the context is scoped to, or empty if it is not scoped.
+func TenantOf(ctx context.Context) string {
+	tenant, _ := ctx.Value(tenantContextKey{}).(string)
+	return tenant
+}
+
+// scoped adds the tenant of the context to the filter.
+func scoped(ctx context.Context, filter bson.M) bson.M {
+	if tenant := TenantOf(ctx); tenant != "" {
+		filter["tenant"] = tenant
+	}
+
+	return filter
+}
+
+// assignTenant sets the owner of a stored document to the tenant of the context, keeping
+// the given one for the super-admin.
+func assignTenant(ctx context.Context, owner *string) {
+	if tenant := TenantOf(ctx); tenant != "" {
+		*owner = tenant
+	}
+}
+
+// AssignTenant sets the tenant of the license before it is generated. A tenant can only use
+// its own stored apps and the apps of the configuration, and the tenant given by the
+// super-admin must exist.
+func AssignTenant(ctx context.Context, h Handler, l *lcs.License) error {
+	assignTenant(ctx, &l.Tenant)
+	if l.Tenant == "" {
+		return nil
+	}
+
+	if TenantOf(ctx) == "" {
+		if _, err := h.GetTenant(ctx, l.Tenant); err != nil {
+			return err
+		}
+	}
+
+	app, err := h.GetApp(WithTenant(ctx, ""), l.GetAppName())
+	if err == ErrAppNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if app.Tenant != "" && app.Tenant != l.Tenant {
+		return ErrAppNotFound
+	}
+
+	return nil
+}
+
+func (h licenseMongoHandler) tenants() *mongo.Collection {
+	return h.col.Database().Collection("tenants")
+}
+
+func (h licenseMongoHandler) apiKeys() *mongo.Collection {
+	return h.col.Database().Collection("api_keys")
+}
+
+func (h licenseMongoHandler) CreateTenant(ctx context.Context, t *Tenant) error {
+	ctx, cancel := context.WithTimeout(ctx, operationTimeout)
+	defer cancel()
+
+	_, err := h.tenants().InsertOne(ctx, t)
+	if isDuplicateKey(err) {
+		return ErrTenantExists
+	}
+	if err != nil {
+		return fmt.Errorf("error while inserting tenant: %s", err)
+	}
+
+	return nil
+}
+
+func (h licenseMongoHandler) GetTenant(ctx context.Context, id string) (*Tenant, error) {
+	ctx, cancel := context.WithTimeout(ctx, operationTimeout)
+	defer cancel()
+
+	res := h.tenants().FindOne(ctx, bson.M{"_id": id})
+	if err := res.Err(); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrTenantNotFound
+		}
+		return nil, fmt.Errorf("error while getting tenant: %s", err)
+	}
+
+	var t Tenant
+	if err := res.Decode(&t); err != nil {
+		return nil, err
+	}
+
+	return &t, nil
+}
+
+func (h licenseMongoHandler) ListTenants(ctx context.Context) ([]*Tenant, error) {
+	ctx, cancel := context.WithTimeout(ctx, operationTimeout)
+	defer cancel()
+
+	cur, err := h.tenants().Find(ctx, bson.M{}, options.Find().SetSort(bson.M{"_id": 1}))
+	if err != nil {
+		return nil, err
+	}
+
+	defer cur.Close(ctx)
+
+	tenants := make([]*Tenant, 0)
+	for cur.Next(ctx) {
+		var t Tenant
+		if err := cur.Decode(&t); err != nil {
+			return nil, err
+		}
+
+		tenants = append(tenants, &t)
+	}
+
+	return tenants, cur.Err()
+}
+
+func (h licenseMongoHandler) CreateAPIKey(ctx context.Context, k *APIKey) error {
+	if _, err := h.GetTenant(ctx, k.Tenant); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, operationTimeout)
+	defer cancel()
+
+	_, err := h.apiKeys().InsertOne(ctx, k)
+	if err != nil {
+		return fmt.Errorf("error while inserting api key: %s", err)
+	}
+
+	return nil
+}
+
+func (h licenseMongoHandler) GetAPIKeyByHash(ctx context.Context, hash string) (*APIKey, error) {
+	ctx, cancel := context.WithTimeout(ctx, operationTimeout)
+	defer cancel()
+
+	res := h.apiKeys().FindOne(ctx, bson.M{"hash": hash})
+	if err := res.Err(); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrAPIKeyNotFound
+		}
+		return nil, fmt.Errorf("error while getting api key: %s", err)
+	}
+
+	var k APIKey
+	if err := res.Decode(&k); err != nil {
+		return nil, err
+	}
+
+	return &k, nil
+}
+
+func (h licenseMongoHandler) ListAPIKeys(ctx context.Context) ([]*APIKey, error) {
+	ctx, cancel := context.WithTimeout(ctx, operationTimeout)
+	defer cancel()
+
+	cur, err := h.apiKeys().Find(ctx, scoped(ctx, bson.M{}), options.Find().SetSort(bson.M{"created_at": 1}))
+	if err != nil {
+		return nil, err
+	}
+
+	defer cur.Close(ctx)
+
+	keys := make([]*APIKey, 0)
+	for cur.Next(ctx) {
+		var k APIKey
+		if err := cur.Decode(&k); err != nil {
+			return nil, err
+		}
+
+		keys = append(keys, &k)
+	}
+
+	return keys, cur.Err()
+}
+
+func (h licenseMongoHandler) DeleteAPIKey(ctx context.Context, id string) error {
+	ctx, cancel := context.WithTimeout(ctx, operationTimeout)
+	defer cancel()
+
+	res, err := h.apiKeys().DeleteOne(ctx, scoped(ctx, bson.M{"_id": id}))
+	if err != nil {
+		return fmt.Errorf("error while deleting api key: %s", err)
+	}
+
+	if res.DeletedCount == 0 {
+		return ErrAPIKeyNotFound
+	}
+
+	return nil
+}
@@ -0,0 +1,279 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/furkansenharputlu/f-license/lcs"
+	"github.com/furkansenharputlu/f-license/webhook"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var ErrDeliveryNotFound = errors.New("delivery not found")
+
+// DeliveryFilter selects webhook deliveries, the newest first. Empty fields match every
+// delivery and zero Limit means no limit.
+type DeliveryFilter struct {
+	WebhookID string
+	Status    string
+	Limit     int64
+}
+
+func (f DeliveryFilter) filter() bson.M {
+	filter := bson.M{}
+	if f.WebhookID != "" {
+		filter["subscription_id"] = f.WebhookID
+	}
+
+	if f.Status != "" {
+		filter["status"] = f.Status
+	}
+
+	return filter
+}
+
+// Publish puts the event of the license to the outbox of every webhook subscribed to it.
+// The webhooks are delivered by a webhook.Dispatcher.
+func Publish(ctx context.Context, h Handler, event string, l *lcs.License) error {
+	// Webhooks of the super-admin receive the events of every tenant
+	subs, err := h.ListWebhooks(WithTenant(ctx, ""))
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	e := webhook.Event{ID: webhook.NewID(), Type: event, CreatedAt: now, Data: l}
+
+	payload, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	var deliveries []*webhook.Delivery
+	for _, sub := range subs {
+		if !sub.Matches(event, l.Tenant) {
+			continue
+		}
+
+		deliveries = append(deliveries, &webhook.Delivery{
+			ID:             webhook.NewID(),
+			SubscriptionID: sub.ID,
+			EventID:        e.ID,
+			Event:          event,
+			Payload:        string(payload),
+			Tenant:         sub.Tenant,
+			Status:         webhook.StatusPending,
+			NextAttemptAt:  now,
+			CreatedAt:      now,
+		})
+	}
+
+	if len(deliveries) == 0 {
+		return nil
+	}
+
+	return h.CreateDeliveries(ctx, deliveries)
+}
+
+func (h licenseMongoHandler) webhooks() *mongo.Collection {
+	return h.col.Database().Collection("webhooks")
+}
+
+func (h licenseMongoHandler) deliveries() *mongo.Collection {
+	return h.col.Database().Collection("webhook_deliveries")
+}
+
+func (h licenseMongoHandler) CreateWebhook(ctx context.Context, s *webhook.Subscription) error {
+	ctx, cancel := context.WithTimeout(ctx, operationTimeout)
+	defer cancel()
+
+	assignTenant(ctx, &s.Tenant)
+	s.ID = webhook.NewID()
+	s.CreatedAt = time.Now().UTC().Truncate(time.Millisecond)
+
+	_, err := h.webhooks().InsertOne(ctx, s)
+	if err != nil {
+		return fmt.Errorf("error while inserting webhook: %s", err)
+	}
+
+	return nil
+}
+
+func (h licenseMongoHandler) GetWebhook(ctx context.Context, id string) (*webhook.Subscription, error) {
+	ctx, cancel := context.WithTimeout(ctx, operationTimeout)
+	defer cancel()
+
+	res := h.webhooks().FindOne(ctx, scoped(ctx, bson.M{"_id": id}))
+	if err := res.Err(); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, webhook.ErrWebhookNotFound
+		}
+		return nil, fmt.Errorf("error while getting webhook: %s", err)
+	}
+
+	var s webhook.Subscription
+	if err := res.Decode(&s); err != nil {
+		return nil, err
+	}
+
+	return &s, nil
+}
+
+func (h licenseMongoHandler) ListWebhooks(ctx context.Context) ([]*webhook.Subscription, error) {
+	ctx, cancel := context.WithTimeout(ctx, operationTimeout)
+	defer cancel()
+
+	cur, err := h.webhooks().Find(ctx, scoped(ctx, bson.M{}), options.Find().SetSort(bson.M{"created_at": 1}))
+	if err != nil {
+		return nil, err
+	}
+
+	defer cur.Close(ctx)
+
+	subs := make([]*webhook.Subscription, 0)
+	for cur.Next(ctx) {
+		var s webhook.Subscription
+		if err := cur.Decode(&s); err != nil {
+			return nil, err
+		}
+
+		subs = append(subs, &s)
+	}
+
+	return subs, cur.Err()
+}
+
+func (h licenseMongoHandler) DeleteWebhook(ctx context.Context, id string) error {
+	ctx, cancel := context.WithTimeout(ctx, operationTimeout)
+	defer cancel()
+
+	res, err := h.webhooks().DeleteOne(ctx, scoped(ctx, bson.M{"_id": id}))
+	if err != nil {
+		return fmt.Errorf("error while deleting webhook: %s", err)
+	}
+
+	if res.DeletedCount == 0 {
+		return webhook.ErrWebhookNotFound
+	}
+
+	return nil
+}
+
+func (h licenseMongoHandler) CreateDeliveries(ctx context.Context, deliveries []*webhook.Delivery) error {
+	ctx, cancel := context.WithTimeout(ctx, operationTimeout)
+	defer cancel()
+
+	docs := make([]interface{}, 0, len(deliveries))
+	for _, d := range deliveries {
+		docs = append(docs, d)
+	}
+
+	_, err := h.deliveries().InsertMany(ctx, docs)
+	if err != nil {
+		return fmt.Errorf("error while inserting deliveries: %s", err)
+	}
+
+	return nil
+}
+
+func (h licenseMongoHandler) GetDelivery(ctx context.Context, id string) (*webhook.Delivery, error) {
+	ctx, cancel := context.WithTimeout(ctx, operationTimeout)
+	defer cancel()
+
+	res := h.deliveries().FindOne(ctx, scoped(ctx, bson.M{"_id": id}))
+	if err := res.Err(); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrDeliveryNotFound
+		}
+		return nil, fmt.Errorf("error while getting delivery: %s", err)
+	}
+
+	var d webhook.Delivery
+	if err := res.Decode(&d); err != nil {
+		return nil, err
+	}
+
+	return &d, nil
+}
+
+func (h licenseMongoHandler) ListDeliveries(ctx context.Context, f DeliveryFilter) ([]*webhook.Delivery, error) {
+	ctx, cancel := context.WithTimeout(ctx, operationTimeout)
+	defer cancel()
+
+	opts := options.Find().SetSort(bson.M{"created_at": -1})
+	if f.Limit > 0 {
+		opts.SetLimit(f.Limit)
+	}
+
+	cur, err := h.deliveries().Find(ctx, scoped(ctx, f.filter()), opts)
+	if err != nil {
+		return nil, err
+	}
+
+	defer cur.Close(ctx)
+
+	deliveries := make([]*webhook.Delivery, 0)
+	for cur.Next(ctx) {
+		var d webhook.Delivery
+		if err := cur.Decode(&d); err != nil {
+			return nil, err
+		}
+
+		deliveries = append(deliveries, &d)
+	}
+
+	return deliveries, cur.Err()
+}
+
+// ClaimDeliveries takes the due deliveries one by one so that a delivery is claimed by only
+// one of the dispatchers sharing the database.
+func (h licenseMongoHandler) ClaimDeliveries(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]*webhook.Delivery, error) {
+	ctx, cancel := context.WithTimeout(ctx, operationTimeout)
+	defer cancel()
+
+	filter := bson.M{"status": webhook.StatusPending, "next_attempt_at": bson.M{"$lte": now}}
+	update := bson.M{"$set": bson.M{"next_attempt_at": now.Add(lease)}}
+	opts := options.FindOneAndUpdate().SetSort(bson.M{"next_attempt_at": 1}).SetReturnDocument(options.After)
+
+	deliveries := make([]*webhook.Delivery, 0)
+	for len(deliveries) < limit {
+		res := h.deliveries().FindOneAndUpdate(ctx, filter, update, opts)
+		if err := res.Err(); err != nil {
+			if err == mongo.ErrNoDocuments {
+				break
+			}
+			return deliveries, fmt.Errorf("error while claiming delivery: %s", err)
+		}
+
+		var d webhook.Delivery
+		if err := res.Decode(&d); err != nil {
+			return deliveries, err
+		}
+
+		deliveries = append(deliveries, &d)
+	}
+
+	return deliveries, nil
+}
+
+func (h licenseMongoHandler) UpdateDelivery(ctx context.Context, d *webhook.Delivery) error {
+	ctx, cancel := context.WithTimeout(ctx, operationTimeout)
+	defer cancel()
+
+	res, err := h.deliveries().ReplaceOne(ctx, scoped(ctx, bson.M{"_id": d.ID}), d)
+	if err != nil {
+		return fmt.Errorf("error while updating delivery: %s", err)
+	}
+
+	if res.MatchedCount == 0 {
+		return ErrDeliveryNotFound
+	}
+
+	return nil
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/furkansenharputlu/f-license/storage"
+
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+)
+
+func CreateTenant(w http.ResponseWriter, r *http.Request) {
+	if !requireSuperAdmin(w, r) {
+		return
+	}
+
+	var t storage.Tenant
+	if err := json.NewDecoder(r.Body).Decode(&t); err != nil {
+		ReturnError(w, http.StatusBadRequest, "invalid tenant: "+err.Error())
+		return
+	}
+
+	if err := t.Validate(); err != nil {
+		ReturnError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	t.CreatedAt = time.Now().UTC().Truncate(time.Millisecond)
+
+	err := storage.LicenseHandler.CreateTenant(r.Context(), &t)
+	if err != nil {
+		logrus.WithError(err).Error("Tenant couldn't be stored")
+		ReturnError(w, tenantErrorStatus(err), err.Error())
+		return
+	}
+
+	logrus.Infof("Tenant is successfully created: %s", t.ID)
+
+	ReturnResponse(w, http.StatusOK, t)
+}
+
+func GetTenants(w http.ResponseWriter, r *http.Request) {
+	if !requireSuperAdmin(w, r) {
+		return
+	}
+
+	tenants, err := storage.LicenseHandler.ListTenants(r.Context())
+	if err != nil {
+		ReturnError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	ReturnResponse(w, http.StatusOK, tenants)
+}
+
+func GetTenant(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	// A tenant can see only itself
+	if tenant := storage.TenantOf(r.Context()); !isSuperAdmin(r) && tenant != id {
+		ReturnError(w, http.StatusNotFound, storage.ErrTenantNotFound.Error())
+		return
+	}
+
+	t, err := storage.LicenseHandler.GetTenant(r.Context(), id)
+	if err != nil {
+		ReturnError(w, tenantErrorStatus(err), err.Error())
+		return
+	}
+
+	ReturnResponse(w, http.StatusOK, t)
+}
+
+// CreateAPIKey creates a key of the tenant of the request. The super-admin gives the tenant
+// in the body or by the X-Tenant-ID header. The key is returned only in this response.
+func CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Tenant string `json:"tenant"`
+		Name   string `json:"name"`
+		Role   string `json:"role"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		ReturnError(w, http.StatusBadRequest, "invalid api key: "+err.Error())
+		return
+	}
+
+	if tenant := storage.TenantOf(r.Context()); tenant != "" {
+		req.Tenant = tenant
+	}
+
+	k, err := storage.NewAPIKey(req.Tenant, req.Name, req.Role)
+	if err != nil {
+		ReturnError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	err = storage.LicenseHandler.CreateAPIKey(r.Context(), k)
+	if err != nil {
+		logrus.WithError(err).Error("API key couldn't be stored")
+		ReturnError(w, tenantErrorStatus(err), err.Error())
+		return
+	}
+
+	logrus.WithField("tenant", k.Tenant).Infof("API key is successfully created: %s", k.ID)
+
+	ReturnResponse(w, http.StatusOK, k)
+}
+
+func GetAPIKeys(w http.ResponseWriter, r *http.Request) {
+	keys, err := storage.LicenseHandler.ListAPIKeys(r.Context())
+	if err != nil {
+		ReturnError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	ReturnResponse(w, http.StatusOK, keys)
+}
+
+func DeleteAPIKey(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	err := storage.LicenseHandler.DeleteAPIKey(r.Context(), id)
+	if err != nil {
+		logrus.WithError(err).Error("API key couldn't be deleted")
+		ReturnError(w, tenantErrorStatus(err), err.Error())
+		return
+	}
+
+	logrus.Infof("API key is successfully deleted: %s", id)
+
+	ReturnResponse(w, http.StatusOK, map[string]interface{}{
+		"message": "API key successfully deleted",
+	})
+}
+
+func requireSuperAdmin(w http.ResponseWriter, r *http.Request) bool {
+	if !isSuperAdmin(r) {
+		ReturnError(w, http.StatusForbidden, "only the super-admin can manage tenants")
+		return false
+	}
+
+	return true
+}
+
+func tenantErrorStatus(err error) int {
+	switch err {
+	case storage.ErrTenantNotFound, storage.ErrAPIKeyNotFound, storage.ErrAppNotFound:
+		return http.StatusNotFound
+	case storage.ErrTenantExists:
+		return http.StatusConflict
+	}
+
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/furkansenharputlu/f-license/config"
+	"github.com/furkansenharputlu/f-license/lcs"
+	"github.com/furkansenharputlu/f-license/storage"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func createAPIKey(t *testing.T, tenant, role string) string {
+	resp := tr.Run(t, &TestCase{Method: http.MethodPost, Path: "/admin/api-keys",
+		Data: map[string]string{"tenant": tenant, "name": tenant + " key", "role": role}, BodyMatch: `"key":"`})
+
+	var k storage.APIKey
+	_ = json.NewDecoder(resp.Body).Decode(&k)
+	assert.Equal(t, tenant, k.Tenant)
+	assert.Equal(t, role, k.Role)
+
+	return k.Key
+}
+
+func as(key string) map[string]string {
+	return map[string]string{"Authorization": key}
+}
+
+func TestTenants(t *testing.T) {
+	defer storage.LicenseHandler.DropDatabase(context.Background())
+
+	t.Run("super-admin creates tenants", func(t *testing.T) {
+		tr.Run(t, &TestCase{Method: http.MethodPost, Path: "/admin/tenants", Data: map[string]string{"id": "acme", "name": "Acme"},
+			BodyMatch: `^{"id":"acme","name":"Acme","created_at":"`})
+		tr.Run(t, &TestCase{Method: http.MethodPost, Path: "/admin/tenants", Data: map[string]string{"id": "globex"}, BodyMatch: `"id":"globex"`})
+
+		resp := tr.Run(t, &TestCase{Method: http.MethodPost, Path: "/admin/tenants", Data: map[string]string{"id": "acme"},
+			BodyMatch: `"error":"tenant already exists"`})
+		assert.Equal(t, http.StatusConflict, resp.StatusCode)
+
+		resp = tr.Run(t, &TestCase{Method: http.MethodPost, Path: "/admin/tenants", Data: map[string]string{"id": "Bad ID"},
+			BodyMatch: `"error":"invalid tenant: `})
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+		tr.Run(t, &TestCase{Method: http.MethodGet, Path: "/admin/tenants", BodyMatch: `^\[{"id":"acme".*{"id":"globex"`})
+
+		resp = tr.Run(t, &TestCase{Method: http.MethodPost, Path: "/admin/api-keys", Data: map[string]string{"tenant": "unknown"},
+			BodyMatch: `"error":"tenant not found"`})
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	})
+
+	acmeKey := createAPIKey(t, "acme", config.RoleAdmin)
+	acmeReader := createAPIKey(t, "acme", config.RoleReadOnly)
+	globexKey := createAPIKey(t, "globex", config.RoleAdmin)
+
+	t.Run("tenants can't manage tenants", func(t *testing.T) {
+		resp := tr.Run(t, &TestCase{Method: http.MethodPost, Path: "/admin/tenants", Data: map[string]string{"id": "initech"},
+			Headers: as(acmeKey), BodyMatch: `"error":"only the super-admin can manage tenants"`})
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+		resp = tr.Run(t, &TestCase{Method: http.MethodGet, Path: "/admin/tenants", Headers: as(acmeKey), BodyMatch: `"error"`})
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+		tr.Run(t, &TestCase{Method: http.MethodGet, Path: "/admin/tenants/acme", Headers: as(acmeKey), BodyMatch: `"id":"acme"`})
+
+		resp = tr.Run(t, &TestCase{Method: http.MethodGet, Path: "/admin/tenants/globex", Headers: as(acmeKey), BodyMatch: `"error":"tenant not found"`})
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+		resp = tr.Run(t, &TestCase{Method: http.MethodGet, Path: "/admin/licenses", Headers: as("unknown-key"), BodyMatch: `"message":"Authorization failed"`})
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	})
+
+	t.Run("licenses are isolated", func(t *testing.T) {
+		l := sampleLicense(func(l *lcs.License) {
+			l.Headers["app"] = "test-app"
+			l.Tenant = "globex"
+		})
+
+		resp := tr.Run(t, &TestCase{Method: http.MethodPost, Path: "/admin/licenses", Data: l, Headers: as(acmeKey), BodyMatch: `"id":"`})
+		var created struct{ ID string }
+		_ = json.NewDecoder(resp.Body).Decode(&created)
+
+		path := "/admin/licenses/" + created.ID
+
+		// The tenant of the key wins over the one in the body
+		tr.Run(t, &TestCase{Method: http.MethodGet, Path: path, Headers: as(acmeKey), BodyMatch: `"tenant":"acme"`})
+		tr.Run(t, &TestCase{Method: http.MethodGet, Path: path, Headers: as(acmeReader), BodyMatch: `"tenant":"acme"`})
+		tr.Run(t, &TestCase{Method: http.MethodGet, Path: path, BodyMatch: `"tenant":"acme"`})
+		tr.Run(t, &TestCase{Method: http.MethodGet, Path: path, Headers: as(globexKey), BodyMatch: `"error":"mongo: no documents in result"`})
+
+		tr.Run(t, &TestCase{Method: http.MethodGet, Path: "/admin/licenses", Headers: as(acmeKey), BodyMatch: `^\[{"id":"` + created.ID})
+		tr.Run(t, &TestCase{Method: http.MethodGet, Path: "/admin/licenses", Headers: as(globexKey), BodyMatch: `^\[\]$`})
+		tr.Run(t, &TestCase{Method: http.MethodGet, Path: "/admin/licenses", Headers: map[string]string{TenantHeader: "globex"}, BodyMatch: `^\[\]$`})
+
+		tr.Run(t, &TestCase{Method: http.MethodDelete, Path: path + "/delete", Headers: as(globexKey), BodyMatch: `"error":"there is no license with ID: `})
+
+		resp = tr.Run(t, &TestCase{Method: http.MethodDelete, Path: path + "/delete", Headers: as(acmeReader),
+			BodyMatch: `"error":"API key role doesn't allow this operation"`})
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+		tr.Run(t, &TestCase{Method: http.MethodDelete, Path: path + "/delete", Headers: as(acmeKey), BodyMatch: `"message":"License successfully deleted"`})
+	})
+
+	t.Run("apps are isolated", func(t *testing.T) {
+		tr.Run(t, &TestCase{Method: http.MethodPost, Path: "/admin/apps", Data: sampleApp("acme-app"), Headers: as(acmeKey), BodyMatch: `"tenant":"acme"`})
+
+		tr.Run(t, &TestCase{Method: http.MethodGet, Path: "/admin/apps", Headers: as(acmeKey), BodyMatch: `^\[{"name":"acme-app"`})
+		tr.Run(t, &TestCase{Method: http.MethodGet, Path: "/admin/apps", Headers: as(globexKey), BodyMatch: `^\[\]$`})
+
+		resp := tr.Run(t, &TestCase{Method: http.MethodGet, Path: "/admin/apps/acme-app", Headers: as(globexKey), BodyMatch: `"error":"app not found"`})
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+		resp = tr.Run(t, &TestCase{Method: http.MethodDelete, Path: "/admin/apps/acme-app", Headers: as(globexKey), BodyMatch: `"error":"app not found"`})
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+		// Licenses can't be signed with the keys of another tenant's app
+		l := sampleLicense(func(l *lcs.License) { l.Headers["app"] = "acme-app" })
+		resp = tr.Run(t, &TestCase{Method: http.MethodPost, Path: "/admin/licenses", Data: l, Headers: as(globexKey), BodyMatch: `"error":"app not found"`})
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+		tr.Run(t, &TestCase{Method: http.MethodPost, Path: "/admin/licenses", Data: l, Headers: as(acmeKey), BodyMatch: `"id":"`})
+
+		// Nor by licenses without a tenant
+		resp = tr.Run(t, &TestCase{Method: http.MethodPost, Path: "/admin/licenses", Data: l, BodyMatch: `"error":"app not found with given name"`})
+		assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+
+		// Names of the apps of other tenants and of the configuration are taken
+		resp = tr.Run(t, &TestCase{Method: http.MethodPost, Path: "/admin/apps", Data: sampleApp("acme-app"), Headers: as(globexKey), BodyMatch: `"error":"app already exists"`})
+		assert.Equal(t, http.StatusConflict, resp.StatusCode)
+
+		resp = tr.Run(t, &TestCase{Method: http.MethodPost, Path: "/admin/apps", Data: sampleApp("test-app"), Headers: as(acmeKey), BodyMatch: `"error":"app already exists"`})
+		assert.Equal(t, http.StatusConflict, resp.StatusCode)
+
+		// Read-only keys don't see the secrets of apps
+		resp = tr.Run(t, &TestCase{Method: http.MethodGet, Path: "/admin/apps/acme-app", Headers: as(acmeReader), BodyMatch: `"has_hmac_secret":true`})
+		body, _ := ioutil.ReadAll(resp.Body)
+		assert.NotContains(t, string(body), "stored-secret")
+	})
+
+	t.Run("apps use only keys of their tenant", func(t *testing.T) {
+		// Key files of the configured apps can't be referenced
+		app := sampleApp("acme-rsa-app")
+		app.Alg = "RS512"
+		app.Signature = config.Get().Apps["test-app"].Signature
+		resp := tr.Run(t, &TestCase{Method: http.MethodPost, Path: "/admin/apps", Data: app, Headers: as(acmeKey),
+			BodyMatch: `"error":"apps of tenants can't use key files"`})
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+		// Neither can the keystore and KMS keys of other apps
+		app.Signature = config.Signature{KeyProvider: config.KeyProviderKeyStore, KeyID: "test-app"}
+		resp = tr.Run(t, &TestCase{Method: http.MethodPost, Path: "/admin/apps", Data: app, Headers: as(acmeKey),
+			BodyMatch: `"error":"key_id of the apps of tenant acme must start with \\"acme/\\""`})
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+		app.Signature.KeyID = "globex/key"
+		resp = tr.Run(t, &TestCase{Method: http.MethodPut, Path: "/admin/apps/acme-app", Data: app, Headers: as(acmeKey),
+			BodyMatch: `"error":"key_id of the apps of tenant acme must start with`})
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+		// The super-admin can still store apps with any keys for tenants
+		app.Tenant = "acme"
+		app.Signature = config.Get().Apps["test-app"].Signature
+		tr.Run(t, &TestCase{Method: http.MethodPost, Path: "/admin/apps", Data: app, BodyMatch: `"tenant":"acme"`})
+	})
+
+	t.Run("api keys are isolated", func(t *testing.T) {
+		resp := tr.Run(t, &TestCase{Method: http.MethodGet, Path: "/admin/api-keys", Headers: as(acmeKey)})
+		var keys []*storage.APIKey
+		_ = json.NewDecoder(resp.Body).Decode(&keys)
+		assert.Len(t, keys, 2)
+		for _, k := range keys {
+			assert.Equal(t, "acme", k.Tenant)
+			assert.Empty(t, k.Key)
+		}
+
+		// A tenant creates keys only for itself
+		tr.Run(t, &TestCase{Method: http.MethodPost, Path: "/admin/api-keys", Data: map[string]string{"tenant": "globex"},
+			Headers: as(acmeKey), BodyMatch: `"tenant":"acme"`})
+
+		// Keys are named by their ID
+		readerID := strings.SplitN(acmeReader, ".", 2)[0]
+
+		resp = tr.Run(t, &TestCase{Method: http.MethodDelete, Path: "/admin/api-keys/" + readerID, Headers: as(globexKey),
+			BodyMatch: `"error":"api key not found"`})
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+		tr.Run(t, &TestCase{Method: http.MethodDelete, Path: "/admin/api-keys/" + readerID, Headers: as(acmeKey),
+			BodyMatch: `"message":"API key successfully deleted"`})
+		resp = tr.Run(t, &TestCase{Method: http.MethodGet, Path: "/admin/licenses", Headers: as(acmeReader), BodyMatch: `"message":"Authorization failed"`})
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	})
+}
@@ -0,0 +1,92 @@
+package webhook
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+
+	"github.com/furkansenharputlu/f-license/config"
+)
+
+// internalNetworks are the networks receivers can't be in unless they are allowed by
+// webhooks.allowed_networks, so that subscriptions can't reach the internal services of
+// the server's network.
+var internalNetworks = parseNetworks(
+	"0.0.0.0/8", "10.0.0.0/8", "100.64.0.0/10", "127.0.0.0/8", "169.254.0.0/16", "172.16.0.0/12",
+	"192.168.0.0/16", "::/128", "::1/128", "fc00::/7", "fe80::/10",
+)
+
+func parseNetworks(cidrs ...string) []*net.IPNet {
+	networks := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+
+		networks = append(networks, network)
+	}
+
+	return networks
+}
+
+// newClient returns the client deliveries are posted with. The address a receiver
+// resolves to is checked when it is dialed, so that DNS can't point a subscription to an
+// internal address after it is created, and redirects are not followed.
+func newClient() *http.Client {
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			return checkAddress(address)
+		},
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = dialer.DialContext
+	// A proxy would dial the receivers itself without the check
+	transport.Proxy = nil
+
+	return &http.Client{
+		Transport: transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// checkAddress refuses the resolved address of a receiver if it is internal and not
+// allowed by the configuration.
+func checkAddress(address string) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return fmt.Errorf("invalid receiver address: %s", address)
+	}
+
+	if containsIP(config.Get().Webhooks.GetAllowedNetworks(), ip) {
+		return nil
+	}
+
+	if ip.IsMulticast() || containsIP(internalNetworks, ip) {
+		return fmt.Errorf("receiver address %s is internal, allow it in webhooks.allowed_networks", ip)
+	}
+
+	return nil
+}
+
+func containsIP(networks []*net.IPNet, ip net.IP) bool {
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,152 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/furkansenharputlu/f-license/config"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ErrWebhookNotFound is returned by the store when there is no subscription with the ID.
+var ErrWebhookNotFound = errors.New("webhook not found")
+
+// Store is the outbox deliveries are taken from.
+type Store interface {
+	GetWebhook(ctx context.Context, id string) (*Subscription, error)
+	// ClaimDeliveries returns up to limit pending deliveries due at now, and postpones them
+	// by lease so that other dispatchers don't take them while they are being delivered.
+	ClaimDeliveries(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]*Delivery, error)
+	UpdateDelivery(ctx context.Context, d *Delivery) error
+}
+
+// batchSize is the number of deliveries claimed at once.
+const batchSize = 50
+
+// Dispatcher posts the due deliveries of the outbox to their subscriptions. Several
+// dispatchers can share a store, e.g. one in every server instance.
+type Dispatcher struct {
+	Store  Store
+	Client *http.Client
+}
+
+// NewDispatcher returns a dispatcher whose client refuses receivers in internal networks
+// and doesn't follow redirects.
+func NewDispatcher(store Store) *Dispatcher {
+	return &Dispatcher{Store: store, Client: newClient()}
+}
+
+// Run delivers the due deliveries every interval until the context is canceled.
+func (d *Dispatcher) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := d.DeliverDue(ctx); err != nil && ctx.Err() == nil {
+			logrus.WithError(err).Error("Error while delivering webhooks")
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// DeliverDue makes an attempt for every due delivery and returns the number of attempts.
+func (d *Dispatcher) DeliverDue(ctx context.Context) (int, error) {
+	attempts := 0
+	for {
+		conf := config.Get().Webhooks
+		// The lease covers the attempts of the whole batch
+		lease := time.Duration(batchSize+1) * conf.GetTimeout()
+
+		deliveries, err := d.Store.ClaimDeliveries(ctx, time.Now(), lease, batchSize)
+		if err != nil {
+			return attempts, err
+		}
+
+		for _, delivery := range deliveries {
+			if err := d.attempt(ctx, delivery, conf); err != nil {
+				return attempts, err
+			}
+			attempts++
+		}
+
+		if len(deliveries) < batchSize {
+			return attempts, nil
+		}
+	}
+}
+
+// attempt posts the delivery and records the outcome. It returns only storage errors.
+func (d *Dispatcher) attempt(ctx context.Context, delivery *Delivery, conf config.Webhooks) error {
+	delivery.Attempts++
+
+	statusCode, err := d.post(ctx, delivery, conf.GetTimeout())
+	delivery.LastStatusCode = statusCode
+
+	switch {
+	case err == nil:
+		now := time.Now().UTC()
+		delivery.Status = StatusDelivered
+		delivery.DeliveredAt = &now
+		delivery.LastError = ""
+	case err == ErrWebhookNotFound || delivery.Attempts >= conf.GetMaxAttempts():
+		delivery.Status = StatusDead
+		delivery.LastError = err.Error()
+		logrus.WithError(err).WithField("delivery", delivery.ID).Warn("Webhook delivery is moved to the dead-letter list")
+	default:
+		delivery.LastError = err.Error()
+		delivery.NextAttemptAt = time.Now().UTC().Add(Backoff(delivery.Attempts, conf.GetInitialBackoff(), conf.GetMaxBackoff()))
+	}
+
+	return d.Store.UpdateDelivery(ctx, delivery)
+}
+
+func (d *Dispatcher) post(ctx context.Context, delivery *Delivery, timeout time.Duration) (int, error) {
+	sub, err := d.Store.GetWebhook(ctx, delivery.SubscriptionID)
+	if err != nil {
+		return 0, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	body := []byte(delivery.Payload)
+	req, err := http.NewRequest(http.MethodPost, sub.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventHeader, delivery.Event)
+	req.Header.Set(DeliveryHeader, delivery.ID)
+	req.Header.Set(TimestampHeader, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(SignatureHeader, Sign(sub.Secret, timestamp, body))
+
+	resp, err := d.Client.Do(req.WithContext(ctx))
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	// Drain the body to reuse the connection
+	_, _ = io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+
+	return resp.StatusCode, nil
+}
@@ -0,0 +1,199 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Events of the license lifecycle webhooks are sent for.
+const (
+	EventGenerated   = "license.generated"
+	EventActivated   = "license.activated"
+	EventInactivated = "license.inactivated"
+	EventDeleted     = "license.deleted"
+	// EventRenewed is emitted when the expiry of a license is extended and it is signed again.
+	EventRenewed = "license.renewed"
+	// EventExpiring is emitted the configured days before a license expires.
+	EventExpiring = "license.expiring"
+	// EventExpired is emitted when an expired license is inactivated.
+	EventExpired = "license.expired"
+	// EventSeatsExceeded is emitted when an instance is refused a lease of a floating
+	// license because every seat is leased.
+	EventSeatsExceeded = "license.seats_exceeded"
+)
+
+// Events are the events subscriptions can filter on.
+var Events = map[string]bool{
+	EventGenerated:     true,
+	EventActivated:     true,
+	EventInactivated:   true,
+	EventDeleted:       true,
+	EventRenewed:       true,
+	EventExpiring:      true,
+	EventExpired:       true,
+	EventSeatsExceeded: true,
+}
+
+// Headers of the delivery requests.
+const (
+	EventHeader     = "X-F-License-Event"
+	DeliveryHeader  = "X-F-License-Delivery"
+	TimestampHeader = "X-F-License-Timestamp"
+	SignatureHeader = "X-F-License-Signature"
+)
+
+// Subscription is an endpoint events are posted to. It receives the events of its tenant,
+// or of every tenant if it has no tenant. Empty Events matches every event.
+type Subscription struct {
+	ID     string   `json:"id" bson:"_id"`
+	URL    string   `json:"url" bson:"url"`
+	Events []string `json:"events,omitempty" bson:"events,omitempty"`
+	// Secret signs the payloads. It is returned only when the subscription is created.
+	Secret    string    `json:"secret,omitempty" bson:"secret"`
+	Tenant    string    `json:"tenant,omitempty" bson:"tenant,omitempty"`
+	CreatedAt time.Time `json:"created_at" bson:"created_at"`
+}
+
+func (s *Subscription) Validate() error {
+	var problems []string
+	if u, err := url.Parse(s.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		problems = append(problems, fmt.Sprintf("invalid url: %q", s.URL))
+	}
+
+	for _, event := range s.Events {
+		if !Events[event] {
+			problems = append(problems, fmt.Sprintf("unknown event %q", event))
+		}
+	}
+
+	if len(problems) > 0 {
+		return errors.New("invalid webhook: " + strings.Join(problems, "; "))
+	}
+
+	return nil
+}
+
+// Matches reports whether the subscription receives the event of the tenant.
+func (s *Subscription) Matches(event, tenant string) bool {
+	if s.Tenant != "" && s.Tenant != tenant {
+		return false
+	}
+
+	if len(s.Events) == 0 {
+		return true
+	}
+
+	for _, e := range s.Events {
+		if e == event {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Event is the payload posted to the subscriptions. ID is the same in the deliveries of
+// an event to different subscriptions, so that receivers can drop duplicates.
+type Event struct {
+	ID        string      `json:"id"`
+	Type      string      `json:"type"`
+	CreatedAt time.Time   `json:"created_at"`
+	Data      interface{} `json:"data"`
+}
+
+// Statuses of deliveries. Dead deliveries are given up after the last attempt and form
+// the dead-letter list.
+const (
+	StatusPending   = "pending"
+	StatusDelivered = "delivered"
+	StatusDead      = "dead"
+)
+
+// Delivery is an event waiting in the outbox to be posted to a subscription, or the
+// record of its delivery. Payload is the exact body which is signed.
+type Delivery struct {
+	ID             string     `json:"id" bson:"_id"`
+	SubscriptionID string     `json:"subscription_id" bson:"subscription_id"`
+	EventID        string     `json:"event_id" bson:"event_id"`
+	Event          string     `json:"event" bson:"event"`
+	Payload        string     `json:"payload" bson:"payload"`
+	Tenant         string     `json:"tenant,omitempty" bson:"tenant,omitempty"`
+	Status         string     `json:"status" bson:"status"`
+	Attempts       int        `json:"attempts" bson:"attempts"`
+	NextAttemptAt  time.Time  `json:"next_attempt_at" bson:"next_attempt_at"`
+	LastError      string     `json:"last_error,omitempty" bson:"last_error,omitempty"`
+	LastStatusCode int        `json:"last_status_code,omitempty" bson:"last_status_code,omitempty"`
+	CreatedAt      time.Time  `json:"created_at" bson:"created_at"`
+	DeliveredAt    *time.Time `json:"delivered_at,omitempty" bson:"delivered_at,omitempty"`
+}
+
+// NewID returns a random ID for subscriptions, events and deliveries.
+func NewID() string {
+	b := make([]byte, 12)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// NewSecret returns a random secret for a subscription.
+func NewSecret() string {
+	b := make([]byte, 32)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Sign returns the signature header value of the body sent at the timestamp, which is
+// the hex encoded HMAC-SHA256 of "<timestamp>.<body>" with the secret.
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10) + "."))
+	mac.Write(body)
+
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks the signature of a delivery request received at now. Requests signed more
+// than tolerance before or after now are rejected to prevent replays.
+func Verify(h http.Header, body []byte, secret string, tolerance time.Duration, now time.Time) error {
+	timestamp, err := strconv.ParseInt(h.Get(TimestampHeader), 10, 64)
+	if err != nil {
+		return errors.New("invalid timestamp")
+	}
+
+	signedAt := time.Unix(timestamp, 0)
+	if now.Sub(signedAt) > tolerance || signedAt.Sub(now) > tolerance {
+		return errors.New("timestamp is out of tolerance")
+	}
+
+	if !hmac.Equal([]byte(h.Get(SignatureHeader)), []byte(Sign(secret, timestamp, body))) {
+		return errors.New("signature mismatch")
+	}
+
+	return nil
+}
+
+// Backoff returns how long to wait before the next attempt after the given number of
+// failed attempts: initial doubled after every attempt, up to max.
+func Backoff(attempts int, initial, max time.Duration) time.Duration {
+	backoff := initial
+	for i := 1; i < attempts; i++ {
+		backoff *= 2
+		if backoff >= max {
+			return max
+		}
+	}
+
+	if backoff > max {
+		return max
+	}
+
+	return backoff
+}
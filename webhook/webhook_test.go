@@ -0,0 +1,322 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/furkansenharputlu/f-license/config"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// memoryStore is a Store keeping the outbox in memory.
+type memoryStore struct {
+	mu         sync.Mutex
+	subs       map[string]*Subscription
+	deliveries map[string]*Delivery
+}
+
+func newMemoryStore(subs ...*Subscription) *memoryStore {
+	s := &memoryStore{subs: map[string]*Subscription{}, deliveries: map[string]*Delivery{}}
+	for _, sub := range subs {
+		s.subs[sub.ID] = sub
+	}
+
+	return s
+}
+
+func (s *memoryStore) add(d *Delivery) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	copied := *d
+	s.deliveries[d.ID] = &copied
+}
+
+func (s *memoryStore) get(id string) Delivery {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return *s.deliveries[id]
+}
+
+func (s *memoryStore) GetWebhook(ctx context.Context, id string) (*Subscription, error) {
+	sub, ok := s.subs[id]
+	if !ok {
+		return nil, ErrWebhookNotFound
+	}
+
+	return sub, nil
+}
+
+func (s *memoryStore) ClaimDeliveries(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]*Delivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var claimed []*Delivery
+	for _, d := range s.deliveries {
+		if len(claimed) == limit {
+			break
+		}
+
+		if d.Status == StatusPending && !d.NextAttemptAt.After(now) {
+			d.NextAttemptAt = now.Add(lease)
+			copied := *d
+			claimed = append(claimed, &copied)
+		}
+	}
+
+	return claimed, nil
+}
+
+func (s *memoryStore) UpdateDelivery(ctx context.Context, d *Delivery) error {
+	s.add(d)
+	return nil
+}
+
+func pendingDelivery(subscriptionID string) *Delivery {
+	return &Delivery{
+		ID:             NewID(),
+		SubscriptionID: subscriptionID,
+		EventID:        NewID(),
+		Event:          EventGenerated,
+		Payload:        `{"type":"license.generated"}`,
+		Status:         StatusPending,
+		NextAttemptAt:  time.Now(),
+	}
+}
+
+func TestSignVerify(t *testing.T) {
+	body := []byte(`{"id":"1"}`)
+	now := time.Unix(1600000000, 0)
+
+	h := http.Header{}
+	h.Set(TimestampHeader, "1600000000")
+	h.Set(SignatureHeader, Sign("secret", now.Unix(), body))
+
+	assert.NoError(t, Verify(h, body, "secret", time.Minute, now))
+	assert.EqualError(t, Verify(h, body, "other", time.Minute, now), "signature mismatch")
+	assert.EqualError(t, Verify(h, []byte(`{"id":"2"}`), "secret", time.Minute, now), "signature mismatch")
+	assert.EqualError(t, Verify(h, body, "secret", time.Minute, now.Add(2*time.Minute)), "timestamp is out of tolerance")
+
+	h.Del(TimestampHeader)
+	assert.EqualError(t, Verify(h, body, "secret", time.Minute, now), "invalid timestamp")
+}
+
+func TestBackoff(t *testing.T) {
+	var backoffs []time.Duration
+	for attempts := 1; attempts <= 6; attempts++ {
+		backoffs = append(backoffs, Backoff(attempts, 30*time.Second, 5*time.Minute))
+	}
+
+	assert.Equal(t, []time.Duration{
+		30 * time.Second, time.Minute, 2 * time.Minute, 4 * time.Minute, 5 * time.Minute, 5 * time.Minute,
+	}, backoffs)
+}
+
+func TestSubscription(t *testing.T) {
+	s := &Subscription{URL: "ftp://example.com", Events: []string{EventGenerated, "license.renamed"}}
+	assert.EqualError(t, s.Validate(), `invalid webhook: invalid url: "ftp://example.com"; unknown event "license.renamed"`)
+
+	s = &Subscription{URL: "https://example.com/hook", Events: []string{EventGenerated}}
+	assert.NoError(t, s.Validate())
+	assert.True(t, s.Matches(EventGenerated, "acme"))
+	assert.False(t, s.Matches(EventDeleted, "acme"))
+
+	s.Events = nil
+	assert.True(t, s.Matches(EventDeleted, "acme"))
+
+	s.Tenant = "globex"
+	assert.False(t, s.Matches(EventDeleted, "acme"))
+	assert.True(t, s.Matches(EventDeleted, "globex"))
+}
+
+func TestDispatcher(t *testing.T) {
+	original := config.Get()
+	defer config.Set(original)
+
+	config.Set(&config.Config{Webhooks: config.Webhooks{MaxAttempts: 3, InitialBackoff: 1, MaxBackoff: 2,
+		AllowedNetworks: []string{"127.0.0.0/8"}}})
+
+	var mu sync.Mutex
+	var received []*http.Request
+	var bodies [][]byte
+	fail := false
+
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		body, _ := ioutil.ReadAll(r.Body)
+		received = append(received, r)
+		bodies = append(bodies, body)
+
+		if fail {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer receiver.Close()
+
+	sub := &Subscription{ID: "sub", URL: receiver.URL, Secret: "secret"}
+	store := newMemoryStore(sub)
+	d := NewDispatcher(store)
+	ctx := context.Background()
+
+	t.Run("delivered", func(t *testing.T) {
+		delivery := pendingDelivery(sub.ID)
+		store.add(delivery)
+
+		attempts, err := d.DeliverDue(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, attempts)
+
+		stored := store.get(delivery.ID)
+		assert.Equal(t, StatusDelivered, stored.Status)
+		assert.Equal(t, 1, stored.Attempts)
+		assert.Equal(t, http.StatusOK, stored.LastStatusCode)
+		assert.NotNil(t, stored.DeliveredAt)
+
+		r := received[0]
+		assert.Equal(t, EventGenerated, r.Header.Get(EventHeader))
+		assert.Equal(t, delivery.ID, r.Header.Get(DeliveryHeader))
+		assert.Equal(t, delivery.Payload, string(bodies[0]))
+		assert.NoError(t, Verify(r.Header, bodies[0], "secret", time.Minute, time.Now()))
+
+		// Delivered ones aren't sent again
+		attempts, _ = d.DeliverDue(ctx)
+		assert.Equal(t, 0, attempts)
+	})
+
+	t.Run("retried with backoff and given up", func(t *testing.T) {
+		mu.Lock()
+		fail = true
+		mu.Unlock()
+
+		delivery := pendingDelivery(sub.ID)
+		store.add(delivery)
+
+		before := time.Now()
+		_, _ = d.DeliverDue(ctx)
+
+		stored := store.get(delivery.ID)
+		assert.Equal(t, StatusPending, stored.Status)
+		assert.Equal(t, 1, stored.Attempts)
+		assert.Equal(t, "unexpected status code: 500", stored.LastError)
+		assert.WithinDuration(t, before.Add(time.Second), stored.NextAttemptAt, 500*time.Millisecond)
+
+		// Not due until the backoff passes
+		attempts, _ := d.DeliverDue(ctx)
+		assert.Equal(t, 0, attempts)
+
+		for i := 0; i < 2; i++ {
+			stored.NextAttemptAt = time.Now()
+			store.add(&stored)
+			_, _ = d.DeliverDue(ctx)
+			stored = store.get(delivery.ID)
+		}
+
+		assert.Equal(t, StatusDead, stored.Status)
+		assert.Equal(t, 3, stored.Attempts)
+		assert.Equal(t, http.StatusInternalServerError, stored.LastStatusCode)
+	})
+
+	t.Run("redirects aren't followed", func(t *testing.T) {
+		redirecting := httptest.NewServer(http.RedirectHandler(receiver.URL, http.StatusFound))
+		defer redirecting.Close()
+
+		redirected := &Subscription{ID: "redirected", URL: redirecting.URL, Secret: "secret"}
+		store.subs[redirected.ID] = redirected
+
+		delivery := pendingDelivery(redirected.ID)
+		store.add(delivery)
+
+		mu.Lock()
+		receivedBefore := len(received)
+		mu.Unlock()
+
+		_, _ = d.DeliverDue(ctx)
+
+		stored := store.get(delivery.ID)
+		assert.Equal(t, http.StatusFound, stored.LastStatusCode)
+		assert.Equal(t, "unexpected status code: 302", stored.LastError)
+
+		mu.Lock()
+		assert.Len(t, received, receivedBefore)
+		mu.Unlock()
+	})
+
+	t.Run("deleted webhook", func(t *testing.T) {
+		delivery := pendingDelivery("deleted")
+		store.add(delivery)
+
+		_, _ = d.DeliverDue(ctx)
+
+		stored := store.get(delivery.ID)
+		assert.Equal(t, StatusDead, stored.Status)
+		assert.Equal(t, "webhook not found", stored.LastError)
+	})
+
+	t.Run("event payload", func(t *testing.T) {
+		e := Event{ID: "1", Type: EventDeleted, CreatedAt: time.Unix(0, 0).UTC(), Data: map[string]string{"id": "2"}}
+		payload, _ := json.Marshal(e)
+		assert.Equal(t, `{"id":"1","type":"license.deleted","created_at":"1970-01-01T00:00:00Z","data":{"id":"2"}}`, string(payload))
+	})
+}
+
+func TestDispatcher_InternalReceiver(t *testing.T) {
+	original := config.Get()
+	defer config.Set(original)
+
+	config.Set(&config.Config{Webhooks: config.Webhooks{MaxAttempts: 1}})
+
+	received := false
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = true
+	}))
+	defer receiver.Close()
+
+	sub := &Subscription{ID: "sub", URL: receiver.URL, Secret: "secret"}
+	store := newMemoryStore(sub)
+	delivery := pendingDelivery(sub.ID)
+	store.add(delivery)
+
+	_, err := NewDispatcher(store).DeliverDue(context.Background())
+	assert.NoError(t, err)
+
+	stored := store.get(delivery.ID)
+	assert.Equal(t, StatusDead, stored.Status)
+	assert.Contains(t, stored.LastError, "receiver address 127.0.0.1 is internal")
+	assert.False(t, received)
+}
+
+func TestCheckAddress(t *testing.T) {
+	original := config.Get()
+	defer config.Set(original)
+
+	config.Set(&config.Config{Webhooks: config.Webhooks{AllowedNetworks: []string{"10.1.0.0/16"}}})
+
+	for address, allowed := range map[string]bool{
+		"93.184.216.34:443":        true,
+		"[2606:2800:220:1::1]:443": true,
+		"10.1.2.3:80":              true,
+		"10.2.0.1:80":              false,
+		"127.0.0.1:8080":           false,
+		"169.254.169.254:80":       false,
+		"192.168.1.1:80":           false,
+		"[::1]:80":                 false,
+		"[::ffff:127.0.0.1]:80":    false,
+		"[fd00::1]:80":             false,
+		"[fe80::1]:80":             false,
+		"0.0.0.0:80":               false,
+		"224.0.0.1:80":             false,
+	} {
+		err := checkAddress(address)
+		assert.Equal(t, allowed, err == nil, address)
+	}
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/furkansenharputlu/f-license/lcs"
+	"github.com/furkansenharputlu/f-license/notify"
+	"github.com/furkansenharputlu/f-license/storage"
+	"github.com/furkansenharputlu/f-license/webhook"
+
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+)
+
+// webhookDeliveryInterval is how often the outbox is checked for due webhook deliveries.
+const webhookDeliveryInterval = time.Second
+
+// emailSendInterval is how often the outbox is checked for due emails.
+const emailSendInterval = 5 * time.Second
+
+// EmailSender sends the emails to customers. It is an SMTP sender of the configured server
+// if nil.
+var EmailSender notify.Sender
+
+// publish puts the event of the license to the webhook outbox, and the email to the
+// customer of the license about it to the email outbox.
+func publish(ctx context.Context, event string, l *lcs.License) {
+	storage.PublishEvent(ctx, storage.LicenseHandler, event, l)
+}
+
+// CreateWebhook subscribes a URL to events. The secret signing the payloads is generated
+// if it is not given, and returned only in this response.
+func CreateWebhook(w http.ResponseWriter, r *http.Request) {
+	var s webhook.Subscription
+	if err := json.NewDecoder(r.Body).Decode(&s); err != nil {
+		ReturnError(w, http.StatusBadRequest, "invalid webhook: "+err.Error())
+		return
+	}
+
+	if err := s.Validate(); err != nil {
+		ReturnError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// The tenant given by the super-admin must exist
+	if s.Tenant != "" && storage.TenantOf(r.Context()) == "" {
+		if _, err := storage.LicenseHandler.GetTenant(r.Context(), s.Tenant); err != nil {
+			ReturnError(w, tenantErrorStatus(err), err.Error())
+			return
+		}
+	}
+
+	if s.Secret == "" {
+		s.Secret = webhook.NewSecret()
+	}
+
+	err := storage.LicenseHandler.CreateWebhook(r.Context(), &s)
+	if err != nil {
+		logrus.WithError(err).Error("Webhook couldn't be stored")
+		ReturnError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	logrus.Infof("Webhook is successfully created: %s", s.ID)
+
+	ReturnResponse(w, http.StatusOK, s)
+}
+
+func GetWebhooks(w http.ResponseWriter, r *http.Request) {
+	subs, err := storage.LicenseHandler.ListWebhooks(r.Context())
+	if err != nil {
+		ReturnError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	for _, s := range subs {
+		s.Secret = ""
+	}
+
+	ReturnResponse(w, http.StatusOK, subs)
+}
+
+func GetWebhook(w http.ResponseWriter, r *http.Request) {
+	s, err := storage.LicenseHandler.GetWebhook(r.Context(), mux.Vars(r)["id"])
+	if err != nil {
+		ReturnError(w, webhookErrorStatus(err), err.Error())
+		return
+	}
+
+	s.Secret = ""
+
+	ReturnResponse(w, http.StatusOK, s)
+}
+
+func DeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	err := storage.LicenseHandler.DeleteWebhook(r.Context(), id)
+	if err != nil {
+		logrus.WithError(err).Error("Webhook couldn't be deleted")
+		ReturnError(w, webhookErrorStatus(err), err.Error())
+		return
+	}
+
+	logrus.Infof("Webhook is successfully deleted: %s", id)
+
+	ReturnResponse(w, http.StatusOK, map[string]interface{}{
+		"message": "Webhook successfully deleted",
+	})
+}
+
+// GetDeliveries lists the deliveries of the outbox, the newest first. The dead-letter list
+// is selected with status=dead.
+func GetDeliveries(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	f := storage.DeliveryFilter{
+		WebhookID: query.Get("webhook_id"),
+		Status:    query.Get("status"),
+	}
+
+	switch f.Status {
+	case "", webhook.StatusPending, webhook.StatusDelivered, webhook.StatusDead:
+	default:
+		ReturnError(w, http.StatusBadRequest, "invalid status: "+f.Status)
+		return
+	}
+
+	if limit := query.Get("limit"); limit != "" {
+		var err error
+		f.Limit, err = strconv.ParseInt(limit, 10, 64)
+		if err != nil || f.Limit < 0 {
+			ReturnError(w, http.StatusBadRequest, "invalid limit: "+limit)
+			return
+		}
+	}
+
+	deliveries, err := storage.LicenseHandler.ListDeliveries(r.Context(), f)
+	if err != nil {
+		ReturnError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	ReturnResponse(w, http.StatusOK, deliveries)
+}
+
+func GetDelivery(w http.ResponseWriter, r *http.Request) {
+	d, err := storage.LicenseHandler.GetDelivery(r.Context(), mux.Vars(r)["id"])
+	if err != nil {
+		ReturnError(w, webhookErrorStatus(err), err.Error())
+		return
+	}
+
+	ReturnResponse(w, http.StatusOK, d)
+}
+
+// Redeliver puts a delivery back to the outbox to be delivered as soon as possible with
+// the full number of attempts, e.g. after the receiver of a dead delivery is fixed.
+func Redeliver(w http.ResponseWriter, r *http.Request) {
+	d, err := storage.LicenseHandler.GetDelivery(r.Context(), mux.Vars(r)["id"])
+	if err != nil {
+		ReturnError(w, webhookErrorStatus(err), err.Error())
+		return
+	}
+
+	d.Status = webhook.StatusPending
+	d.Attempts = 0
+	d.NextAttemptAt = time.Now().UTC()
+	d.DeliveredAt = nil
+
+	err = storage.LicenseHandler.UpdateDelivery(r.Context(), d)
+	if err != nil {
+		logrus.WithError(err).Error("Delivery couldn't be updated")
+		ReturnError(w, webhookErrorStatus(err), err.Error())
+		return
+	}
+
+	logrus.Infof("Delivery is scheduled for redelivery: %s", d.ID)
+
+	ReturnResponse(w, http.StatusOK, d)
+}
+
+func webhookErrorStatus(err error) int {
+	switch err {
+	case webhook.ErrWebhookNotFound, storage.ErrDeliveryNotFound:
+		return http.StatusNotFound
+	}
+
+	return http.StatusInternalServerError
+}
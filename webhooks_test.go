@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/furkansenharputlu/f-license/config"
+	"github.com/furkansenharputlu/f-license/lcs"
+	"github.com/furkansenharputlu/f-license/notify"
+	"github.com/furkansenharputlu/f-license/storage"
+	"github.com/furkansenharputlu/f-license/webhook"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWebhooks(t *testing.T) {
+	defer storage.LicenseHandler.DropDatabase(context.Background())
+
+	defer setTestConfig(func(c *config.Config) {
+		c.Webhooks = config.Webhooks{MaxAttempts: 1, AllowedNetworks: []string{"127.0.0.0/8"}}
+	})()
+
+	var mu sync.Mutex
+	var events []webhook.Event
+	var signatureErrors []error
+	statusCode := http.StatusOK
+
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		body, _ := ioutil.ReadAll(r.Body)
+		signatureErrors = append(signatureErrors, webhook.Verify(r.Header, body, "receiver-secret", time.Minute, time.Now()))
+
+		var e webhook.Event
+		_ = json.Unmarshal(body, &e)
+		events = append(events, e)
+
+		w.WriteHeader(statusCode)
+	}))
+	defer receiver.Close()
+
+	dispatcher := webhook.NewDispatcher(storage.LicenseHandler)
+
+	resp := tr.Run(t, &TestCase{Method: http.MethodPost, Path: "/admin/webhooks",
+		Data:      map[string]interface{}{"url": receiver.URL, "events": []string{"license.generated", "license.inactivated"}, "secret": "receiver-secret"},
+		BodyMatch: `"secret":"receiver-secret"`})
+	var sub webhook.Subscription
+	_ = json.NewDecoder(resp.Body).Decode(&sub)
+
+	resp = tr.Run(t, &TestCase{Method: http.MethodPost, Path: "/admin/webhooks", Data: map[string]interface{}{"url": "localhost"},
+		BodyMatch: `"error":"invalid webhook: invalid url: \\"localhost\\""`})
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	// Secrets are generated and shown only once
+	tr.Run(t, &TestCase{Method: http.MethodPost, Path: "/admin/webhooks", Data: map[string]interface{}{"url": receiver.URL + "/all"},
+		BodyMatch: `"secret":"[0-9a-f]{64}"`})
+	tr.Run(t, &TestCase{Method: http.MethodGet, Path: "/admin/webhooks", BodyMatch: `^\[{"id":"` + sub.ID + `","url":"[^"]+","events":\["license.generated","license.inactivated"\],"created_at"`})
+	tr.Run(t, &TestCase{Method: http.MethodGet, Path: "/admin/webhooks/" + sub.ID, BodyMatch: `^{"id":"` + sub.ID + `","url"[^}]+}$`})
+
+	t.Run("events are delivered", func(t *testing.T) {
+		resp := tr.Run(t, &TestCase{Method: http.MethodPost, Path: "/admin/licenses", Data: sampleLicense(), BodyMatch: `"id":"`})
+		var created struct{ ID string }
+		_ = json.NewDecoder(resp.Body).Decode(&created)
+
+		tr.Run(t, &TestCase{Method: http.MethodPut, Path: "/admin/licenses/" + created.ID + "/activate", BodyMatch: `"message":"Activated"`})
+		tr.Run(t, &TestCase{Method: http.MethodPut, Path: "/admin/licenses/" + created.ID + "/inactivate", BodyMatch: `"message":"Inactivated"`})
+
+		// 3 events for the catch-all webhook, 2 for the filtered one
+		deliveries, err := storage.LicenseHandler.ListDeliveries(context.Background(), storage.DeliveryFilter{Status: webhook.StatusPending})
+		assert.NoError(t, err)
+		assert.Len(t, deliveries, 5)
+
+		attempts, err := dispatcher.DeliverDue(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, 5, attempts)
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		assert.Len(t, events, 5)
+		for _, err := range signatureErrors {
+			if err != nil {
+				// Deliveries of the catch-all webhook are signed with its generated secret
+				assert.EqualError(t, err, "signature mismatch")
+			}
+		}
+
+		var types []string
+		for _, e := range events {
+			data, _ := json.Marshal(e.Data)
+			var l lcs.License
+			_ = json.Unmarshal(data, &l)
+			assert.Equal(t, created.ID, l.ID.Hex())
+
+			types = append(types, e.Type)
+		}
+		assert.ElementsMatch(t, []string{"license.generated", "license.generated", "license.activated", "license.inactivated", "license.inactivated"}, types)
+
+		tr.Run(t, &TestCase{Method: http.MethodGet, Path: "/admin/deliveries?status=delivered&webhook_id=" + sub.ID,
+			BodyMatch: `"status":"delivered","attempts":1,.*"status":"delivered","attempts":1,`})
+	})
+
+	t.Run("failed deliveries are dead-lettered and redelivered", func(t *testing.T) {
+		mu.Lock()
+		statusCode = http.StatusServiceUnavailable
+		mu.Unlock()
+
+		tr.Run(t, &TestCase{Method: http.MethodPost, Path: "/admin/licenses", Data: sampleLicense(func(l *lcs.License) {
+			l.Claims["name"] = "Dead"
+		}), BodyMatch: `"id":"`})
+
+		_, err := dispatcher.DeliverDue(context.Background())
+		assert.NoError(t, err)
+
+		resp := tr.Run(t, &TestCase{Method: http.MethodGet, Path: "/admin/deliveries?status=dead&webhook_id=" + sub.ID,
+			BodyMatch: `"last_error":"unexpected status code: 503","last_status_code":503`})
+		var dead []*webhook.Delivery
+		_ = json.NewDecoder(resp.Body).Decode(&dead)
+		assert.Len(t, dead, 1)
+
+		mu.Lock()
+		statusCode = http.StatusOK
+		mu.Unlock()
+
+		tr.Run(t, &TestCase{Method: http.MethodPost, Path: "/admin/deliveries/" + dead[0].ID + "/redeliver", BodyMatch: `"status":"pending","attempts":0`})
+
+		_, err = dispatcher.DeliverDue(context.Background())
+		assert.NoError(t, err)
+
+		tr.Run(t, &TestCase{Method: http.MethodGet, Path: "/admin/deliveries/" + dead[0].ID, BodyMatch: `"status":"delivered","attempts":1`})
+
+		resp = tr.Run(t, &TestCase{Method: http.MethodPost, Path: "/admin/deliveries/unknown/redeliver", BodyMatch: `"error":"delivery not found"`})
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+		resp = tr.Run(t, &TestCase{Method: http.MethodGet, Path: "/admin/deliveries?status=lost", BodyMatch: `"error":"invalid status: lost"`})
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	})
+
+	t.Run("webhooks of tenants", func(t *testing.T) {
+		tr.Run(t, &TestCase{Method: http.MethodPost, Path: "/admin/tenants", Data: map[string]string{"id": "acme"}, BodyMatch: `"id":"acme"`})
+		acmeKey := createAPIKey(t, "acme", config.RoleAdmin)
+
+		tr.Run(t, &TestCase{Method: http.MethodPost, Path: "/admin/webhooks", Data: map[string]interface{}{"url": receiver.URL + "/acme"},
+			Headers: as(acmeKey), BodyMatch: `"tenant":"acme"`})
+		tr.Run(t, &TestCase{Method: http.MethodGet, Path: "/admin/webhooks", Headers: as(acmeKey), BodyMatch: `^\[{[^\]]*"tenant":"acme"[^\]]*\]$`})
+		tr.Run(t, &TestCase{Method: http.MethodGet, Path: "/admin/deliveries", Headers: as(acmeKey), BodyMatch: `^\[\]$`})
+
+		resp := tr.Run(t, &TestCase{Method: http.MethodDelete, Path: "/admin/webhooks/" + sub.ID, Headers: as(acmeKey), BodyMatch: `"error":"webhook not found"`})
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+		// The license of the tenant is sent to its own webhook and the ones of the super-admin
+		tr.Run(t, &TestCase{Method: http.MethodPost, Path: "/admin/licenses", Data: sampleLicense(func(l *lcs.License) {
+			l.Claims["name"] = "Acme"
+		}), Headers: as(acmeKey), BodyMatch: `"id":"`})
+
+		resp = tr.Run(t, &TestCase{Method: http.MethodGet, Path: "/admin/deliveries?status=pending"})
+		var pending []*webhook.Delivery
+		_ = json.NewDecoder(resp.Body).Decode(&pending)
+		assert.Len(t, pending, 3)
+
+		tr.Run(t, &TestCase{Method: http.MethodGet, Path: "/admin/deliveries?status=pending", Headers: as(acmeKey), BodyMatch: `^\[{[^\]]*"tenant":"acme"[^\]]*\]$`})
+
+		tr.Run(t, &TestCase{Method: http.MethodDelete, Path: "/admin/webhooks/" + sub.ID, BodyMatch: `"message":"Webhook successfully deleted"`})
+	})
+}
+
+// chanSender is an email sender passing the messages to a channel.
+type chanSender chan *notify.Message
+
+func (s chanSender) Send(ctx context.Context, m *notify.Message) error {
+	s <- m
+	return nil
+}
+
+func TestEmails(t *testing.T) {
+	defer storage.LicenseHandler.DropDatabase(context.Background())
+
+	defer setTestConfig(func(c *config.Config) {
+		c.Email = config.Email{Enable: true, From: "noreply@example.com"}
+	})()
+
+	sent := make(chanSender, 10)
+	outbox := notify.NewOutbox(storage.LicenseHandler, sent)
+
+	// receive sends the due emails of the outbox and returns the only one sent
+	receive := func(t *testing.T) *notify.Message {
+		attempts, err := outbox.SendDue(context.Background())
+		assert.NoError(t, err)
+		if !assert.Equal(t, 1, attempts) {
+			t.FailNow()
+		}
+
+		return <-sent
+	}
+
+	resp := tr.Run(t, &TestCase{Method: http.MethodPost, Path: "/admin/customers",
+		Data: map[string]string{"name": "Furkan", "email": "furkan@example.com"}, BodyMatch: `"name":"Furkan"`})
+	var customer lcs.Customer
+	_ = json.NewDecoder(resp.Body).Decode(&customer)
+
+	resp = tr.Run(t, &TestCase{Method: http.MethodPost, Path: "/admin/licenses", Data: sampleLicense(func(l *lcs.License) {
+		l.Headers["app"] = "test-app"
+		l.CustomerID = customer.ID.Hex()
+	}), BodyMatch: `"id":"`})
+	var created struct{ ID string }
+	_ = json.NewDecoder(resp.Body).Decode(&created)
+
+	var l lcs.License
+	assert.NoError(t, storage.LicenseHandler.GetByID(context.Background(), created.ID, &l))
+
+	m := receive(t)
+	assert.Equal(t, `"Furkan" <furkan@example.com>`, m.To)
+	assert.Equal(t, "Your test-app license", m.Subject)
+	assert.Contains(t, m.Body, l.Token)
+
+	tr.Run(t, &TestCase{Method: http.MethodPut, Path: "/admin/licenses/" + created.ID + "/inactivate", BodyMatch: `"message":"Inactivated"`})
+	assert.Equal(t, "Your test-app license is revoked", receive(t).Subject)
+
+	// Licenses without a customer aren't emailed about
+	tr.Run(t, &TestCase{Method: http.MethodPost, Path: "/admin/licenses", Data: sampleLicense(), BodyMatch: `"id":"`})
+	tr.Run(t, &TestCase{Method: http.MethodPut, Path: "/admin/licenses/" + created.ID + "/activate", BodyMatch: `"message":"Activated"`})
+
+	attempts, err := outbox.SendDue(context.Background())
+	assert.NoError(t, err)
+	assert.Zero(t, attempts)
+}